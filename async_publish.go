@@ -0,0 +1,221 @@
+package nakadi
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ErrAsyncQueueFull is the cause of errors passed to the callbacks of events which were not published because
+// the queue of PublishAsync was full.
+var ErrAsyncQueueFull = errors.New("async publish queue is full")
+
+// An AsyncQueuePolicy defines how PublishAsync behaves if its queue is full.
+type AsyncQueuePolicy int
+
+// Policies for a full queue of PublishAsync.
+const (
+	// AsyncQueueBlock blocks PublishAsync until the event can be enqueued.
+	AsyncQueueBlock AsyncQueuePolicy = iota
+	// AsyncQueueDropOldest removes the oldest event from the queue in order to enqueue the new event. The
+	// callback of the removed event receives an error caused by ErrAsyncQueueFull.
+	AsyncQueueDropOldest
+	// AsyncQueueError rejects the new event. Its callback receives an error caused by ErrAsyncQueueFull.
+	AsyncQueueError
+)
+
+// PublishAsync enqueues a single event for publishing and returns without waiting for the event to be
+// published. The callback is invoked with the result of the publish request once it is completed and may
+// be nil. If the queue of the client is full, PublishAsync behaves according to the AsyncQueuePolicy of the
+// client: by default it blocks until the event can be enqueued. Events
+// are published by a pool of goroutines owned by the client, which is started with the first call of
+// PublishAsync. Use Flush to wait for all enqueued events and Close to stop the goroutines on shutdown.
+func (c *Client) PublishAsync(eventType string, event interface{}, callback func(error)) {
+	if callback == nil {
+		callback = func(error) {}
+	}
+	if c.async == nil {
+		callback(errors.New("unable to publish event asynchronously: client was not created with New"))
+		return
+	}
+	c.async.enqueue(asyncPublishRequest{eventType: eventType, event: event, callback: callback})
+}
+
+// AsyncQueueDepth returns the number of events passed to PublishAsync which wait in the queue to be published.
+func (c *Client) AsyncQueueDepth() int {
+	if c.async == nil {
+		return 0
+	}
+	return len(c.async.queue)
+}
+
+// Flush blocks until all events passed to PublishAsync were published and their callbacks were invoked.
+// If the context is done before, Flush returns the error of the context.
+func (c *Client) Flush(ctx context.Context) error {
+	if c.async == nil {
+		return nil
+	}
+	return c.async.flush(ctx)
+}
+
+// Close stops the goroutines publishing the events passed to PublishAsync. It blocks until all enqueued events
+// were published and their callbacks were invoked. Events passed to PublishAsync afterwards are not published,
+// their callbacks receive an error. To bound the time spent on shutdown, call Flush with a context before.
+// Closing a client more than once has no effect.
+func (c *Client) Close() error {
+	if c.async == nil {
+		return nil
+	}
+	c.async.close()
+	return nil
+}
+
+// asyncPublishRequest is a single event enqueued by PublishAsync.
+type asyncPublishRequest struct {
+	eventType string
+	event     interface{}
+	callback  func(error)
+}
+
+// asyncPublisher publishes events enqueued by PublishAsync using a pool of workers.
+type asyncPublisher struct {
+	client    *Client
+	workers   uint
+	policy    AsyncQueuePolicy
+	queue     chan asyncPublishRequest
+	startOnce sync.Once
+	running   sync.WaitGroup
+	closing   sync.RWMutex
+	closed    bool
+	mutex     sync.Mutex
+	apis      map[string]*PublishAPI
+	pending   int
+	idle      []chan struct{}
+}
+
+func newAsyncPublisher(client *Client, workers, queueSize uint, policy AsyncQueuePolicy) *asyncPublisher {
+	return &asyncPublisher{
+		client:  client,
+		workers: workers,
+		policy:  policy,
+		queue:   make(chan asyncPublishRequest, queueSize),
+		apis:    make(map[string]*PublishAPI)}
+}
+
+func (a *asyncPublisher) enqueue(request asyncPublishRequest) {
+	const errMsg = "unable to publish event asynchronously"
+
+	// the queue is not closed while events are enqueued
+	a.closing.RLock()
+	defer a.closing.RUnlock()
+	if a.closed {
+		request.callback(errors.New(errMsg + ": client is closed"))
+		return
+	}
+
+	a.startOnce.Do(func() {
+		a.running.Add(int(a.workers))
+		for i := uint(0); i < a.workers; i++ {
+			go a.work()
+		}
+	})
+
+	a.mutex.Lock()
+	a.pending++
+	a.mutex.Unlock()
+
+	switch a.policy {
+	case AsyncQueueError:
+		select {
+		case a.queue <- request:
+		default:
+			a.complete(request, errors.Wrap(ErrAsyncQueueFull, errMsg))
+		}
+	case AsyncQueueDropOldest:
+		for {
+			select {
+			case a.queue <- request:
+				return
+			default:
+			}
+			select {
+			case dropped := <-a.queue:
+				a.complete(dropped, errors.Wrap(ErrAsyncQueueFull, errMsg))
+			default:
+			}
+		}
+	default:
+		a.queue <- request
+	}
+}
+
+func (a *asyncPublisher) flush(ctx context.Context) error {
+	a.mutex.Lock()
+	if a.pending == 0 {
+		a.mutex.Unlock()
+		return nil
+	}
+	idle := make(chan struct{})
+	a.idle = append(a.idle, idle)
+	a.mutex.Unlock()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// close closes the queue and waits until the workers published all enqueued events.
+func (a *asyncPublisher) close() {
+	a.closing.Lock()
+	if a.closed {
+		a.closing.Unlock()
+		return
+	}
+	a.closed = true
+	close(a.queue)
+	a.closing.Unlock()
+
+	a.running.Wait()
+}
+
+func (a *asyncPublisher) work() {
+	defer a.running.Done()
+	for request := range a.queue {
+		err := a.publishAPI(request.eventType).Publish([]interface{}{request.event})
+		a.complete(request, err)
+	}
+}
+
+// complete invokes the callback of a request which was published or dropped and wakes up waiting flushes once
+// no requests are pending.
+func (a *asyncPublisher) complete(request asyncPublishRequest, err error) {
+	request.callback(err)
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	a.pending--
+	if a.pending == 0 {
+		for _, idle := range a.idle {
+			close(idle)
+		}
+		a.idle = nil
+	}
+}
+
+// publishAPI returns the PublishAPI of an event type. PublishAPIs are created on demand and reused.
+func (a *asyncPublisher) publishAPI(eventType string) *PublishAPI {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	api, ok := a.apis[eventType]
+	if !ok {
+		api = NewPublishAPI(a.client, eventType, nil)
+		a.apis[eventType] = api
+	}
+	return api
+}
@@ -0,0 +1,201 @@
+package nakadi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_PublishAsync(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	url := fmt.Sprintf("%s/event-types/%s/events", defaultNakadiURL, "test-event.undefined")
+	event := &SomeUndefinedEvent{Test: "test"}
+
+	setupClient := func() *Client {
+		client := New(defaultNakadiURL, &ClientOptions{AsyncPublishWorkers: 2, AsyncPublishQueueSize: 5})
+		client.httpClient = http.DefaultClient
+		return client
+	}
+
+	t.Run("fail client without publisher", func(t *testing.T) {
+		client := &Client{nakadiURL: defaultNakadiURL, httpClient: http.DefaultClient}
+
+		var result error
+		client.PublishAsync("test-event.undefined", event, func(err error) { result = err })
+
+		require.Error(t, result)
+		assert.Regexp(t, "client was not created with New", result)
+		assert.NoError(t, client.Flush(context.Background()))
+	})
+
+	t.Run("fail publish", func(t *testing.T) {
+		client := setupClient()
+		httpmock.RegisterResponder("POST", url, httpmock.NewStringResponder(http.StatusUnauthorized, testProblemJSON))
+
+		errCh := make(chan error, 1)
+		client.PublishAsync("test-event.undefined", event, func(err error) { errCh <- err })
+
+		require.NoError(t, client.Flush(context.Background()))
+		err := <-errCh
+		require.Error(t, err)
+		assert.Regexp(t, "some problem detail", err)
+	})
+
+	t.Run("fail flush deadline", func(t *testing.T) {
+		client := setupClient()
+		blockCh := make(chan struct{})
+		defer close(blockCh)
+		httpmock.RegisterResponder("POST", url, func(r *http.Request) (*http.Response, error) {
+			<-blockCh
+			return httpmock.NewStringResponse(http.StatusOK, ""), nil
+		})
+
+		client.PublishAsync("test-event.undefined", event, nil)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		assert.Equal(t, context.DeadlineExceeded, client.Flush(ctx))
+	})
+
+	t.Run("success", func(t *testing.T) {
+		client := setupClient()
+
+		var mutex sync.Mutex
+		published := 0
+		httpmock.RegisterResponder("POST", url, func(r *http.Request) (*http.Response, error) {
+			mutex.Lock()
+			published++
+			mutex.Unlock()
+			return httpmock.NewStringResponse(http.StatusOK, ""), nil
+		})
+
+		var results sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			results.Add(1)
+			client.PublishAsync("test-event.undefined", event, func(err error) {
+				assert.NoError(t, err)
+				results.Done()
+			})
+		}
+
+		require.NoError(t, client.Flush(context.Background()))
+		results.Wait()
+		assert.Equal(t, 20, published)
+	})
+
+	t.Run("success close", func(t *testing.T) {
+		client := setupClient()
+
+		var mutex sync.Mutex
+		published := 0
+		httpmock.RegisterResponder("POST", url, func(r *http.Request) (*http.Response, error) {
+			time.Sleep(time.Millisecond)
+			mutex.Lock()
+			published++
+			mutex.Unlock()
+			return httpmock.NewStringResponse(http.StatusOK, ""), nil
+		})
+
+		results := make(chan error, 11)
+		for i := 0; i < 10; i++ {
+			client.PublishAsync("test-event.undefined", event, func(err error) { results <- err })
+		}
+
+		require.NoError(t, client.Close())
+		assert.Equal(t, 10, published)
+		assert.Len(t, results, 10)
+		for i := 0; i < 10; i++ {
+			assert.NoError(t, <-results)
+		}
+
+		client.PublishAsync("test-event.undefined", event, func(err error) { results <- err })
+		err := <-results
+		require.Error(t, err)
+		assert.Regexp(t, "client is closed", err)
+		assert.NoError(t, client.Close())
+		assert.NoError(t, client.Flush(context.Background()))
+	})
+
+	t.Run("success close unused client", func(t *testing.T) {
+		assert.NoError(t, setupClient().Close())
+		assert.NoError(t, (&Client{}).Close())
+	})
+}
+
+func TestClient_PublishAsyncQueuePolicy(t *testing.T) {
+	url := fmt.Sprintf("%s/event-types/%s/events", defaultNakadiURL, "test-event.undefined")
+
+	// setup creates a client with a single worker and a queue for a single event. The worker is blocked
+	// by the first event until release is closed.
+	setup := func(policy AsyncQueuePolicy) (client *Client, release chan struct{}, published chan string) {
+		transport := httpmock.NewMockTransport()
+		client = New(defaultNakadiURL, &ClientOptions{AsyncPublishWorkers: 1, AsyncPublishQueueSize: 1, AsyncQueuePolicy: policy})
+		client.httpClient = &http.Client{Transport: transport}
+
+		started := make(chan struct{})
+		release = make(chan struct{})
+		published = make(chan string, 3)
+		var startOnce sync.Once
+		transport.RegisterResponder("POST", url, func(r *http.Request) (*http.Response, error) {
+			var events []SomeUndefinedEvent
+			if err := json.NewDecoder(r.Body).Decode(&events); err != nil {
+				return nil, err
+			}
+			startOnce.Do(func() { close(started) })
+			<-release
+			published <- events[0].Test
+			return httpmock.NewStringResponse(http.StatusOK, ""), nil
+		})
+
+		client.PublishAsync("test-event.undefined", &SomeUndefinedEvent{Test: "first"}, nil)
+		<-started
+		client.PublishAsync("test-event.undefined", &SomeUndefinedEvent{Test: "second"}, func(err error) {
+			if err != nil {
+				published <- "dropped second"
+			}
+		})
+		return client, release, published
+	}
+
+	t.Run("error if full", func(t *testing.T) {
+		client, release, published := setup(AsyncQueueError)
+		assert.Equal(t, 1, client.AsyncQueueDepth())
+
+		var result error
+		client.PublishAsync("test-event.undefined", &SomeUndefinedEvent{Test: "third"}, func(err error) { result = err })
+		require.Error(t, result)
+		assert.Equal(t, ErrAsyncQueueFull, errors.Cause(result))
+
+		close(release)
+		require.NoError(t, client.Flush(context.Background()))
+		assert.Equal(t, "first", <-published)
+		assert.Equal(t, "second", <-published)
+		assert.Equal(t, 0, client.AsyncQueueDepth())
+	})
+
+	t.Run("drop oldest if full", func(t *testing.T) {
+		client, release, published := setup(AsyncQueueDropOldest)
+
+		var result error
+		client.PublishAsync("test-event.undefined", &SomeUndefinedEvent{Test: "third"}, func(err error) { result = err })
+		assert.Equal(t, "dropped second", <-published)
+		assert.Equal(t, 1, client.AsyncQueueDepth())
+
+		close(release)
+		require.NoError(t, client.Flush(context.Background()))
+		assert.NoError(t, result)
+		assert.Equal(t, "first", <-published)
+		assert.Equal(t, "third", <-published)
+	})
+}
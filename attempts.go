@@ -0,0 +1,107 @@
+package nakadi
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/cenkalti/backoff/v3"
+	"github.com/pkg/errors"
+)
+
+// ErrAttemptsExhausted is returned if a request of a publish or commit is not sent anymore, because the
+// budget of ClientOptions.MaxTotalAttempts was already used up.
+var ErrAttemptsExhausted = errors.New("maximum total attempts exhausted")
+
+// attemptBudget counts the requests of a single publish or commit across retries and redirects. A nil budget
+// is unlimited.
+type attemptBudget struct {
+	used int32
+	max  int32
+}
+
+// newAttemptBudget returns a budget of max attempts or nil if max is zero.
+func newAttemptBudget(max int) *attemptBudget {
+	if max <= 0 {
+		return nil
+	}
+	return &attemptBudget{max: int32(max)}
+}
+
+// take uses one attempt of the budget. It returns false if no attempt was left.
+func (b *attemptBudget) take() bool {
+	if b == nil {
+		return true
+	}
+	for {
+		used := atomic.LoadInt32(&b.used)
+		if used >= b.max {
+			return false
+		}
+		if atomic.CompareAndSwapInt32(&b.used, used, used+1) {
+			return true
+		}
+	}
+}
+
+// exhausted reports whether all attempts of the budget were used.
+func (b *attemptBudget) exhausted() bool {
+	return b != nil && atomic.LoadInt32(&b.used) >= b.max
+}
+
+// limit returns a backoff which stops once the budget is exhausted, so that the last error is returned by
+// the retry loop.
+func (b *attemptBudget) limit(backOff backoff.BackOff) backoff.BackOff {
+	if b == nil {
+		return backOff
+	}
+	return &budgetBackOff{BackOff: backOff, budget: b}
+}
+
+type budgetBackOff struct {
+	backoff.BackOff
+	budget *attemptBudget
+}
+
+func (b *budgetBackOff) NextBackOff() time.Duration {
+	if b.budget.exhausted() {
+		return backoff.Stop
+	}
+	return b.BackOff.NextBackOff()
+}
+
+type attemptBudgetKey struct{}
+
+// withAttemptBudget returns a copy of ctx which carries a new budget of the client's maximum total attempts.
+// If ctx already carries a budget or the attempts are unlimited, ctx is returned unchanged.
+func (c *Client) withAttemptBudget(ctx context.Context) context.Context {
+	if c.maxAttempts <= 0 || attemptBudgetFrom(ctx) != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, attemptBudgetKey{}, newAttemptBudget(c.maxAttempts))
+}
+
+// attemptBudgetFrom returns the budget carried by ctx or nil.
+func attemptBudgetFrom(ctx context.Context) *attemptBudget {
+	budget, _ := ctx.Value(attemptBudgetKey{}).(*attemptBudget)
+	return budget
+}
+
+// checkRedirectAttempts counts followed redirects against the budget of the request. Once the budget is
+// exhausted the redirect response is returned instead of being followed. Otherwise check decides, without
+// check the default limit of 10 redirects of http.Client applies.
+func checkRedirectAttempts(check func(*http.Request, []*http.Request) error) func(*http.Request, []*http.Request) error {
+	return func(request *http.Request, via []*http.Request) error {
+		if !attemptBudgetFrom(request.Context()).take() {
+			return http.ErrUseLastResponse
+		}
+		if check != nil {
+			return check(request, via)
+		}
+		if len(via) >= 10 {
+			return errors.New("stopped after 10 redirects")
+		}
+		return nil
+	}
+}
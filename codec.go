@@ -0,0 +1,139 @@
+package nakadi
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"reflect"
+
+	"github.com/pkg/errors"
+)
+
+// A Codec decodes the batches received from a stream. The default codec JSONCodec handles Nakadi's line
+// based JSON format. Custom codecs can be used to consume event types which are encoded differently,
+// e.g. with Avro.
+type Codec interface {
+	// MediaType returns the value of the Accept header sent when a stream is opened. If the media type
+	// is empty no Accept header is sent.
+	MediaType() string
+	// ReadBatch reads the next batch from the stream and returns its cursor and the events of the batch.
+	// For batches without events, like keep alive batches, the returned events must be empty.
+	ReadBatch(reader *bufio.Reader) (Cursor, []byte, error)
+}
+
+// JSONCodec is the Codec for Nakadi's JSON stream format. Each batch is a single line containing a json
+// object with the cursor and the events of the batch.
+type JSONCodec struct{}
+
+// MediaType returns an empty media type, in which case Nakadi streams JSON.
+func (JSONCodec) MediaType() string {
+	return ""
+}
+
+// ReadBatch reads the next line of the stream and returns the cursor and the json encoded events. If the stream
+// ends within a line, e.g. because the connection was closed while a batch was sent, the incomplete batch is
+// discarded and the cause of the returned error is io.ErrUnexpectedEOF.
+func (JSONCodec) ReadBatch(reader *bufio.Reader) (Cursor, []byte, error) {
+	line, err := reader.ReadBytes('\n')
+	if err == io.EOF && len(line) > 0 {
+		return Cursor{}, nil, errors.Wrap(io.ErrUnexpectedEOF, "failed to read next batch: stream ended within a batch")
+	}
+	if err != nil {
+		return Cursor{}, nil, errors.Wrap(err, "failed to read next batch")
+	}
+	line = bytes.TrimRight(line, "\r\n")
+
+	batch := struct {
+		Cursor Cursor           `json:"cursor"`
+		Events *json.RawMessage `json:"events"`
+	}{}
+	err = json.Unmarshal(line, &batch)
+	if err != nil {
+		return Cursor{}, nil, errors.Wrap(err, "failed to unmarshal next batch")
+	}
+
+	if batch.Events == nil {
+		return batch.Cursor, nil, nil
+	}
+	return batch.Cursor, []byte(*batch.Events), nil
+}
+
+// DecodeBatch decodes the json encoded events of a batch, as returned by NextEvents of streams using JSONCodec,
+// into target, which is usually a pointer to a slice of structs or of map[string]interface{}. Like with
+// encoding/json numbers decoded into interface{} become float64, so integers beyond 2^53 lose precision; use
+// DecodeBatchUseNumber for events which carry such numbers, e.g. 64-bit ids.
+func DecodeBatch(events []byte, target interface{}) error {
+	if err := json.Unmarshal(events, target); err != nil {
+		return errors.Wrap(err, "unable to decode batch")
+	}
+	return nil
+}
+
+// DecodeBatchUseNumber decodes the events of a batch like DecodeBatch, but numbers decoded into interface{}
+// become json.Number, which preserves their exact representation.
+func DecodeBatchUseNumber(events []byte, target interface{}) error {
+	if err := decodeUsingNumber(events, target); err != nil {
+		return errors.Wrap(err, "unable to decode batch")
+	}
+	return nil
+}
+
+// DecodeOptions is a set of optional parameters used by DecodeBatchWithOptions.
+type DecodeOptions struct {
+	// Whether or not numbers decoded into interface{} become json.Number like with DecodeBatchUseNumber
+	// (default: false).
+	UseNumber bool
+	// Whether or not events which can't be decoded into the element type of the target are skipped instead of
+	// failing the whole batch. This keeps a consumer running despite a few malformed events or events of an
+	// unexpected category, but the skipped events are missing from the target: a consumer which commits the
+	// batch afterwards never processes them, unless it handles the skipped events itself (default: false).
+	SkipUndecodable bool
+	// Logger is used to log each skipped event along with the reason (default: nil, nothing is logged).
+	Logger Logger
+}
+
+// DecodeBatchWithOptions decodes the events of a batch like DecodeBatch into target, which must be a pointer
+// to a slice. By default it fails on the first event which can't be decoded. With SkipUndecodable such events
+// are left out of the target and returned in their json encoding for inspection instead. The options may be
+// nil.
+func DecodeBatchWithOptions(events []byte, target interface{}, options *DecodeOptions) ([]json.RawMessage, error) {
+	const errMsg = "unable to decode batch"
+	if options == nil {
+		options = &DecodeOptions{}
+	}
+	decode := json.Unmarshal
+	if options.UseNumber {
+		decode = decodeUsingNumber
+	}
+
+	slice := reflect.ValueOf(target)
+	if slice.Kind() != reflect.Ptr || slice.Elem().Kind() != reflect.Slice {
+		return nil, errors.Errorf("%s: target must be a pointer to a slice, not %T", errMsg, target)
+	}
+	slice = slice.Elem()
+
+	var raw []json.RawMessage
+	if err := json.Unmarshal(events, &raw); err != nil {
+		return nil, errors.Wrap(err, errMsg)
+	}
+
+	decoded := reflect.MakeSlice(slice.Type(), 0, len(raw))
+	var skipped []json.RawMessage
+	for i, event := range raw {
+		element := reflect.New(slice.Type().Elem())
+		if err := decode(event, element.Interface()); err != nil {
+			if !options.SkipUndecodable {
+				return nil, errors.Wrapf(err, "%s: event %d", errMsg, i)
+			}
+			if options.Logger != nil {
+				options.Logger.Printf("skipped undecodable event: index=%d error=%v", i, err)
+			}
+			skipped = append(skipped, event)
+			continue
+		}
+		decoded = reflect.Append(decoded, element.Elem())
+	}
+	slice.Set(decoded)
+	return skipped, nil
+}
@@ -0,0 +1,86 @@
+package nakadi
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeBatch(t *testing.T) {
+	events := []byte(`[{"id":9007199254740993,"name":"test"}]`)
+
+	t.Run("fail invalid json", func(t *testing.T) {
+		var decoded []map[string]interface{}
+		err := DecodeBatch([]byte(`[{`), &decoded)
+		require.Error(t, err)
+		assert.Regexp(t, "unable to decode batch", err)
+
+		err = DecodeBatchUseNumber([]byte(`[{`), &decoded)
+		require.Error(t, err)
+		assert.Regexp(t, "unable to decode batch", err)
+	})
+
+	t.Run("success float64", func(t *testing.T) {
+		var decoded []map[string]interface{}
+		require.NoError(t, DecodeBatch(events, &decoded))
+		require.Len(t, decoded, 1)
+		assert.Equal(t, float64(9007199254740992), decoded[0]["id"])
+	})
+
+	t.Run("success use number", func(t *testing.T) {
+		var decoded []map[string]interface{}
+		require.NoError(t, DecodeBatchUseNumber(events, &decoded))
+		require.Len(t, decoded, 1)
+		assert.Equal(t, json.Number("9007199254740993"), decoded[0]["id"])
+		assert.Equal(t, "test", decoded[0]["name"])
+
+		id, err := decoded[0]["id"].(json.Number).Int64()
+		require.NoError(t, err)
+		assert.Equal(t, int64(9007199254740993), id)
+	})
+}
+
+func TestDecodeBatchWithOptions(t *testing.T) {
+	type testEvent struct {
+		ID   int64  `json:"id"`
+		Name string `json:"name"`
+	}
+	events := []byte(`[{"id":1,"name":"first"},{"id":"not-a-number"},{"id":2,"name":"second"}]`)
+
+	t.Run("fail strict by default", func(t *testing.T) {
+		var decoded []testEvent
+		_, err := DecodeBatchWithOptions(events, &decoded, nil)
+		require.Error(t, err)
+		assert.Regexp(t, "unable to decode batch: event 1", err)
+		assert.Nil(t, decoded)
+	})
+
+	t.Run("fail target no slice", func(t *testing.T) {
+		var decoded testEvent
+		_, err := DecodeBatchWithOptions(events, &decoded, nil)
+		require.Error(t, err)
+		assert.Regexp(t, "target must be a pointer to a slice", err)
+	})
+
+	t.Run("success skip undecodable", func(t *testing.T) {
+		logger := &recordingLogger{}
+		var decoded []testEvent
+		skipped, err := DecodeBatchWithOptions(events, &decoded, &DecodeOptions{SkipUndecodable: true, Logger: logger})
+		require.NoError(t, err)
+		assert.Equal(t, []testEvent{{ID: 1, Name: "first"}, {ID: 2, Name: "second"}}, decoded)
+		require.Len(t, skipped, 1)
+		assert.JSONEq(t, `{"id":"not-a-number"}`, string(skipped[0]))
+		require.Len(t, logger.Messages(), 1)
+		assert.Regexp(t, "skipped undecodable event: index=1", logger.Messages()[0])
+	})
+
+	t.Run("success use number", func(t *testing.T) {
+		var decoded []map[string]interface{}
+		skipped, err := DecodeBatchWithOptions([]byte(`[{"id":9007199254740993}]`), &decoded, &DecodeOptions{UseNumber: true})
+		require.NoError(t, err)
+		assert.Empty(t, skipped)
+		assert.Equal(t, []map[string]interface{}{{"id": json.Number("9007199254740993")}}, decoded)
+	})
+}
@@ -0,0 +1,116 @@
+package nakadi
+
+import (
+	"sync"
+	"time"
+)
+
+// maxTrackedBatches is the maximum number of uncommitted batches per partition for which the delivery time is
+// tracked. Batches delivered beyond the limit are not reported, which only affects the batch counts since
+// the oldest batches are kept.
+const maxTrackedBatches = 1000
+
+// CommitLag describes the time between the delivery of batches by NextEvents and the commit of their cursors.
+// A commit covers all batches of the partition delivered up to the committed cursor: Max is the lag of the
+// oldest of them, Min the lag of the batch of the committed cursor and Batches the number of covered batches.
+type CommitLag struct {
+	SubscriptionID string
+	EventType      string
+	Partition      string
+	Max            time.Duration
+	Min            time.Duration
+	Batches        int
+}
+
+// CommitLagCollector can be implemented by the MetricsCollector of a client in order to receive the commit lag
+// of each partition committed by streams with StreamOptions.ObserveCommitLag.
+type CommitLagCollector interface {
+	ObserveCommitLag(lag CommitLag)
+}
+
+// deliveredBatch is a batch which was delivered but not committed yet.
+type deliveredBatch struct {
+	cursor      Cursor
+	deliveredAt time.Time
+}
+
+// commitLagTracker keeps the delivery times of uncommitted batches per partition.
+type commitLagTracker struct {
+	mutex   sync.Mutex
+	batches map[string][]deliveredBatch
+}
+
+func newCommitLagTracker() *commitLagTracker {
+	return &commitLagTracker{batches: make(map[string][]deliveredBatch)}
+}
+
+// delivered records the delivery of the batch with the given cursor.
+func (t *commitLagTracker) delivered(cursor Cursor, now time.Time) {
+	key := cursor.EventType + "/" + cursor.Partition
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if len(t.batches[key]) < maxTrackedBatches {
+		t.batches[key] = append(t.batches[key], deliveredBatch{cursor: cursor, deliveredAt: now})
+	}
+}
+
+// committed removes the batches covered by the committed cursors and returns the lag of each partition with
+// covered batches. Batches of other streams are dropped, since they can't be committed anymore.
+func (t *commitLagTracker) committed(subscriptionID string, cursors []Cursor, now time.Time) []CommitLag {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	var lags []CommitLag
+	for _, cursor := range cursors {
+		key := cursor.EventType + "/" + cursor.Partition
+		batches := t.batches[key]
+
+		lag := CommitLag{SubscriptionID: subscriptionID, EventType: cursor.EventType, Partition: cursor.Partition}
+		covered := 0
+		for _, batch := range batches {
+			if batch.cursor.NakadiStreamID == cursor.NakadiStreamID {
+				if cmp, ok := compareOffsets(batch.cursor.Offset, cursor.Offset); !ok || cmp > 0 {
+					break
+				}
+				if lag.Batches == 0 {
+					lag.Max = now.Sub(batch.deliveredAt)
+				}
+				lag.Min = now.Sub(batch.deliveredAt)
+				lag.Batches++
+			}
+			covered++
+		}
+
+		if covered == len(batches) {
+			delete(t.batches, key)
+		} else {
+			t.batches[key] = append(batches[:0], batches[covered:]...)
+		}
+		if lag.Batches > 0 {
+			lags = append(lags, lag)
+		}
+	}
+	return lags
+}
+
+// observeCommitLag reports the lag of the committed cursors to the commit lag collector and the callback of
+// the stream.
+func (s *StreamAPI) observeCommitLag(cursors []Cursor) {
+	if s.commitLag == nil {
+		return
+	}
+
+	lags := s.commitLag.committed(s.SubscriptionID(), cursors, time.Now())
+	if len(lags) == 0 {
+		return
+	}
+	if s.commitLagSink != nil {
+		for _, lag := range lags {
+			s.commitLagSink.ObserveCommitLag(lag)
+		}
+	}
+	if s.onCommitLag != nil {
+		s.onCommitLag(lags)
+	}
+}
@@ -0,0 +1,112 @@
+package nakadi
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommitLagTracker(t *testing.T) {
+	now := time.Now()
+	cursor := func(stream, partition, offset string) Cursor {
+		return Cursor{NakadiStreamID: stream, EventType: "test-event", Partition: partition, Offset: offset}
+	}
+
+	tracker := newCommitLagTracker()
+	tracker.delivered(cursor("old-stream", "0", "001-0001-000000000000000001"), now.Add(-time.Hour))
+	tracker.delivered(cursor("stream-id", "0", "001-0001-000000000000000002"), now.Add(-3*time.Second))
+	tracker.delivered(cursor("stream-id", "0", "001-0001-000000000000000003"), now.Add(-2*time.Second))
+	tracker.delivered(cursor("stream-id", "0", "001-0001-000000000000000004"), now.Add(-time.Second))
+	tracker.delivered(cursor("stream-id", "1", "001-0001-000000000000000001"), now.Add(-time.Second))
+
+	lags := tracker.committed("sub-id", []Cursor{
+		cursor("stream-id", "0", "001-0001-000000000000000003"),
+		cursor("stream-id", "2", "001-0001-000000000000000001")}, now)
+	assert.Equal(t, []CommitLag{{
+		SubscriptionID: "sub-id",
+		EventType:      "test-event",
+		Partition:      "0",
+		Max:            3 * time.Second,
+		Min:            2 * time.Second,
+		Batches:        2}}, lags)
+
+	lags = tracker.committed("sub-id", []Cursor{cursor("stream-id", "0", "001-0001-000000000000000004")}, now)
+	require.Len(t, lags, 1)
+	assert.Equal(t, 1, lags[0].Batches)
+	assert.Equal(t, time.Second, lags[0].Max)
+	assert.NotContains(t, tracker.batches, "test-event/0")
+	assert.Contains(t, tracker.batches, "test-event/1")
+}
+
+type recordingCommitLagCollector struct {
+	recordingCollector
+	mutex sync.Mutex
+	lags  []CommitLag
+}
+
+func (c *recordingCommitLagCollector) ObserveCommitLag(lag CommitLag) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.lags = append(c.lags, lag)
+}
+
+func TestStreamAPI_ObserveCommitLag(t *testing.T) {
+	t.Run("report commit lag", func(t *testing.T) {
+		first := Cursor{NakadiStreamID: "stream-id", EventType: "test-event", Partition: "0", Offset: "001-0001-000000000000000001"}
+		second := Cursor{NakadiStreamID: "stream-id", EventType: "test-event", Partition: "0", Offset: "001-0001-000000000000000002"}
+
+		collector := &recordingCommitLagCollector{}
+		var reported []CommitLag
+		stream := &mockStreamer{}
+		streamAPI, opener, committer := newMockStream(nil, nil)
+		streamAPI.subscriptionID = "sub-id"
+		streamAPI.commitLag = newCommitLagTracker()
+		streamAPI.commitLagSink = collector
+		streamAPI.onCommitLag = func(lags []CommitLag) { reported = append(reported, lags...) }
+
+		opener.On("openStream").Return(stream, nil)
+		stream.On("nextEvents").Return(first, []byte(`[{}]`), nil).Once()
+		stream.On("nextEvents").Return(second, []byte(`[{}]`), nil).Once()
+		stream.On("nextEvents").Return(Cursor{}, []byte(nil), assert.AnError)
+		stream.On("closeStream").Return(nil)
+		committer.On("commitCursors", mock.Anything).Return(nil)
+
+		go streamAPI.startStream()
+		defer streamAPI.Close()
+
+		for i := 0; i < 2; i++ {
+			_, _, err := streamAPI.NextEvents()
+			require.NoError(t, err)
+		}
+		require.NoError(t, streamAPI.CommitCursor(second))
+
+		collector.mutex.Lock()
+		defer collector.mutex.Unlock()
+		require.Len(t, collector.lags, 1)
+		assert.Equal(t, "sub-id", collector.lags[0].SubscriptionID)
+		assert.Equal(t, "0", collector.lags[0].Partition)
+		assert.Equal(t, 2, collector.lags[0].Batches)
+		assert.True(t, collector.lags[0].Max >= collector.lags[0].Min)
+		assert.Equal(t, collector.lags, reported)
+	})
+
+	t.Run("enable via options", func(t *testing.T) {
+		client := New(defaultNakadiURL, &ClientOptions{Metrics: &recordingCommitLagCollector{}})
+
+		disabled, _ := newStreamAPI(context.Background(), client, "sub-id", nil)
+		assert.Nil(t, disabled.commitLag)
+
+		enabled, _ := newStreamAPI(context.Background(), client, "sub-id", &StreamOptions{ObserveCommitLag: true})
+		assert.NotNil(t, enabled.commitLag)
+		assert.NotNil(t, enabled.commitLagSink)
+
+		callback, _ := newStreamAPI(context.Background(), client, "sub-id", &StreamOptions{OnCommitLag: func([]CommitLag) {}})
+		assert.NotNil(t, callback.commitLag)
+		assert.Nil(t, callback.commitLagSink)
+	})
+}
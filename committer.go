@@ -0,0 +1,87 @@
+package nakadi
+
+import (
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// A CommitBodyShape defines how the cursors of a commit are encoded in the body of the commit request.
+type CommitBodyShape int
+
+// Shapes of the body of commit requests.
+const (
+	// CommitBodyItems wraps the cursors in an object: {"items":[...]}. This is the shape expected by Nakadi.
+	CommitBodyItems CommitBodyShape = iota
+	// CommitBodyArray sends the cursors as a bare array: [...]. Some versions of Nakadi reject the wrapped
+	// cursors with status 422 and expect this shape instead.
+	CommitBodyArray
+)
+
+// encodeCommitBody encodes the cursors of a commit request in the given shape.
+func encodeCommitBody(shape CommitBodyShape, cursors []Cursor) ([]byte, error) {
+	if shape == CommitBodyArray {
+		return json.Marshal(cursors)
+	}
+	return json.Marshal(&struct {
+		Items []Cursor `json:"items"`
+	}{Items: cursors})
+}
+
+// NewCommitter creates a Committer which commits cursors of the subscription on the stream with the given id.
+// This allows to separate the commit logic from the consumption of the stream. Once the stream was closed,
+// commits fail with the error returned by Nakadi.
+func NewCommitter(client *Client, subscriptionID, streamID string) *Committer {
+	return &Committer{
+		streamID:  streamID,
+		committer: &simpleCommitter{client: client, subscriptionID: subscriptionID}}
+}
+
+// A Committer commits cursors of a single stream of a subscription.
+type Committer struct {
+	streamID  string
+	committer committer
+	stream    *StreamAPI
+}
+
+// StreamID returns the id of the stream the cursors are committed on.
+func (c *Committer) StreamID() string {
+	return c.streamID
+}
+
+// Commit commits the cursors on the stream of the committer in a single request. Cursors without stream id are
+// committed on the stream of the committer, cursors of other streams are rejected. If the committer was
+// obtained from a StreamAPI, the commit behaves like StreamAPI.CommitCursors and fails with an error caused
+// by ErrStaleStream once the StreamAPI reconnected.
+func (c *Committer) Commit(cursors []Cursor) error {
+	if len(cursors) == 0 {
+		return nil
+	}
+
+	bound := make([]Cursor, len(cursors))
+	for i, cursor := range cursors {
+		if cursor.NakadiStreamID != "" && cursor.NakadiStreamID != c.streamID {
+			return errors.Errorf("unable to commit cursor of stream %s on stream %s", cursor.NakadiStreamID, c.streamID)
+		}
+		cursor.NakadiStreamID = c.streamID
+		bound[i] = cursor
+	}
+
+	if c.stream != nil {
+		return c.stream.CommitCursors(bound)
+	}
+	return c.committer.commitCursors(bound)
+}
+
+// Committer returns a Committer for the current stream of the StreamAPI. Commits of the Committer are handled
+// like commits via CommitCursors. Since the id of a stream is taken from its batches, an error is returned
+// if no batch was received yet.
+func (s *StreamAPI) Committer() (*Committer, error) {
+	s.committedMutex.Lock()
+	defer s.committedMutex.Unlock()
+
+	if s.streamID == "" {
+		return nil, errors.New("unable to create committer: no batch was received from the stream yet")
+	}
+	return &Committer{streamID: s.streamID, committer: s.committer, stream: s}, nil
+}
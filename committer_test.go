@@ -0,0 +1,104 @@
+package nakadi
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommitter_Commit(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	id := "7dd69d58-7f20-11e7-9748-133d6a0dbfb3"
+	url := fmt.Sprintf("%s/subscriptions/%s/cursors", defaultNakadiURL, id)
+	client := &Client{nakadiURL: defaultNakadiURL, httpClient: http.DefaultClient}
+	cursor := Cursor{Partition: "0", Offset: "1", EventType: "test-event", CursorToken: "token"}
+
+	t.Run("fail cursor of other stream", func(t *testing.T) {
+		other := cursor
+		other.NakadiStreamID = "other-stream-id"
+
+		err := NewCommitter(client, id, "stream-id").Commit([]Cursor{other})
+		require.Error(t, err)
+		assert.Regexp(t, "unable to commit cursor of stream other-stream-id on stream stream-id", err)
+	})
+
+	t.Run("fail closed stream", func(t *testing.T) {
+		problem := `{"title":"Unprocessable Entity","status":422,"detail":"Session with stream id stream-id not found"}`
+		httpmock.RegisterResponder("POST", url, httpmock.NewStringResponder(http.StatusUnprocessableEntity, problem))
+
+		err := NewCommitter(client, id, "stream-id").Commit([]Cursor{cursor})
+		require.Error(t, err)
+		assert.Regexp(t, "unable to commit cursor: Session with stream id stream-id not found", err)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		httpmock.RegisterResponder("POST", url, func(r *http.Request) (*http.Response, error) {
+			assert.Equal(t, "stream-id", r.Header.Get("X-Nakadi-StreamId"))
+			body := struct {
+				Items []Cursor `json:"items"`
+			}{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			assert.Equal(t, []Cursor{cursor}, body.Items)
+			return httpmock.NewStringResponse(http.StatusNoContent, ""), nil
+		})
+
+		committer := NewCommitter(client, id, "stream-id")
+		assert.Equal(t, "stream-id", committer.StreamID())
+		assert.NoError(t, committer.Commit([]Cursor{cursor}))
+	})
+}
+
+func TestStreamAPI_Committer(t *testing.T) {
+	oldCursor := Cursor{EventType: "test-event", Partition: "0", Offset: "1", NakadiStreamID: "old-stream-id"}
+	newCursor := Cursor{EventType: "test-event", Partition: "0", Offset: "2", NakadiStreamID: "new-stream-id"}
+
+	streamAPI, opener, committer := newMockStream(nil, nil)
+	defer streamAPI.Close()
+	_, err := streamAPI.Committer()
+	require.Error(t, err)
+	assert.Regexp(t, "no batch was received from the stream yet", err)
+
+	oldStream, newStream := &mockStreamer{}, &mockStreamer{}
+	reconnectCh := make(chan time.Time)
+	opener.On("openStream").Once().Return(oldStream, nil)
+	opener.On("openStream").Once().Return(newStream, nil)
+	oldStream.On("nextEvents").Once().Return(oldCursor, []byte(`[{}]`), nil)
+	oldStream.On("nextEvents").Once().Return(Cursor{}, []byte{}, assert.AnError).WaitUntil(reconnectCh)
+	oldStream.On("closeStream").Return(nil)
+	newStream.On("nextEvents").Once().Return(newCursor, []byte(`[{}]`), nil)
+	newStream.On("nextEvents").Return(Cursor{}, []byte{}, nil).WaitUntil(make(chan time.Time))
+	newStream.On("closeStream").Return(nil)
+	committer.On("commitCursors", []Cursor{oldCursor}).Once().Return(nil)
+	go streamAPI.startStream()
+
+	cursor, _, err := streamAPI.NextEvents()
+	require.NoError(t, err)
+	oldCommitter, err := streamAPI.Committer()
+	require.NoError(t, err)
+	assert.Equal(t, "old-stream-id", oldCommitter.StreamID())
+
+	withoutID := cursor
+	withoutID.NakadiStreamID = ""
+	require.NoError(t, oldCommitter.Commit([]Cursor{withoutID}))
+	assert.Equal(t, []Cursor{oldCursor}, streamAPI.CommittedCursors())
+
+	close(reconnectCh)
+	_, _, err = streamAPI.NextEvents()
+	require.Equal(t, assert.AnError, err)
+	_, _, err = streamAPI.NextEvents()
+	require.NoError(t, err)
+
+	err = oldCommitter.Commit([]Cursor{oldCursor})
+	require.Error(t, err)
+	assert.Equal(t, ErrStaleStream, errors.Cause(err))
+	committer.AssertExpectations(t)
+}
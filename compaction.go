@@ -0,0 +1,245 @@
+package nakadi
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ErrNotCompacted is returned by CompactedSnapshot if the event type is not compacted.
+var ErrNotCompacted = errors.New("event type is not compacted")
+
+// ErrMissingCompactionKey is returned by publish methods if the key of an event of a compacted event type,
+// which is extracted because of PublishOptions.CompactionKeyPath or CompactionKey, is empty.
+var ErrMissingCompactionKey = errors.New("missing partition compaction key")
+
+// Compacted returns true if Nakadi compacts the event type, i.e. if it retains only the latest event of each
+// partition compaction key. The events of compacted event types are not deleted after the retention time.
+func (e *EventType) Compacted() bool {
+	return e.CleanupPolicy == CleanupPolicyCompact || e.CleanupPolicy == CleanupPolicyCompactAndDelete
+}
+
+// eventTypeCache holds the event types requested by a client. The cleanup policy of an event type can't
+// be changed, therefore the event types never expire.
+type eventTypeCache struct {
+	mutex      sync.Mutex
+	eventTypes map[string]*EventType
+}
+
+// cachedEventType returns the event type with the given name. The event type is requested on the first
+// call and cached by the client afterwards.
+func (c *Client) cachedEventType(name string) (*EventType, error) {
+	if c.eventTypes == nil {
+		return NewEventAPI(c, nil).Get(name)
+	}
+
+	c.eventTypes.mutex.Lock()
+	defer c.eventTypes.mutex.Unlock()
+
+	if eventType, ok := c.eventTypes.eventTypes[name]; ok {
+		return eventType, nil
+	}
+	eventType, err := NewEventAPI(c, nil).Get(name)
+	if err != nil {
+		return nil, err
+	}
+	if c.eventTypes.eventTypes == nil {
+		c.eventTypes.eventTypes = make(map[string]*EventType)
+	}
+	c.eventTypes.eventTypes[name] = eventType
+	return eventType, nil
+}
+
+// setCompactionKeys sets metadata.partition_compaction_key of all json encoded events which have no key yet,
+// if the event type of the PublishAPI is compacted.
+func (p *PublishAPI) setCompactionKeys(encoded []byte) ([]byte, error) {
+	const errMsg = "unable to set partition compaction keys"
+
+	eventType, err := p.client.cachedEventType(p.eventType)
+	if err != nil {
+		return nil, errors.Wrap(err, errMsg)
+	}
+	if !eventType.Compacted() {
+		return encoded, nil
+	}
+
+	var events []json.RawMessage
+	if err := json.Unmarshal(encoded, &events); err != nil {
+		return nil, errors.Wrap(err, errMsg)
+	}
+
+	for i, event := range events {
+		var decoded map[string]interface{}
+		if err := decodeUsingNumber(event, &decoded); err != nil {
+			return nil, errors.Wrapf(err, "%s: event %d", errMsg, i)
+		}
+		metadata, _ := decoded["metadata"].(map[string]interface{})
+		if metadata == nil {
+			metadata = make(map[string]interface{})
+			decoded["metadata"] = metadata
+		}
+		if key, ok := metadata["partition_compaction_key"].(string); ok && key != "" {
+			continue
+		}
+
+		key, err := p.compactionKey(event)
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s: event %d", errMsg, i)
+		}
+		if key == "" {
+			return nil, errors.Wrapf(ErrMissingCompactionKey, "%s: event %d", errMsg, i)
+		}
+		metadata["partition_compaction_key"] = key
+		if events[i], err = json.Marshal(decoded); err != nil {
+			return nil, errors.Wrapf(err, "%s: event %d", errMsg, i)
+		}
+	}
+
+	return json.Marshal(events)
+}
+
+// compactionKeyAt returns a function which extracts the compaction key from the field at the dot separated
+// path. String values are used as they are, all other values in their json encoding.
+func compactionKeyAt(path string) func(event json.RawMessage) (string, error) {
+	return func(event json.RawMessage) (string, error) {
+		var decoded map[string]interface{}
+		if err := decodeUsingNumber(event, &decoded); err != nil {
+			return "", err
+		}
+		value, ok := lookupPath(decoded, path)
+		if !ok || value == nil {
+			return "", nil
+		}
+		if key, ok := value.(string); ok {
+			return key, nil
+		}
+		key, err := json.Marshal(value)
+		return string(key), err
+	}
+}
+
+// CompactedEventTypes reports for each event type of the subscription identified by id whether it is
+// compacted. Consumers of compacted event types can't expect to replay every event ever published: after
+// compaction only the latest event of each key is retained. The event types are requested once and cached
+// by the client afterwards.
+func (c *Client) CompactedEventTypes(id string) (map[string]bool, error) {
+	const errMsg = "unable to check compaction of subscription"
+
+	subscription, err := NewSubscriptionAPI(c, nil).Get(id)
+	if err != nil {
+		return nil, errors.Wrap(err, errMsg)
+	}
+
+	compacted := make(map[string]bool, len(subscription.EventTypes))
+	for _, name := range subscription.EventTypes {
+		eventType, err := c.cachedEventType(name)
+		if err != nil {
+			return nil, errors.Wrap(err, errMsg)
+		}
+		compacted[name] = eventType.Compacted()
+	}
+	return compacted, nil
+}
+
+// CompactedSnapshot reads a compacted event type from the beginning of all partitions up to their newest
+// offsets at the time of the call and returns the latest event of each partition compaction key, e.g. in
+// order to rebuild state from the event type. Events without a compaction key are skipped. CompactedSnapshot
+// returns an error caused by ErrNotCompacted if the event type is not compacted.
+//
+// Compaction runs asynchronously in Nakadi: the portion of a partition which was not compacted yet may still
+// contain older events of a key, and events published while the snapshot is read are only included if they
+// are before the newest offsets determined at the start. In both cases the events are read in order, so the
+// snapshot contains the latest event of each key up to these offsets. The snapshot is kept in memory, it
+// should only be used for event types with a limited number of keys.
+func (c *Client) CompactedSnapshot(ctx context.Context, eventType string) (map[string][]byte, error) {
+	const errMsg = "unable to read compacted snapshot"
+
+	definition, err := c.cachedEventType(eventType)
+	if err != nil {
+		return nil, errors.Wrap(err, errMsg)
+	}
+	if !definition.Compacted() {
+		return nil, errors.Wrapf(ErrNotCompacted, "%s: %s", errMsg, eventType)
+	}
+
+	partitions, err := NewEventAPI(c, nil).Partitions(eventType)
+	if err != nil {
+		return nil, errors.Wrap(err, errMsg)
+	}
+
+	// the newest offset of each partition which has to be read
+	tail := make(map[string]string)
+	var cursors []Cursor
+	for _, p := range partitions {
+		if cmp, ok := compareOffsets(p.NewestAvailableOffset, p.OldestAvailableOffset); ok && cmp < 0 {
+			// the partition is empty
+			continue
+		}
+		tail[p.Partition] = p.NewestAvailableOffset
+		cursors = append(cursors, Cursor{EventType: eventType, Partition: p.Partition, Offset: "BEGIN"})
+	}
+
+	snapshot := make(map[string][]byte)
+	if len(tail) == 0 {
+		return snapshot, nil
+	}
+
+	stream := NewEventTypeStream(c, eventType, &EventTypeStreamOptions{InitialCursors: cursors})
+	defer stream.Close()
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			stream.Close()
+		case <-stream.ctx.Done():
+		}
+	}()
+
+	for len(tail) > 0 {
+		cursor, events, err := stream.NextEvents()
+		if ctx.Err() != nil {
+			return nil, errors.Wrap(ctx.Err(), errMsg)
+		}
+		if err != nil {
+			// the stream reconnects on its own
+			continue
+		}
+
+		if err := addLatestPerKey(snapshot, events); err != nil {
+			return nil, errors.Wrap(err, errMsg)
+		}
+
+		if newest, ok := tail[cursor.Partition]; ok {
+			if cmp, ok := compareOffsets(cursor.Offset, newest); ok && cmp >= 0 {
+				delete(tail, cursor.Partition)
+			}
+		}
+	}
+
+	return snapshot, nil
+}
+
+// addLatestPerKey adds the events of a batch to the snapshot, replacing earlier events with the same key.
+func addLatestPerKey(snapshot map[string][]byte, events []byte) error {
+	var rawEvents []json.RawMessage
+	if err := json.Unmarshal(events, &rawEvents); err != nil {
+		return errors.Wrap(err, "unable to decode events")
+	}
+
+	for _, raw := range rawEvents {
+		event := struct {
+			Metadata struct {
+				PartitionCompactionKey string `json:"partition_compaction_key"`
+			} `json:"metadata"`
+		}{}
+		if err := json.Unmarshal(raw, &event); err != nil {
+			return errors.Wrap(err, "unable to decode event")
+		}
+		if key := event.Metadata.PartitionCompactionKey; key != "" {
+			snapshot[key] = raw
+		}
+	}
+	return nil
+}
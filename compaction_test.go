@@ -0,0 +1,192 @@
+package nakadi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_CompactedEventTypes(t *testing.T) {
+	transport := httpmock.NewMockTransport()
+	client := &Client{
+		nakadiURL:  defaultNakadiURL,
+		httpClient: &http.Client{Transport: transport},
+		eventTypes: &eventTypeCache{}}
+	subscriptionURL := defaultNakadiURL + "/subscriptions/sub-id"
+	eventTypeURL := defaultNakadiURL + "/event-types/"
+
+	t.Run("fail request subscription", func(t *testing.T) {
+		transport.RegisterResponder("GET", subscriptionURL, httpmock.NewStringResponder(http.StatusNotFound, testProblemJSON))
+
+		_, err := client.CompactedEventTypes("sub-id")
+		require.Error(t, err)
+		assert.Regexp(t, "unable to check compaction of subscription: .*some problem detail", err)
+	})
+
+	t.Run("success cached event types", func(t *testing.T) {
+		transport.RegisterResponder("GET", subscriptionURL, httpmock.NewStringResponder(http.StatusOK,
+			`{"id":"sub-id","event_types":["compacted-event","deleted-event"]}`))
+		transport.RegisterResponder("GET", eventTypeURL+"compacted-event", httpmock.NewStringResponder(http.StatusOK,
+			`{"name":"compacted-event","cleanup_policy":"compact"}`))
+		transport.RegisterResponder("GET", eventTypeURL+"deleted-event", httpmock.NewStringResponder(http.StatusOK,
+			`{"name":"deleted-event"}`))
+
+		for i := 0; i < 2; i++ {
+			compacted, err := client.CompactedEventTypes("sub-id")
+			require.NoError(t, err)
+			assert.Equal(t, map[string]bool{"compacted-event": true, "deleted-event": false}, compacted)
+		}
+		assert.Equal(t, 1, transport.GetCallCountInfo()["GET "+eventTypeURL+"compacted-event"])
+	})
+}
+
+func TestClient_CompactedSnapshot(t *testing.T) {
+	eventTypeURL := defaultNakadiURL + "/event-types/test-event"
+	batches := `{"cursor":{"partition":"0","offset":"001-0001-000000000000000001"},"events":[{"metadata":{"eid":"1","partition_compaction_key":"a"}},{"metadata":{"eid":"2"}}]}
+{"cursor":{"partition":"0","offset":"001-0001-000000000000000002"},"events":[{"metadata":{"eid":"3","partition_compaction_key":"b"}}]}
+{"cursor":{"partition":"0","offset":"001-0001-000000000000000003"},"events":[{"metadata":{"eid":"4","partition_compaction_key":"a"}}]}
+`
+
+	setup := func(cleanupPolicy string) (*httpmock.MockTransport, *Client) {
+		transport := httpmock.NewMockTransport()
+		transport.RegisterResponder("GET", eventTypeURL, httpmock.NewStringResponder(http.StatusOK,
+			`{"name":"test-event","cleanup_policy":"`+cleanupPolicy+`"}`))
+		transport.RegisterResponder("GET", eventTypeURL+"/partitions", httpmock.NewStringResponder(http.StatusOK, `[
+			{"partition":"0","oldest_available_offset":"001-0001-000000000000000000","newest_available_offset":"001-0001-000000000000000003"},
+			{"partition":"1","oldest_available_offset":"001-0001-000000000000000000","newest_available_offset":"BEGIN"}]`))
+		return transport, &Client{
+			nakadiURL:        defaultNakadiURL,
+			httpClient:       &http.Client{Transport: transport},
+			httpStreamClient: &http.Client{Transport: transport}}
+	}
+
+	t.Run("fail not compacted", func(t *testing.T) {
+		_, client := setup(CleanupPolicyDelete)
+
+		_, err := client.CompactedSnapshot(context.Background(), "test-event")
+		require.Error(t, err)
+		assert.Equal(t, ErrNotCompacted, errors.Cause(err))
+	})
+
+	t.Run("fail context canceled", func(t *testing.T) {
+		transport, client := setup(CleanupPolicyCompact)
+		transport.RegisterResponder("GET", eventTypeURL+"/events", helperCanceledResponder())
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := client.CompactedSnapshot(ctx, "test-event")
+		require.Error(t, err)
+		assert.Equal(t, context.Canceled, errors.Cause(err))
+	})
+
+	t.Run("success latest per key", func(t *testing.T) {
+		transport, client := setup(CleanupPolicyCompact)
+		var calls int32
+		transport.RegisterResponder("GET", eventTypeURL+"/events", func(r *http.Request) (*http.Response, error) {
+			if atomic.AddInt32(&calls, 1) > 1 {
+				// the stream may be reopened before it is closed
+				return helperCanceledResponder()(r)
+			}
+			assert.Equal(t, `[{"partition":"0","offset":"BEGIN"}]`, r.Header.Get("X-Nakadi-Cursors"))
+			return httpmock.NewStringResponse(http.StatusOK, batches), nil
+		})
+
+		snapshot, err := client.CompactedSnapshot(context.Background(), "test-event")
+		require.NoError(t, err)
+		require.Len(t, snapshot, 2)
+		assert.JSONEq(t, `{"metadata":{"eid":"4","partition_compaction_key":"a"}}`, string(snapshot["a"]))
+		assert.JSONEq(t, `{"metadata":{"eid":"3","partition_compaction_key":"b"}}`, string(snapshot["b"]))
+	})
+}
+
+func TestPublishAPI_CompactionKey(t *testing.T) {
+	eventTypeURL := defaultNakadiURL + "/event-types/test-event"
+
+	setup := func(cleanupPolicy string) (*httpmock.MockTransport, *Client, *[]map[string]interface{}) {
+		transport := httpmock.NewMockTransport()
+		transport.RegisterResponder("GET", eventTypeURL, httpmock.NewStringResponder(http.StatusOK,
+			`{"name":"test-event","cleanup_policy":"`+cleanupPolicy+`"}`))
+		var published []map[string]interface{}
+		transport.RegisterResponder("POST", eventTypeURL+"/events", func(r *http.Request) (*http.Response, error) {
+			published = nil
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&published))
+			return httpmock.NewStringResponse(http.StatusOK, ""), nil
+		})
+		return transport, &Client{
+			nakadiURL:  defaultNakadiURL,
+			httpClient: &http.Client{Transport: transport},
+			eventTypes: &eventTypeCache{}}, &published
+	}
+	events := []map[string]interface{}{
+		{"metadata": map[string]interface{}{"eid": "1"}, "data": map[string]interface{}{"id": "a"}},
+		{"metadata": map[string]interface{}{"eid": "2", "partition_compaction_key": "b"}, "data": map[string]interface{}{"id": "c"}},
+		{"metadata": map[string]interface{}{"eid": "3"}, "data": map[string]interface{}{"id": 42}}}
+
+	t.Run("fail missing key", func(t *testing.T) {
+		transport, client, _ := setup(CleanupPolicyCompact)
+		publishAPI := NewPublishAPI(client, "test-event", &PublishOptions{CompactionKeyPath: "data.id"})
+
+		err := publishAPI.Publish([]map[string]interface{}{{"metadata": map[string]interface{}{"eid": "1"}}})
+		require.Error(t, err)
+		assert.Equal(t, ErrMissingCompactionKey, errors.Cause(err))
+		assert.Regexp(t, "event 0: missing partition compaction key", err)
+		assert.Equal(t, 0, transport.GetCallCountInfo()["POST "+eventTypeURL+"/events"])
+	})
+
+	t.Run("fail key function", func(t *testing.T) {
+		_, client, _ := setup(CleanupPolicyCompactAndDelete)
+		publishAPI := NewPublishAPI(client, "test-event", &PublishOptions{
+			CompactionKey: func(json.RawMessage) (string, error) { return "", assert.AnError }})
+
+		err := publishAPI.Publish(events)
+		require.Error(t, err)
+		assert.Equal(t, assert.AnError, errors.Cause(err))
+	})
+
+	t.Run("success key path", func(t *testing.T) {
+		transport, client, published := setup(CleanupPolicyCompact)
+		publishAPI := NewPublishAPI(client, "test-event", &PublishOptions{CompactionKeyPath: "data.id"})
+
+		for i := 0; i < 2; i++ {
+			require.NoError(t, publishAPI.Publish(events))
+		}
+		require.Len(t, *published, 3)
+		for i, key := range []string{"a", "b", "42"} {
+			assert.Equal(t, key, (*published)[i]["metadata"].(map[string]interface{})["partition_compaction_key"])
+		}
+		assert.Equal(t, 1, transport.GetCallCountInfo()["GET "+eventTypeURL])
+	})
+
+	t.Run("success key function", func(t *testing.T) {
+		_, client, published := setup(CleanupPolicyCompact)
+		publishAPI := NewPublishAPI(client, "test-event", &PublishOptions{
+			CompactionKey: func(event json.RawMessage) (string, error) {
+				var decoded struct {
+					Metadata EventMetadata `json:"metadata"`
+				}
+				err := json.Unmarshal(event, &decoded)
+				return "eid-" + decoded.Metadata.EID, err
+			},
+			CompactionKeyPath: "data.id"})
+
+		require.NoError(t, publishAPI.Publish(events[:1]))
+		require.Len(t, *published, 1)
+		assert.Equal(t, "eid-1", (*published)[0]["metadata"].(map[string]interface{})["partition_compaction_key"])
+	})
+
+	t.Run("success not compacted", func(t *testing.T) {
+		_, client, published := setup(CleanupPolicyDelete)
+		publishAPI := NewPublishAPI(client, "test-event", &PublishOptions{CompactionKeyPath: "data.missing"})
+
+		require.NoError(t, publishAPI.Publish(events))
+		require.Len(t, *published, 3)
+		assert.NotContains(t, (*published)[0]["metadata"], "partition_compaction_key")
+	})
+}
@@ -0,0 +1,100 @@
+package nakadi
+
+import (
+	"net/url"
+	"time"
+)
+
+// ClientConfig describes the effective configuration of a Client as returned by Client.Config. It contains
+// the values of the ClientOptions after defaults were applied. Functions and interfaces like the token
+// provider or the logger are only reported as being set, so the config can be logged without exposing
+// secrets: a password contained in the URL is redacted as well.
+type ClientConfig struct {
+	URL                   string
+	ConnectionTimeout     time.Duration
+	DialTimeout           time.Duration
+	TLSHandshakeTimeout   time.Duration
+	ResponseHeaderTimeout time.Duration
+	ContentType           string
+	Accept                string
+	APIVersion            string
+	AsyncPublishWorkers   uint
+	AsyncPublishQueueSize uint
+	AsyncQueuePolicy      AsyncQueuePolicy
+	CatchUpPollInterval   time.Duration
+	StrictDecode          bool
+	SlowRequestThreshold  time.Duration
+	CommitBodyShape       CommitBodyShape
+	MaxTotalAttempts      int
+	RequestDecorators     int
+	TokenProvider         bool
+	Logger                bool
+	Metrics               bool
+	DialContext           bool
+	CheckRedirect         bool
+	ResponseInspector     bool
+	// Retry is a copy of the retry policy of the client with defaults applied, nil if requests are not
+	// retried by the client.
+	Retry *RetryPolicy
+	// RetryIf is set for copies of the client used by sub APIs with a custom retry predicate. The retry
+	// settings of sub APIs are not part of the client config.
+	RetryIf bool
+}
+
+// Config returns the effective configuration of the client, e.g. in order to log it for debugging. Changes of
+// the returned config have no effect on the client.
+func (c *Client) Config() ClientConfig {
+	config := ClientConfig{
+		URL:                   redactURL(c.nakadiURL),
+		ConnectionTimeout:     c.timeout,
+		DialTimeout:           c.timeouts.dial,
+		TLSHandshakeTimeout:   c.timeouts.tlsHandshake,
+		ResponseHeaderTimeout: c.timeouts.responseHeader,
+		ContentType:           c.contentType,
+		Accept:                c.accept,
+		APIVersion:            c.apiVersion,
+		CatchUpPollInterval:   c.catchUpInterval,
+		StrictDecode:          c.strictDecode,
+		SlowRequestThreshold:  c.slowThreshold,
+		CommitBodyShape:       c.commitBody,
+		MaxTotalAttempts:      c.maxAttempts,
+		RequestDecorators:     len(c.decorators),
+		TokenProvider:         c.tokenProvider != nil,
+		Logger:                c.logger != nil,
+		Metrics:               c.metrics != nil,
+		DialContext:           c.timeouts.dialContext != nil,
+		CheckRedirect:         c.httpClient != nil && c.httpClient.CheckRedirect != nil,
+		ResponseInspector:     c.inspector != nil,
+		RetryIf:               c.retryIf != nil}
+	if c.apiVersion != "" {
+		// the decorator setting the version header is not configured by the user
+		config.RequestDecorators--
+	}
+	if c.async != nil {
+		config.AsyncPublishWorkers = c.async.workers
+		config.AsyncPublishQueueSize = uint(cap(c.async.queue))
+		config.AsyncQueuePolicy = c.async.policy
+	}
+	if c.retryPolicy != nil {
+		policy := *c.retryPolicy
+		policy.RetryStatusCodes = append([]int(nil), policy.RetryStatusCodes...)
+		config.Retry = &policy
+	}
+	if c.countRedirects {
+		// the redirects are counted by a wrapper, which only calls the CheckRedirect of the options if set
+		config.CheckRedirect = c.customRedirect
+	}
+	return config
+}
+
+// redactURL replaces the password of a URL with "xxxxx".
+func redactURL(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.User == nil {
+		return rawURL
+	}
+	if _, ok := parsed.User.Password(); ok {
+		parsed.User = url.UserPassword(parsed.User.Username(), "xxxxx")
+	}
+	return parsed.String()
+}
@@ -0,0 +1,107 @@
+package nakadi
+
+import (
+	"encoding/json"
+	"sync"
+)
+
+// DeduplicateMiddleware returns a middleware which drops events that were already processed successfully,
+// e.g. events redelivered after a reconnect because their batch was handled but not committed. Events are
+// identified by their event type and metadata.eid, events without eid are never dropped. The middleware
+// remembers the eids of the last window events passed to handlers which returned without an error, so
+// duplicates are only detected as long as they are delivered within window events of the original. The
+// memory used grows linearly with window, roughly 100 bytes per remembered event. A batch which only
+// contains duplicates is not passed to the handler and therefore committed right away. Batches which can't
+// be decoded as a JSON array, e.g. of streams with a custom codec, are passed on unchanged. The window is
+// shared by all handlers decorated by the returned middleware; a window of 0 disables the deduplication.
+func DeduplicateMiddleware(window uint) Middleware {
+	seen := newEIDWindow(int(window))
+	return func(next Handler) Handler {
+		return func(batch StreamBatch) error {
+			if window == 0 || len(batch.Events) == 0 {
+				return next(batch)
+			}
+			var events []json.RawMessage
+			if err := json.Unmarshal(batch.Events, &events); err != nil {
+				return next(batch)
+			}
+
+			filtered := make([]json.RawMessage, 0, len(events))
+			keys := make([]string, 0, len(events))
+			inBatch := make(map[string]struct{}, len(events))
+			for _, event := range events {
+				var decoded struct {
+					Metadata struct {
+						EID string `json:"eid"`
+					} `json:"metadata"`
+				}
+				if json.Unmarshal(event, &decoded) != nil || decoded.Metadata.EID == "" {
+					filtered = append(filtered, event)
+					continue
+				}
+				key := batch.Cursor.EventType + "/" + decoded.Metadata.EID
+				if _, ok := inBatch[key]; ok || seen.contains(key) {
+					continue
+				}
+				inBatch[key] = struct{}{}
+				keys = append(keys, key)
+				filtered = append(filtered, event)
+			}
+
+			if len(filtered) == 0 {
+				return nil
+			}
+			if len(filtered) < len(events) {
+				encoded, err := json.Marshal(filtered)
+				if err != nil {
+					return next(batch)
+				}
+				batch.Events = encoded
+			}
+
+			err := next(batch)
+			if err == nil {
+				seen.add(keys...)
+			}
+			return err
+		}
+	}
+}
+
+// eidWindow remembers a bounded number of keys, once it is full the oldest key is forgotten.
+type eidWindow struct {
+	mutex sync.Mutex
+	size  int
+	keys  map[string]struct{}
+	order []string
+	next  int
+}
+
+func newEIDWindow(size int) *eidWindow {
+	return &eidWindow{size: size, keys: make(map[string]struct{}, size)}
+}
+
+func (w *eidWindow) contains(key string) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	_, ok := w.keys[key]
+	return ok
+}
+
+func (w *eidWindow) add(keys ...string) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for _, key := range keys {
+		if _, ok := w.keys[key]; ok || w.size == 0 {
+			continue
+		}
+		if len(w.order) < w.size {
+			w.order = append(w.order, key)
+		} else {
+			delete(w.keys, w.order[w.next])
+			w.order[w.next] = key
+			w.next = (w.next + 1) % w.size
+		}
+		w.keys[key] = struct{}{}
+	}
+}
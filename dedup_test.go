@@ -0,0 +1,65 @@
+package nakadi
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeduplicateMiddleware(t *testing.T) {
+	batch := func(events string) StreamBatch {
+		return StreamBatch{Cursor: Cursor{EventType: "test-event", Partition: "0"}, Events: []byte(events)}
+	}
+	record := func(handled *[]string, err error) Handler {
+		return func(b StreamBatch) error {
+			*handled = append(*handled, string(b.Events))
+			return err
+		}
+	}
+
+	t.Run("fail handler error keeps events", func(t *testing.T) {
+		var handled []string
+		dedup := DeduplicateMiddleware(10)
+
+		err := dedup(record(&handled, assert.AnError))(batch(`[{"metadata":{"eid":"1"}}]`))
+		assert.Equal(t, assert.AnError, err)
+		err = dedup(record(&handled, nil))(batch(`[{"metadata":{"eid":"1"}}]`))
+		require.NoError(t, err)
+		assert.Equal(t, []string{`[{"metadata":{"eid":"1"}}]`, `[{"metadata":{"eid":"1"}}]`}, handled)
+	})
+
+	t.Run("success drop redelivered events", func(t *testing.T) {
+		var handled []string
+		handler := DeduplicateMiddleware(10)(record(&handled, nil))
+
+		require.NoError(t, handler(batch(`[{"metadata":{"eid":"1"}},{"metadata":{"eid":"2"}}]`)))
+		require.NoError(t, handler(batch(`[{"metadata":{"eid":"2"}},{"metadata":{"eid":"3"}},{"metadata":{"eid":"3"}}]`)))
+		require.NoError(t, handler(batch(`[{"metadata":{"eid":"1"}},{"metadata":{"eid":"3"}}]`)))
+		require.NoError(t, handler(batch(`[{"test":"event"},{"test":"event"}]`)))
+		assert.Equal(t, []string{
+			`[{"metadata":{"eid":"1"}},{"metadata":{"eid":"2"}}]`,
+			`[{"metadata":{"eid":"3"}}]`,
+			`[{"test":"event"},{"test":"event"}]`}, handled)
+	})
+
+	t.Run("success forget events outside of window", func(t *testing.T) {
+		var handled []string
+		handler := DeduplicateMiddleware(2)(record(&handled, nil))
+
+		require.NoError(t, handler(batch(`[{"metadata":{"eid":"1"}},{"metadata":{"eid":"2"}},{"metadata":{"eid":"3"}}]`)))
+		require.NoError(t, handler(batch(`[{"metadata":{"eid":"1"}},{"metadata":{"eid":"3"}}]`)))
+		assert.Equal(t, []string{
+			`[{"metadata":{"eid":"1"}},{"metadata":{"eid":"2"}},{"metadata":{"eid":"3"}}]`,
+			`[{"metadata":{"eid":"1"}}]`}, handled)
+	})
+
+	t.Run("success pass undecodable batches", func(t *testing.T) {
+		var handled []string
+		handler := DeduplicateMiddleware(10)(record(&handled, nil))
+
+		require.NoError(t, handler(batch("\x01\x02")))
+		require.NoError(t, handler(batch("\x01\x02")))
+		assert.Equal(t, []string{"\x01\x02", "\x01\x02"}, handled)
+	})
+}
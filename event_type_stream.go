@@ -0,0 +1,299 @@
+package nakadi
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff/v3"
+	"github.com/pkg/errors"
+)
+
+// EventTypeStreamOptions contains optional parameters that are used to create an EventTypeStream.
+type EventTypeStreamOptions struct {
+	// The maximum number of Events in each chunk (and therefore per partition) of the stream (default: 1)
+	BatchLimit uint
+	// Maximum time in seconds to wait for the flushing of each chunk (per partition).(default: 30)
+	FlushTimeout uint
+	// The maximum number of consecutive keep-alive batches after which Nakadi closes the stream. The stream
+	// is reopened afterwards (default: 0, no limit).
+	StreamKeepAliveLimit uint
+	// InitialCursors are the positions from which the stream starts to read. The stream reads all events
+	// after the offset of each cursor. Partitions without cursor are read from their newest position.
+	// Without cursors all partitions are read from their newest position (default: nil).
+	InitialCursors []Cursor
+	// The initial (minimal) retry interval used for the exponential backoff when the stream is opened.
+	InitialRetryInterval time.Duration
+	// MaxRetryInterval the maximum retry interval. Once the exponential backoff reaches this value
+	// the retry intervals remain constant.
+	MaxRetryInterval time.Duration
+	// NotifyErr is called when an error occurs that leads to a retry. This notify function can be used to
+	// detect unhealthy streams.
+	NotifyErr func(error, time.Duration)
+	// NotifyOK is called whenever a stream was opened successfully. This notify function can be used
+	// to detect that a stream is healthy again.
+	NotifyOK func()
+}
+
+func (o *EventTypeStreamOptions) withDefaults() *EventTypeStreamOptions {
+	var copyOptions EventTypeStreamOptions
+	if o != nil {
+		copyOptions = *o
+	}
+	if copyOptions.InitialRetryInterval == 0 {
+		copyOptions.InitialRetryInterval = defaultInitialRetryInterval
+	}
+	if copyOptions.MaxRetryInterval == 0 {
+		copyOptions.MaxRetryInterval = defaultMaxRetryInterval
+	}
+	if copyOptions.NotifyErr == nil {
+		copyOptions.NotifyErr = func(_ error, _ time.Duration) {}
+	}
+	if copyOptions.NotifyOK == nil {
+		copyOptions.NotifyOK = func() {}
+	}
+	return &copyOptions
+}
+
+// NewEventTypeStream creates a stream which consumes the events of an event type via Nakadi's low level API.
+// In contrast to the StreamAPI no subscription is needed and no cursors are committed: the stream keeps
+// track of the latest cursor of each partition and uses these cursors to continue reading after the last
+// received batch whenever the stream is reopened. The options may be nil.
+func NewEventTypeStream(client *Client, eventType string, options *EventTypeStreamOptions) *EventTypeStream {
+	options = options.withDefaults()
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	stream := &EventTypeStream{
+		eventType: eventType,
+		eventCh:   make(chan eventsOrError, 10),
+		ctx:       ctx,
+		cancel:    cancel,
+		streamBackOffConf: backOffConfiguration{
+			Retry:                true,
+			InitialRetryInterval: options.InitialRetryInterval,
+			MaxRetryInterval:     options.MaxRetryInterval},
+		cursors:   make(map[string]Cursor),
+		notifyErr: options.NotifyErr,
+		notifyOK:  options.NotifyOK}
+	for _, cursor := range options.InitialCursors {
+		stream.cursors[cursor.Partition] = cursor
+	}
+	stream.opener = &simpleStreamOpener{
+		ctx:                  ctx,
+		client:               client,
+		eventType:            eventType,
+		batchLimit:           options.BatchLimit,
+		flushTimeout:         options.FlushTimeout,
+		streamKeepAliveLimit: options.StreamKeepAliveLimit,
+		codec:                JSONCodec{},
+		cursors:              stream.Cursors}
+
+	go stream.startStream()
+
+	return stream
+}
+
+// An EventTypeStream consumes the events of a single event type using Nakadi's low level API.
+type EventTypeStream struct {
+	eventType         string
+	opener            streamOpener
+	eventCh           chan eventsOrError
+	ctx               context.Context
+	cancel            context.CancelFunc
+	streamBackOffConf backOffConfiguration
+	cursorsMutex      sync.Mutex
+	cursors           map[string]Cursor
+	notifyErr         func(error, time.Duration)
+	notifyOK          func()
+}
+
+// NextEvents reads the next batch of events from the stream and returns the encoded events along with the
+// respective cursor. It blocks until the batch of events can be read from the stream, or the stream is closed.
+// After an error the stream is reopened at the latest received cursors.
+func (s *EventTypeStream) NextEvents() (Cursor, []byte, error) {
+	select {
+	case <-s.ctx.Done():
+		return Cursor{}, nil, context.Canceled
+	case next := <-s.eventCh:
+		return next.cursor, next.events, next.err
+	}
+}
+
+// Cursors returns the latest cursor of each partition received by the stream, including the initial cursors of
+// partitions from which no batch was received yet. The cursors are sorted by partition.
+func (s *EventTypeStream) Cursors() []Cursor {
+	s.cursorsMutex.Lock()
+	defer s.cursorsMutex.Unlock()
+
+	cursors := make([]Cursor, 0, len(s.cursors))
+	for _, cursor := range s.cursors {
+		cursors = append(cursors, cursor)
+	}
+	sort.Slice(cursors, func(i, j int) bool {
+		return cursors[i].Partition < cursors[j].Partition
+	})
+	return cursors
+}
+
+// Close ends the stream.
+func (s *EventTypeStream) Close() error {
+	s.cancel()
+	return nil
+}
+
+func (s *EventTypeStream) updateCursor(cursor Cursor) {
+	s.cursorsMutex.Lock()
+	defer s.cursorsMutex.Unlock()
+
+	s.cursors[cursor.Partition] = cursor
+}
+
+func (s *EventTypeStream) startStream() {
+	for {
+		var err error
+		var stream streamer
+
+		streamBackOff := backoff.WithContext(s.streamBackOffConf.create(), s.ctx)
+		backoff.RetryNotify(func() error {
+			stream, err = s.opener.openStream()
+			return err
+		}, streamBackOff, s.notifyErr)
+
+		if err != nil {
+			select {
+			case <-s.ctx.Done():
+				return
+			default:
+				continue
+			}
+		}
+		s.notifyOK()
+
+		for {
+			var cursor Cursor
+			var events []byte
+
+			select {
+			case <-s.ctx.Done():
+				err = context.Canceled
+			default:
+				cursor, events, err = stream.nextEvents()
+			}
+
+			if err == nil {
+				cursor.EventType = s.eventType
+				if len(events) == 0 {
+					// keep alive batches point to the current position of the partition
+					s.updateCursor(cursor)
+					continue
+				}
+			}
+
+			select {
+			case <-s.ctx.Done():
+				err = context.Canceled
+			case s.eventCh <- eventsOrError{cursor: cursor, events: events, err: err}:
+				if err == nil {
+					s.updateCursor(cursor)
+				}
+			}
+
+			if err == context.Canceled {
+				stream.closeStream()
+				return
+			}
+			if err != nil {
+				break
+			}
+		}
+
+		stream.closeStream()
+	}
+}
+
+// ExtractRange reads the events of an event type between two positions without a subscription, e.g. for
+// one-off backfills. For each partition of to the events after the cursor of the partition in from are
+// passed to the handler until the cursor in to is reached, partitions without cursor in from are read from
+// the beginning. Partitions reach their end independently: once all partitions of to were read up to their
+// cursors ExtractRange returns. Since the stream delivers one event per batch, the batches contain no events
+// after to. An error returned by the handler stops the extraction and is returned as is. The cursors of to
+// must point to available offsets, otherwise ExtractRange blocks until events up to them are published or
+// the context is done. The extraction also stops if the stream can't be opened because of an error which
+// won't go away on retry, e.g. because the event type doesn't exist or the client is not authorized to read it.
+func (c *Client) ExtractRange(ctx context.Context, eventType string, from, to []Cursor, handler func(StreamBatch) error) error {
+	const errMsg = "unable to extract range"
+
+	start := make(map[string]Cursor, len(from))
+	for _, cursor := range from {
+		start[cursor.Partition] = cursor
+	}
+
+	end := make(map[string]string, len(to))
+	var initial []Cursor
+	for _, cursor := range to {
+		begin, ok := start[cursor.Partition]
+		if !ok {
+			begin = Cursor{Partition: cursor.Partition, Offset: "BEGIN", EventType: eventType}
+		}
+		if cmp, ok := compareOffsets(begin.Offset, cursor.Offset); ok && cmp >= 0 {
+			continue
+		}
+		end[cursor.Partition] = cursor.Offset
+		initial = append(initial, begin)
+	}
+	if len(end) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// errors opening the stream are only reported to NotifyErr, since the stream retries on its own
+	openErrCh := make(chan error, 1)
+	stream := NewEventTypeStream(c, eventType, &EventTypeStreamOptions{
+		InitialCursors: initial,
+		NotifyErr: func(err error, _ time.Duration) {
+			if isPersistentError(err) {
+				select {
+				case openErrCh <- err:
+				default:
+				}
+				cancel()
+			}
+		}})
+	defer stream.Close()
+	go func() {
+		<-ctx.Done()
+		stream.Close()
+	}()
+
+	for len(end) > 0 {
+		cursor, events, err := stream.NextEvents()
+		if err != nil {
+			select {
+			case err := <-openErrCh:
+				return errors.Wrap(err, errMsg)
+			default:
+			}
+			if ctx.Err() != nil {
+				return errors.Wrap(ctx.Err(), errMsg)
+			}
+			// the stream reconnects on its own
+			continue
+		}
+
+		last, ok := end[cursor.Partition]
+		if !ok {
+			continue
+		}
+		if err := handler(StreamBatch{Cursor: cursor, Events: events}); err != nil {
+			return err
+		}
+		if cmp, ok := compareOffsets(cursor.Offset, last); ok && cmp >= 0 {
+			delete(end, cursor.Partition)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package nakadi
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventTypeStream(t *testing.T) {
+	url := defaultNakadiURL + "/event-types/test-event/events"
+	batches := `{"cursor":{"partition":"0","offset":"001-0001-000000000000000005"},"events":[{"metadata":{"eid":"1"}}]}
+{"cursor":{"partition":"1","offset":"001-0001-000000000000000003"},"events":[{"metadata":{"eid":"2"}}]}
+{"cursor":{"partition":"1","offset":"001-0001-000000000000000003"}}
+`
+
+	setup := func(options *EventTypeStreamOptions) (*EventTypeStream, chan string) {
+		transport := httpmock.NewMockTransport()
+		client := &Client{nakadiURL: defaultNakadiURL, httpStreamClient: &http.Client{Transport: transport}}
+
+		headers := make(chan string, 2)
+		var calls int32
+		transport.RegisterResponder("GET", `=~^`+url, func(r *http.Request) (*http.Response, error) {
+			headers <- r.Header.Get("X-Nakadi-Cursors")
+			if atomic.AddInt32(&calls, 1) == 1 {
+				return httpmock.NewStringResponse(http.StatusOK, batches), nil
+			}
+			return helperCanceledResponder()(r)
+		})
+
+		return NewEventTypeStream(client, "test-event", options), headers
+	}
+
+	t.Run("resume at latest cursors after reconnect", func(t *testing.T) {
+		stream, headers := setup(&EventTypeStreamOptions{InitialRetryInterval: time.Millisecond})
+		defer stream.Close()
+
+		cursor, events, err := stream.NextEvents()
+		require.NoError(t, err)
+		assert.Equal(t, Cursor{EventType: "test-event", Partition: "0", Offset: "001-0001-000000000000000005"}, cursor)
+		assert.JSONEq(t, `[{"metadata":{"eid":"1"}}]`, string(events))
+
+		_, _, err = stream.NextEvents()
+		require.NoError(t, err)
+
+		// the end of the stream is reported and the stream is reopened
+		_, _, err = stream.NextEvents()
+		require.Error(t, err)
+
+		assert.Equal(t, "", <-headers)
+		assert.Equal(t, `[{"partition":"0","offset":"001-0001-000000000000000005"},{"partition":"1","offset":"001-0001-000000000000000003"}]`, <-headers)
+		assert.Equal(t, []Cursor{
+			{EventType: "test-event", Partition: "0", Offset: "001-0001-000000000000000005"},
+			{EventType: "test-event", Partition: "1", Offset: "001-0001-000000000000000003"}}, stream.Cursors())
+	})
+
+	t.Run("start at initial cursors", func(t *testing.T) {
+		stream, headers := setup(&EventTypeStreamOptions{
+			InitialCursors: []Cursor{
+				{Partition: "1", Offset: "001-0001-000000000000000001"},
+				{Partition: "0", Offset: "001-0001-000000000000000002"}}})
+		defer stream.Close()
+
+		assert.Equal(t, `[{"partition":"0","offset":"001-0001-000000000000000002"},{"partition":"1","offset":"001-0001-000000000000000001"}]`, <-headers)
+	})
+
+	t.Run("close stream", func(t *testing.T) {
+		stream, _ := setup(nil)
+		stream.Close()
+
+		_, _, err := stream.NextEvents()
+		require.Error(t, err)
+	})
+}
+
+func TestClient_ExtractRange(t *testing.T) {
+	url := defaultNakadiURL + "/event-types/test-event/events"
+	batches := `{"cursor":{"partition":"0","offset":"001-0001-000000000000000003"},"events":[{"metadata":{"eid":"1"}}]}
+{"cursor":{"partition":"1","offset":"001-0001-000000000000000002"},"events":[{"metadata":{"eid":"2"}}]}
+{"cursor":{"partition":"1","offset":"001-0001-000000000000000002"}}
+{"cursor":{"partition":"0","offset":"001-0001-000000000000000004"},"events":[{"metadata":{"eid":"3"}}]}
+{"cursor":{"partition":"0","offset":"001-0001-000000000000000005"},"events":[{"metadata":{"eid":"4"}}]}
+`
+	from := []Cursor{
+		{Partition: "0", Offset: "001-0001-000000000000000002"},
+		{Partition: "2", Offset: "001-0001-000000000000000007"}}
+	to := []Cursor{
+		{Partition: "0", Offset: "001-0001-000000000000000004"},
+		{Partition: "1", Offset: "001-0001-000000000000000002"},
+		{Partition: "2", Offset: "001-0001-000000000000000007"}}
+
+	setup := func() (*Client, chan string) {
+		transport := httpmock.NewMockTransport()
+		headers := make(chan string, 1)
+		transport.RegisterResponder("GET", `=~^`+url, func(r *http.Request) (*http.Response, error) {
+			select {
+			case headers <- r.Header.Get("X-Nakadi-Cursors"):
+			default:
+			}
+			return httpmock.NewStringResponse(http.StatusOK, batches), nil
+		})
+		return &Client{nakadiURL: defaultNakadiURL, httpStreamClient: &http.Client{Transport: transport}}, headers
+	}
+
+	t.Run("fail handler", func(t *testing.T) {
+		client, _ := setup()
+
+		err := client.ExtractRange(context.Background(), "test-event", from, to, func(StreamBatch) error { return assert.AnError })
+		assert.Equal(t, assert.AnError, err)
+	})
+
+	t.Run("fail persistent stream error", func(t *testing.T) {
+		transport := httpmock.NewMockTransport()
+		transport.RegisterResponder("GET", `=~^`+url, httpmock.NewStringResponder(http.StatusNotFound, testProblemJSON))
+		client := &Client{nakadiURL: defaultNakadiURL, httpStreamClient: &http.Client{Transport: transport}}
+
+		err := client.ExtractRange(context.Background(), "test-event", from, to, func(StreamBatch) error { return nil })
+		require.Error(t, err)
+		assert.Regexp(t, "unable to extract range: .*some problem detail", err)
+		status, ok := ResponseStatus(err)
+		assert.True(t, ok)
+		assert.Equal(t, http.StatusNotFound, status)
+	})
+
+	t.Run("fail canceled context", func(t *testing.T) {
+		transport := httpmock.NewMockTransport()
+		transport.RegisterResponder("GET", `=~^`+url, httpmock.NewStringResponder(http.StatusServiceUnavailable, testProblemJSON))
+		client := &Client{nakadiURL: defaultNakadiURL, httpStreamClient: &http.Client{Transport: transport}}
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		err := client.ExtractRange(ctx, "test-event", from, to, func(StreamBatch) error { return nil })
+		require.Error(t, err)
+		assert.Equal(t, context.DeadlineExceeded, errors.Cause(err))
+	})
+
+	t.Run("success partitions end independently", func(t *testing.T) {
+		client, headers := setup()
+
+		var offsets []string
+		err := client.ExtractRange(context.Background(), "test-event", from, to, func(batch StreamBatch) error {
+			offsets = append(offsets, batch.Cursor.Partition+"/"+batch.Cursor.Offset)
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"0/001-0001-000000000000000003", "1/001-0001-000000000000000002", "0/001-0001-000000000000000004"}, offsets)
+		assert.Equal(t, `[{"partition":"0","offset":"001-0001-000000000000000002"},{"partition":"1","offset":"BEGIN"}]`, <-headers)
+	})
+
+	t.Run("success nothing to extract", func(t *testing.T) {
+		err := (&Client{}).ExtractRange(context.Background(), "test-event", to, to, func(StreamBatch) error {
+			assert.Fail(t, "handler must not be called")
+			return nil
+		})
+		assert.NoError(t, err)
+	})
+}
+
+func TestSimpleStreamOpener_eventTypeStreamURL(t *testing.T) {
+	opener := &simpleStreamOpener{
+		client:               &Client{nakadiURL: defaultNakadiURL},
+		eventType:            "test-event",
+		batchLimit:           10,
+		flushTimeout:         5,
+		streamKeepAliveLimit: 3}
+
+	assert.Equal(t, defaultNakadiURL+"/event-types/test-event/events?batch_flush_timeout=5&batch_limit=10&stream_keep_alive_limit=3",
+		opener.eventTypeStreamURL())
+}
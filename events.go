@@ -1,28 +1,97 @@
 package nakadi
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"reflect"
 	"time"
 
 	"github.com/pkg/errors"
 )
 
+// Compatibility modes which control how the evolution of event type schemas is checked by Nakadi.
+const (
+	CompatibilityModeNone       = "none"
+	CompatibilityModeForward    = "forward"
+	CompatibilityModeCompatible = "compatible"
+)
+
+// Audiences which describe the intended target audience of an event type.
+const (
+	AudienceComponentInternal    = "component-internal"
+	AudienceBusinessUnitInternal = "business-unit-internal"
+	AudienceCompanyInternal      = "company-internal"
+	AudienceExternalPartner      = "external-partner"
+	AudienceExternalPublic       = "external-public"
+)
+
+// EnrichmentStrategyMetadata is the enrichment strategy which lets Nakadi populate the metadata of events, e.g.
+// received_at. Nakadi requires it for event types of the categories "business" and "data".
+const EnrichmentStrategyMetadata = "metadata_enrichment"
+
+// Cleanup policies which determine how Nakadi removes events of an event type from its storage.
+const (
+	CleanupPolicyDelete           = "delete"
+	CleanupPolicyCompact          = "compact"
+	CleanupPolicyCompactAndDelete = "compact_and_delete"
+)
+
 // An EventType defines a kind of event that can be processed on a Nakadi service.
 type EventType struct {
-	Name                 string               `json:"name"`
-	OwningApplication    string               `json:"owning_application"`
-	Category             string               `json:"category"`
-	EnrichmentStrategies []string             `json:"enrichment_strategies,omitempty"`
-	PartitionStrategy    string               `json:"partition_strategy,omitempty"`
-	CompatibilityMode    string               `json:"compatibility_mode,omitempty"`
-	Schema               *EventTypeSchema     `json:"schema"`
-	PartitionKeyFields   []string             `json:"partition_key_fields"`
-	DefaultStatistics    *EventTypeStatistics `json:"default_statistics,omitempty"`
-	Options              *EventTypeOptions    `json:"options,omitempty"`
-	CreatedAt            time.Time            `json:"created_at,omitempty"`
-	UpdatedAt            time.Time            `json:"updated_at,omitempty"`
+	Name                 string                  `json:"name"`
+	OwningApplication    string                  `json:"owning_application"`
+	Category             string                  `json:"category"`
+	EnrichmentStrategies []string                `json:"enrichment_strategies,omitempty"`
+	PartitionStrategy    string                  `json:"partition_strategy,omitempty"`
+	CompatibilityMode    string                  `json:"compatibility_mode,omitempty"`
+	Audience             string                  `json:"audience,omitempty"`
+	CleanupPolicy        string                  `json:"cleanup_policy,omitempty"`
+	EventOwnerSelector   *EventOwnerSelector     `json:"event_owner_selector,omitempty"`
+	Schema               *EventTypeSchema        `json:"schema"`
+	PartitionKeyFields   []string                `json:"partition_key_fields"`
+	DefaultStatistics    *EventTypeStatistics    `json:"default_statistics,omitempty"`
+	Options              *EventTypeOptions       `json:"options,omitempty"`
+	Authorization        *EventTypeAuthorization `json:"authorization,omitempty"`
+	CreatedAt            time.Time               `json:"created_at,omitempty"`
+	UpdatedAt            time.Time               `json:"updated_at,omitempty"`
+}
+
+// MarshalJSON encodes the event type without the fields created_at and updated_at if they are zero. Both are
+// set by Nakadi and only decoded from its responses, e.g. in order to detect whether an event type was changed.
+func (e EventType) MarshalJSON() ([]byte, error) {
+	type eventType EventType
+	return json.Marshal(struct {
+		eventType
+		CreatedAt *time.Time `json:"created_at,omitempty"`
+		UpdatedAt *time.Time `json:"updated_at,omitempty"`
+	}{eventType(e), nonZeroTime(e.CreatedAt), nonZeroTime(e.UpdatedAt)})
+}
+
+// nonZeroTime returns nil for the zero time, so that it can be omitted when encoded as json.
+func nonZeroTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// EventOwnerSelector describes how Nakadi determines the owner of single events of an event type. The
+// type is either "path", in which case value is the path of the field in the events, or "static".
+type EventOwnerSelector struct {
+	Type  string `json:"type"`
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// EventTypeAuthorization lists the attributes of the clients which are allowed to administer, read from and
+// publish to an event type. Without authorization the event type is accessible by all clients.
+type EventTypeAuthorization struct {
+	Admins  []AuthorizationAttribute `json:"admins"`
+	Readers []AuthorizationAttribute `json:"readers"`
+	Writers []AuthorizationAttribute `json:"writers"`
 }
 
 // EventTypeSchema is a non optional description of the schema on an event type.
@@ -33,6 +102,15 @@ type EventTypeSchema struct {
 	CreatedAt time.Time `json:"created_at,omitempty"`
 }
 
+// MarshalJSON encodes the schema without the field created_at if it is zero.
+func (s EventTypeSchema) MarshalJSON() ([]byte, error) {
+	type eventTypeSchema EventTypeSchema
+	return json.Marshal(struct {
+		eventTypeSchema
+		CreatedAt *time.Time `json:"created_at,omitempty"`
+	}{eventTypeSchema(s), nonZeroTime(s.CreatedAt)})
+}
+
 // EventTypeStatistics describe operational statistics for an event type. This statistics are
 // used by Nakadi to optimize the throughput events from a certain kind. They are provided on
 // event type creation.
@@ -64,6 +142,15 @@ type EventOptions struct {
 	// Once this value was reached the exponential backoff is halted and the request will
 	// fail with an error.
 	MaxElapsedTime time.Duration
+	// Whether or not Create skips the local validation of the partition strategy. Set to true in
+	// order to use partition strategies which are unknown to this client (default: false).
+	SkipValidation bool
+	// Whether or not Ensure updates an existing event type whose definition differs from the given one. Only
+	// the fields which are set in the given event type are compared and updated (default: false).
+	UpdateOnEnsure bool
+	// Whether or not Create and Update check the schema of event types with ValidateSchema before sending them
+	// to Nakadi. Only schemas of the type json_schema are checked (default: false).
+	ValidateSchema bool
 }
 
 func (o *EventOptions) withDefaults() *EventOptions {
@@ -95,19 +182,25 @@ func NewEventAPI(client *Client, options *EventOptions) *EventAPI {
 			Retry:                options.Retry,
 			InitialRetryInterval: options.InitialRetryInterval,
 			MaxRetryInterval:     options.MaxRetryInterval,
-			MaxElapsedTime:       options.MaxElapsedTime}}
+			MaxElapsedTime:       options.MaxElapsedTime},
+		skipValidation: options.SkipValidation,
+		updateOnEnsure: options.UpdateOnEnsure,
+		validateSchema: options.ValidateSchema}
 }
 
 // EventAPI is a sub API that allows to inspect and manage event types on a Nakadi instance.
 type EventAPI struct {
-	client      *Client
-	backOffConf backOffConfiguration
+	client         *Client
+	backOffConf    backOffConfiguration
+	skipValidation bool
+	updateOnEnsure bool
+	validateSchema bool
 }
 
 // List returns all registered event types.
 func (e *EventAPI) List() ([]*EventType, error) {
 	eventTypes := []*EventType{}
-	err := e.client.httpGET(e.backOffConf.create(), e.eventBaseURL(), &eventTypes, "unable to request event types")
+	err := e.client.httpGET(context.Background(), e.backOffConf.create(), e.eventBaseURL(), &eventTypes, "unable to request event types")
 	if err != nil {
 		return nil, err
 	}
@@ -117,18 +210,41 @@ func (e *EventAPI) List() ([]*EventType, error) {
 // Get returns an event type based on its name.
 func (e *EventAPI) Get(name string) (*EventType, error) {
 	eventType := &EventType{}
-	err := e.client.httpGET(e.backOffConf.create(), e.eventURL(name), eventType, "unable to request event types")
+	err := e.client.httpGET(context.Background(), e.backOffConf.create(), e.eventURL(name), eventType, "unable to request event types")
 	if err != nil {
 		return nil, err
 	}
 	return eventType, nil
 }
 
-// Create saves a new event type.
+// ErrEventTypeExists is the cause of errors returned when Nakadi refuses to create an event type with status 409,
+// because an event type with the same name already exists.
+var ErrEventTypeExists = errors.New("event type already exists")
+
+// Create saves a new event type. If the enrichment strategies of event types of the categories "business"
+// and "data" are nil, the event type is created with EnrichmentStrategyMetadata. Set the enrichment strategies
+// to an empty slice in order to create the event type without enrichment strategies. If the event type already
+// exists the cause of the returned error is ErrEventTypeExists, use Ensure in order to create event types only
+// if they don't exist.
 func (e *EventAPI) Create(eventType *EventType) error {
 	const errMsg = "unable to create event type"
 
-	response, err := e.client.httpPOST(e.backOffConf.create(), e.eventBaseURL(), eventType, errMsg)
+	if eventType.EnrichmentStrategies == nil && (eventType.Category == "business" || eventType.Category == "data") {
+		withDefaults := *eventType
+		withDefaults.EnrichmentStrategies = []string{EnrichmentStrategyMetadata}
+		eventType = &withDefaults
+	}
+
+	if !e.skipValidation {
+		if err := validatePartitionStrategy(eventType); err != nil {
+			return errors.Wrap(err, errMsg)
+		}
+	}
+	if err := e.checkSchema(eventType); err != nil {
+		return errors.Wrap(err, errMsg)
+	}
+
+	response, err := e.client.httpPOST(context.Background(), e.backOffConf.create(), e.eventBaseURL(), eventType, errMsg)
 	if err != nil {
 		return err
 	}
@@ -139,7 +255,11 @@ func (e *EventAPI) Create(eventType *EventType) error {
 		if err != nil {
 			return errors.Wrapf(err, "%s: unable to read response body", errMsg)
 		}
-		return decodeResponseToError(buffer, errMsg)
+		err = decodeResponseToError(response.StatusCode, buffer, errMsg)
+		if response.StatusCode == http.StatusConflict {
+			return withSentinel(ErrEventTypeExists, err)
+		}
+		return err
 	}
 
 	return nil
@@ -149,7 +269,11 @@ func (e *EventAPI) Create(eventType *EventType) error {
 func (e *EventAPI) Update(eventType *EventType) error {
 	const errMsg = "unable to update event type"
 
-	response, err := e.client.httpPUT(e.backOffConf.create(), e.eventURL(eventType.Name), eventType, errMsg)
+	if err := e.checkSchema(eventType); err != nil {
+		return errors.Wrap(err, errMsg)
+	}
+
+	response, err := e.client.httpPUT(context.Background(), e.backOffConf.create(), e.eventURL(eventType.Name), eventType, errMsg)
 	if err != nil {
 		return err
 	}
@@ -160,15 +284,305 @@ func (e *EventAPI) Update(eventType *EventType) error {
 		if err != nil {
 			return errors.Wrapf(err, "%s: unable to read response body", errMsg)
 		}
-		return decodeResponseToError(buffer, "unable to update event type")
+		return decodeResponseToError(response.StatusCode, buffer, "unable to update event type")
 	}
 
 	return nil
 }
 
-// Delete removes an event type.
+// checkSchema validates the json schema of the event type if the EventAPI validates schemas.
+func (e *EventAPI) checkSchema(eventType *EventType) error {
+	if !e.validateSchema || eventType.Schema == nil || eventType.Schema.Type != "json_schema" {
+		return nil
+	}
+	return ValidateSchema(eventType.Schema.Schema)
+}
+
+// EnsureEventType returns the event type with the name of the given event type and creates it if it does not
+// exist. The second return value is true if the event type was created. Existing event types are returned
+// unchanged, use EventAPI.Ensure with EventOptions.UpdateOnEnsure in order to update them.
+func (c *Client) EnsureEventType(eventType *EventType) (*EventType, bool, error) {
+	return NewEventAPI(c, nil).Ensure(eventType)
+}
+
+// Ensure returns the event type with the name of the given event type and creates it if it does not exist.
+// The second return value is true if the event type was created. If UpdateOnEnsure is set and an existing
+// event type differs from the given one, it is updated. Only the fields which are set in the given event type
+// are compared, fields like CreatedAt or the version of the schema, which are managed by Nakadi, are ignored.
+// This way an unchanged definition never causes an update which Nakadi could reject, e.g. because of the
+// compatibility mode.
+func (e *EventAPI) Ensure(eventType *EventType) (*EventType, bool, error) {
+	const errMsg = "unable to ensure event type"
+
+	existing, err := e.Get(eventType.Name)
+	if isNotFound(err) {
+		if err := e.Create(eventType); err != nil {
+			return nil, false, errors.Wrap(err, errMsg)
+		}
+		created := *eventType
+		return &created, true, nil
+	}
+	if err != nil {
+		return nil, false, errors.Wrap(err, errMsg)
+	}
+
+	if !e.updateOnEnsure {
+		return existing, false, nil
+	}
+	updated, changed := mergeEventType(existing, eventType)
+	if !changed {
+		return existing, false, nil
+	}
+	if err := e.Update(updated); err != nil {
+		return nil, false, errors.Wrap(err, errMsg)
+	}
+	return updated, false, nil
+}
+
+// mergeEventType returns a copy of the existing event type with all fields set in the desired event type and
+// reports whether any of these fields differs from the existing definition.
+func mergeEventType(existing, desired *EventType) (*EventType, bool) {
+	merged := *existing
+	changed := false
+	mergeString := func(target *string, value string) {
+		if value != "" && value != *target {
+			*target = value
+			changed = true
+		}
+	}
+	differs := func(isSet bool, current, value interface{}) bool {
+		if isSet && !reflect.DeepEqual(current, value) {
+			changed = true
+			return true
+		}
+		return false
+	}
+
+	mergeString(&merged.OwningApplication, desired.OwningApplication)
+	mergeString(&merged.Category, desired.Category)
+	mergeString(&merged.PartitionStrategy, desired.PartitionStrategy)
+	mergeString(&merged.CompatibilityMode, desired.CompatibilityMode)
+	mergeString(&merged.Audience, desired.Audience)
+	mergeString(&merged.CleanupPolicy, desired.CleanupPolicy)
+	if differs(desired.EnrichmentStrategies != nil, merged.EnrichmentStrategies, desired.EnrichmentStrategies) {
+		merged.EnrichmentStrategies = desired.EnrichmentStrategies
+	}
+	if differs(desired.PartitionKeyFields != nil, merged.PartitionKeyFields, desired.PartitionKeyFields) {
+		merged.PartitionKeyFields = desired.PartitionKeyFields
+	}
+	if differs(desired.EventOwnerSelector != nil, merged.EventOwnerSelector, desired.EventOwnerSelector) {
+		merged.EventOwnerSelector = desired.EventOwnerSelector
+	}
+	if differs(desired.DefaultStatistics != nil, merged.DefaultStatistics, desired.DefaultStatistics) {
+		merged.DefaultStatistics = desired.DefaultStatistics
+	}
+	if differs(desired.Options != nil, merged.Options, desired.Options) {
+		merged.Options = desired.Options
+	}
+	if differs(desired.Authorization != nil, merged.Authorization, desired.Authorization) {
+		merged.Authorization = desired.Authorization
+	}
+
+	if desired.Schema != nil && (existing.Schema == nil || existing.Schema.Type != desired.Schema.Type ||
+		!equalJSON(existing.Schema.Schema, desired.Schema.Schema)) {
+		merged.Schema = &EventTypeSchema{Type: desired.Schema.Type, Schema: desired.Schema.Schema}
+		changed = true
+	}
+
+	return &merged, changed
+}
+
+// equalJSON compares two json documents semantically, so that the formatting of both is ignored.
+func equalJSON(a, b string) bool {
+	var decodedA, decodedB interface{}
+	if json.Unmarshal([]byte(a), &decodedA) != nil || json.Unmarshal([]byte(b), &decodedB) != nil {
+		return a == b
+	}
+	return reflect.DeepEqual(decodedA, decodedB)
+}
+
+// EventTypeDiff compares two event type definitions and returns the json names of the fields which differ,
+// e.g. in order to skip updates which would not change anything or to log what an update changes. Fields of
+// the schema and the authorization are reported by their path, like "schema.schema" or "authorization.readers".
+// Fields populated by Nakadi, i.e. the timestamps and the version of the schema, are ignored. Schemas are
+// compared semantically, authorization attributes regardless of their order and an empty partition strategy
+// equals "random". A nil event type is compared like an empty one.
+func EventTypeDiff(a, b *EventType) []string {
+	if a == nil {
+		a = &EventType{}
+	}
+	if b == nil {
+		b = &EventType{}
+	}
+
+	var diff []string
+	compare := func(name string, equal bool) {
+		if !equal {
+			diff = append(diff, name)
+		}
+	}
+
+	compare("name", a.Name == b.Name)
+	compare("owning_application", a.OwningApplication == b.OwningApplication)
+	compare("category", a.Category == b.Category)
+	compare("enrichment_strategies", equalStrings(a.EnrichmentStrategies, b.EnrichmentStrategies))
+	compare("partition_strategy", a.Strategy() == b.Strategy() && (a.Strategy() != PartitionStrategyCustom ||
+		a.PartitionStrategy == b.PartitionStrategy))
+	compare("compatibility_mode", a.CompatibilityMode == b.CompatibilityMode)
+	compare("audience", a.Audience == b.Audience)
+	compare("cleanup_policy", a.CleanupPolicy == b.CleanupPolicy)
+	compare("event_owner_selector", reflect.DeepEqual(a.EventOwnerSelector, b.EventOwnerSelector))
+
+	switch {
+	case a.Schema == nil || b.Schema == nil:
+		compare("schema", a.Schema == b.Schema)
+	default:
+		compare("schema.type", a.Schema.Type == b.Schema.Type)
+		compare("schema.schema", equalJSON(a.Schema.Schema, b.Schema.Schema))
+	}
+
+	compare("partition_key_fields", equalStrings(a.PartitionKeyFields, b.PartitionKeyFields))
+	compare("default_statistics", reflect.DeepEqual(a.DefaultStatistics, b.DefaultStatistics))
+	compare("options", reflect.DeepEqual(a.Options, b.Options))
+
+	switch {
+	case a.Authorization == nil || b.Authorization == nil:
+		compare("authorization", a.Authorization == b.Authorization)
+	default:
+		compare("authorization.admins", equalAttributes(a.Authorization.Admins, b.Authorization.Admins))
+		compare("authorization.readers", equalAttributes(a.Authorization.Readers, b.Authorization.Readers))
+		compare("authorization.writers", equalAttributes(a.Authorization.Writers, b.Authorization.Writers))
+	}
+
+	return diff
+}
+
+// equalStrings compares two lists of strings in order. Nil and empty lists are equal.
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// equalAttributes compares two lists of authorization attributes regardless of their order.
+func equalAttributes(a, b []AuthorizationAttribute) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	counts := make(map[AuthorizationAttribute]int, len(a))
+	for _, attribute := range a {
+		counts[attribute]++
+	}
+	for _, attribute := range b {
+		if counts[attribute] == 0 {
+			return false
+		}
+		counts[attribute]--
+	}
+	return true
+}
+
+// ErrEventTypeInUse is the cause of errors returned when an event type can't be deleted because it is
+// still used, e.g. by subscriptions reading from it.
+var ErrEventTypeInUse = errors.New("event type is in use")
+
+// Delete removes an event type. If Nakadi refuses to delete the event type because it is still in use the
+// cause of the returned error is ErrEventTypeInUse.
 func (e *EventAPI) Delete(name string) error {
-	return e.client.httpDELETE(e.backOffConf.create(), e.eventURL(name), "unable to delete event type")
+	const errMsg = "unable to delete event type"
+
+	response, err := e.client.httpDELETEResponse(context.Background(), e.backOffConf.create(), e.eventURL(name), errMsg)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusNoContent {
+		buffer, err := ioutil.ReadAll(response.Body)
+		if err != nil {
+			return errors.Wrapf(err, "%s: unable to read response body", errMsg)
+		}
+		err = decodeResponseToError(response.StatusCode, buffer, errMsg)
+		if response.StatusCode == http.StatusConflict {
+			return withSentinel(ErrEventTypeInUse, err)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// DeleteForce removes an event type along with all subscriptions which read from it. Use with care: the
+// subscriptions are deleted even if they also read from other event types and all offsets committed to
+// them are lost. Subscriptions are deleted one after another, if an error occurs the remaining
+// subscriptions and the event type are left untouched.
+func (e *EventAPI) DeleteForce(name string) error {
+	subAPI := &SubscriptionAPI{client: e.client, backOffConf: e.backOffConf}
+
+	subscriptions, err := subAPI.ListFiltered(&SubscriptionFilter{EventTypes: []string{name}})
+	if err != nil {
+		return errors.Wrap(err, "unable to delete subscriptions of event type")
+	}
+	for _, sub := range subscriptions {
+		if err := subAPI.Delete(sub.ID); err != nil {
+			return errors.Wrap(err, "unable to delete subscriptions of event type")
+		}
+	}
+
+	return e.Delete(name)
+}
+
+// EventTypePartition describes the offsets currently available in a single partition of an event type.
+type EventTypePartition struct {
+	Partition             string `json:"partition"`
+	OldestAvailableOffset string `json:"oldest_available_offset"`
+	NewestAvailableOffset string `json:"newest_available_offset"`
+	UnconsumedEvents      int    `json:"unconsumed_events"`
+}
+
+// Partitions returns the partitions of an event type along with their available offsets.
+func (e *EventAPI) Partitions(name string) ([]*EventTypePartition, error) {
+	return e.partitions(context.Background(), name)
+}
+
+// partitions requests the partitions of an event type like Partitions, bound to the given context.
+func (e *EventAPI) partitions(ctx context.Context, name string) ([]*EventTypePartition, error) {
+	partitions := []*EventTypePartition{}
+	err := e.client.httpGET(ctx, e.backOffConf.create(), e.eventURL(name)+"/partitions", &partitions, "unable to request partitions")
+	if err != nil {
+		return nil, err
+	}
+	return partitions, nil
+}
+
+// eventTypeResponse decodes an event type from a response of Nakadi. Nakadi names the statistics of an event
+// type default_statistic, which is accepted in addition to the field default_statistics of EventType.
+type eventTypeResponse struct {
+	*EventType
+	DefaultStatistic *EventTypeStatistics `json:"default_statistic,omitempty"`
+}
+
+// GetEventTypeStatistics returns the statistics of the event type with the given name, which can be used to
+// decide how many consumers to run for a subscription of the event type. Nakadi does not expose observed write
+// rates of event types, therefore the statistics configured when the event type was created are returned.
+// If the event type has no statistics, GetEventTypeStatistics returns nil without an error.
+func (c *Client) GetEventTypeStatistics(name string) (*EventTypeStatistics, error) {
+	eventAPI := NewEventAPI(c, nil)
+	response := &eventTypeResponse{EventType: &EventType{}}
+	err := c.httpGET(context.Background(), eventAPI.backOffConf.create(), eventAPI.eventURL(name), response, "unable to request event type statistics")
+	if err != nil {
+		return nil, err
+	}
+	if response.DefaultStatistic != nil {
+		return response.DefaultStatistic, nil
+	}
+	return response.DefaultStatistics, nil
 }
 
 func (e *EventAPI) eventURL(name string) string {
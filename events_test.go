@@ -9,6 +9,7 @@ import (
 	"time"
 
 	"github.com/jarcoal/httpmock"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -22,6 +23,23 @@ func TestEventType_Marshal(t *testing.T) {
 	assert.JSONEq(t, string(expected), string(serialized))
 }
 
+func TestEventType_MarshalOmitEmpty(t *testing.T) {
+	eventType := &EventType{Name: "test-event.change", OwningApplication: "test-application", Category: "data",
+		Schema: &EventTypeSchema{Type: "json_schema", Schema: "{}"}}
+
+	serialized, err := json.Marshal(eventType)
+	require.NoError(t, err)
+
+	fields := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(serialized, &fields))
+	assert.NotContains(t, fields, "audience")
+	assert.NotContains(t, fields, "event_owner_selector")
+	assert.NotContains(t, fields, "compatibility_mode")
+	assert.NotContains(t, fields, "created_at")
+	assert.NotContains(t, fields, "updated_at")
+	assert.NotContains(t, fields["schema"], "created_at")
+}
+
 func TestEventAPI_Get(t *testing.T) {
 	httpmock.Activate()
 	defer httpmock.DeactivateAndReset()
@@ -71,6 +89,10 @@ func TestEventAPI_Get(t *testing.T) {
 		requested, err := api.Get(expected.Name)
 		require.NoError(t, err)
 		assert.Equal(t, expected, requested)
+
+		zone := time.FixedZone("", 2*60*60)
+		assert.True(t, time.Date(2017, 8, 7, 22, 53, 3, 0, zone).Equal(requested.CreatedAt))
+		assert.True(t, time.Date(2017, 8, 8, 22, 53, 3, 0, zone).Equal(requested.UpdatedAt))
 	})
 }
 
@@ -126,6 +148,85 @@ func TestEventAPI_List(t *testing.T) {
 	})
 }
 
+func TestEventTypePartition_Marshal(t *testing.T) {
+	partitions := []*EventTypePartition{}
+	expected := helperLoadTestData(t, "event-type-partitions.json", &partitions)
+
+	serialized, err := json.Marshal(partitions)
+	require.NoError(t, err)
+	assert.JSONEq(t, string(expected), string(serialized))
+}
+
+func TestEventAPI_Partitions(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	expected := []*EventTypePartition{}
+	serialized := helperLoadTestData(t, "event-type-partitions.json", &expected)
+
+	client := &Client{nakadiURL: defaultNakadiURL, httpClient: http.DefaultClient}
+	api := NewEventAPI(client, nil)
+	url := fmt.Sprintf("%s/event-types/%s/partitions", defaultNakadiURL, "test-event.change")
+
+	t.Run("fail connection error", func(t *testing.T) {
+		httpmock.RegisterResponder("GET", url, httpmock.NewErrorResponder(assert.AnError))
+
+		_, err := api.Partitions("test-event.change")
+		require.Error(t, err)
+		assert.Regexp(t, assert.AnError, err)
+	})
+
+	t.Run("fail with problem", func(t *testing.T) {
+		httpmock.RegisterResponder("GET", url, httpmock.NewStringResponder(http.StatusNotFound, testProblemJSON))
+
+		_, err := api.Partitions("test-event.change")
+		require.Error(t, err)
+		assert.Regexp(t, "unable to request partitions: some problem detail", err)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		httpmock.RegisterResponder("GET", url, httpmock.NewBytesResponder(http.StatusOK, serialized))
+
+		partitions, err := api.Partitions("test-event.change")
+		require.NoError(t, err)
+		assert.Equal(t, expected, partitions)
+	})
+}
+
+func TestClient_GetEventTypeStatistics(t *testing.T) {
+	transport := httpmock.NewMockTransport()
+	client := &Client{nakadiURL: defaultNakadiURL, httpClient: &http.Client{Transport: transport}, strictDecode: true}
+	url := fmt.Sprintf("%s/event-types/%s", defaultNakadiURL, "test-event.change")
+	statistics := &EventTypeStatistics{MessagesPerMinute: 6000, MessageSize: 1024, ReadParallelism: 4, WriteParallelism: 8}
+
+	t.Run("fail with problem", func(t *testing.T) {
+		transport.RegisterResponder("GET", url, httpmock.NewStringResponder(http.StatusNotFound, testProblemJSON))
+
+		_, err := client.GetEventTypeStatistics("test-event.change")
+		require.Error(t, err)
+		assert.Regexp(t, "unable to request event type statistics: some problem detail", err)
+	})
+
+	t.Run("success without statistics", func(t *testing.T) {
+		transport.RegisterResponder("GET", url, httpmock.NewStringResponder(http.StatusOK, `{"name":"test-event.change"}`))
+
+		requested, err := client.GetEventTypeStatistics("test-event.change")
+		require.NoError(t, err)
+		assert.Nil(t, requested)
+	})
+
+	for _, field := range []string{"default_statistic", "default_statistics"} {
+		t.Run("success "+field, func(t *testing.T) {
+			transport.RegisterResponder("GET", url, httpmock.NewStringResponder(http.StatusOK, `{"name":"test-event.change","`+field+`":
+				{"messages_per_minute":6000,"message_size":1024,"read_parallelism":4,"write_parallelism":8}}`))
+
+			requested, err := client.GetEventTypeStatistics("test-event.change")
+			require.NoError(t, err)
+			assert.Equal(t, statistics, requested)
+		})
+	}
+}
+
 func TestEventAPI_Create(t *testing.T) {
 	httpmock.Activate()
 	defer httpmock.DeactivateAndReset()
@@ -149,11 +250,24 @@ func TestEventAPI_Create(t *testing.T) {
 	})
 
 	t.Run("fail with problem", func(t *testing.T) {
-		httpmock.RegisterResponder("POST", url, httpmock.NewStringResponder(http.StatusConflict, testProblemJSON))
+		httpmock.RegisterResponder("POST", url, httpmock.NewStringResponder(http.StatusUnprocessableEntity, testProblemJSON))
 
 		err := api.Create(eventType)
 		require.Error(t, err)
 		assert.Regexp(t, "some problem detail", err)
+		assert.NotEqual(t, ErrEventTypeExists, errors.Cause(err))
+	})
+
+	t.Run("fail event type exists", func(t *testing.T) {
+		httpmock.RegisterResponder("POST", url, httpmock.NewStringResponder(http.StatusConflict, testProblemJSON))
+
+		err := api.Create(eventType)
+		require.Error(t, err)
+		assert.Regexp(t, "unable to create event type: some problem detail", err)
+		assert.Equal(t, ErrEventTypeExists, errors.Cause(err))
+		status, ok := ResponseStatus(err)
+		assert.True(t, ok)
+		assert.Equal(t, http.StatusConflict, status)
 	})
 
 	t.Run("fail to read body", func(t *testing.T) {
@@ -169,6 +283,33 @@ func TestEventAPI_Create(t *testing.T) {
 		assert.Regexp(t, "unable to read response body", err)
 	})
 
+	t.Run("fail unknown partition strategy", func(t *testing.T) {
+		invalid := *eventType
+		invalid.PartitionStrategy = "round_robin"
+
+		err := api.Create(&invalid)
+		require.Error(t, err)
+		assert.Regexp(t, `unable to create event type: unknown partition strategy "round_robin"`, err)
+	})
+
+	t.Run("fail hash without partition key fields", func(t *testing.T) {
+		invalid := *eventType
+		invalid.PartitionKeyFields = nil
+
+		err := api.Create(&invalid)
+		require.Error(t, err)
+		assert.Regexp(t, "requires partition key fields", err)
+	})
+
+	t.Run("success skip validation", func(t *testing.T) {
+		httpmock.RegisterResponder("POST", url, httpmock.NewStringResponder(http.StatusCreated, ""))
+		unknown := *eventType
+		unknown.PartitionStrategy = "round_robin"
+
+		err := NewEventAPI(client, &EventOptions{SkipValidation: true}).Create(&unknown)
+		require.NoError(t, err)
+	})
+
 	t.Run("success", func(t *testing.T) {
 		httpmock.RegisterResponder("POST", url, httpmock.Responder(func(r *http.Request) (*http.Response, error) {
 			uploaded := &EventType{}
@@ -181,6 +322,70 @@ func TestEventAPI_Create(t *testing.T) {
 		err := api.Create(eventType)
 		require.NoError(t, err)
 	})
+
+	enrichmentTests := []struct {
+		Name       string
+		Category   string
+		Strategies []string
+		Expected   []string
+	}{
+		{Name: "default for data", Category: "data", Expected: []string{EnrichmentStrategyMetadata}},
+		{Name: "default for business", Category: "business", Expected: []string{EnrichmentStrategyMetadata}},
+		{Name: "no default for undefined", Category: "undefined"},
+		{Name: "explicit empty", Category: "data", Strategies: []string{}},
+		{Name: "explicit", Category: "data", Strategies: []string{"other"}, Expected: []string{"other"}},
+	}
+
+	for _, tt := range enrichmentTests {
+		t.Run("success enrichment strategies "+tt.Name, func(t *testing.T) {
+			httpmock.RegisterResponder("POST", url, httpmock.Responder(func(r *http.Request) (*http.Response, error) {
+				uploaded := &EventType{}
+				err := json.NewDecoder(r.Body).Decode(uploaded)
+				require.NoError(t, err)
+				assert.Equal(t, tt.Expected, uploaded.EnrichmentStrategies)
+				return httpmock.NewStringResponse(http.StatusCreated, ""), nil
+			}))
+			created := *eventType
+			created.Category = tt.Category
+			created.EnrichmentStrategies = tt.Strategies
+
+			err := api.Create(&created)
+			require.NoError(t, err)
+			assert.Equal(t, tt.Strategies, created.EnrichmentStrategies)
+		})
+	}
+}
+
+func TestEventAPI_ValidateSchema(t *testing.T) {
+	transport := httpmock.NewMockTransport()
+	client := &Client{nakadiURL: defaultNakadiURL, httpClient: &http.Client{Transport: transport}}
+	api := NewEventAPI(client, &EventOptions{ValidateSchema: true})
+	transport.RegisterResponder("POST", defaultNakadiURL+"/event-types", httpmock.NewStringResponder(http.StatusCreated, ""))
+	transport.RegisterResponder("PUT", defaultNakadiURL+"/event-types/test-event", httpmock.NewStringResponder(http.StatusOK, ""))
+	eventType := func(schema string) *EventType {
+		return &EventType{Name: "test-event", Category: "undefined", PartitionStrategy: "random",
+			Schema: &EventTypeSchema{Type: "json_schema", Schema: schema}}
+	}
+
+	t.Run("fail invalid schema", func(t *testing.T) {
+		err := api.Create(eventType(`{"type":"text"}`))
+		require.Error(t, err)
+		assert.Regexp(t, `unable to create event type: invalid schema: schema at #: unknown type "text"`, err)
+
+		err = api.Update(eventType(`{"type":`))
+		require.Error(t, err)
+		assert.Regexp(t, "unable to update event type: invalid schema: invalid json at offset", err)
+		assert.Equal(t, 0, transport.GetTotalCallCount())
+	})
+
+	t.Run("success valid schema", func(t *testing.T) {
+		require.NoError(t, api.Create(eventType(`{"type":"object"}`)))
+		require.NoError(t, api.Update(eventType(`{"type":"object"}`)))
+	})
+
+	t.Run("success without validation", func(t *testing.T) {
+		require.NoError(t, NewEventAPI(client, nil).Create(eventType(`{"type":"text"}`)))
+	})
 }
 
 func TestEventAPI_Update(t *testing.T) {
@@ -213,6 +418,15 @@ func TestEventAPI_Update(t *testing.T) {
 		assert.Regexp(t, "some problem detail", err)
 	})
 
+	t.Run("fail incompatible schema", func(t *testing.T) {
+		problem := `{"title": "Unprocessable Entity", "status": 422, "detail": "Invalid schema: schema changes are not compatible"}`
+		httpmock.RegisterResponder("PUT", url, httpmock.NewStringResponder(http.StatusUnprocessableEntity, problem))
+
+		err := api.Update(eventType)
+		require.Error(t, err)
+		assert.Regexp(t, "unable to update event type: Invalid schema: schema changes are not compatible", err)
+	})
+
 	t.Run("fail to read body", func(t *testing.T) {
 		responder := httpmock.ResponderFromResponse(&http.Response{
 			Status:     strconv.Itoa(http.StatusBadRequest),
@@ -240,6 +454,96 @@ func TestEventAPI_Update(t *testing.T) {
 	})
 }
 
+func TestEventAPI_Ensure(t *testing.T) {
+	existing := &EventType{}
+	serialized := helperLoadTestData(t, "event-type-complete.json", existing)
+
+	url := fmt.Sprintf("%s/event-types/%s", defaultNakadiURL, existing.Name)
+	setup := func(updateOnEnsure bool) (*httpmock.MockTransport, *EventAPI) {
+		transport := httpmock.NewMockTransport()
+		client := &Client{nakadiURL: defaultNakadiURL, httpClient: &http.Client{Transport: transport}}
+		return transport, NewEventAPI(client, &EventOptions{UpdateOnEnsure: updateOnEnsure})
+	}
+
+	t.Run("fail get", func(t *testing.T) {
+		transport, api := setup(false)
+		transport.RegisterResponder("GET", url, httpmock.NewStringResponder(http.StatusForbidden, testProblemJSON))
+
+		_, _, err := api.Ensure(existing)
+		require.Error(t, err)
+		assert.Regexp(t, "unable to ensure event type: .*some problem detail", err)
+	})
+
+	t.Run("fail create", func(t *testing.T) {
+		transport, api := setup(false)
+		transport.RegisterResponder("GET", url, httpmock.NewStringResponder(http.StatusNotFound, testProblemJSON))
+		transport.RegisterResponder("POST", defaultNakadiURL+"/event-types", httpmock.NewStringResponder(http.StatusUnprocessableEntity, testProblemJSON))
+
+		_, _, err := api.Ensure(existing)
+		require.Error(t, err)
+		assert.Regexp(t, "unable to ensure event type: unable to create event type: some problem detail", err)
+	})
+
+	t.Run("success created", func(t *testing.T) {
+		transport, api := setup(false)
+		transport.RegisterResponder("GET", url, httpmock.NewStringResponder(http.StatusNotFound, testProblemJSON))
+		transport.RegisterResponder("POST", defaultNakadiURL+"/event-types", httpmock.NewStringResponder(http.StatusCreated, ""))
+
+		eventType, created, err := api.Ensure(existing)
+		require.NoError(t, err)
+		assert.True(t, created)
+		assert.Equal(t, existing, eventType)
+	})
+
+	t.Run("success existing not updated", func(t *testing.T) {
+		transport, api := setup(false)
+		transport.RegisterResponder("GET", url, httpmock.NewBytesResponder(http.StatusOK, serialized))
+
+		changed := *existing
+		changed.Audience = "external-public"
+		eventType, created, err := api.Ensure(&changed)
+		require.NoError(t, err)
+		assert.False(t, created)
+		assert.Equal(t, existing, eventType)
+		assert.Equal(t, 0, transport.GetCallCountInfo()["PUT "+url])
+	})
+
+	t.Run("success unchanged fields not updated", func(t *testing.T) {
+		transport, api := setup(true)
+		transport.RegisterResponder("GET", url, httpmock.NewBytesResponder(http.StatusOK, serialized))
+
+		eventType, created, err := api.Ensure(&EventType{
+			Name:              existing.Name,
+			OwningApplication: existing.OwningApplication,
+			Schema: &EventTypeSchema{
+				Type:   "json_schema",
+				Schema: `{"additionalProperties": true, "properties": {"test": {"type": "string"}}}`}})
+		require.NoError(t, err)
+		assert.False(t, created)
+		assert.Equal(t, existing, eventType)
+		assert.Equal(t, 0, transport.GetCallCountInfo()["PUT "+url])
+	})
+
+	t.Run("success differing fields updated", func(t *testing.T) {
+		transport, api := setup(true)
+		transport.RegisterResponder("GET", url, httpmock.NewBytesResponder(http.StatusOK, serialized))
+		transport.RegisterResponder("PUT", url, func(r *http.Request) (*http.Response, error) {
+			updated := &EventType{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(updated))
+			assert.Equal(t, "external-public", updated.Audience)
+			assert.Equal(t, existing.CompatibilityMode, updated.CompatibilityMode)
+			assert.Equal(t, existing.PartitionKeyFields, updated.PartitionKeyFields)
+			return httpmock.NewStringResponse(http.StatusOK, ""), nil
+		})
+
+		eventType, created, err := api.Ensure(&EventType{Name: existing.Name, Audience: "external-public"})
+		require.NoError(t, err)
+		assert.False(t, created)
+		assert.Equal(t, "external-public", eventType.Audience)
+		assert.Equal(t, 1, transport.GetCallCountInfo()["PUT "+url])
+	})
+}
+
 func TestEventAPI_Delete(t *testing.T) {
 	httpmock.Activate()
 	defer httpmock.DeactivateAndReset()
@@ -274,6 +578,14 @@ func TestEventAPI_Delete(t *testing.T) {
 		assert.Regexp(t, "some problem detail", err)
 	})
 
+	t.Run("fail event type in use", func(t *testing.T) {
+		httpmock.RegisterResponder("DELETE", url, httpmock.NewStringResponder(http.StatusConflict, testProblemJSON))
+
+		err := api.Delete(name)
+		require.Error(t, err)
+		assert.Equal(t, ErrEventTypeInUse, errors.Cause(err))
+	})
+
 	t.Run("success", func(t *testing.T) {
 		httpmock.RegisterResponder("DELETE", url, httpmock.NewStringResponder(http.StatusNoContent, ""))
 
@@ -282,6 +594,61 @@ func TestEventAPI_Delete(t *testing.T) {
 	})
 }
 
+func TestEventAPI_DeleteForce(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	name := "test-event.change"
+
+	client := &Client{nakadiURL: defaultNakadiURL, httpClient: http.DefaultClient}
+	api := NewEventAPI(client, nil)
+	url := fmt.Sprintf("%s/event-types/%s", defaultNakadiURL, name)
+	subsURL := fmt.Sprintf("%s/subscriptions", defaultNakadiURL)
+
+	subscriptions := struct {
+		Items []*Subscription `json:"items"`
+	}{Items: []*Subscription{{ID: "sub-1"}, {ID: "sub-2"}}}
+
+	t.Run("fail list subscriptions", func(t *testing.T) {
+		httpmock.RegisterResponder("GET", subsURL, httpmock.NewStringResponder(http.StatusBadRequest, testProblemJSON))
+
+		err := api.DeleteForce(name)
+		require.Error(t, err)
+		assert.Regexp(t, "unable to delete subscriptions of event type", err)
+	})
+
+	t.Run("fail delete subscription", func(t *testing.T) {
+		httpmock.Reset()
+		responder, _ := httpmock.NewJsonResponder(http.StatusOK, subscriptions)
+		httpmock.RegisterResponder("GET", subsURL, responder)
+		httpmock.RegisterResponder("DELETE", subsURL+"/sub-1", httpmock.NewStringResponder(http.StatusNotFound, testProblemJSON))
+
+		err := api.DeleteForce(name)
+		require.Error(t, err)
+		assert.Regexp(t, "some problem detail", err)
+		assert.Equal(t, 0, httpmock.GetCallCountInfo()["DELETE "+url])
+	})
+
+	t.Run("success", func(t *testing.T) {
+		httpmock.Reset()
+		httpmock.RegisterResponder("GET", subsURL, func(r *http.Request) (*http.Response, error) {
+			assert.Equal(t, name, r.URL.Query().Get("event_type"))
+			return httpmock.NewJsonResponse(http.StatusOK, subscriptions)
+		})
+		httpmock.RegisterResponder("DELETE", subsURL+"/sub-1", httpmock.NewStringResponder(http.StatusNoContent, ""))
+		httpmock.RegisterResponder("DELETE", subsURL+"/sub-2", httpmock.NewStringResponder(http.StatusNoContent, ""))
+		httpmock.RegisterResponder("DELETE", url, httpmock.NewStringResponder(http.StatusNoContent, ""))
+
+		err := api.DeleteForce(name)
+		require.NoError(t, err)
+
+		info := httpmock.GetCallCountInfo()
+		assert.Equal(t, 1, info["DELETE "+subsURL+"/sub-1"])
+		assert.Equal(t, 1, info["DELETE "+subsURL+"/sub-2"])
+		assert.Equal(t, 1, info["DELETE "+url])
+	})
+}
+
 func TestEventOptions_withDefaults(t *testing.T) {
 	tests := []struct {
 		Options  *EventOptions
@@ -334,3 +701,59 @@ func TestEventOptions_withDefaults(t *testing.T) {
 		assert.Equal(t, tt.Expected, tt.Options.withDefaults())
 	}
 }
+
+func TestEventTypeDiff(t *testing.T) {
+	base := func() *EventType {
+		return &EventType{
+			Name:              "test-event",
+			OwningApplication: "test-app",
+			Category:          "data",
+			Schema:            &EventTypeSchema{Type: "json_schema", Schema: `{"properties":{"test":{"type":"string"}}}`},
+			Authorization: &EventTypeAuthorization{
+				Readers: []AuthorizationAttribute{{DataType: "service", Value: "a"}, {DataType: "service", Value: "b"}}}}
+	}
+
+	t.Run("no differences", func(t *testing.T) {
+		a, b := base(), base()
+		b.PartitionStrategy = PartitionStrategyRandom
+		b.PartitionKeyFields = []string{}
+		b.CreatedAt = time.Now()
+		b.UpdatedAt = time.Now()
+		b.Schema.Version = "1.0.0"
+		b.Schema.CreatedAt = time.Now()
+		b.Schema.Schema = `{ "properties": { "test": { "type": "string" } } }`
+		b.Authorization.Readers = []AuthorizationAttribute{{DataType: "service", Value: "b"}, {DataType: "service", Value: "a"}}
+
+		assert.Empty(t, EventTypeDiff(a, b))
+		assert.Empty(t, EventTypeDiff(nil, nil))
+	})
+
+	t.Run("differences", func(t *testing.T) {
+		a, b := base(), base()
+		b.OwningApplication = "other-app"
+		b.PartitionStrategy = PartitionStrategyHash
+		b.PartitionKeyFields = []string{"test"}
+		b.Options = &EventTypeOptions{RetentionTime: 1000}
+		b.Schema.Schema = `{"properties":{"test":{"type":"integer"}}}`
+		b.Authorization.Readers = b.Authorization.Readers[:1]
+		b.Authorization.Writers = []AuthorizationAttribute{{DataType: "service", Value: "a"}}
+
+		assert.Equal(t, []string{
+			"owning_application",
+			"partition_strategy",
+			"schema.schema",
+			"partition_key_fields",
+			"options",
+			"authorization.readers",
+			"authorization.writers"}, EventTypeDiff(a, b))
+	})
+
+	t.Run("missing nested definitions", func(t *testing.T) {
+		a, b := base(), base()
+		b.Schema = nil
+		b.Authorization = nil
+
+		assert.Equal(t, []string{"schema", "authorization"}, EventTypeDiff(a, b))
+		assert.Contains(t, EventTypeDiff(nil, a), "name")
+	})
+}
@@ -0,0 +1,181 @@
+package nakadi
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// FailoverOptions is a set of optional parameters used to configure the FailoverPublishAPI.
+type FailoverOptions struct {
+	// FailureThreshold is the number of consecutive publish calls failing with a connection error after which
+	// the FailoverPublishAPI switches to the next cluster (default: 3).
+	FailureThreshold uint
+	// RecoveryInterval is the interval in which the health of the primary cluster is checked while events are
+	// published to another cluster. Once the primary cluster responds, publishing switches back to it
+	// (default: 30s).
+	RecoveryInterval time.Duration
+}
+
+func (o *FailoverOptions) withDefaults() *FailoverOptions {
+	var copyOptions FailoverOptions
+	if o != nil {
+		copyOptions = *o
+	}
+	if copyOptions.FailureThreshold == 0 {
+		copyOptions.FailureThreshold = defaultFailoverThreshold
+	}
+	if copyOptions.RecoveryInterval == 0 {
+		copyOptions.RecoveryInterval = defaultRecoveryInterval
+	}
+	return &copyOptions
+}
+
+// FailoverPublishAPI publishes events of an event type to one of several Nakadi clusters, e.g. a primary and a
+// secondary cluster for disaster recovery. Events are published to the primary cluster as long as it is
+// reachable. If FailureThreshold consecutive publish calls fail with a connection error, the FailoverPublishAPI
+// switches to the next cluster and publishes the failed batch there again. Errors returned by Nakadi, like
+// rejected events or status 503, don't trigger a failover. While the primary cluster is not used, its health
+// endpoint is requested in the background every RecoveryInterval and publishing switches back once it
+// responds with a status below 500.
+//
+// A failover does not preserve any guarantees across clusters: a batch which failed with a connection error may
+// still have been published to the previous cluster and is then published twice, and events published to
+// different clusters are not ordered with respect to each other. Consumers have to read from all clusters in
+// order to receive all events and should be able to handle duplicates.
+type FailoverPublishAPI struct {
+	clients    []*Client
+	publishers []*PublishAPI
+	eventType  string
+	threshold  uint
+	interval   time.Duration
+	mutex      sync.Mutex
+	active     int
+	failures   uint
+	lastCheck  time.Time
+	checking   bool
+}
+
+// NewFailoverPublishAPI creates a new instance of a FailoverPublishAPI which publishes events of the given event
+// type to the clusters of the clients. The first client is the primary cluster, the others are used in order
+// when the previous one fails. The publish options are used for each of the clients and the failover options
+// may be nil.
+func NewFailoverPublishAPI(clients []*Client, eventType string, options *PublishOptions, failover *FailoverOptions) *FailoverPublishAPI {
+	failover = failover.withDefaults()
+	publishers := make([]*PublishAPI, len(clients))
+	for i, client := range clients {
+		publishers[i] = NewPublishAPI(client, eventType, options)
+	}
+	return &FailoverPublishAPI{
+		clients:    clients,
+		publishers: publishers,
+		eventType:  eventType,
+		threshold:  failover.FailureThreshold,
+		interval:   failover.RecoveryInterval}
+}
+
+// Publish emits a batch of events to the active cluster like PublishAPI.Publish.
+func (f *FailoverPublishAPI) Publish(events interface{}) error {
+	return f.PublishContext(context.Background(), events)
+}
+
+// PublishContext emits a batch of events to the active cluster like PublishAPI.PublishContext. If the batch
+// fails with a connection error and the failure threshold is reached, the batch is published to the next
+// cluster.
+func (f *FailoverPublishAPI) PublishContext(ctx context.Context, events interface{}) error {
+	if len(f.publishers) == 0 {
+		return errors.New("unable to publish events: no clusters configured")
+	}
+	f.checkRecovery()
+
+	active := f.current()
+	err := f.publishers[active].PublishContext(ctx, events)
+	if !f.record(ctx, active, err) {
+		return err
+	}
+	return f.publishers[f.current()].PublishContext(ctx, events)
+}
+
+// Active returns the index of the client whose cluster is currently used to publish events.
+func (f *FailoverPublishAPI) Active() int {
+	return f.current()
+}
+
+func (f *FailoverPublishAPI) current() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.active
+}
+
+// record updates the consecutive failures of the cluster with the result of a publish call and switches to the
+// next cluster if the failure threshold is reached. It returns true if it switched to another cluster.
+func (f *FailoverPublishAPI) record(ctx context.Context, index int, err error) bool {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if index != f.active {
+		return false
+	}
+	if !isConnectionError(ctx, err) {
+		f.failures = 0
+		return false
+	}
+	f.failures++
+	if f.failures < f.threshold || len(f.publishers) < 2 {
+		return false
+	}
+
+	f.active = (f.active + 1) % len(f.publishers)
+	f.failures = 0
+	f.lastCheck = time.Now()
+	f.logf("publish failover: event_type=%s from=%s to=%s", f.eventType, f.clients[index].nakadiURL, f.clients[f.active].nakadiURL)
+	return true
+}
+
+// checkRecovery requests the health of the primary cluster in the background if another cluster is active and
+// the recovery interval has passed since the last check.
+func (f *FailoverPublishAPI) checkRecovery() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if f.active == 0 || f.checking || time.Since(f.lastCheck) < f.interval {
+		return
+	}
+	f.checking = true
+	f.lastCheck = time.Now()
+
+	go func() {
+		status, err := f.clients[0].requestHealth(context.Background(), "unable to check health of primary cluster")
+
+		f.mutex.Lock()
+		defer f.mutex.Unlock()
+		f.checking = false
+		f.lastCheck = time.Now()
+		if err == nil && status < http.StatusInternalServerError && f.active != 0 {
+			f.logf("publish recovery: event_type=%s from=%s to=%s", f.eventType, f.clients[f.active].nakadiURL, f.clients[0].nakadiURL)
+			f.active = 0
+			f.failures = 0
+		}
+	}()
+}
+
+// logf logs a message via the logger of the primary client.
+func (f *FailoverPublishAPI) logf(format string, v ...interface{}) {
+	if logger := f.clients[0].logger; logger != nil {
+		logger.Printf(format, v...)
+	}
+}
+
+// isConnectionError checks whether a publish call failed because the cluster could not be reached, rather than
+// because of a response of Nakadi or because ctx is done.
+func isConnectionError(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() != nil {
+		return false
+	}
+	_, ok := errors.Cause(err).(*url.Error)
+	return ok
+}
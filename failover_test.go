@@ -0,0 +1,76 @@
+package nakadi
+
+import (
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFailoverPublishAPI_Publish(t *testing.T) {
+	primaryURL, secondaryURL := "http://primary.example.com", "http://secondary.example.com"
+	events := []SomeUndefinedEvent{{Test: "event"}}
+
+	setup := func(options *FailoverOptions) (*httpmock.MockTransport, *httpmock.MockTransport, *FailoverPublishAPI) {
+		primary, secondary := httpmock.NewMockTransport(), httpmock.NewMockTransport()
+		clients := []*Client{
+			{nakadiURL: primaryURL, httpClient: &http.Client{Transport: primary}},
+			{nakadiURL: secondaryURL, httpClient: &http.Client{Transport: secondary}}}
+		return primary, secondary, NewFailoverPublishAPI(clients, "test-event", nil, options)
+	}
+
+	t.Run("fail no clusters", func(t *testing.T) {
+		err := NewFailoverPublishAPI(nil, "test-event", nil, nil).Publish(events)
+		require.Error(t, err)
+		assert.Regexp(t, "no clusters configured", err)
+	})
+
+	t.Run("fail nakadi errors don't fail over", func(t *testing.T) {
+		primary, secondary, api := setup(&FailoverOptions{FailureThreshold: 1})
+		primary.RegisterResponder("POST", primaryURL+"/event-types/test-event/events", httpmock.NewStringResponder(http.StatusInternalServerError, testProblemJSON))
+
+		err := api.Publish(events)
+		require.Error(t, err)
+		assert.Regexp(t, "some problem detail", err)
+		assert.Equal(t, 0, api.Active())
+		assert.Equal(t, 0, secondary.GetTotalCallCount())
+	})
+
+	t.Run("success fail over and recover", func(t *testing.T) {
+		primary, secondary, api := setup(&FailoverOptions{FailureThreshold: 2, RecoveryInterval: time.Millisecond})
+		primary.RegisterResponder("POST", primaryURL+"/event-types/test-event/events", httpmock.NewErrorResponder(assert.AnError))
+		secondary.RegisterResponder("POST", secondaryURL+"/event-types/test-event/events", httpmock.NewStringResponder(http.StatusOK, ""))
+
+		err := api.Publish(events)
+		require.Error(t, err)
+		assert.Regexp(t, assert.AnError, err)
+		assert.Equal(t, 0, api.Active())
+
+		err = api.Publish(events)
+		require.NoError(t, err)
+		assert.Equal(t, 1, api.Active())
+		assert.Equal(t, 1, secondary.GetTotalCallCount())
+
+		healthStatus := int32(http.StatusServiceUnavailable)
+		checked := make(chan struct{}, 10)
+		primary.RegisterResponder("GET", primaryURL+"/health", func(*http.Request) (*http.Response, error) {
+			defer func() { checked <- struct{}{} }()
+			return httpmock.NewStringResponse(int(atomic.LoadInt32(&healthStatus)), ""), nil
+		})
+		primary.RegisterResponder("POST", primaryURL+"/event-types/test-event/events", httpmock.NewStringResponder(http.StatusOK, ""))
+
+		time.Sleep(5 * time.Millisecond)
+		require.NoError(t, api.Publish(events))
+		<-checked
+		assert.Equal(t, 1, api.Active())
+
+		atomic.StoreInt32(&healthStatus, http.StatusOK)
+		assert.Eventually(t, func() bool {
+			return api.Publish(events) == nil && api.Active() == 0
+		}, time.Second, 5*time.Millisecond)
+	})
+}
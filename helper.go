@@ -1,7 +1,11 @@
 package nakadi
 
 import (
+	"context"
+	"crypto/rand"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net"
 	"net/http"
 	"time"
@@ -16,49 +20,184 @@ const (
 	defaultIdleConnTimeout = 90 * time.Second
 	// nakadi specific timeouts
 	nakadiHeartbeatInterval = 30 * time.Second
+	nakadiCommitTimeout     = 60 * time.Second
 )
 
+// newUUID creates a random UUID version 4 as described in RFC 4122.
+func newUUID() string {
+	var uuid [16]byte
+	if _, err := io.ReadFull(rand.Reader, uuid[:]); err != nil {
+		panic(errors.Wrap(err, "unable to generate uuid"))
+	}
+	uuid[6] = (uuid[6] & 0x0f) | 0x40
+	uuid[8] = (uuid[8] & 0x3f) | 0x80
+
+	return fmt.Sprintf("%x-%x-%x-%x-%x", uuid[0:4], uuid[4:6], uuid[6:8], uuid[8:10], uuid[10:16])
+}
+
+// transportTimeouts are the timeouts of the transports used by the http clients.
+type transportTimeouts struct {
+	dial           time.Duration
+	tlsHandshake   time.Duration
+	responseHeader time.Duration
+	dialContext    func(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// dialer returns the custom dial function of the timeouts or the DialContext of a net.Dialer with the dial
+// timeout and the given keep alive period.
+func (t transportTimeouts) dialer(keepAlive time.Duration) func(ctx context.Context, network, address string) (net.Conn, error) {
+	if t.dialContext != nil {
+		return t.dialContext
+	}
+	return (&net.Dialer{
+		Timeout:   t.dial,
+		KeepAlive: keepAlive,
+		DualStack: true,
+	}).DialContext
+}
+
 // newHTTPClient crates an http client which is used for non streaming requests.
-func newHTTPClient(timeout time.Duration) *http.Client {
+func newHTTPClient(timeout time.Duration, timeouts transportTimeouts) *http.Client {
 	return &http.Client{
 		Timeout: timeout,
 		Transport: &http.Transport{
-			Proxy: http.ProxyFromEnvironment,
-			DialContext: (&net.Dialer{
-				Timeout:   timeout,
-				KeepAlive: defaultKeepAlive,
-				DualStack: true,
-			}).DialContext,
-			MaxIdleConns:        100,
-			IdleConnTimeout:     defaultIdleConnTimeout,
-			TLSHandshakeTimeout: timeout,
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           timeouts.dialer(defaultKeepAlive),
+			MaxIdleConns:          100,
+			IdleConnTimeout:       defaultIdleConnTimeout,
+			TLSHandshakeTimeout:   timeouts.tlsHandshake,
+			ResponseHeaderTimeout: timeouts.responseHeader,
 		},
 	}
 }
 
 // newHTTPStream creates an http client which is used for streaming purposes.
-func newHTTPStream(timeout time.Duration) *http.Client {
+func newHTTPStream(timeouts transportTimeouts) *http.Client {
 	return &http.Client{
 		Transport: &http.Transport{
-			Proxy: http.ProxyFromEnvironment,
-			DialContext: (&net.Dialer{
-				Timeout:   timeout,
-				KeepAlive: 2 * nakadiHeartbeatInterval,
-				DualStack: true,
-			}).DialContext,
-			MaxIdleConns:        100,
-			IdleConnTimeout:     2 * nakadiHeartbeatInterval,
-			TLSHandshakeTimeout: timeout,
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           timeouts.dialer(2 * nakadiHeartbeatInterval),
+			MaxIdleConns:          100,
+			IdleConnTimeout:       2 * nakadiHeartbeatInterval,
+			TLSHandshakeTimeout:   timeouts.tlsHandshake,
+			ResponseHeaderTimeout: timeouts.responseHeader,
 		},
 	}
 }
 
 // problemJSON is used to decode error responses.
 type problemJSON struct {
-	Title  string `json:"title"`
-	Detail string `json:"detail"`
-	Status int    `json:"status"`
-	Type   string `json:"type"`
+	Title    string `json:"title"`
+	Detail   string `json:"detail"`
+	Status   int    `json:"status"`
+	Type     string `json:"type"`
+	Instance string `json:"instance,omitempty"`
+}
+
+// A Problem is an error response of Nakadi as defined by RFC 7807. Members of the response which are not
+// defined by the RFC, e.g. error codes specific to a cluster, are decoded into Extensions.
+type Problem struct {
+	Type       string
+	Title      string
+	Status     int
+	Detail     string
+	Instance   string
+	Extensions map[string]interface{}
+}
+
+// UnmarshalJSON decodes a problem along with its extension members.
+func (p *Problem) UnmarshalJSON(data []byte) error {
+	known := problemJSON{}
+	if err := json.Unmarshal(data, &known); err != nil {
+		return err
+	}
+	members := map[string]interface{}{}
+	if err := json.Unmarshal(data, &members); err != nil {
+		return err
+	}
+	for _, key := range []string{"type", "title", "status", "detail", "instance"} {
+		delete(members, key)
+	}
+
+	*p = Problem{
+		Type:     known.Type,
+		Title:    known.Title,
+		Status:   known.Status,
+		Detail:   known.Detail,
+		Instance: known.Instance}
+	if len(members) > 0 {
+		p.Extensions = members
+	}
+	return nil
+}
+
+// A ProblemError is the cause of errors returned when Nakadi responds with a problem. The problem, including
+// its extension members, can be obtained via errors.Cause(err).(*ProblemError). StatusCode is the status of
+// the response, which is also set if the problem has no status member. For errors caused by a sentinel error
+// like ErrSubscriptionConflict the status is available via ResponseStatus.
+type ProblemError struct {
+	StatusCode int
+	Problem    *Problem
+	msg        string
+}
+
+func (e *ProblemError) Error() string {
+	return e.msg
+}
+
+// An HTTPError is the cause of errors returned when Nakadi responds with an unexpected status and a body which
+// is not a problem, e.g. an OAuth error or the error page of a gateway.
+type HTTPError struct {
+	StatusCode int
+	msg        string
+}
+
+func (e *HTTPError) Error() string {
+	return e.msg
+}
+
+// ResponseStatus returns the status of the response which caused err, if err was caused by an unexpected
+// response of Nakadi. This allows to handle e.g. conflicts or missing resources without inspecting the
+// message of the error. The error may be wrapped with errors.Wrap. The status is also reported for errors
+// caused by a sentinel error like ErrSubscriptionConflict, if the sentinel stands for a response of Nakadi.
+func ResponseStatus(err error) (int, bool) {
+	for err != nil {
+		switch typed := err.(type) {
+		case *ProblemError:
+			return typed.StatusCode, true
+		case *HTTPError:
+			return typed.StatusCode, true
+		case *sentinelError:
+			return ResponseStatus(typed.response)
+		}
+		causer, ok := err.(interface{ Cause() error })
+		if !ok {
+			return 0, false
+		}
+		err = causer.Cause()
+	}
+	return 0, false
+}
+
+// sentinelError replaces the cause of the error of an unexpected response with a sentinel error, while the
+// status of the response remains available to ResponseStatus. The message is the same as with errors.Wrap.
+type sentinelError struct {
+	sentinel error
+	response error
+}
+
+// withSentinel marks the error of an unexpected response as caused by the sentinel error.
+func withSentinel(sentinel, response error) error {
+	return errors.WithStack(&sentinelError{sentinel: sentinel, response: response})
+}
+
+func (e *sentinelError) Error() string {
+	return e.response.Error() + ": " + e.sentinel.Error()
+}
+
+// Cause returns the sentinel error.
+func (e *sentinelError) Cause() error {
+	return e.sentinel
 }
 
 type errorJSON struct {
@@ -66,23 +205,73 @@ type errorJSON struct {
 	ErrorDescription string `json:"error_description"`
 }
 
+// maxErrorBodyLength is the maximum number of bytes of a response body which are included in an error
+// message if the body can not be decoded.
+const maxErrorBodyLength = 512
+
+// notFoundError marks the error of a request for a resource which does not exist. The message of the error
+// is not changed, isNotFound detects it even if it was wrapped.
+type notFoundError struct {
+	error
+}
+
+// Cause returns the marked error, so that errors.Cause is not stopped by the mark.
+func (e notFoundError) Cause() error {
+	return e.error
+}
+
+// isPersistentError checks whether err was caused by a response of Nakadi which won't change if the request is
+// repeated, like a missing resource or a missing permission. Conflicts and throttling are considered transient.
+func isPersistentError(err error) bool {
+	status, ok := ResponseStatus(err)
+	return ok && status >= 400 && status < 500 && status != http.StatusConflict && status != http.StatusTooManyRequests
+}
+
+// isNotFound checks whether err or one of its causes is the response of Nakadi to a request for a missing
+// resource.
+func isNotFound(err error) bool {
+	for err != nil {
+		if _, ok := err.(notFoundError); ok {
+			return true
+		}
+		cause, ok := err.(interface{ Cause() error })
+		if !ok {
+			return false
+		}
+		err = cause.Cause()
+	}
+	return false
+}
+
 // decodeResponseToError will try do decode into problemJSON then errorJSON
 // and extract details from this defined formats.
-// It will fallback to creating and error with message body
-// Second parameter is an error message
-func decodeResponseToError(buffer []byte, msg string) error {
-	problem := problemJSON{}
-	err := json.Unmarshal(buffer, &problem)
-	if err == nil {
-		return errors.Errorf("%s: %s", msg, problem.Detail)
+// It will fallback to creating an error with the status code and the message body,
+// which is truncated to maxErrorBodyLength.
+// The last parameter is an error message
+func decodeResponseToError(status int, buffer []byte, msg string) error {
+	problem := &Problem{}
+	err := json.Unmarshal(buffer, problem)
+	if err == nil && (problem.Detail != "" || problem.Title != "") {
+		if problem.Detail == "" {
+			return errors.WithStack(&ProblemError{StatusCode: status, Problem: problem, msg: fmt.Sprintf("%s: %s", msg, problem.Title)})
+		}
+		return errors.WithStack(&ProblemError{StatusCode: status, Problem: problem, msg: fmt.Sprintf("%s: %s", msg, problem.Detail)})
 	}
-	errJSON := &errorJSON{}
 
+	errJSON := errorJSON{}
 	err = json.Unmarshal(buffer, &errJSON)
-	if err == nil {
-		return errors.Errorf("%s: %s", msg, errJSON.ErrorDescription)
+	if err == nil && (errJSON.ErrorDescription != "" || errJSON.Error != "") {
+		if errJSON.ErrorDescription == "" {
+			return errors.WithStack(&HTTPError{StatusCode: status, msg: fmt.Sprintf("%s: %s", msg, errJSON.Error)})
+		}
+		return errors.WithStack(&HTTPError{StatusCode: status, msg: fmt.Sprintf("%s: %s", msg, errJSON.ErrorDescription)})
+	}
+
+	body := string(buffer)
+	if len(body) > maxErrorBodyLength {
+		body = body[:maxErrorBodyLength] + "..."
 	}
-	return errors.Errorf("%s: %s", msg, string(buffer))
+	return errors.WithStack(&HTTPError{StatusCode: status, msg: fmt.Sprintf("%s: %s (status %d)", msg, body, status)})
 }
 
 // backOffConfiguration holds initial values for the initialization of a backoff that can
@@ -3,29 +3,38 @@ package nakadi
 import (
 	"encoding/json"
 	"io/ioutil"
+	"net/http"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/cenkalti/backoff/v3"
+	"github.com/jarcoal/httpmock"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
 func TestNewHTTPClient(t *testing.T) {
 	timeout := 20 * time.Second
-	client := newHTTPClient(timeout)
+	client := newHTTPClient(timeout, transportTimeouts{tlsHandshake: 5 * time.Second, responseHeader: 10 * time.Second})
 
 	require.NotNil(t, client)
 	assert.Equal(t, timeout, client.Timeout)
+	transport := client.Transport.(*http.Transport)
+	assert.Equal(t, 5*time.Second, transport.TLSHandshakeTimeout)
+	assert.Equal(t, 10*time.Second, transport.ResponseHeaderTimeout)
 }
 
 func TestNewHTTPStream(t *testing.T) {
-	timeout := 20 * time.Second
-	client := newHTTPStream(timeout)
+	client := newHTTPStream(transportTimeouts{tlsHandshake: 5 * time.Second, responseHeader: 10 * time.Second})
 
 	require.NotNil(t, client)
 	assert.Equal(t, 0*time.Second, client.Timeout)
+	transport := client.Transport.(*http.Transport)
+	assert.Equal(t, 5*time.Second, transport.TLSHandshakeTimeout)
+	assert.Equal(t, 10*time.Second, transport.ResponseHeaderTimeout)
 }
 
 func TestProblemJSON_Marshal(t *testing.T) {
@@ -46,6 +55,113 @@ func TestErrorJSON_Marshal(t *testing.T) {
 	assert.JSONEq(t, string(expected), string(serialized))
 }
 
+func TestDecodeResponseToError(t *testing.T) {
+	t.Run("problem detail", func(t *testing.T) {
+		err := decodeResponseToError(422, []byte(`{"title":"Unprocessable Entity","detail":"invalid"}`), "msg")
+		assert.EqualError(t, err, "msg: invalid")
+	})
+
+	t.Run("problem title", func(t *testing.T) {
+		err := decodeResponseToError(403, []byte(`{"title":"Forbidden","status":403}`), "msg")
+		assert.EqualError(t, err, "msg: Forbidden")
+	})
+
+	t.Run("problem extensions", func(t *testing.T) {
+		body := `{"type":"about:blank","title":"Forbidden","status":403,"detail":"denied","instance":"/event-types/test",
+			"error_code":"NAK-42","retryable":false,"context":{"team":"test"}}`
+		err := decodeResponseToError(403, []byte(body), "msg")
+		assert.EqualError(t, err, "msg: denied")
+
+		problemErr, ok := errors.Cause(errors.Wrap(err, "wrapped")).(*ProblemError)
+		require.True(t, ok)
+		assert.Equal(t, &Problem{
+			Type:     "about:blank",
+			Title:    "Forbidden",
+			Status:   403,
+			Detail:   "denied",
+			Instance: "/event-types/test",
+			Extensions: map[string]interface{}{
+				"error_code": "NAK-42",
+				"retryable":  false,
+				"context":    map[string]interface{}{"team": "test"}}}, problemErr.Problem)
+
+		err = decodeResponseToError(422, []byte(`{"title":"Unprocessable Entity","detail":"invalid"}`), "msg")
+		assert.Nil(t, errors.Cause(err).(*ProblemError).Problem.Extensions)
+	})
+
+	t.Run("problem cause of not found error", func(t *testing.T) {
+		err := errors.Wrap(notFoundError{decodeResponseToError(404, []byte(testProblemJSON), "msg")}, "wrapped")
+		assert.True(t, isNotFound(err))
+		_, ok := errors.Cause(err).(*ProblemError)
+		assert.True(t, ok)
+		assert.False(t, isNotFound(decodeResponseToError(404, []byte(testProblemJSON), "msg")))
+	})
+
+	t.Run("error json", func(t *testing.T) {
+		err := decodeResponseToError(401, []byte(`{"error":"invalid_token","error_description":"token expired"}`), "msg")
+		assert.EqualError(t, err, "msg: token expired")
+	})
+
+	t.Run("error json without description", func(t *testing.T) {
+		err := decodeResponseToError(401, []byte(`{"error":"invalid_token"}`), "msg")
+		assert.EqualError(t, err, "msg: invalid_token")
+	})
+
+	t.Run("raw body", func(t *testing.T) {
+		err := decodeResponseToError(502, []byte(`<html><body>Bad Gateway</body></html>`), "msg")
+		assert.EqualError(t, err, "msg: <html><body>Bad Gateway</body></html> (status 502)")
+	})
+
+	t.Run("unknown json", func(t *testing.T) {
+		err := decodeResponseToError(500, []byte(`{"message":"failure"}`), "msg")
+		assert.EqualError(t, err, `msg: {"message":"failure"} (status 500)`)
+	})
+
+	t.Run("response status", func(t *testing.T) {
+		err := decodeResponseToError(409, []byte(`{"title":"Conflict","status":409,"detail":"exists"}`), "msg")
+		status, ok := ResponseStatus(errors.Wrap(err, "wrapped"))
+		assert.True(t, ok)
+		assert.Equal(t, 409, status)
+
+		err = decodeResponseToError(422, []byte(`{"detail":"invalid"}`), "msg")
+		assert.Equal(t, 422, errors.Cause(err).(*ProblemError).StatusCode)
+		assert.Equal(t, 0, errors.Cause(err).(*ProblemError).Problem.Status)
+
+		err = decodeResponseToError(401, []byte(`{"error":"invalid_token"}`), "msg")
+		httpErr, ok := errors.Cause(err).(*HTTPError)
+		require.True(t, ok)
+		assert.Equal(t, 401, httpErr.StatusCode)
+
+		status, ok = ResponseStatus(notFoundError{decodeResponseToError(404, []byte("not found"), "msg")})
+		assert.True(t, ok)
+		assert.Equal(t, 404, status)
+
+		_, ok = ResponseStatus(errors.New("msg"))
+		assert.False(t, ok)
+	})
+
+	t.Run("response status with sentinel", func(t *testing.T) {
+		err := withSentinel(ErrSubscriptionConflict, decodeResponseToError(409, []byte(`{"detail":"exists"}`), "msg"))
+		err = errors.Wrap(err, "wrapped")
+		assert.EqualError(t, err, "wrapped: msg: exists: "+ErrSubscriptionConflict.Error())
+		assert.Equal(t, ErrSubscriptionConflict, errors.Cause(err))
+
+		status, ok := ResponseStatus(err)
+		assert.True(t, ok)
+		assert.Equal(t, 409, status)
+
+		status, ok = ResponseStatus(notFoundError{withSentinel(ErrSubscriptionNotFound, decodeResponseToError(404, []byte("not found"), "msg"))})
+		assert.True(t, ok)
+		assert.Equal(t, 404, status)
+	})
+
+	t.Run("truncated raw body", func(t *testing.T) {
+		body := strings.Repeat("x", 2*maxErrorBodyLength)
+		err := decodeResponseToError(503, []byte(body), "msg")
+		assert.EqualError(t, err, "msg: "+body[:maxErrorBodyLength]+"... (status 503)")
+	})
+}
+
 func TestBackOffConfiguration_createBackOff(t *testing.T) {
 
 	t.Run("stop backoff", func(t *testing.T) {
@@ -98,9 +214,24 @@ func helperMakeCounter(n int) chan int {
 	return counter
 }
 
+// helperCanceledResponder creates a responder which blocks until the context of the request is canceled.
+func helperCanceledResponder() httpmock.Responder {
+	return func(r *http.Request) (*http.Response, error) {
+		<-r.Context().Done()
+		return nil, r.Context().Err()
+	}
+}
+
 // brokenBodyReader is an implementation of ReadCloser interface to be used for
 // mocking errors while reading from body
 type brokenBodyReader struct{}
 
 func (brokenBodyReader) Read(p []byte) (n int, err error) { return 0, assert.AnError }
 func (brokenBodyReader) Close() error                     { return nil }
+
+func TestNewUUID(t *testing.T) {
+	first, second := newUUID(), newUUID()
+
+	assert.Regexp(t, "^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$", first)
+	assert.NotEqual(t, first, second)
+}
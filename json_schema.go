@@ -0,0 +1,721 @@
+package nakadi
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// ErrSchemaViolation is the cause of errors returned if events don't match the schema of their event type.
+var ErrSchemaViolation = errors.New("event does not match the schema of the event type")
+
+// errUnsupportedSchema is the cause of compile errors for valid schemas which can't be checked by the compiled
+// schema, like references to other documents or patterns which use ECMA 262 features unknown to package regexp.
+var errUnsupportedSchema = errors.New("unsupported schema")
+
+// maxSchemaProblems limits the number of problems reported for a single event.
+const maxSchemaProblems = 10
+
+// jsonSchema is a compiled JSON Schema. It supports the keywords of JSON Schema draft 4 which are used for
+// event type schemas. Of the formats only date-time is checked, unknown keywords and formats are ignored.
+type jsonSchema struct {
+	ref                  *jsonSchema
+	types                []string
+	enum                 []interface{}
+	constant             interface{}
+	hasConstant          bool
+	properties           map[string]*jsonSchema
+	patternProperties    map[*regexp.Regexp]*jsonSchema
+	additionalProperties *jsonSchema
+	noAdditional         bool
+	required             []string
+	minProperties        *int
+	maxProperties        *int
+	items                *jsonSchema
+	tupleItems           []*jsonSchema
+	minItems             *int
+	maxItems             *int
+	uniqueItems          bool
+	minLength            *int
+	maxLength            *int
+	pattern              *regexp.Regexp
+	format               string
+	minimum              *float64
+	maximum              *float64
+	exclusiveMinimum     bool
+	exclusiveMaximum     bool
+	multipleOf           *float64
+	allOf                []*jsonSchema
+	anyOf                []*jsonSchema
+	oneOf                []*jsonSchema
+	not                  *jsonSchema
+}
+
+// ValidateSchema checks whether schema is a valid JSON Schema, using the same compiler as the client side
+// validation of PublishOptions.ValidateSchema. The returned error describes the first problem found and points
+// to the invalid part of the schema as JSON pointer, or to the offset of a json syntax error. Schemas using
+// constructs the compiler doesn't support, like references to other documents or patterns which can't be
+// compiled by package regexp, are left to the validation of Nakadi and no error is returned.
+func ValidateSchema(schema string) error {
+	_, err := compileJSONSchema(schema)
+	if errors.Cause(err) == errUnsupportedSchema {
+		return nil
+	}
+	return errors.Wrap(err, "invalid schema")
+}
+
+// compileJSONSchema parses and compiles a JSON Schema. Errors point to the location of the invalid part of
+// the schema as JSON pointer. Errors caused by errUnsupportedSchema don't mean that the schema is invalid.
+func compileJSONSchema(schema string) (*jsonSchema, error) {
+	var document interface{}
+	if err := json.Unmarshal([]byte(schema), &document); err != nil {
+		if syntaxErr, ok := err.(*json.SyntaxError); ok {
+			return nil, errors.Errorf("invalid json at offset %d: %s", syntaxErr.Offset, syntaxErr)
+		}
+		return nil, errors.Wrap(err, "invalid json")
+	}
+
+	compiler := &schemaCompiler{document: document, compiled: make(map[string]*jsonSchema)}
+	return compiler.compile(document, "#")
+}
+
+// schemaCompiler compiles the sub schemas of a schema document. Compiled schemas are cached by their JSON
+// pointer, which allows recursive references.
+type schemaCompiler struct {
+	document interface{}
+	compiled map[string]*jsonSchema
+}
+
+func (c *schemaCompiler) compile(value interface{}, pointer string) (*jsonSchema, error) {
+	if compiled, ok := c.compiled[pointer]; ok {
+		return compiled, nil
+	}
+
+	object, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, errors.Errorf("schema at %s: must be an object", pointer)
+	}
+	s := &jsonSchema{}
+	c.compiled[pointer] = s
+
+	if ref, ok := object["$ref"]; ok {
+		// in draft 4 all other keywords next to $ref are ignored
+		refString, ok := ref.(string)
+		if !ok {
+			return nil, errors.Errorf("schema at %s: $ref must be a string", pointer)
+		}
+		target, err := c.resolve(refString, pointer)
+		if err != nil {
+			return nil, err
+		}
+		s.ref = target
+		return s, nil
+	}
+
+	var err error
+	if s.types, err = schemaTypes(object["type"], pointer); err != nil {
+		return nil, err
+	}
+	if enum, ok := object["enum"]; ok {
+		values, ok := enum.([]interface{})
+		if !ok || len(values) == 0 {
+			return nil, errors.Errorf("schema at %s: enum must be a non empty array", pointer)
+		}
+		s.enum = values
+	}
+	if value, ok := object["const"]; ok {
+		s.constant = value
+		s.hasConstant = true
+	}
+	if s.format, err = schemaString(object, "format", pointer); err != nil {
+		return nil, err
+	}
+
+	if err := c.compileObjectKeywords(s, object, pointer); err != nil {
+		return nil, err
+	}
+	if err := c.compileArrayKeywords(s, object, pointer); err != nil {
+		return nil, err
+	}
+	if err := compileStringKeywords(s, object, pointer); err != nil {
+		return nil, err
+	}
+	if err := compileNumberKeywords(s, object, pointer); err != nil {
+		return nil, err
+	}
+
+	if s.allOf, err = c.compileList(object, "allOf", pointer); err != nil {
+		return nil, err
+	}
+	if s.anyOf, err = c.compileList(object, "anyOf", pointer); err != nil {
+		return nil, err
+	}
+	if s.oneOf, err = c.compileList(object, "oneOf", pointer); err != nil {
+		return nil, err
+	}
+	if not, ok := object["not"]; ok {
+		if s.not, err = c.compile(not, pointer+"/not"); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+func (c *schemaCompiler) compileObjectKeywords(s *jsonSchema, object map[string]interface{}, pointer string) error {
+	var err error
+	if properties, ok := object["properties"]; ok {
+		fields, ok := properties.(map[string]interface{})
+		if !ok {
+			return errors.Errorf("schema at %s: properties must be an object", pointer)
+		}
+		s.properties = make(map[string]*jsonSchema, len(fields))
+		for name, field := range fields {
+			if s.properties[name], err = c.compile(field, pointer+"/properties/"+escapePointer(name)); err != nil {
+				return err
+			}
+		}
+	}
+	if patternProperties, ok := object["patternProperties"]; ok {
+		fields, ok := patternProperties.(map[string]interface{})
+		if !ok {
+			return errors.Errorf("schema at %s: patternProperties must be an object", pointer)
+		}
+		s.patternProperties = make(map[*regexp.Regexp]*jsonSchema, len(fields))
+		for pattern, field := range fields {
+			expr, err := regexp.Compile(pattern)
+			if err != nil {
+				return errors.Wrapf(errUnsupportedSchema, "schema at %s: pattern %q: %s", pointer+"/patternProperties", pattern, err)
+			}
+			if s.patternProperties[expr], err = c.compile(field, pointer+"/patternProperties/"+escapePointer(pattern)); err != nil {
+				return err
+			}
+		}
+	}
+	switch additional := object["additionalProperties"].(type) {
+	case nil:
+	case bool:
+		s.noAdditional = !additional
+	default:
+		if s.additionalProperties, err = c.compile(additional, pointer+"/additionalProperties"); err != nil {
+			return err
+		}
+	}
+	if required, ok := object["required"]; ok {
+		names, ok := required.([]interface{})
+		if !ok {
+			return errors.Errorf("schema at %s: required must be an array of strings", pointer)
+		}
+		for _, name := range names {
+			nameString, ok := name.(string)
+			if !ok {
+				return errors.Errorf("schema at %s: required must be an array of strings", pointer)
+			}
+			s.required = append(s.required, nameString)
+		}
+	}
+	if s.minProperties, err = schemaCount(object, "minProperties", pointer); err != nil {
+		return err
+	}
+	s.maxProperties, err = schemaCount(object, "maxProperties", pointer)
+	return err
+}
+
+func (c *schemaCompiler) compileArrayKeywords(s *jsonSchema, object map[string]interface{}, pointer string) error {
+	var err error
+	switch items := object["items"].(type) {
+	case nil:
+	case []interface{}:
+		for i, item := range items {
+			compiled, err := c.compile(item, fmt.Sprintf("%s/items/%d", pointer, i))
+			if err != nil {
+				return err
+			}
+			s.tupleItems = append(s.tupleItems, compiled)
+		}
+	default:
+		if s.items, err = c.compile(items, pointer+"/items"); err != nil {
+			return err
+		}
+	}
+	if s.minItems, err = schemaCount(object, "minItems", pointer); err != nil {
+		return err
+	}
+	if s.maxItems, err = schemaCount(object, "maxItems", pointer); err != nil {
+		return err
+	}
+	if unique, ok := object["uniqueItems"]; ok {
+		if s.uniqueItems, ok = unique.(bool); !ok {
+			return errors.Errorf("schema at %s: uniqueItems must be a boolean", pointer)
+		}
+	}
+	return nil
+}
+
+func compileStringKeywords(s *jsonSchema, object map[string]interface{}, pointer string) error {
+	var err error
+	if s.minLength, err = schemaCount(object, "minLength", pointer); err != nil {
+		return err
+	}
+	if s.maxLength, err = schemaCount(object, "maxLength", pointer); err != nil {
+		return err
+	}
+	pattern, err := schemaString(object, "pattern", pointer)
+	if err != nil || pattern == "" {
+		return err
+	}
+	if s.pattern, err = regexp.Compile(pattern); err != nil {
+		return errors.Wrapf(errUnsupportedSchema, "schema at %s: pattern %q: %s", pointer, pattern, err)
+	}
+	return nil
+}
+
+func compileNumberKeywords(s *jsonSchema, object map[string]interface{}, pointer string) error {
+	var err error
+	if s.minimum, err = schemaNumber(object, "minimum", pointer); err != nil {
+		return err
+	}
+	if s.maximum, err = schemaNumber(object, "maximum", pointer); err != nil {
+		return err
+	}
+	if s.multipleOf, err = schemaNumber(object, "multipleOf", pointer); err != nil {
+		return err
+	}
+	if s.multipleOf != nil && *s.multipleOf <= 0 {
+		return errors.Errorf("schema at %s: multipleOf must be greater than 0", pointer)
+	}
+
+	// draft 4 uses booleans, later drafts use numbers for the exclusive limits
+	switch exclusive := object["exclusiveMinimum"].(type) {
+	case nil:
+	case bool:
+		s.exclusiveMinimum = exclusive
+	case float64:
+		s.minimum, s.exclusiveMinimum = &exclusive, true
+	default:
+		return errors.Errorf("schema at %s: exclusiveMinimum must be a boolean or a number", pointer)
+	}
+	switch exclusive := object["exclusiveMaximum"].(type) {
+	case nil:
+	case bool:
+		s.exclusiveMaximum = exclusive
+	case float64:
+		s.maximum, s.exclusiveMaximum = &exclusive, true
+	default:
+		return errors.Errorf("schema at %s: exclusiveMaximum must be a boolean or a number", pointer)
+	}
+	return nil
+}
+
+func (c *schemaCompiler) compileList(object map[string]interface{}, keyword, pointer string) ([]*jsonSchema, error) {
+	value, ok := object[keyword]
+	if !ok {
+		return nil, nil
+	}
+	list, ok := value.([]interface{})
+	if !ok || len(list) == 0 {
+		return nil, errors.Errorf("schema at %s: %s must be a non empty array", pointer, keyword)
+	}
+	schemas := make([]*jsonSchema, len(list))
+	for i, item := range list {
+		compiled, err := c.compile(item, fmt.Sprintf("%s/%s/%d", pointer, keyword, i))
+		if err != nil {
+			return nil, err
+		}
+		schemas[i] = compiled
+	}
+	return schemas, nil
+}
+
+// resolve compiles the schema a reference points to. Only references within the document are supported.
+func (c *schemaCompiler) resolve(ref, pointer string) (*jsonSchema, error) {
+	if ref != "#" && !strings.HasPrefix(ref, "#/") {
+		return nil, errors.Wrapf(errUnsupportedSchema, "schema at %s: reference %q, only references within the schema are supported", pointer, ref)
+	}
+
+	current := c.document
+	if ref != "#" {
+		for _, token := range strings.Split(ref[2:], "/") {
+			token = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+			var found bool
+			switch container := current.(type) {
+			case map[string]interface{}:
+				current, found = container[token]
+			case []interface{}:
+				index, err := strconv.Atoi(token)
+				if found = err == nil && index >= 0 && index < len(container); found {
+					current = container[index]
+				}
+			}
+			if !found {
+				return nil, errors.Errorf("schema at %s: unresolvable reference %q", pointer, ref)
+			}
+		}
+	}
+	return c.compile(current, ref)
+}
+
+func schemaTypes(value interface{}, pointer string) ([]string, error) {
+	var types []string
+	switch typed := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		types = []string{typed}
+	case []interface{}:
+		for _, item := range typed {
+			name, ok := item.(string)
+			if !ok {
+				return nil, errors.Errorf("schema at %s: type must be a string or an array of strings", pointer)
+			}
+			types = append(types, name)
+		}
+	default:
+		return nil, errors.Errorf("schema at %s: type must be a string or an array of strings", pointer)
+	}
+
+	for _, name := range types {
+		switch name {
+		case "null", "boolean", "object", "array", "number", "integer", "string":
+		default:
+			return nil, errors.Errorf("schema at %s: unknown type %q", pointer, name)
+		}
+	}
+	return types, nil
+}
+
+func schemaString(object map[string]interface{}, keyword, pointer string) (string, error) {
+	value, ok := object[keyword]
+	if !ok {
+		return "", nil
+	}
+	str, ok := value.(string)
+	if !ok {
+		return "", errors.Errorf("schema at %s: %s must be a string", pointer, keyword)
+	}
+	return str, nil
+}
+
+func schemaNumber(object map[string]interface{}, keyword, pointer string) (*float64, error) {
+	value, ok := object[keyword]
+	if !ok {
+		return nil, nil
+	}
+	number, ok := value.(float64)
+	if !ok {
+		return nil, errors.Errorf("schema at %s: %s must be a number", pointer, keyword)
+	}
+	return &number, nil
+}
+
+func schemaCount(object map[string]interface{}, keyword, pointer string) (*int, error) {
+	number, err := schemaNumber(object, keyword, pointer)
+	if err != nil || number == nil {
+		return nil, err
+	}
+	if *number < 0 || *number != math.Trunc(*number) {
+		return nil, errors.Errorf("schema at %s: %s must be a non-negative integer", pointer, keyword)
+	}
+	count := int(*number)
+	return &count, nil
+}
+
+func escapePointer(token string) string {
+	return strings.Replace(strings.Replace(token, "~", "~0", -1), "/", "~1", -1)
+}
+
+// validate checks a decoded json value against the schema and returns the problems found. The problems are
+// prefixed with the JSON pointer of the invalid part of the value.
+func (s *jsonSchema) validate(value interface{}) []string {
+	var problems []string
+	s.validateValue(value, "", &problems)
+	if len(problems) > maxSchemaProblems {
+		problems = append(problems[:maxSchemaProblems], fmt.Sprintf("and %d more problems", len(problems)-maxSchemaProblems))
+	}
+	return problems
+}
+
+func (s *jsonSchema) validateValue(value interface{}, path string, problems *[]string) {
+	if s.ref != nil {
+		s.ref.validateValue(value, path, problems)
+		return
+	}
+
+	report := func(format string, args ...interface{}) {
+		location := path
+		if location == "" {
+			location = "/"
+		}
+		*problems = append(*problems, location+": "+fmt.Sprintf(format, args...))
+	}
+
+	actual := jsonType(value)
+	if len(s.types) > 0 && !matchesType(actual, s.types) {
+		report("expected %s but got %s", strings.Join(s.types, " or "), actual)
+		return
+	}
+	if s.enum != nil {
+		found := false
+		for _, allowed := range s.enum {
+			if reflect.DeepEqual(allowed, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			report("value is not one of the allowed values")
+		}
+	}
+	if s.hasConstant && !reflect.DeepEqual(s.constant, value) {
+		report("value is not the constant value")
+	}
+
+	switch typed := value.(type) {
+	case map[string]interface{}:
+		s.validateObject(typed, path, problems, report)
+	case []interface{}:
+		s.validateArray(typed, path, problems, report)
+	case string:
+		s.validateString(typed, report)
+	case float64:
+		s.validateNumber(typed, report)
+	}
+
+	for _, sub := range s.allOf {
+		sub.validateValue(value, path, problems)
+	}
+	if s.anyOf != nil && countMatches(s.anyOf, value) == 0 {
+		report("value does not match any of the schemas in anyOf")
+	}
+	if s.oneOf != nil {
+		if matches := countMatches(s.oneOf, value); matches != 1 {
+			report("value must match exactly one of the schemas in oneOf but matches %d", matches)
+		}
+	}
+	if s.not != nil && countMatches([]*jsonSchema{s.not}, value) == 1 {
+		report("value must not match the schema in not")
+	}
+}
+
+func (s *jsonSchema) validateObject(object map[string]interface{}, path string, problems *[]string, report func(string, ...interface{})) {
+	for _, name := range s.required {
+		if _, ok := object[name]; !ok {
+			report("required property %s is missing", name)
+		}
+	}
+	if s.minProperties != nil && len(object) < *s.minProperties {
+		report("expected at least %d properties but got %d", *s.minProperties, len(object))
+	}
+	if s.maxProperties != nil && len(object) > *s.maxProperties {
+		report("expected at most %d properties but got %d", *s.maxProperties, len(object))
+	}
+
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		fieldPath := path + "/" + escapePointer(name)
+		matched := false
+		if property, ok := s.properties[name]; ok {
+			matched = true
+			property.validateValue(object[name], fieldPath, problems)
+		}
+		for expr, property := range s.patternProperties {
+			if expr.MatchString(name) {
+				matched = true
+				property.validateValue(object[name], fieldPath, problems)
+			}
+		}
+		if matched {
+			continue
+		}
+		if s.noAdditional {
+			report("additional property %s is not allowed", name)
+		} else if s.additionalProperties != nil {
+			s.additionalProperties.validateValue(object[name], fieldPath, problems)
+		}
+	}
+}
+
+func (s *jsonSchema) validateArray(array []interface{}, path string, problems *[]string, report func(string, ...interface{})) {
+	if s.minItems != nil && len(array) < *s.minItems {
+		report("expected at least %d items but got %d", *s.minItems, len(array))
+	}
+	if s.maxItems != nil && len(array) > *s.maxItems {
+		report("expected at most %d items but got %d", *s.maxItems, len(array))
+	}
+	if s.uniqueItems {
+		for i := range array {
+			for j := i + 1; j < len(array); j++ {
+				if reflect.DeepEqual(array[i], array[j]) {
+					report("items %d and %d are equal", i, j)
+				}
+			}
+		}
+	}
+
+	for i, item := range array {
+		itemPath := fmt.Sprintf("%s/%d", path, i)
+		switch {
+		case s.items != nil:
+			s.items.validateValue(item, itemPath, problems)
+		case i < len(s.tupleItems):
+			s.tupleItems[i].validateValue(item, itemPath, problems)
+		}
+	}
+}
+
+func (s *jsonSchema) validateString(str string, report func(string, ...interface{})) {
+	length := utf8.RuneCountInString(str)
+	if s.minLength != nil && length < *s.minLength {
+		report("expected at least %d characters but got %d", *s.minLength, length)
+	}
+	if s.maxLength != nil && length > *s.maxLength {
+		report("expected at most %d characters but got %d", *s.maxLength, length)
+	}
+	if s.pattern != nil && !s.pattern.MatchString(str) {
+		report("value does not match the pattern %s", s.pattern)
+	}
+	if s.format == "date-time" {
+		if _, err := time.Parse(time.RFC3339Nano, str); err != nil {
+			report("value is not a valid date-time")
+		}
+	}
+}
+
+func (s *jsonSchema) validateNumber(number float64, report func(string, ...interface{})) {
+	if s.minimum != nil && (number < *s.minimum || s.exclusiveMinimum && number == *s.minimum) {
+		report("value %v is less than the minimum %v", number, *s.minimum)
+	}
+	if s.maximum != nil && (number > *s.maximum || s.exclusiveMaximum && number == *s.maximum) {
+		report("value %v is greater than the maximum %v", number, *s.maximum)
+	}
+	if s.multipleOf != nil {
+		quotient := number / *s.multipleOf
+		if math.Abs(quotient-math.Round(quotient)) > 1e-9 {
+			report("value %v is not a multiple of %v", number, *s.multipleOf)
+		}
+	}
+}
+
+// countMatches returns the number of schemas the value is valid against.
+func countMatches(schemas []*jsonSchema, value interface{}) int {
+	matches := 0
+	for _, schema := range schemas {
+		var problems []string
+		schema.validateValue(value, "", &problems)
+		if len(problems) == 0 {
+			matches++
+		}
+	}
+	return matches
+}
+
+// jsonType returns the JSON Schema type of a decoded json value. Numbers without fraction are integers.
+func jsonType(value interface{}) string {
+	switch typed := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case float64:
+		if typed == math.Trunc(typed) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	default:
+		return fmt.Sprintf("%T", value)
+	}
+}
+
+func matchesType(actual string, types []string) bool {
+	for _, name := range types {
+		if name == actual || name == "number" && actual == "integer" {
+			return true
+		}
+	}
+	return false
+}
+
+// validateEvents checks json encoded events against the compiled schema of their event type. Depending on
+// the category of the event type the schema applies to different parts of the events: for "data" events it
+// describes the data field, for "business" events all fields besides the metadata and for "undefined" events
+// the whole event.
+func validateEvents(encoded []byte, schema *jsonSchema, category string) error {
+	var events []interface{}
+	if err := json.Unmarshal(encoded, &events); err != nil {
+		return errors.Wrap(err, "unable to validate events")
+	}
+
+	for i, event := range events {
+		if err := validateEvent(event, schema, category); err != nil {
+			return errors.Wrapf(err, "event %d", i)
+		}
+	}
+	return nil
+}
+
+// validateEvent checks a single decoded event against the schema of its event type.
+func validateEvent(event interface{}, schema *jsonSchema, category string) error {
+	object, isObject := event.(map[string]interface{})
+	if isObject && (category == "data" || category == "business") {
+		if err := validateOccurredAt(object["metadata"]); err != nil {
+			return err
+		}
+	}
+
+	switch {
+	case category == "data" && isObject:
+		event = object["data"]
+	case category == "business" && isObject:
+		withoutMetadata := make(map[string]interface{}, len(object))
+		for name, value := range object {
+			if name != "metadata" {
+				withoutMetadata[name] = value
+			}
+		}
+		event = withoutMetadata
+	}
+
+	if problems := schema.validate(event); len(problems) > 0 {
+		return errors.Wrap(ErrSchemaViolation, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// validateOccurredAt checks whether occurred_at of the decoded metadata of a business or data event is an
+// RFC 3339 timestamp with timezone, e.g. "2017-08-10T20:01:45.764802230Z". A missing occurred_at is left to the
+// validation of Nakadi.
+func validateOccurredAt(metadata interface{}) error {
+	object, _ := metadata.(map[string]interface{})
+	value, ok := object["occurred_at"]
+	if !ok {
+		return nil
+	}
+	occurredAt, ok := value.(string)
+	if !ok {
+		return errors.Wrapf(ErrSchemaViolation, "/metadata/occurred_at: expected timestamp but got %s", jsonType(value))
+	}
+	if _, err := time.Parse(time.RFC3339Nano, occurredAt); err != nil {
+		return errors.Wrapf(ErrSchemaViolation, "/metadata/occurred_at: %q is not an RFC 3339 timestamp with timezone", occurredAt)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package nakadi
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCompileJSONSchema(t *testing.T) {
+	tests := []struct {
+		Name   string
+		Schema string
+		Error  string
+	}{
+		{Name: "invalid json", Schema: `{"type":`, Error: "invalid json at offset"},
+		{Name: "no object", Schema: `[]`, Error: "schema at #: must be an object"},
+		{Name: "unknown type", Schema: `{"type":"text"}`, Error: `schema at #: unknown type "text"`},
+		{Name: "invalid nested", Schema: `{"properties":{"a":{"minLength":-1}}}`, Error: "schema at #/properties/a: minLength must be a non-negative integer"},
+		{Name: "unsupported pattern", Schema: `{"pattern":"^(?!internal)"}`, Error: `schema at #: pattern "^(?!internal)"`},
+		{Name: "unsupported pattern properties", Schema: `{"patternProperties":{"\\k<a>":{}}}`, Error: "schema at #/patternProperties: pattern"},
+		{Name: "external ref", Schema: `{"$ref":"other.json"}`, Error: `reference "other.json", only references within the schema are supported`},
+		{Name: "unresolvable ref", Schema: `{"$ref":"#/definitions/missing"}`, Error: "unresolvable reference"},
+		{Name: "empty enum", Schema: `{"enum":[]}`, Error: "enum must be a non empty array"},
+		{Name: "valid", Schema: `{"definitions":{"a":{"type":"string"}},"properties":{"a":{"$ref":"#/definitions/a"}}}`},
+		{Name: "recursive", Schema: `{"properties":{"child":{"$ref":"#"}}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			_, err := compileJSONSchema(tt.Schema)
+			if tt.Error == "" {
+				assert.NoError(t, err)
+			} else {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), tt.Error)
+			}
+		})
+	}
+}
+
+func TestValidateSchema(t *testing.T) {
+	err := ValidateSchema(`{"properties":{"a":{"minLength":-1}}}`)
+	require.Error(t, err)
+	assert.Regexp(t, "invalid schema: schema at #/properties/a: minLength must be a non-negative integer", err)
+
+	assert.NoError(t, ValidateSchema(`{"properties":{"a":{"type":"string"}}}`))
+	assert.NoError(t, ValidateSchema(`{"properties":{"a":{"$ref":"other.json"},"b":{"pattern":"^(?!internal)"}}}`))
+}
+
+func TestJSONSchema_validate(t *testing.T) {
+	tests := []struct {
+		Name     string
+		Schema   string
+		Value    interface{}
+		Problems []string
+	}{
+		{Name: "type", Schema: `{"type":"string"}`, Value: 1.0, Problems: []string{"/: expected string but got integer"}},
+		{Name: "integer is number", Schema: `{"type":"number"}`, Value: 1.0},
+		{Name: "number is no integer", Schema: `{"type":["integer","null"]}`, Value: 1.5, Problems: []string{"/: expected integer or null but got number"}},
+		{Name: "enum", Schema: `{"enum":["a","b"]}`, Value: "c", Problems: []string{"/: value is not one of the allowed values"}},
+		{Name: "const", Schema: `{"const":null}`, Value: false, Problems: []string{"/: value is not the constant value"}},
+		{Name: "const and enum", Schema: `{"enum":["a","b"],"const":"c"}`, Value: "c", Problems: []string{"/: value is not one of the allowed values"}},
+		{Name: "const within enum", Schema: `{"enum":["a","b"],"const":"b"}`, Value: "b"},
+		{
+			Name:     "required and additional",
+			Schema:   `{"properties":{"a":{}},"required":["a"],"additionalProperties":false}`,
+			Value:    map[string]interface{}{"b": true},
+			Problems: []string{"/: required property a is missing", "/: additional property b is not allowed"},
+		},
+		{
+			Name:     "nested path",
+			Schema:   `{"properties":{"a":{"items":{"type":"string"}}}}`,
+			Value:    map[string]interface{}{"a": []interface{}{"x", false}},
+			Problems: []string{"/a/1: expected string but got boolean"},
+		},
+		{Name: "string length", Schema: `{"maxLength":2}`, Value: "äöü", Problems: []string{"/: expected at most 2 characters but got 3"}},
+		{Name: "pattern", Schema: `{"pattern":"^[a-z]+$"}`, Value: "A", Problems: []string{"/: value does not match the pattern ^[a-z]+$"}},
+		{Name: "date-time", Schema: `{"format":"date-time"}`, Value: "yesterday", Problems: []string{"/: value is not a valid date-time"}},
+		{Name: "valid date-time", Schema: `{"format":"date-time"}`, Value: "2019-03-01T12:00:00.123Z"},
+		{Name: "exclusive minimum", Schema: `{"minimum":1,"exclusiveMinimum":true}`, Value: 1.0, Problems: []string{"/: value 1 is less than the minimum 1"}},
+		{Name: "multiple of", Schema: `{"multipleOf":0.1}`, Value: 0.3},
+		{Name: "unique items", Schema: `{"uniqueItems":true}`, Value: []interface{}{1.0, 1.0}, Problems: []string{"/: items 0 and 1 are equal"}},
+		{Name: "one of", Schema: `{"oneOf":[{"type":"number"},{"type":"integer"}]}`, Value: 1.0, Problems: []string{"/: value must match exactly one of the schemas in oneOf but matches 2"}},
+		{Name: "any of", Schema: `{"anyOf":[{"type":"string"},{"type":"null"}]}`, Value: nil},
+		{Name: "not", Schema: `{"not":{"type":"null"}}`, Value: nil, Problems: []string{"/: value must not match the schema in not"}},
+		{
+			Name:     "recursive ref",
+			Schema:   `{"properties":{"name":{"type":"string"},"child":{"$ref":"#"}}}`,
+			Value:    map[string]interface{}{"child": map[string]interface{}{"child": map[string]interface{}{"name": 1.0}}},
+			Problems: []string{"/child/child/name: expected string but got integer"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.Name, func(t *testing.T) {
+			schema, err := compileJSONSchema(tt.Schema)
+			require.NoError(t, err)
+
+			assert.Equal(t, tt.Problems, schema.validate(tt.Value))
+		})
+	}
+}
+
+func TestValidateEvents(t *testing.T) {
+	schema, err := compileJSONSchema(`{"properties":{"id":{"type":"string"}},"required":["id"],"additionalProperties":false}`)
+	require.NoError(t, err)
+
+	t.Run("fail decode events", func(t *testing.T) {
+		err := validateEvents([]byte(`{}`), schema, "undefined")
+		require.Error(t, err)
+		assert.Regexp(t, "unable to validate events", err)
+	})
+
+	t.Run("fail undefined", func(t *testing.T) {
+		err := validateEvents([]byte(`[{"id":"1"},{"id":"2","metadata":{}}]`), schema, "undefined")
+		require.Error(t, err)
+		assert.Equal(t, ErrSchemaViolation, errors.Cause(err))
+		assert.Regexp(t, "event 1: /: additional property metadata is not allowed", err)
+	})
+
+	t.Run("success business", func(t *testing.T) {
+		err := validateEvents([]byte(`[{"id":"1","metadata":{}}]`), schema, "business")
+		assert.NoError(t, err)
+	})
+
+	t.Run("success data", func(t *testing.T) {
+		err := validateEvents([]byte(`[{"data":{"id":"1"},"data_op":"C","metadata":{}}]`), schema, "data")
+		assert.NoError(t, err)
+	})
+
+	t.Run("fail data", func(t *testing.T) {
+		err := validateEvents([]byte(`[{"data":{},"metadata":{}}]`), schema, "data")
+		require.Error(t, err)
+		assert.Regexp(t, "event 0: /: required property id is missing", err)
+	})
+
+	t.Run("fail occurred_at without timezone", func(t *testing.T) {
+		err := validateEvents([]byte(`[{"id":"1","metadata":{"occurred_at":"2017-08-10T22:01:45.764802230"}}]`), schema, "business")
+		require.Error(t, err)
+		assert.Equal(t, ErrSchemaViolation, errors.Cause(err))
+		assert.Regexp(t, `event 0: /metadata/occurred_at: "2017-08-10T22:01:45.764802230" is not an RFC 3339 timestamp with timezone`, err)
+	})
+
+	t.Run("fail occurred_at no timestamp", func(t *testing.T) {
+		err := validateEvents([]byte(`[{"data":{"id":"1"},"metadata":{"occurred_at":"2017-08-10T20:01:45Z"}},{"data":{"id":"2"},"metadata":{"occurred_at":1502395305}}]`), schema, "data")
+		require.Error(t, err)
+		assert.Regexp(t, "event 1: /metadata/occurred_at: expected timestamp but got integer", err)
+	})
+
+	t.Run("success occurred_at with nanoseconds", func(t *testing.T) {
+		err := validateEvents([]byte(`[{"id":"1","metadata":{"occurred_at":"2017-08-10T20:01:45.764802230Z"}},{"id":"2","metadata":{"occurred_at":"2017-08-10T22:01:45.1+02:00"}}]`), schema, "business")
+		assert.NoError(t, err)
+	})
+
+	t.Run("success occurred_at of undefined events", func(t *testing.T) {
+		schema, err := compileJSONSchema(`{}`)
+		require.NoError(t, err)
+		err = validateEvents([]byte(`[{"metadata":{"occurred_at":"yesterday"}},{"metadata":null}]`), schema, "undefined")
+		assert.NoError(t, err)
+	})
+}
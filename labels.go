@@ -0,0 +1,44 @@
+package nakadi
+
+import (
+	"context"
+	"net/http"
+)
+
+type labelsKey struct{}
+
+// WithLabels returns a copy of ctx which carries the given labels in addition to the labels already carried
+// by ctx, labels of the same name are replaced. Labels don't change requests on their own: decorators like
+// the one returned by LabelHeaders read them from the context of requests, e.g. in order to route the
+// requests of PublishContext by tenant without using a separate client per tenant.
+func WithLabels(ctx context.Context, labels map[string]string) context.Context {
+	merged := make(map[string]string, len(labels))
+	for name, value := range LabelsFromContext(ctx) {
+		merged[name] = value
+	}
+	for name, value := range labels {
+		merged[name] = value
+	}
+	return context.WithValue(ctx, labelsKey{}, merged)
+}
+
+// LabelsFromContext returns the labels carried by ctx or nil if ctx has no labels. The returned map must not
+// be modified.
+func LabelsFromContext(ctx context.Context) map[string]string {
+	labels, _ := ctx.Value(labelsKey{}).(map[string]string)
+	return labels
+}
+
+// LabelHeaders returns a RequestDecorator which sets a header for each label carried by the context of a
+// request. The headers map the names of labels to the names of the headers, e.g. {"tenant": "X-Tenant"}.
+// Labels without header are not sent.
+func LabelHeaders(headers map[string]string) RequestDecorator {
+	return func(request *http.Request) error {
+		for name, value := range LabelsFromContext(request.Context()) {
+			if header, ok := headers[name]; ok {
+				request.Header.Set(header, value)
+			}
+		}
+		return nil
+	}
+}
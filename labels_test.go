@@ -0,0 +1,45 @@
+package nakadi
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWithLabels(t *testing.T) {
+	assert.Nil(t, LabelsFromContext(context.Background()))
+
+	ctx := WithLabels(context.Background(), map[string]string{"tenant": "tenant-1", "env": "test"})
+	overridden := WithLabels(ctx, map[string]string{"tenant": "tenant-2"})
+	assert.Equal(t, map[string]string{"tenant": "tenant-1", "env": "test"}, LabelsFromContext(ctx))
+	assert.Equal(t, map[string]string{"tenant": "tenant-2", "env": "test"}, LabelsFromContext(overridden))
+}
+
+func TestLabelHeaders(t *testing.T) {
+	transport := httpmock.NewMockTransport()
+	client := New(defaultNakadiURL, &ClientOptions{RequestDecorators: []RequestDecorator{
+		LabelHeaders(map[string]string{"tenant": "X-Tenant", "env": "X-Env"})}})
+	client.httpClient = &http.Client{Transport: transport}
+	api := NewPublishAPI(client, "test-event", nil)
+
+	var headers []http.Header
+	transport.RegisterResponder("POST", defaultNakadiURL+"/event-types/test-event/events", func(r *http.Request) (*http.Response, error) {
+		headers = append(headers, r.Header)
+		return httpmock.NewStringResponse(http.StatusOK, ""), nil
+	})
+
+	ctx := WithLabels(context.Background(), map[string]string{"tenant": "tenant-1", "env": "test", "other": "value"})
+	require.NoError(t, api.PublishContext(ctx, []SomeUndefinedEvent{{Test: "event"}}))
+	require.NoError(t, api.Publish([]SomeUndefinedEvent{{Test: "event"}}))
+
+	require.Len(t, headers, 2)
+	assert.Equal(t, "tenant-1", headers[0].Get("X-Tenant"))
+	assert.Equal(t, "test", headers[0].Get("X-Env"))
+	assert.Empty(t, headers[0].Get("Other"))
+	assert.Empty(t, headers[1].Get("X-Tenant"))
+	assert.Empty(t, headers[1].Get("X-Env"))
+}
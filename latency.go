@@ -0,0 +1,89 @@
+package nakadi
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Latency describes the time between the ingestion of the events of a batch by Nakadi and the delivery of the
+// batch to the consumer. Max is the latency of the oldest event of the batch, Events is the number of events
+// of the batch which have a metadata field received_at.
+type Latency struct {
+	SubscriptionID string
+	EventType      string
+	Partition      string
+	Max            time.Duration
+	Events         int
+}
+
+// LatencyCollector can be implemented by the MetricsCollector of a client in order to receive the latency of
+// each batch delivered by streams with StreamOptions.ObserveLatency. Batches without any event with received_at
+// are not reported.
+type LatencyCollector interface {
+	ObserveLatency(latency Latency)
+}
+
+// Latencies returns the time passed between metadata.received_at of each event of the batch and now. Events
+// without received_at, e.g. events of the category "undefined", are skipped, so the result may contain fewer
+// entries than the batch has events.
+func (b StreamBatch) Latencies(now time.Time) ([]time.Duration, error) {
+	var events []struct {
+		Metadata struct {
+			ReceivedAt *time.Time `json:"received_at"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal(b.Events, &events); err != nil {
+		return nil, errors.Wrap(err, "unable to compute latency")
+	}
+
+	latencies := make([]time.Duration, 0, len(events))
+	for _, event := range events {
+		if event.Metadata.ReceivedAt != nil {
+			latencies = append(latencies, now.Sub(*event.Metadata.ReceivedAt))
+		}
+	}
+	return latencies, nil
+}
+
+// MaxLatency returns the largest latency of the events of the batch as computed by Latencies. It returns zero
+// if no event of the batch has received_at.
+func (b StreamBatch) MaxLatency(now time.Time) (time.Duration, error) {
+	latencies, err := b.Latencies(now)
+	if err != nil {
+		return 0, err
+	}
+
+	var max time.Duration
+	for _, latency := range latencies {
+		if latency > max {
+			max = latency
+		}
+	}
+	return max, nil
+}
+
+// observeLatency reports the latency of a delivered batch to the latency collector of the stream.
+func (s *StreamAPI) observeLatency(cursor Cursor, events []byte) {
+	if s.latencySink == nil || len(events) == 0 {
+		return
+	}
+
+	latencies, err := StreamBatch{Cursor: cursor, Events: events}.Latencies(time.Now())
+	if err != nil || len(latencies) == 0 {
+		return
+	}
+
+	latency := Latency{
+		SubscriptionID: s.SubscriptionID(),
+		EventType:      cursor.EventType,
+		Partition:      cursor.Partition,
+		Events:         len(latencies)}
+	for _, l := range latencies {
+		if l > latency.Max {
+			latency.Max = l
+		}
+	}
+	s.latencySink.ObserveLatency(latency)
+}
@@ -0,0 +1,84 @@
+package nakadi
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamBatch_Latencies(t *testing.T) {
+	now := time.Date(2020, 1, 1, 12, 0, 10, 0, time.UTC)
+	batch := StreamBatch{Events: []byte(`[
+		{"metadata":{"eid":"1","received_at":"2020-01-01T12:00:08Z"}},
+		{"metadata":{"eid":"2"}},
+		{"metadata":{"eid":"3","received_at":"2020-01-01T13:00:05+01:00"}}]`)}
+
+	t.Run("fail invalid events", func(t *testing.T) {
+		_, err := StreamBatch{Events: []byte(`invalid`)}.Latencies(now)
+		require.Error(t, err)
+		assert.Regexp(t, "unable to compute latency", err)
+	})
+
+	t.Run("success skip events without received at", func(t *testing.T) {
+		latencies, err := batch.Latencies(now)
+		require.NoError(t, err)
+		assert.Equal(t, []time.Duration{2 * time.Second, 5 * time.Second}, latencies)
+
+		max, err := batch.MaxLatency(now)
+		require.NoError(t, err)
+		assert.Equal(t, 5*time.Second, max)
+	})
+
+	t.Run("success undefined events", func(t *testing.T) {
+		max, err := StreamBatch{Events: []byte(`[{"test":"event"}]`)}.MaxLatency(now)
+		require.NoError(t, err)
+		assert.Equal(t, time.Duration(0), max)
+	})
+}
+
+type recordingLatencyCollector struct {
+	recordingCollector
+	mutex     sync.Mutex
+	latencies []Latency
+}
+
+func (c *recordingLatencyCollector) ObserveLatency(latency Latency) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.latencies = append(c.latencies, latency)
+}
+
+func TestStreamAPI_ObserveLatency(t *testing.T) {
+	cursor := Cursor{NakadiStreamID: "stream-id", EventType: "test-event", Partition: "0"}
+	receivedAt := time.Now().Add(-time.Minute).UTC().Format(time.RFC3339Nano)
+	events := []byte(`[{"metadata":{"eid":"1","received_at":"` + receivedAt + `"}},{"metadata":{"eid":"2"}}]`)
+
+	collector := &recordingLatencyCollector{}
+	stream := &mockStreamer{}
+	streamAPI, opener, _ := newMockStream(nil, nil)
+	streamAPI.subscriptionID = "sub-id"
+	streamAPI.latencySink = collector
+
+	opener.On("openStream").Return(stream, nil)
+	stream.On("nextEvents").Return(cursor, events, nil)
+	stream.On("closeStream").Return(nil)
+
+	go streamAPI.startStream()
+	defer streamAPI.Close()
+
+	_, _, err := streamAPI.NextEvents()
+	require.NoError(t, err)
+
+	collector.mutex.Lock()
+	defer collector.mutex.Unlock()
+	require.Len(t, collector.latencies, 1)
+	latency := collector.latencies[0]
+	assert.Equal(t, "sub-id", latency.SubscriptionID)
+	assert.Equal(t, "test-event", latency.EventType)
+	assert.Equal(t, "0", latency.Partition)
+	assert.Equal(t, 1, latency.Events)
+	assert.True(t, latency.Max >= time.Minute)
+}
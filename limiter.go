@@ -0,0 +1,47 @@
+package nakadi
+
+import (
+	"sync"
+	"time"
+)
+
+// eventLimiter is a token bucket which limits the number of events processed per second. The bucket holds at
+// most one second worth of events. Batches which are larger than the bucket are admitted once the bucket is
+// full and leave it in debt, so that the average rate is kept.
+type eventLimiter struct {
+	mutex  sync.Mutex
+	rate   float64
+	tokens float64
+	last   time.Time
+}
+
+func newEventLimiter(eventsPerSecond uint, now time.Time) *eventLimiter {
+	return &eventLimiter{rate: float64(eventsPerSecond), tokens: float64(eventsPerSecond), last: now}
+}
+
+// reserve takes the tokens for n events from the bucket and returns the time to wait before the events may be
+// processed.
+func (l *eventLimiter) reserve(n int64, now time.Time) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if elapsed := now.Sub(l.last); elapsed > 0 {
+		l.tokens += elapsed.Seconds() * l.rate
+		if l.tokens > l.rate {
+			l.tokens = l.rate
+		}
+		l.last = now
+	}
+
+	// a batch larger than the bucket has to wait until the bucket is full
+	required := float64(n)
+	if required > l.rate {
+		required = l.rate
+	}
+	var wait time.Duration
+	if l.tokens < required {
+		wait = time.Duration((required - l.tokens) / l.rate * float64(time.Second))
+	}
+	l.tokens -= float64(n)
+	return wait
+}
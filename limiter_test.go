@@ -0,0 +1,26 @@
+package nakadi
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventLimiter_reserve(t *testing.T) {
+	start := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	limiter := newEventLimiter(10, start)
+
+	// the bucket starts full
+	assert.Equal(t, time.Duration(0), limiter.reserve(6, start))
+	assert.Equal(t, time.Duration(0), limiter.reserve(4, start))
+	assert.Equal(t, 500*time.Millisecond, limiter.reserve(5, start))
+
+	// the wait was taken, the bucket is empty again
+	assert.Equal(t, 500*time.Millisecond, limiter.reserve(5, start.Add(500*time.Millisecond)))
+
+	// batches larger than the bucket leave it in debt
+	later := start.Add(time.Hour)
+	assert.Equal(t, time.Duration(0), limiter.reserve(30, later))
+	assert.Equal(t, 2100*time.Millisecond, limiter.reserve(1, later))
+}
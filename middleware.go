@@ -0,0 +1,215 @@
+package nakadi
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrHandlerPanic is the cause of errors returned by handlers wrapped with RecoverMiddleware when the
+// handler panicked.
+var ErrHandlerPanic = errors.New("handler panicked")
+
+// ErrHandlerTimeout is the cause of errors returned by ForEach and ForEachContext when the handler did not
+// process a batch within StreamOptions.HandlerTimeout.
+var ErrHandlerTimeout = errors.New("handler timed out")
+
+// A Handler processes a single batch received from a stream. If the handler returns without an error the
+// cursor of the batch is committed.
+type Handler func(batch StreamBatch) error
+
+// A Middleware decorates a Handler, e.g. in order to add instrumentation or error handling around it. A
+// middleware calls next to pass the batch on to the decorated handler.
+type Middleware func(next Handler) Handler
+
+// Chain applies the middlewares to the handler. The first middleware is the outermost one: it receives
+// each batch first and the result of the handler last. Chain(h, a, b) is therefore equivalent to a(b(h)).
+func Chain(handler Handler, middlewares ...Middleware) Handler {
+	for i := len(middlewares) - 1; i >= 0; i-- {
+		handler = middlewares[i](handler)
+	}
+	return handler
+}
+
+// RecoverMiddleware recovers from panics of the handlers it decorates. A panic is turned into an error
+// caused by ErrHandlerPanic, so the batch is not committed and the panic does not crash the consumer.
+func RecoverMiddleware(next Handler) Handler {
+	return func(batch StreamBatch) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = errors.Wrapf(ErrHandlerPanic, "%v", r)
+			}
+		}()
+		return next(batch)
+	}
+}
+
+// TracingMiddleware returns a middleware which allows to trace the handling of each batch without
+// depending on a specific tracing library. Before the handler is called, start receives the batch and the
+// span contexts of its events as returned by SpanContexts; the returned function is called with the result
+// of the handler. If the span contexts can't be extracted, start receives nil.
+func TracingMiddleware(start func(batch StreamBatch, spanContexts []map[string]string) func(err error)) Middleware {
+	return func(next Handler) Handler {
+		return func(batch StreamBatch) error {
+			spanContexts, _ := SpanContexts(batch.Events)
+			finish := start(batch, spanContexts)
+
+			err := next(batch)
+			if finish != nil {
+				finish(err)
+			}
+			return err
+		}
+	}
+}
+
+// A ContextHandler processes a single batch like a Handler. The context is canceled once the context passed to
+// ForEachContext is done or the stream is closed, so that long running handlers can abort processing.
+type ContextHandler func(ctx context.Context, batch StreamBatch) error
+
+// ForEach passes each batch received from the stream to the handler decorated with the middlewares, see
+// Chain for their order. The cursor of a batch is committed as soon as the handler returns without an
+// error. ForEach blocks until the stream is closed, in which case it returns nil, or until the handler or
+// a commit fails, in which case the error is returned and the batch is not committed. Errors while reading
+// from the stream are not returned, since the stream reconnects on its own. ForEach must not be combined
+// with NextEvents or Channel. If StreamOptions.RecoverPanics is set, panics are recovered outside of all
+// middlewares.
+func (s *StreamAPI) ForEach(handler Handler, middlewares ...Middleware) error {
+	return s.ForEachContext(context.Background(), func(_ context.Context, batch StreamBatch) error {
+		return handler(batch)
+	}, middlewares...)
+}
+
+// ForEachContext works like ForEach, but passes a context to the handler which is canceled once ctx is done
+// or the stream is closed. The batch which is processed while the context is canceled is not committed, even
+// if the handler returns without an error. If ctx is done ForEachContext returns an error caused by the error
+// of ctx, if the stream was closed it returns nil. The stream is not closed when ctx is done.
+func (s *StreamAPI) ForEachContext(ctx context.Context, handler ContextHandler, middlewares ...Middleware) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-s.ctx.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	// batchCtx is the context of the batch which is processed, it is only replaced after the handler returned
+	batchCtx := ctx
+	chained := Chain(func(batch StreamBatch) error { return handler(batchCtx, batch) }, middlewares...)
+	if s.recoverPanic {
+		chained = s.recoverPanics(chained)
+	}
+
+	var delivered int64
+	for {
+		cursor, events, err := s.nextEvents(ctx.Done())
+		if err == context.Canceled {
+			break
+		}
+		if err != nil {
+			continue
+		}
+		if !s.waitForLimiter(ctx, events) {
+			break
+		}
+
+		if s.handlerTimeout > 0 {
+			var cancelBatch context.CancelFunc
+			batchCtx, cancelBatch = context.WithTimeout(ctx, s.handlerTimeout)
+			err = s.handleWithTimeout(batchCtx, chained, StreamBatch{Cursor: cursor, Events: events})
+			cancelBatch()
+		} else {
+			err = chained(StreamBatch{Cursor: cursor, Events: events})
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := s.CommitCursor(cursor); err != nil {
+			return errors.Wrap(err, "unable to commit batch")
+		}
+
+		if s.maxEvents > 0 {
+			delivered += s.batchSize(events)
+			if delivered >= s.maxEvents {
+				return s.Close()
+			}
+		}
+	}
+
+	if s.ctx.Err() != nil {
+		return nil
+	}
+	return errors.Wrap(ctx.Err(), "unable to consume stream")
+}
+
+// handleWithTimeout passes the batch to the handler and waits until it returns or ctx is done. If the timeout
+// of ctx expires first, an error caused by ErrHandlerTimeout is returned and the handler is left running.
+func (s *StreamAPI) handleWithTimeout(ctx context.Context, handler Handler, batch StreamBatch) error {
+	done := make(chan error, 1)
+	go func() { done <- handler(batch) }()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		if ctx.Err() != context.DeadlineExceeded {
+			return ctx.Err()
+		}
+		return errors.Wrapf(ErrHandlerTimeout, "partition %s of %s at offset %s not processed within %s",
+			batch.Cursor.Partition, batch.Cursor.EventType, batch.Cursor.Offset, s.handlerTimeout)
+	}
+}
+
+// batchSize returns the number of events of a batch. Batches of streams which don't use JSONCodec count as a
+// single event.
+func (s *StreamAPI) batchSize(events []byte) int64 {
+	if s.jsonCodec {
+		return countEvents(events)
+	}
+	return 1
+}
+
+// waitForLimiter blocks until the limiter of the stream permits the events of a batch to be processed. It
+// returns false if ctx is done before.
+func (s *StreamAPI) waitForLimiter(ctx context.Context, events []byte) bool {
+	if s.limiter == nil {
+		return true
+	}
+
+	wait := s.limiter.reserve(s.batchSize(events), time.Now())
+	if wait <= 0 {
+		return true
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// recoverPanics recovers from panics like RecoverMiddleware and logs them via the logger of the client.
+func (s *StreamAPI) recoverPanics(next Handler) Handler {
+	return func(batch StreamBatch) (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				err = errors.Wrapf(ErrHandlerPanic, "%v", r)
+				if s.logger != nil {
+					s.logger.Printf("recovered panic: subscription=%s partition=%s offset=%s panic=%v",
+						s.SubscriptionID(), batch.Cursor.Partition, batch.Cursor.Offset, r)
+				}
+			}
+		}()
+		return next(batch)
+	}
+}
@@ -0,0 +1,284 @@
+package nakadi
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChain(t *testing.T) {
+	var calls []string
+	middleware := func(name string) Middleware {
+		return func(next Handler) Handler {
+			return func(batch StreamBatch) error {
+				calls = append(calls, name+" before")
+				err := next(batch)
+				calls = append(calls, name+" after")
+				return err
+			}
+		}
+	}
+	handler := func(StreamBatch) error {
+		calls = append(calls, "handler")
+		return assert.AnError
+	}
+
+	err := Chain(handler, middleware("a"), middleware("b"))(StreamBatch{})
+	assert.Equal(t, assert.AnError, err)
+	assert.Equal(t, []string{"a before", "b before", "handler", "b after", "a after"}, calls)
+
+	calls = nil
+	err = Chain(handler)(StreamBatch{})
+	assert.Equal(t, assert.AnError, err)
+	assert.Equal(t, []string{"handler"}, calls)
+}
+
+func TestRecoverMiddleware(t *testing.T) {
+	t.Run("fail panic", func(t *testing.T) {
+		err := RecoverMiddleware(func(StreamBatch) error { panic("boom") })(StreamBatch{})
+		require.Error(t, err)
+		assert.Equal(t, ErrHandlerPanic, errors.Cause(err))
+		assert.Regexp(t, "boom", err)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		err := RecoverMiddleware(func(StreamBatch) error { return assert.AnError })(StreamBatch{})
+		assert.Equal(t, assert.AnError, err)
+	})
+}
+
+func TestTracingMiddleware(t *testing.T) {
+	batch := StreamBatch{
+		Cursor: Cursor{Partition: "0"},
+		Events: []byte(`[{"metadata":{"span_ctx":{"traceparent":"00-1-2-01"}}},{"metadata":{}}]`)}
+
+	var started StreamBatch
+	var spanContexts []map[string]string
+	var finished error
+	tracing := TracingMiddleware(func(b StreamBatch, s []map[string]string) func(error) {
+		started, spanContexts = b, s
+		return func(err error) { finished = err }
+	})
+
+	err := tracing(func(StreamBatch) error { return assert.AnError })(batch)
+	assert.Equal(t, assert.AnError, err)
+	assert.Equal(t, batch, started)
+	assert.Equal(t, []map[string]string{{SpanContextTraceParent: "00-1-2-01"}, nil}, spanContexts)
+	assert.Equal(t, assert.AnError, finished)
+
+	err = tracing(func(StreamBatch) error { return nil })(StreamBatch{Events: []byte(`invalid`)})
+	assert.NoError(t, err)
+	assert.Nil(t, spanContexts)
+	assert.NoError(t, finished)
+}
+
+func TestStreamAPI_ForEach(t *testing.T) {
+	firstCursor := Cursor{NakadiStreamID: "stream-id", Partition: "0", Offset: "001-0001-000000000000000001"}
+	secondCursor := Cursor{NakadiStreamID: "stream-id", Partition: "0", Offset: "001-0001-000000000000000002"}
+	events := []byte(`[{"metadata":{"eid":"1"}}]`)
+
+	t.Run("fail handler", func(t *testing.T) {
+		stream := &mockStreamer{}
+		streamAPI, opener, committer := newMockStream(nil, nil)
+		opener.On("openStream").Return(stream, nil)
+		stream.On("nextEvents").Once().Return(firstCursor, events, nil)
+		stream.On("nextEvents").Return(secondCursor, events, nil)
+		stream.On("closeStream").Return(nil)
+		committer.On("commitCursors", []Cursor{firstCursor}).Once().Return(nil)
+
+		go streamAPI.startStream()
+		defer streamAPI.Close()
+
+		var batches []StreamBatch
+		err := streamAPI.ForEach(func(batch StreamBatch) error {
+			batches = append(batches, batch)
+			if len(batches) > 1 {
+				panic("boom")
+			}
+			return nil
+		}, RecoverMiddleware)
+
+		require.Error(t, err)
+		assert.Equal(t, ErrHandlerPanic, errors.Cause(err))
+		assert.Equal(t, []StreamBatch{{Cursor: firstCursor, Events: events}, {Cursor: secondCursor, Events: events}}, batches)
+		mock.AssertExpectationsForObjects(t, committer)
+	})
+
+	t.Run("fail commit", func(t *testing.T) {
+		stream := &mockStreamer{}
+		streamAPI, opener, committer := newMockStream(nil, nil)
+		streamAPI.commitBackOffConf.Retry = false
+		opener.On("openStream").Return(stream, nil)
+		stream.On("nextEvents").Return(firstCursor, events, nil)
+		stream.On("closeStream").Return(nil)
+		committer.On("commitCursors", []Cursor{firstCursor}).Return(assert.AnError)
+
+		go streamAPI.startStream()
+		defer streamAPI.Close()
+
+		err := streamAPI.ForEach(func(StreamBatch) error { return nil })
+		require.Error(t, err)
+		assert.Regexp(t, "unable to commit batch", err)
+	})
+
+	t.Run("fail recover panics", func(t *testing.T) {
+		logger := &recordingLogger{}
+		stream := &mockStreamer{}
+		streamAPI, opener, committer := newMockStream(nil, nil)
+		streamAPI.subscriptionID = "sub-id"
+		streamAPI.recoverPanic = true
+		streamAPI.logger = logger
+		opener.On("openStream").Return(stream, nil)
+		stream.On("nextEvents").Return(firstCursor, events, nil)
+		stream.On("closeStream").Return(nil)
+
+		go streamAPI.startStream()
+		defer streamAPI.Close()
+
+		err := streamAPI.ForEach(func(StreamBatch) error { panic("boom") })
+		require.Error(t, err)
+		assert.Equal(t, ErrHandlerPanic, errors.Cause(err))
+		assert.Equal(t, []string{"recovered panic: subscription=sub-id partition=0 offset=001-0001-000000000000000001 panic=boom"},
+			logger.Messages())
+		committer.AssertNotCalled(t, "commitCursors", mock.Anything)
+	})
+
+	t.Run("success max events", func(t *testing.T) {
+		stream := &mockStreamer{}
+		streamAPI, opener, committer := newMockStream(nil, nil)
+		streamAPI.jsonCodec = true
+		streamAPI.maxEvents = 3
+		opener.On("openStream").Return(stream, nil)
+		stream.On("nextEvents").Once().Return(firstCursor, []byte(`[{"metadata":{"eid":"1"}},{"metadata":{"eid":"2"}}]`), nil)
+		stream.On("nextEvents").Return(secondCursor, []byte(`[{"metadata":{"eid":"3"}},{"metadata":{"eid":"4"}}]`), nil)
+		stream.On("closeStream").Return(nil)
+		committer.On("commitCursors", []Cursor{firstCursor}).Once().Return(nil)
+		committer.On("commitCursors", []Cursor{secondCursor}).Once().Return(nil)
+
+		go streamAPI.startStream()
+
+		var batches []StreamBatch
+		err := streamAPI.ForEach(func(batch StreamBatch) error {
+			batches = append(batches, batch)
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Len(t, batches, 2)
+		assert.Error(t, streamAPI.ctx.Err())
+		mock.AssertExpectationsForObjects(t, committer)
+	})
+
+	t.Run("success stream closed", func(t *testing.T) {
+		streamAPI, _, _ := newMockStream(nil, nil)
+		streamAPI.Close()
+
+		err := streamAPI.ForEach(func(StreamBatch) error { return assert.AnError })
+		assert.NoError(t, err)
+	})
+}
+
+func TestStreamAPI_ForEachContext(t *testing.T) {
+	cursor := Cursor{NakadiStreamID: "stream-id", Partition: "0", Offset: "001-0001-000000000000000001"}
+	events := []byte(`[{"metadata":{"eid":"1"}}]`)
+
+	setup := func() (*StreamAPI, *mockCommitter) {
+		stream := &mockStreamer{}
+		streamAPI, opener, committer := newMockStream(nil, nil)
+		opener.On("openStream").Return(stream, nil)
+		stream.On("nextEvents").Return(cursor, events, nil)
+		stream.On("closeStream").Return(nil)
+		go streamAPI.startStream()
+		return streamAPI, committer
+	}
+
+	t.Run("fail canceled in handler", func(t *testing.T) {
+		streamAPI, committer := setup()
+		defer streamAPI.Close()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		err := streamAPI.ForEachContext(ctx, func(ctx context.Context, _ StreamBatch) error {
+			cancel()
+			<-ctx.Done()
+			return nil
+		})
+		require.Error(t, err)
+		assert.Equal(t, context.Canceled, errors.Cause(err))
+		committer.AssertNotCalled(t, "commitCursors", mock.Anything)
+	})
+
+	t.Run("fail canceled while throttled", func(t *testing.T) {
+		streamAPI, committer := setup()
+		defer streamAPI.Close()
+		streamAPI.limiter = newEventLimiter(1, time.Now())
+		committer.On("commitCursors", []Cursor{cursor}).Once().Return(nil)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		calls := 0
+		go func() {
+			time.Sleep(100 * time.Millisecond)
+			cancel()
+		}()
+		err := streamAPI.ForEachContext(ctx, func(context.Context, StreamBatch) error {
+			calls++
+			return nil
+		})
+		require.Error(t, err)
+		assert.Equal(t, context.Canceled, errors.Cause(err))
+		assert.Equal(t, 1, calls)
+		committer.AssertNumberOfCalls(t, "commitCursors", 1)
+	})
+
+	t.Run("success stream closed in handler", func(t *testing.T) {
+		streamAPI, committer := setup()
+
+		err := streamAPI.ForEachContext(context.Background(), func(ctx context.Context, _ StreamBatch) error {
+			streamAPI.Close()
+			<-ctx.Done()
+			return nil
+		})
+		assert.NoError(t, err)
+		committer.AssertNotCalled(t, "commitCursors", mock.Anything)
+	})
+	t.Run("fail handler timeout", func(t *testing.T) {
+		streamAPI, committer := setup()
+		defer streamAPI.Close()
+		streamAPI.handlerTimeout = 20 * time.Millisecond
+
+		canceled := make(chan struct{})
+		err := streamAPI.ForEachContext(context.Background(), func(ctx context.Context, _ StreamBatch) error {
+			<-ctx.Done()
+			close(canceled)
+			time.Sleep(100 * time.Millisecond)
+			return nil
+		})
+		require.Error(t, err)
+		assert.Equal(t, ErrHandlerTimeout, errors.Cause(err))
+		assert.Regexp(t, "offset 001-0001-000000000000000001 not processed within 20ms", err)
+		<-canceled
+		committer.AssertNotCalled(t, "commitCursors", mock.Anything)
+	})
+
+	t.Run("success within handler timeout", func(t *testing.T) {
+		streamAPI, committer := setup()
+		streamAPI.handlerTimeout = time.Second
+		committer.On("commitCursors", []Cursor{cursor}).Once().Return(nil)
+
+		calls := 0
+		err := streamAPI.ForEachContext(context.Background(), func(ctx context.Context, _ StreamBatch) error {
+			calls++
+			if calls == 2 {
+				streamAPI.Close()
+				<-ctx.Done()
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 2, calls)
+		committer.AssertNumberOfCalls(t, "commitCursors", 1)
+	})
+}
@@ -16,9 +16,14 @@ package nakadi
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/cenkalti/backoff/v3"
@@ -30,6 +35,22 @@ const (
 	defaultInitialRetryInterval = time.Millisecond * 10
 	defaultMaxRetryInterval     = 10 * time.Second
 	defaultMaxElapsedTime       = 30 * time.Second
+	defaultContentType          = "application/json;charset=UTF-8"
+	defaultAccept               = "application/json"
+	defaultAsyncPublishWorkers  = 4
+	defaultAsyncPublishQueue    = 1000
+	defaultCatchUpPollInterval  = time.Second
+	defaultSchemaCacheTTL       = 5 * time.Minute
+	defaultMaxPartialRetries    = 3
+	defaultThroughputWindow     = time.Minute
+	defaultFailoverThreshold    = 3
+	defaultRecoveryInterval     = 30 * time.Second
+	defaultPartitionCacheTTL    = 5 * time.Minute
+	defaultCompressThreshold    = 1024
+	defaultRetryMaxAttempts     = 3
+	defaultRetryMaxInterval     = time.Second
+	defaultTokenRefreshMargin   = 30 * time.Second
+	defaultChannelBufferSize    = 10
 )
 
 // A Client represents a basic configuration to access a Nakadi instance. The client is used to configure
@@ -38,14 +59,160 @@ type Client struct {
 	nakadiURL        string
 	tokenProvider    func() (string, error)
 	timeout          time.Duration
+	contentType      string
+	accept           string
 	httpClient       *http.Client
 	httpStreamClient *http.Client
+	async            *asyncPublisher
+	catchUpInterval  time.Duration
+	eidGenerator     func() string
+	settings         *settingsCache
+	eventTypes       *eventTypeCache
+	partitions       *partitionCache
+	retryIf          func(*http.Response, error) bool
+	strictDecode     bool
+	logger           Logger
+	slowThreshold    time.Duration
+	metrics          MetricsCollector
+	decorators       []RequestDecorator
+	commitBody       CommitBodyShape
+	timeouts         transportTimeouts
+	apiVersion       string
+	streams          *streamRegistry
+	maxAttempts      int
+	countRedirects   bool
+	customRedirect   bool
+	inspector        ResponseInspector
+	retryPolicy      *RetryPolicy
+}
+
+// A RequestDecorator modifies a request before it is sent to Nakadi, e.g. in order to add custom headers. An
+// error returned by a decorator aborts the request. The context of the request is the context passed to the
+// method sending it, e.g. PublishContext, so decorators can use values of the context like a tenant id.
+type RequestDecorator func(*http.Request) error
+
+// A ResponseInspector receives the responses of publish requests, commits, attempts to open a stream and
+// requests creating a subscription along with the operation, e.g. in order to log headers added by a gateway.
+// The body of the response is replaced by http.NoBody since it is read and closed by the client. The inspector
+// is called synchronously after each request and must not modify the response.
+type ResponseInspector func(operation string, response *http.Response)
+
+// Logger is used by the client to log messages. It is implemented by the *log.Logger of the standard library.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// MetricsCollector receives an observation for each publish request, commit and attempt to open a stream
+// made by the client. Implementations must be safe for concurrent use and should return quickly, since
+// ObserveRequest is called synchronously after each request.
+type MetricsCollector interface {
+	ObserveRequest(metrics RequestMetrics)
+}
+
+// Operations reported in RequestMetrics and to the ResponseInspector.
+const (
+	OperationPublish            = "publish"
+	OperationCommit             = "commit"
+	OperationOpenStream         = "open stream"
+	OperationCreateSubscription = "create subscription"
+)
+
+// RequestMetrics describes a single request made by the client. EventType is set for publish requests,
+// commits and streams consuming an event type, SubscriptionID is set for commits and streams consuming a
+// subscription. A commit of cursors of several event types is reported once for each event type.
+type RequestMetrics struct {
+	Operation      string
+	EventType      string
+	SubscriptionID string
+	Duration       time.Duration
+	// StatusCode is the status of Nakadi's response or 0 if no response was received.
+	StatusCode int
+	// Err is the error which prevented a response or nil.
+	Err error
 }
 
 // ClientOptions contains all non mandatory parameters used to instantiate the Nakadi client.
 type ClientOptions struct {
 	TokenProvider     func() (string, error)
 	ConnectionTimeout time.Duration
+	// DialTimeout is the maximum time to establish a connection to Nakadi, it applies to requests and
+	// streams (default: ConnectionTimeout).
+	DialTimeout time.Duration
+	// DialContext establishes the connections of requests and streams instead of a net.Dialer, e.g. in order to
+	// connect through a service mesh sidecar or a unix socket. DialTimeout does not apply to connections
+	// established by DialContext, the function is responsible to respect the deadline of the context. The
+	// option has no effect if the http clients of the Client are replaced (default: nil, a net.Dialer is used).
+	DialContext func(ctx context.Context, network, address string) (net.Conn, error)
+	// TLSHandshakeTimeout is the maximum time to wait for the TLS handshake, it applies to requests and
+	// streams (default: ConnectionTimeout).
+	TLSHandshakeTimeout time.Duration
+	// ResponseHeaderTimeout is the maximum time to wait for the headers of Nakadi's response after the
+	// request was written, it applies to requests and streams. The time to read the body of a response is
+	// only limited by the ConnectionTimeout of requests (default: 0, no timeout).
+	ResponseHeaderTimeout time.Duration
+	// The media type sent in the Content-Type header of requests with a body
+	// (default: application/json;charset=UTF-8).
+	ContentType string
+	// The media type sent in the Accept header of requests, except for requests opening a stream
+	// (default: application/json).
+	Accept string
+	// The number of goroutines publishing events passed to PublishAsync (default: 4).
+	AsyncPublishWorkers uint
+	// The maximum number of events passed to PublishAsync which wait to be published. Once the queue
+	// is full PublishAsync blocks (default: 1000).
+	AsyncPublishQueueSize uint
+	// AsyncQueuePolicy defines the behavior of PublishAsync if the queue is full (default: AsyncQueueBlock).
+	AsyncQueuePolicy AsyncQueuePolicy
+	// The interval in which WaitForCatchUp requests the statistics of a subscription (default: 1s).
+	CatchUpPollInterval time.Duration
+	// EIDGenerator creates the eids of events with metadata created by NewEventMetadata. The generated ids
+	// are not validated by the client (default: random UUIDs version 4).
+	EIDGenerator func() string
+	// Whether or not decoding responses of Nakadi, like subscriptions or event types, fails if a response
+	// contains fields which are unknown to this package. This can be used in tests to detect changes of
+	// Nakadi's API which are not supported yet (default: false).
+	StrictDecode bool
+	// Logger receives the messages logged by the client (default: nil, nothing is logged).
+	Logger Logger
+	// SlowRequestThreshold is the latency above which publish requests, commits and attempts to open a stream
+	// are logged via the Logger along with the operation, the event type or subscription and the duration.
+	// Faster requests are not logged (default: 0, no requests are logged).
+	SlowRequestThreshold time.Duration
+	// Metrics receives an observation for each publish request, commit and attempt to open a stream, which
+	// are labeled with the event type or subscription (default: nil, nothing is collected).
+	Metrics MetricsCollector
+	// RequestDecorators are applied in order to every request sent to Nakadi, after the body and the headers
+	// set by the client, including the Authorization header obtained from the TokenProvider (default: nil).
+	RequestDecorators []RequestDecorator
+	// APIVersion is sent in the header X-Nakadi-Api-Version of every request, which allows to pin the behavior
+	// of clusters serving several versions of the API. The header is set before the RequestDecorators are
+	// applied (default: empty, no header is sent).
+	APIVersion string
+	// PathPrefix is inserted between the URL passed to New and the paths of all endpoints, which is the same as
+	// appending the prefix to the URL (default: empty).
+	PathPrefix string
+	// CheckRedirect is called before a redirect of requests and streams is followed, like the CheckRedirect
+	// function of http.Client. It can rewrite the URL of the redirected request or stop following redirects
+	// by returning an error. Redirects with status 307 and 308 are followed with the same method and body,
+	// which allows publishing through load balancers redirecting to another instance. For redirects to
+	// another domain the Authorization header is dropped, it can be restored by CheckRedirect for trusted
+	// hosts (default: nil, at most 10 redirects are followed).
+	CheckRedirect func(request *http.Request, via []*http.Request) error
+	// CommitBodyShape defines how cursors are encoded in the body of commit requests. All commits of the client
+	// use this shape, which has to match the version of the Nakadi cluster (default: CommitBodyItems).
+	CommitBodyShape CommitBodyShape
+	// MaxTotalAttempts caps the number of HTTP requests a single publish or commit sends, including retries,
+	// retries of throttled or partially failed batches and followed redirects. Once the attempts are used up,
+	// the last error or response is returned. Redirects are only counted by the http client created by New
+	// (default: 0, unlimited).
+	MaxTotalAttempts int
+	// ResponseInspector receives the status and headers of the responses of publish requests, commits, attempts
+	// to open a stream and requests creating a subscription (default: nil).
+	ResponseInspector ResponseInspector
+	// Retry configures the retries of requests which fail with transient errors, see RetryPolicy. Fields of
+	// the policy which are not set are replaced by their defaults (default: nil, requests are only retried by
+	// sub APIs with retries enabled).
+	Retry *RetryPolicy
 }
 
 func (o *ClientOptions) withDefaults() *ClientOptions {
@@ -56,40 +223,357 @@ func (o *ClientOptions) withDefaults() *ClientOptions {
 	if copyOptions.ConnectionTimeout == 0 {
 		copyOptions.ConnectionTimeout = defaultTimeOut
 	}
+	if copyOptions.DialTimeout == 0 {
+		copyOptions.DialTimeout = copyOptions.ConnectionTimeout
+	}
+	if copyOptions.TLSHandshakeTimeout == 0 {
+		copyOptions.TLSHandshakeTimeout = copyOptions.ConnectionTimeout
+	}
+	if copyOptions.ContentType == "" {
+		copyOptions.ContentType = defaultContentType
+	}
+	if copyOptions.Accept == "" {
+		copyOptions.Accept = defaultAccept
+	}
+	if copyOptions.AsyncPublishWorkers == 0 {
+		copyOptions.AsyncPublishWorkers = defaultAsyncPublishWorkers
+	}
+	if copyOptions.AsyncPublishQueueSize == 0 {
+		copyOptions.AsyncPublishQueueSize = defaultAsyncPublishQueue
+	}
+	if copyOptions.CatchUpPollInterval == 0 {
+		copyOptions.CatchUpPollInterval = defaultCatchUpPollInterval
+	}
+	if copyOptions.EIDGenerator == nil {
+		copyOptions.EIDGenerator = newUUID
+	}
+	if copyOptions.Retry != nil {
+		copyOptions.Retry = copyOptions.Retry.withDefaults()
+	}
 	return &copyOptions
 }
 
 // New creates a new Nakadi client. New receives the URL of the Nakadi instance the client should connect to.
-// In addition the second parameter options can be used to configure the behavior of the client and of all sub
-// APIs in this package. The options may be nil.
+// The URL may contain a path, e.g. if Nakadi is served under a path prefix by a gateway. In addition the
+// second parameter options can be used to configure the behavior of the client and of all sub APIs in this
+// package. The options may be nil.
 func New(url string, options *ClientOptions) *Client {
 	options = options.withDefaults()
+	timeouts := transportTimeouts{
+		dial:           options.DialTimeout,
+		dialContext:    options.DialContext,
+		tlsHandshake:   options.TLSHandshakeTimeout,
+		responseHeader: options.ResponseHeaderTimeout}
 
 	client := &Client{
-		nakadiURL:        url,
+		nakadiURL:        joinPathPrefix(url, options.PathPrefix),
 		timeout:          options.ConnectionTimeout,
 		tokenProvider:    options.TokenProvider,
-		httpClient:       newHTTPClient(options.ConnectionTimeout),
-		httpStreamClient: newHTTPStream(options.ConnectionTimeout)}
+		contentType:      options.ContentType,
+		accept:           options.Accept,
+		httpClient:       newHTTPClient(options.ConnectionTimeout, timeouts),
+		httpStreamClient: newHTTPStream(timeouts),
+		catchUpInterval:  options.CatchUpPollInterval,
+		eidGenerator:     options.EIDGenerator,
+		strictDecode:     options.StrictDecode,
+		logger:           options.Logger,
+		slowThreshold:    options.SlowRequestThreshold,
+		metrics:          options.Metrics,
+		decorators:       withAPIVersion(options.APIVersion, options.RequestDecorators),
+		settings:         &settingsCache{},
+		eventTypes:       &eventTypeCache{},
+		partitions:       &partitionCache{},
+		commitBody:       options.CommitBodyShape,
+		timeouts:         timeouts,
+		apiVersion:       options.APIVersion,
+		streams:          newStreamRegistry(),
+		maxAttempts:      options.MaxTotalAttempts,
+		inspector:        options.ResponseInspector,
+		retryPolicy:      options.Retry}
+	client.httpClient.CheckRedirect = options.CheckRedirect
+	if options.Retry != nil {
+		client.httpClient.Transport = &retryTransport{next: client.httpClient.Transport, policy: options.Retry}
+	}
+	if options.MaxTotalAttempts > 0 {
+		client.httpClient.CheckRedirect = checkRedirectAttempts(options.CheckRedirect)
+		client.countRedirects = true
+		client.customRedirect = options.CheckRedirect != nil
+	}
+	client.httpStreamClient.CheckRedirect = options.CheckRedirect
+	client.async = newAsyncPublisher(client, options.AsyncPublishWorkers, options.AsyncPublishQueueSize, options.AsyncQueuePolicy)
 
 	return client
 }
 
+// joinPathPrefix appends the path prefix to the URL. The result has no trailing slash.
+func joinPathPrefix(url, prefix string) string {
+	url = strings.TrimSuffix(url, "/")
+	if prefix = strings.Trim(prefix, "/"); prefix != "" {
+		url += "/" + prefix
+	}
+	return url
+}
+
+// eventsPath returns the path of the endpoint used to publish and to stream the events of an event type.
+func eventsPath(eventType string) string {
+	return "/event-types/" + eventType + "/events"
+}
+
+// Warmup establishes a connection to Nakadi by requesting its health endpoint. Since connections are reused by
+// the client, subsequent requests like publishing events don't have to wait for the connection and TLS setup.
+// This allows to warm up the client e.g. during a readiness check. The status of the response is not checked,
+// but an error is returned if Nakadi is not reachable or the context is done before the connection was made.
+func (c *Client) Warmup(ctx context.Context) error {
+	_, err := c.requestHealth(ctx, "unable to warm up connection")
+	return err
+}
+
+// requestHealth requests the health endpoint of Nakadi and returns the status of the response.
+func (c *Client) requestHealth(ctx context.Context, msg string) (int, error) {
+	request, err := http.NewRequest("GET", c.nakadiURL+"/health", nil)
+	if err != nil {
+		return 0, errors.Wrap(err, msg)
+	}
+
+	request = request.WithContext(ctx)
+	if err := c.decorateRequest(request); err != nil {
+		return 0, errors.Wrap(err, msg)
+	}
+
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return 0, errors.Wrap(err, msg)
+	}
+	defer response.Body.Close()
+
+	// the body is consumed completely so that the connection can be reused
+	_, err = io.Copy(ioutil.Discard, response.Body)
+	return response.StatusCode, errors.Wrap(err, msg)
+}
+
+// logSlowRequest logs a request which was started at the given time if it took longer than the slow request
+// threshold of the client. The key value pairs describe the request.
+func (c *Client) logSlowRequest(started time.Time, operation string, keyValues ...string) {
+	if c.logger == nil || c.slowThreshold <= 0 {
+		return
+	}
+	duration := time.Since(started)
+	if duration <= c.slowThreshold {
+		return
+	}
+
+	var fields strings.Builder
+	for i := 0; i+1 < len(keyValues); i += 2 {
+		fmt.Fprintf(&fields, " %s=%s", keyValues[i], keyValues[i+1])
+	}
+	c.logger.Printf("slow request: operation=%s%s duration=%s", operation, fields.String(), duration)
+}
+
+// collectRequest passes the metrics of a request which was started at the given time to the metrics collector
+// of the client. The duration and the status are filled in from started and the response.
+func (c *Client) collectRequest(started time.Time, metrics RequestMetrics, response *http.Response, err error) {
+	if c.metrics == nil {
+		return
+	}
+	metrics.Duration = time.Since(started)
+	metrics.Err = err
+	if response != nil {
+		metrics.StatusCode = response.StatusCode
+	}
+	c.metrics.ObserveRequest(metrics)
+}
+
+// inspectResponse passes a copy of the response without body to the response inspector of the client.
+func (c *Client) inspectResponse(operation string, response *http.Response) {
+	if c.inspector == nil || response == nil {
+		return
+	}
+	inspected := *response
+	inspected.Body = http.NoBody
+	c.inspector(operation, &inspected)
+}
+
+// A ClientOption overrides a setting of the copy of a client created by Client.With.
+type ClientOption func(c *Client)
+
+// WithTokenProvider replaces the TokenProvider of the client.
+func WithTokenProvider(tokenProvider func() (string, error)) ClientOption {
+	return func(c *Client) {
+		c.tokenProvider = tokenProvider
+	}
+}
+
+// WithEIDGenerator replaces the EIDGenerator of the client.
+func WithEIDGenerator(eidGenerator func() string) ClientOption {
+	return func(c *Client) {
+		c.eidGenerator = eidGenerator
+	}
+}
+
+// WithLogger replaces the Logger of the client.
+func WithLogger(logger Logger) ClientOption {
+	return func(c *Client) {
+		c.logger = logger
+	}
+}
+
+// WithMetrics replaces the MetricsCollector of the client.
+func WithMetrics(metrics MetricsCollector) ClientOption {
+	return func(c *Client) {
+		c.metrics = metrics
+	}
+}
+
+// WithRequestDecorators appends decorators to the RequestDecorators of the client, they are applied after
+// the decorators of the original client.
+func WithRequestDecorators(decorators ...RequestDecorator) ClientOption {
+	return func(c *Client) {
+		c.decorators = append(c.decorators, decorators...)
+	}
+}
+
+// With creates a copy of the client with the given options applied, e.g. in order to use a different token
+// provider per tenant without repeating the configuration. The copy shares the http clients and therefore the
+// connections of the original client as well as its caches of event types and settings. The request decorators
+// are copied, so that WithRequestDecorators does not affect the original client. PublishAsync of the copy
+// uses a separate queue with the size, workers and policy of the original client, Flush of the copy only waits
+// for the events passed to PublishAsync of the copy.
+func (c *Client) With(options ...ClientOption) *Client {
+	copyClient := *c
+	copyClient.decorators = append([]RequestDecorator(nil), c.decorators...)
+	for _, apply := range options {
+		apply(&copyClient)
+	}
+	if c.async != nil {
+		copyClient.async = newAsyncPublisher(&copyClient, c.async.workers, uint(cap(c.async.queue)), c.async.policy)
+	}
+	return &copyClient
+}
+
+// withTimeout creates a copy of the client which uses a different timeout for requests. The copy shares the
+// connections of the original client.
+func (c *Client) withTimeout(timeout time.Duration) *Client {
+	copyClient := *c
+	copyClient.timeout = timeout
+	if c.httpClient != nil {
+		httpClient := *c.httpClient
+		httpClient.Timeout = timeout
+		copyClient.httpClient = &httpClient
+	}
+	return &copyClient
+}
+
+// withRetryIf creates a copy of the client which decides with the given predicate whether POST requests are
+// retried. The copy shares the connections of the original client.
+func (c *Client) withRetryIf(retryIf func(*http.Response, error) bool) *Client {
+	copyClient := *c
+	copyClient.retryIf = retryIf
+	return &copyClient
+}
+
+// setContentHeaders sets the Accept header and if the request has a body the Content-Type header.
+func (c *Client) setContentHeaders(request *http.Request, hasBody bool) {
+	accept, contentType := c.accept, c.contentType
+	if accept == "" {
+		accept = defaultAccept
+	}
+	if contentType == "" {
+		contentType = defaultContentType
+	}
+
+	request.Header.Set("Accept", accept)
+	if hasBody {
+		request.Header.Set("Content-Type", contentType)
+	}
+}
+
+// apiVersionHeader is the header which carries ClientOptions.APIVersion.
+const apiVersionHeader = "X-Nakadi-Api-Version"
+
+// withAPIVersion returns the decorators preceded by a decorator which sets the API version header. Without
+// version the decorators are returned unchanged.
+func withAPIVersion(version string, decorators []RequestDecorator) []RequestDecorator {
+	if version == "" {
+		return decorators
+	}
+	setVersion := func(request *http.Request) error {
+		request.Header.Set(apiVersionHeader, version)
+		return nil
+	}
+	return append([]RequestDecorator{setVersion}, decorators...)
+}
+
+// decorateRequest sets the Authorization header obtained from the token provider and then applies the
+// decorators of the client to a request.
+func (c *Client) decorateRequest(request *http.Request) error {
+	if err := c.authorize(request); err != nil {
+		return err
+	}
+	for _, decorate := range c.decorators {
+		if err := decorate(request); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// authorize sets the Authorization header if the client has a token provider.
+func (c *Client) authorize(request *http.Request) error {
+	if c.tokenProvider == nil {
+		return nil
+	}
+	token, err := c.tokenProvider()
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+type requestHeadersKey struct{}
+
+// withRequestHeaders returns a copy of ctx which carries additional headers for requests sent with it.
+func withRequestHeaders(ctx context.Context, headers http.Header) context.Context {
+	return context.WithValue(ctx, requestHeadersKey{}, headers)
+}
+
+// setRequestHeaders adds the additional headers carried by the context of the request. The headers
+// Authorization, Content-Type and Accept are always controlled by the client and can not be overridden.
+func setRequestHeaders(request *http.Request) {
+	headers, _ := request.Context().Value(requestHeadersKey{}).(http.Header)
+	for key, values := range headers {
+		switch http.CanonicalHeaderKey(key) {
+		case "Authorization", "Content-Type", "Accept":
+			continue
+		}
+		for _, value := range values {
+			request.Header.Add(key, value)
+		}
+	}
+}
+
+// decodeJSON decodes a json encoded response body. If the client decodes strictly, unknown fields are an error.
+func (c *Client) decodeJSON(body io.Reader, target interface{}) error {
+	decoder := json.NewDecoder(body)
+	if c.strictDecode {
+		decoder.DisallowUnknownFields()
+	}
+	return decoder.Decode(target)
+}
+
 // httpGET fetches json encoded data with a GET request.
-func (c *Client) httpGET(backOff backoff.BackOff, url string, body interface{}, msg string) error {
+func (c *Client) httpGET(ctx context.Context, backOff backoff.BackOff, url string, body interface{}, msg string) error {
 	var response *http.Response
 	err := backoff.Retry(func() error {
 		request, err := http.NewRequest("GET", url, nil)
 		if err != nil {
 			return backoff.Permanent(errors.Wrapf(err, "%s: unable to prepare request", msg))
 		}
+		request = request.WithContext(ctx)
+		c.setContentHeaders(request, false)
 
-		if c.tokenProvider != nil {
-			token, err := c.tokenProvider()
-			if err != nil {
-				return backoff.Permanent(errors.Wrapf(err, "%s: unable to prepare request", msg))
-			}
-			request.Header.Set("Authorization", "Bearer "+token)
+		if err := c.decorateRequest(request); err != nil {
+			return backoff.Permanent(errors.Wrapf(err, "%s: unable to prepare request", msg))
 		}
 
 		response, err = c.httpClient.Do(request)
@@ -102,13 +586,13 @@ func (c *Client) httpGET(backOff backoff.BackOff, url string, body interface{},
 			if err != nil {
 				return errors.Wrapf(err, "%s: unable to read response body", msg)
 			}
-			err = decodeResponseToError(buffer, msg)
+			err = decodeResponseToError(response.StatusCode, buffer, msg)
 			response.Body.Close()
 			return err
 		}
 
 		return nil
-	}, backOff)
+	}, backoff.WithContext(backOff, ctx))
 
 	if err != nil {
 		return err
@@ -120,10 +604,13 @@ func (c *Client) httpGET(backOff backoff.BackOff, url string, body interface{},
 		if err != nil {
 			return errors.Wrap(err, "unable to read response body")
 		}
-		return decodeResponseToError(buffer, msg)
+		if response.StatusCode == http.StatusNotFound {
+			return notFoundError{decodeResponseToError(response.StatusCode, buffer, msg)}
+		}
+		return decodeResponseToError(response.StatusCode, buffer, msg)
 	}
 
-	err = json.NewDecoder(response.Body).Decode(body)
+	err = c.decodeJSON(response.Body, body)
 	if err != nil {
 		return errors.Wrap(err, "unable to decode response body")
 	}
@@ -132,7 +619,7 @@ func (c *Client) httpGET(backOff backoff.BackOff, url string, body interface{},
 }
 
 // httpPUT sends json encoded data via PUT request and returns a response.
-func (c *Client) httpPUT(backOff backoff.BackOff, url string, body interface{}, msg string) (*http.Response, error) {
+func (c *Client) httpPUT(ctx context.Context, backOff backoff.BackOff, url string, body interface{}, msg string) (*http.Response, error) {
 	encoded, err := json.Marshal(body)
 	if err != nil {
 		return nil, errors.Wrapf(err, "%s: unable to encode json body", msg)
@@ -144,14 +631,11 @@ func (c *Client) httpPUT(backOff backoff.BackOff, url string, body interface{},
 		if err != nil {
 			return backoff.Permanent(errors.Wrapf(err, "%s: unable to prepare request", msg))
 		}
+		request = request.WithContext(ctx)
 
-		request.Header.Set("Content-Type", "application/json;charset=UTF-8")
-		if c.tokenProvider != nil {
-			token, err := c.tokenProvider()
-			if err != nil {
-				return backoff.Permanent(errors.Wrapf(err, "%s: unable to prepare request", msg))
-			}
-			request.Header.Set("Authorization", "Bearer "+token)
+		c.setContentHeaders(request, true)
+		if err := c.decorateRequest(request); err != nil {
+			return backoff.Permanent(errors.Wrapf(err, "%s: unable to prepare request", msg))
 		}
 
 		response, err = c.httpClient.Do(request)
@@ -164,41 +648,68 @@ func (c *Client) httpPUT(backOff backoff.BackOff, url string, body interface{},
 			if err != nil {
 				return errors.Wrapf(err, "%s: unable to read response body", msg)
 			}
-			err = decodeResponseToError(buffer, msg)
+			err = decodeResponseToError(response.StatusCode, buffer, msg)
 			response.Body.Close()
 			return err
 		}
 
 		return nil
-	}, backOff)
+	}, backoff.WithContext(backOff, ctx))
 
 	return response, err
 }
 
-// httpPOST sends json encoded data via POST request and returns a response.
-func (c *Client) httpPOST(backOff backoff.BackOff, url string, body interface{}, msg string) (*http.Response, error) {
-	encoded, err := json.Marshal(body)
-	if err != nil {
-		return nil, errors.Wrapf(err, "%s: unable to encode json body", msg)
+// encodedJSON is a request body which is already encoded. In contrast to json.RawMessage it is sent without
+// being compacted, so the exact bytes are preserved.
+type encodedJSON []byte
+
+// gzipJSON is a request body which contains gzip compressed json. It is sent with the header
+// Content-Encoding: gzip.
+type gzipJSON []byte
+
+// httpPOST sends json encoded data via POST request and returns a response. Bodies of the type gzipJSON are sent
+// as they are along with the Content-Encoding header. If the client has a retry predicate, the predicate
+// decides which failed requests are retried instead of the status code.
+func (c *Client) httpPOST(ctx context.Context, backOff backoff.BackOff, url string, body interface{}, msg string) (*http.Response, error) {
+	var encoded []byte
+	var err error
+	var compressed bool
+	switch raw := body.(type) {
+	case encodedJSON:
+		encoded = raw
+	case gzipJSON:
+		encoded, compressed = raw, true
+	default:
+		if encoded, err = json.Marshal(body); err != nil {
+			return nil, errors.Wrapf(err, "%s: unable to encode json body", msg)
+		}
 	}
 
 	var response *http.Response
+	budget := attemptBudgetFrom(ctx)
 	err = backoff.Retry(func() error {
 		request, err := http.NewRequest("POST", url, bytes.NewReader(encoded))
 		if err != nil {
 			return backoff.Permanent(errors.Wrapf(err, "%s: unable to prepare request", msg))
 		}
+		request = request.WithContext(ctx)
 
-		request.Header.Set("Content-Type", "application/json;charset=UTF-8")
-		if c.tokenProvider != nil {
-			token, err := c.tokenProvider()
-			if err != nil {
-				return backoff.Permanent(errors.Wrapf(err, "%s: unable to prepare request", msg))
-			}
-			request.Header.Set("Authorization", "Bearer "+token)
+		setRequestHeaders(request)
+		c.setContentHeaders(request, true)
+		if compressed {
+			request.Header.Set("Content-Encoding", "gzip")
+		}
+		if err := c.decorateRequest(request); err != nil {
+			return backoff.Permanent(errors.Wrapf(err, "%s: unable to prepare request", msg))
+		}
+		if !budget.take() {
+			return backoff.Permanent(errors.Wrap(ErrAttemptsExhausted, msg))
 		}
 
 		response, err = c.httpClient.Do(request)
+		if c.retryIf != nil {
+			return c.classifyRetry(response, err, msg)
+		}
 		if err != nil {
 			return errors.Wrap(err, msg)
 		}
@@ -208,33 +719,62 @@ func (c *Client) httpPOST(backOff backoff.BackOff, url string, body interface{},
 			if err != nil {
 				return errors.Wrapf(err, "%s: unable to read response body", msg)
 			}
-			err = decodeResponseToError(buffer, msg)
+			err = decodeResponseToError(response.StatusCode, buffer, msg)
 			response.Body.Close()
 			return err
 		}
 
 		return nil
-	}, backOff)
+	}, backoff.WithContext(budget.limit(backOff), ctx))
 
 	return response, err
 }
 
-// httpDELETE sends a DELETE request. On errors httpDELETE expects a response body to contain
-// an error message in the format of application/problem+json.
-func (c *Client) httpDELETE(backOff backoff.BackOff, url, msg string) error {
+// classifyRetry uses the retry predicate of the client to decide whether a request is retried. The body of the
+// response is buffered, so that it can be read by the predicate as well as by the caller. For requests which
+// should be retried an error is returned.
+func (c *Client) classifyRetry(response *http.Response, err error, msg string) error {
+	if err != nil {
+		err = errors.Wrap(err, msg)
+		if c.retryIf(nil, err) {
+			return err
+		}
+		return backoff.Permanent(err)
+	}
+
+	buffer, err := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	if err != nil {
+		return errors.Wrapf(err, "%s: unable to read response body", msg)
+	}
+
+	response.Body = ioutil.NopCloser(bytes.NewReader(buffer))
+	retry := c.retryIf(response, nil)
+	response.Body = ioutil.NopCloser(bytes.NewReader(buffer))
+	if retry {
+		return decodeResponseToError(response.StatusCode, buffer, msg)
+	}
+	return nil
+}
+
+// httpPATCH sends json encoded data via PATCH request and returns a response.
+func (c *Client) httpPATCH(ctx context.Context, backOff backoff.BackOff, url string, body interface{}, msg string) (*http.Response, error) {
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "%s: unable to encode json body", msg)
+	}
+
 	var response *http.Response
-	err := backoff.Retry(func() error {
-		request, err := http.NewRequest("DELETE", url, nil)
+	err = backoff.Retry(func() error {
+		request, err := http.NewRequest("PATCH", url, bytes.NewReader(encoded))
 		if err != nil {
 			return backoff.Permanent(errors.Wrapf(err, "%s: unable to prepare request", msg))
 		}
+		request = request.WithContext(ctx)
 
-		if c.tokenProvider != nil {
-			token, err := c.tokenProvider()
-			if err != nil {
-				return backoff.Permanent(errors.Wrapf(err, "%s: unable to prepare request", msg))
-			}
-			request.Header.Set("Authorization", "Bearer "+token)
+		c.setContentHeaders(request, true)
+		if err := c.decorateRequest(request); err != nil {
+			return backoff.Permanent(errors.Wrapf(err, "%s: unable to prepare request", msg))
 		}
 
 		response, err = c.httpClient.Do(request)
@@ -247,14 +787,21 @@ func (c *Client) httpDELETE(backOff backoff.BackOff, url, msg string) error {
 			if err != nil {
 				return errors.Wrapf(err, "%s: unable to read response body", msg)
 			}
-			err = decodeResponseToError(buffer, msg)
+			err = decodeResponseToError(response.StatusCode, buffer, msg)
 			response.Body.Close()
 			return err
 		}
 
 		return nil
-	}, backOff)
+	}, backoff.WithContext(backOff, ctx))
+
+	return response, err
+}
 
+// httpDELETE sends a DELETE request. On errors httpDELETE expects a response body to contain
+// an error message in the format of application/problem+json.
+func (c *Client) httpDELETE(ctx context.Context, backOff backoff.BackOff, url, msg string) error {
+	response, err := c.httpDELETEResponse(ctx, backOff, url, msg)
 	if err != nil {
 		return err
 	}
@@ -265,8 +812,47 @@ func (c *Client) httpDELETE(backOff backoff.BackOff, url, msg string) error {
 		if err != nil {
 			return errors.Wrapf(err, "%s: unable to read response body", msg)
 		}
-		return decodeResponseToError(buffer, msg)
+		if response.StatusCode == http.StatusNotFound {
+			return notFoundError{decodeResponseToError(response.StatusCode, buffer, msg)}
+		}
+		return decodeResponseToError(response.StatusCode, buffer, msg)
 	}
 
 	return nil
 }
+
+// httpDELETEResponse sends a DELETE request and returns a response.
+func (c *Client) httpDELETEResponse(ctx context.Context, backOff backoff.BackOff, url, msg string) (*http.Response, error) {
+	var response *http.Response
+	err := backoff.Retry(func() error {
+		request, err := http.NewRequest("DELETE", url, nil)
+		if err != nil {
+			return backoff.Permanent(errors.Wrapf(err, "%s: unable to prepare request", msg))
+		}
+		request = request.WithContext(ctx)
+		c.setContentHeaders(request, false)
+
+		if err := c.decorateRequest(request); err != nil {
+			return backoff.Permanent(errors.Wrapf(err, "%s: unable to prepare request", msg))
+		}
+
+		response, err = c.httpClient.Do(request)
+		if err != nil {
+			return errors.Wrap(err, msg)
+		}
+
+		if response.StatusCode >= 500 {
+			buffer, err := ioutil.ReadAll(response.Body)
+			if err != nil {
+				return errors.Wrapf(err, "%s: unable to read response body", msg)
+			}
+			err = decodeResponseToError(response.StatusCode, buffer, msg)
+			response.Body.Close()
+			return err
+		}
+
+		return nil
+	}, backoff.WithContext(backOff, ctx))
+
+	return response, err
+}
@@ -1,14 +1,20 @@
 package nakadi
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
 	"net/http"
 	"strconv"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/cenkalti/backoff/v3"
 	"github.com/jarcoal/httpmock"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -32,6 +38,42 @@ func TestNew(t *testing.T) {
 		assert.Nil(t, client.tokenProvider)
 	})
 
+	t.Run("with transport timeouts", func(t *testing.T) {
+		client := New(defaultNakadiURL, &ClientOptions{
+			ConnectionTimeout:     30 * time.Second,
+			TLSHandshakeTimeout:   2 * time.Second,
+			ResponseHeaderTimeout: 10 * time.Second})
+
+		for _, httpClient := range []*http.Client{client.httpClient, client.httpStreamClient} {
+			transport := httpClient.Transport.(*http.Transport)
+			assert.Equal(t, 2*time.Second, transport.TLSHandshakeTimeout)
+			assert.Equal(t, 10*time.Second, transport.ResponseHeaderTimeout)
+		}
+		assert.Equal(t, 30*time.Second, client.httpClient.Timeout)
+		assert.Equal(t, time.Duration(0), client.httpStreamClient.Timeout)
+
+		client = New(defaultNakadiURL, nil)
+		transport := client.httpClient.Transport.(*http.Transport)
+		assert.Equal(t, defaultTimeOut, transport.TLSHandshakeTimeout)
+		assert.Equal(t, time.Duration(0), transport.ResponseHeaderTimeout)
+	})
+
+	t.Run("with dial context", func(t *testing.T) {
+		var addresses []string
+		client := New(defaultNakadiURL, &ClientOptions{
+			DialContext: func(ctx context.Context, network, address string) (net.Conn, error) {
+				addresses = append(addresses, address)
+				return nil, errors.New("dial refused")
+			}})
+
+		for _, httpClient := range []*http.Client{client.httpClient, client.httpStreamClient} {
+			_, err := httpClient.Get(defaultNakadiURL + "/event-types")
+			require.Error(t, err)
+			assert.Regexp(t, "dial refused", err)
+		}
+		assert.Equal(t, []string{"localhost:8080", "localhost:8080"}, addresses)
+	})
+
 	t.Run("with token provider", func(t *testing.T) {
 		client := New(defaultNakadiURL, &ClientOptions{TokenProvider: func() (string, error) { return testToken, nil }})
 
@@ -53,6 +95,567 @@ func TestNew(t *testing.T) {
 		assert.NotNil(t, client.httpClient)
 		assert.Equal(t, defaultTimeOut, client.httpClient.Timeout)
 		assert.Nil(t, client.tokenProvider)
+		assert.Equal(t, defaultContentType, client.contentType)
+		assert.Equal(t, defaultAccept, client.accept)
+	})
+
+	t.Run("with content negotiation", func(t *testing.T) {
+		client := New(defaultNakadiURL, &ClientOptions{ContentType: "application/vnd.test+json", Accept: "application/problem+json"})
+
+		require.NotNil(t, client)
+		assert.Equal(t, "application/vnd.test+json", client.contentType)
+		assert.Equal(t, "application/problem+json", client.accept)
+	})
+}
+
+func TestNew_basePath(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	base := "https://example.com/nakadi"
+	id := "7dd69d58-7f20-11e7-9748-133d6a0dbfb3"
+	client := New(base+"/", nil)
+	client.httpClient = http.DefaultClient
+	client.httpStreamClient = http.DefaultClient
+
+	t.Run("publish", func(t *testing.T) {
+		httpmock.RegisterResponder("POST", base+"/event-types/test-event/events", httpmock.NewStringResponder(http.StatusOK, ""))
+
+		err := NewPublishAPI(client, "test-event", nil).Publish([]interface{}{SomeUndefinedEvent{Test: "event"}})
+		require.NoError(t, err)
+	})
+
+	t.Run("subscribe", func(t *testing.T) {
+		responder, _ := httpmock.NewJsonResponder(http.StatusOK, &Subscription{ID: id})
+		httpmock.RegisterResponder("GET", base+"/subscriptions/"+id, responder)
+
+		sub, err := NewSubscriptionAPI(client, nil).Get(id)
+		require.NoError(t, err)
+		assert.Equal(t, id, sub.ID)
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		httpmock.RegisterResponder("GET", base+"/subscriptions/"+id+"/events", httpmock.NewStringResponder(http.StatusOK, ""))
+		httpmock.RegisterResponder("POST", base+"/subscriptions/"+id+"/cursors", httpmock.NewStringResponder(http.StatusNoContent, ""))
+
+		stream, err := (&simpleStreamOpener{client: client, subscriptionID: id}).openStream()
+		require.NoError(t, err)
+		defer stream.closeStream()
+
+		err = (&simpleCommitter{client: client, subscriptionID: id}).commitCursors([]Cursor{{Partition: "0", Offset: "1"}})
+		require.NoError(t, err)
+	})
+
+	t.Run("event type stream", func(t *testing.T) {
+		httpmock.RegisterResponder("GET", base+"/event-types/test-event/events", httpmock.NewStringResponder(http.StatusOK, ""))
+
+		stream, err := (&simpleStreamOpener{client: client, eventType: "test-event"}).openStream()
+		require.NoError(t, err)
+		stream.closeStream()
+	})
+
+	t.Run("path prefix", func(t *testing.T) {
+		for _, prefix := range []string{"nakadi", "/nakadi/"} {
+			prefixed := New("https://example.com/", &ClientOptions{PathPrefix: prefix})
+			assert.Equal(t, base, prefixed.nakadiURL)
+		}
+		assert.Equal(t, base+"/v2", New(base, &ClientOptions{PathPrefix: "v2"}).nakadiURL)
+	})
+}
+
+func TestClient_withTimeout(t *testing.T) {
+	client := New(defaultNakadiURL, nil)
+
+	copyClient := client.withTimeout(5 * time.Second)
+
+	assert.Equal(t, 5*time.Second, copyClient.timeout)
+	assert.Equal(t, 5*time.Second, copyClient.httpClient.Timeout)
+	assert.Equal(t, client.httpClient.Transport, copyClient.httpClient.Transport)
+	assert.Equal(t, defaultTimeOut, client.timeout)
+	assert.Equal(t, defaultTimeOut, client.httpClient.Timeout)
+}
+
+func TestClient_With(t *testing.T) {
+	transport := httpmock.NewMockTransport()
+	client := New(defaultNakadiURL, &ClientOptions{
+		TokenProvider:         func() (string, error) { return "original", nil },
+		AsyncPublishQueueSize: 5,
+		AsyncQueuePolicy:      AsyncQueueError})
+	client.httpClient = &http.Client{Transport: transport}
+	setTenant := func(r *http.Request) error {
+		r.Header.Set("X-Tenant", "tenant")
+		return nil
+	}
+
+	copyClient := client.With(
+		WithTokenProvider(func() (string, error) { return "tenant", nil }),
+		WithEIDGenerator(func() string { return "eid" }),
+		WithRequestDecorators(setTenant))
+
+	require.NotNil(t, copyClient)
+	assert.True(t, client.httpClient == copyClient.httpClient)
+	assert.True(t, client.httpStreamClient == copyClient.httpStreamClient)
+	assert.True(t, client.eventTypes == copyClient.eventTypes)
+	assert.Equal(t, "eid", copyClient.eidGenerator())
+	assert.Len(t, copyClient.decorators, 1)
+	assert.Empty(t, client.decorators)
+	require.NotNil(t, copyClient.async)
+	assert.False(t, client.async == copyClient.async)
+	assert.True(t, copyClient == copyClient.async.client)
+	assert.Equal(t, 5, cap(copyClient.async.queue))
+	assert.Equal(t, AsyncQueueError, copyClient.async.policy)
+
+	transport.RegisterResponder("GET", defaultNakadiURL+"/subscriptions/sub-id", func(r *http.Request) (*http.Response, error) {
+		assert.Equal(t, "Bearer tenant", r.Header.Get("Authorization"))
+		assert.Equal(t, "tenant", r.Header.Get("X-Tenant"))
+		return httpmock.NewStringResponse(http.StatusOK, "{}"), nil
+	})
+	_, err := NewSubscriptionAPI(copyClient, nil).Get("sub-id")
+	require.NoError(t, err)
+
+	token, err := client.tokenProvider()
+	require.NoError(t, err)
+	assert.Equal(t, "original", token)
+}
+
+func TestClient_Warmup(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	url := defaultNakadiURL + "/health"
+	client := &Client{nakadiURL: defaultNakadiURL, httpClient: http.DefaultClient}
+
+	t.Run("fail unreachable", func(t *testing.T) {
+		httpmock.RegisterResponder("GET", url, httpmock.NewErrorResponder(assert.AnError))
+
+		err := client.Warmup(context.Background())
+		require.Error(t, err)
+		assert.Regexp(t, "unable to warm up connection", err)
+		assert.Regexp(t, assert.AnError, err)
+	})
+
+	t.Run("fail canceled context", func(t *testing.T) {
+		httpmock.RegisterResponder("GET", url, helperCanceledResponder())
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		err := client.Warmup(ctx)
+		require.Error(t, err)
+		assert.Regexp(t, "unable to warm up connection", err)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		httpmock.RegisterResponder("GET", url, httpmock.NewStringResponder(http.StatusOK, "OK"))
+
+		err := client.Warmup(context.Background())
+		require.NoError(t, err)
+	})
+
+	t.Run("success with any status", func(t *testing.T) {
+		httpmock.RegisterResponder("GET", url, httpmock.NewStringResponder(http.StatusServiceUnavailable, ""))
+
+		err := client.Warmup(context.Background())
+		require.NoError(t, err)
+	})
+}
+
+type recordingLogger struct {
+	sync.Mutex
+	messages []string
+}
+
+func (l *recordingLogger) Printf(format string, v ...interface{}) {
+	l.Lock()
+	defer l.Unlock()
+	l.messages = append(l.messages, fmt.Sprintf(format, v...))
+}
+
+func (l *recordingLogger) Messages() []string {
+	l.Lock()
+	defer l.Unlock()
+	return append([]string(nil), l.messages...)
+}
+
+func TestClient_SlowRequestThreshold(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	publishURL := fmt.Sprintf("%s/event-types/%s/events", defaultNakadiURL, "test-event")
+	commitURL := fmt.Sprintf("%s/subscriptions/%s/cursors", defaultNakadiURL, "sub-id")
+	slow := func(status int) httpmock.Responder {
+		return func(r *http.Request) (*http.Response, error) {
+			time.Sleep(20 * time.Millisecond)
+			return httpmock.NewStringResponse(status, ""), nil
+		}
+	}
+
+	t.Run("log slow requests", func(t *testing.T) {
+		logger := &recordingLogger{}
+		client := New(defaultNakadiURL, &ClientOptions{Logger: logger, SlowRequestThreshold: 10 * time.Millisecond})
+		client.httpClient = http.DefaultClient
+		httpmock.RegisterResponder("POST", publishURL, slow(http.StatusOK))
+		httpmock.RegisterResponder("POST", commitURL, slow(http.StatusNoContent))
+
+		require.NoError(t, NewPublishAPI(client, "test-event", nil).Publish([]SomeUndefinedEvent{{Test: "event"}}))
+		committer := &simpleCommitter{client: client, subscriptionID: "sub-id"}
+		require.NoError(t, committer.commitCursors([]Cursor{{EventType: "test-event", Partition: "0"}}))
+		client.httpStreamClient = http.DefaultClient
+		httpmock.RegisterResponder("GET", fmt.Sprintf("%s/subscriptions/%s/events", defaultNakadiURL, "sub-id"), slow(http.StatusOK))
+		opener := &simpleStreamOpener{client: client, subscriptionID: "sub-id"}
+		stream, _, err := opener.openStreamOnce()
+		require.NoError(t, err)
+		stream.closeStream()
+
+		messages := logger.Messages()
+		require.Len(t, messages, 3)
+		assert.Regexp(t, "^slow request: operation=publish event_type=test-event duration=[0-9.]+ms$", messages[0])
+		assert.Regexp(t, "^slow request: operation=commit subscription=sub-id event_type=test-event duration=", messages[1])
+		assert.Regexp(t, "^slow request: operation=open stream subscription=sub-id duration=", messages[2])
+	})
+
+	t.Run("skip fast requests", func(t *testing.T) {
+		logger := &recordingLogger{}
+		client := New(defaultNakadiURL, &ClientOptions{Logger: logger, SlowRequestThreshold: time.Second})
+		client.httpClient = http.DefaultClient
+		httpmock.RegisterResponder("POST", publishURL, httpmock.NewStringResponder(http.StatusOK, ""))
+
+		require.NoError(t, NewPublishAPI(client, "test-event", nil).Publish([]SomeUndefinedEvent{{Test: "event"}}))
+		assert.Empty(t, logger.Messages())
+	})
+
+	t.Run("skip without threshold", func(t *testing.T) {
+		logger := &recordingLogger{}
+		client := New(defaultNakadiURL, &ClientOptions{Logger: logger})
+		client.httpClient = http.DefaultClient
+		httpmock.RegisterResponder("POST", publishURL, slow(http.StatusOK))
+
+		require.NoError(t, NewPublishAPI(client, "test-event", nil).Publish([]SomeUndefinedEvent{{Test: "event"}}))
+		assert.Empty(t, logger.Messages())
+	})
+}
+
+type recordingCollector struct {
+	sync.Mutex
+	observed []RequestMetrics
+}
+
+func (c *recordingCollector) ObserveRequest(metrics RequestMetrics) {
+	c.Lock()
+	defer c.Unlock()
+	c.observed = append(c.observed, metrics)
+}
+
+func (c *recordingCollector) Observed() []RequestMetrics {
+	c.Lock()
+	defer c.Unlock()
+	return append([]RequestMetrics(nil), c.observed...)
+}
+
+func TestClient_Metrics(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	collector := &recordingCollector{}
+	client := New(defaultNakadiURL, &ClientOptions{Metrics: collector})
+	client.httpClient = http.DefaultClient
+	client.httpStreamClient = http.DefaultClient
+
+	httpmock.RegisterResponder("POST", fmt.Sprintf("%s/event-types/%s/events", defaultNakadiURL, "test-event"),
+		httpmock.NewStringResponder(http.StatusOK, ""))
+	httpmock.RegisterResponder("POST", fmt.Sprintf("%s/subscriptions/%s/cursors", defaultNakadiURL, "sub-id"),
+		httpmock.NewErrorResponder(assert.AnError))
+	httpmock.RegisterResponder("GET", fmt.Sprintf("%s/subscriptions/%s/events", defaultNakadiURL, "sub-id"),
+		httpmock.NewStringResponder(http.StatusOK, ""))
+
+	require.NoError(t, NewPublishAPI(client, "test-event", nil).Publish([]SomeUndefinedEvent{{Test: "event"}}))
+	committer := &simpleCommitter{client: client, subscriptionID: "sub-id"}
+	require.Error(t, committer.commitCursors([]Cursor{
+		{EventType: "test-event.a", Partition: "0"},
+		{EventType: "test-event.b", Partition: "0"},
+		{EventType: "test-event.a", Partition: "1"}}))
+	opener := &simpleStreamOpener{client: client, subscriptionID: "sub-id"}
+	stream, _, err := opener.openStreamOnce()
+	require.NoError(t, err)
+	stream.closeStream()
+
+	observed := collector.Observed()
+	require.Len(t, observed, 4)
+	for _, metrics := range observed {
+		assert.True(t, metrics.Duration > 0)
+	}
+
+	assert.Equal(t, OperationPublish, observed[0].Operation)
+	assert.Equal(t, "test-event", observed[0].EventType)
+	assert.Equal(t, http.StatusOK, observed[0].StatusCode)
+	assert.NoError(t, observed[0].Err)
+
+	for i, eventType := range []string{"test-event.a", "test-event.b"} {
+		assert.Equal(t, OperationCommit, observed[i+1].Operation)
+		assert.Equal(t, eventType, observed[i+1].EventType)
+		assert.Equal(t, "sub-id", observed[i+1].SubscriptionID)
+		assert.Equal(t, 0, observed[i+1].StatusCode)
+		assert.Regexp(t, assert.AnError, observed[i+1].Err)
+	}
+
+	assert.Equal(t, OperationOpenStream, observed[3].Operation)
+	assert.Equal(t, "sub-id", observed[3].SubscriptionID)
+	assert.Empty(t, observed[3].EventType)
+	assert.Equal(t, http.StatusOK, observed[3].StatusCode)
+}
+
+func TestClient_ResponseInspector(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	var operations, requestIDs []string
+	client := New(defaultNakadiURL, &ClientOptions{ResponseInspector: func(operation string, response *http.Response) {
+		operations = append(operations, operation)
+		requestIDs = append(requestIDs, response.Header.Get("X-Request-Id"))
+		assert.Equal(t, http.NoBody, response.Body)
+	}})
+	client.httpClient = http.DefaultClient
+	client.httpStreamClient = http.DefaultClient
+
+	responder := func(status int, body string) httpmock.Responder {
+		return func(r *http.Request) (*http.Response, error) {
+			response := httpmock.NewStringResponse(status, body)
+			response.Header.Set("X-Request-Id", r.Method+" "+r.URL.Path)
+			return response, nil
+		}
+	}
+	subscription := `{"id":"sub-id","owning_application":"test-app","event_types":["test-event"]}`
+	httpmock.RegisterResponder("POST", defaultNakadiURL+"/event-types/test-event/events", responder(http.StatusOK, ""))
+	httpmock.RegisterResponder("POST", defaultNakadiURL+"/subscriptions/sub-id/cursors", responder(http.StatusNoContent, ""))
+	httpmock.RegisterResponder("GET", defaultNakadiURL+"/subscriptions/sub-id/events", responder(http.StatusOK, ""))
+	httpmock.RegisterResponder("POST", defaultNakadiURL+"/subscriptions", responder(http.StatusCreated, subscription))
+
+	require.NoError(t, NewPublishAPI(client, "test-event", nil).Publish([]SomeUndefinedEvent{{Test: "event"}}))
+	committer := &simpleCommitter{client: client, subscriptionID: "sub-id"}
+	require.NoError(t, committer.commitCursors([]Cursor{{EventType: "test-event", Partition: "0"}}))
+	opener := &simpleStreamOpener{client: client, subscriptionID: "sub-id"}
+	stream, _, err := opener.openStreamOnce()
+	require.NoError(t, err)
+	stream.closeStream()
+	created, err := NewSubscriptionAPI(client, nil).Create(&Subscription{OwningApplication: "test-app", EventTypes: []string{"test-event"}})
+	require.NoError(t, err)
+	assert.Equal(t, "sub-id", created.ID)
+
+	assert.Equal(t, []string{OperationPublish, OperationCommit, OperationOpenStream, OperationCreateSubscription}, operations)
+	assert.Equal(t, []string{
+		"POST /event-types/test-event/events",
+		"POST /subscriptions/sub-id/cursors",
+		"GET /subscriptions/sub-id/events",
+		"POST /subscriptions"}, requestIDs)
+}
+
+func TestClient_RequestDecorators(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	url := fmt.Sprintf("%s/subscriptions/%s", defaultNakadiURL, "sub-id")
+	tokenProvider := func() (string, error) { return testToken, nil }
+	setHeader := func(key, value string) RequestDecorator {
+		return func(r *http.Request) error {
+			r.Header.Set(key, r.Header.Get(key)+value)
+			return nil
+		}
+	}
+
+	t.Run("fail decorator error", func(t *testing.T) {
+		client := New(defaultNakadiURL, &ClientOptions{RequestDecorators: []RequestDecorator{
+			func(*http.Request) error { return assert.AnError }}})
+		client.httpClient = http.DefaultClient
+		httpmock.RegisterResponder("GET", url, func(r *http.Request) (*http.Response, error) {
+			assert.Fail(t, "request must not be sent")
+			return httpmock.NewStringResponse(http.StatusOK, "{}"), nil
+		})
+
+		_, err := NewSubscriptionAPI(client, nil).Get("sub-id")
+		require.Error(t, err)
+		assert.Regexp(t, "unable to prepare request", err)
+		assert.Regexp(t, assert.AnError, err)
+	})
+
+	t.Run("success decorators in order", func(t *testing.T) {
+		client := New(defaultNakadiURL, &ClientOptions{TokenProvider: tokenProvider, RequestDecorators: []RequestDecorator{
+			setHeader("X-Test", "first,"),
+			setHeader("X-Test", "second"),
+			setHeader("Accept", "+custom")}})
+		client.httpClient = http.DefaultClient
+		client.httpStreamClient = http.DefaultClient
+		decorated := func(r *http.Request) {
+			assert.Equal(t, "Bearer "+testToken, r.Header.Get("Authorization"))
+			assert.Equal(t, "first,second", r.Header.Get("X-Test"))
+			assert.Regexp(t, `\+custom$`, r.Header.Get("Accept"))
+		}
+		httpmock.RegisterResponder("GET", url, func(r *http.Request) (*http.Response, error) {
+			decorated(r)
+			return httpmock.NewStringResponse(http.StatusOK, "{}"), nil
+		})
+		httpmock.RegisterResponder("GET", url+"/events", func(r *http.Request) (*http.Response, error) {
+			decorated(r)
+			return httpmock.NewStringResponse(http.StatusOK, ""), nil
+		})
+		httpmock.RegisterResponder("POST", url+"/cursors", func(r *http.Request) (*http.Response, error) {
+			decorated(r)
+			return httpmock.NewStringResponse(http.StatusNoContent, ""), nil
+		})
+
+		_, err := NewSubscriptionAPI(client, nil).Get("sub-id")
+		require.NoError(t, err)
+		opener := &simpleStreamOpener{client: client, subscriptionID: "sub-id"}
+		stream, _, err := opener.openStreamOnce()
+		require.NoError(t, err)
+		stream.closeStream()
+		committer := &simpleCommitter{client: client, subscriptionID: "sub-id"}
+		require.NoError(t, committer.commitCursors([]Cursor{{EventType: "test-event", Partition: "0"}}))
+	})
+
+	t.Run("success context values", func(t *testing.T) {
+		type tenantKey struct{}
+		client := New(defaultNakadiURL, &ClientOptions{RequestDecorators: []RequestDecorator{
+			func(r *http.Request) error {
+				if tenant, ok := r.Context().Value(tenantKey{}).(string); ok {
+					r.Header.Set("X-Tenant", tenant)
+				}
+				return nil
+			}}})
+		client.httpClient = http.DefaultClient
+		tenant := func(r *http.Request) {
+			assert.Equal(t, "tenant-1", r.Header.Get("X-Tenant"))
+		}
+		httpmock.RegisterResponder("GET", url, func(r *http.Request) (*http.Response, error) {
+			tenant(r)
+			return httpmock.NewStringResponse(http.StatusOK, "{}"), nil
+		})
+		httpmock.RegisterResponder("POST", defaultNakadiURL+"/event-types/test-event/events", func(r *http.Request) (*http.Response, error) {
+			tenant(r)
+			return httpmock.NewStringResponse(http.StatusOK, ""), nil
+		})
+		httpmock.RegisterResponder("GET", defaultNakadiURL+"/health", func(r *http.Request) (*http.Response, error) {
+			tenant(r)
+			return httpmock.NewStringResponse(http.StatusOK, ""), nil
+		})
+
+		ctx := context.WithValue(context.Background(), tenantKey{}, "tenant-1")
+		_, err := NewSubscriptionAPI(client, nil).GetContext(ctx, "sub-id")
+		require.NoError(t, err)
+		err = NewPublishAPI(client, "test-event", nil).PublishContext(ctx, []SomeUndefinedEvent{{Test: "event"}})
+		require.NoError(t, err)
+		require.NoError(t, client.Warmup(ctx))
+	})
+}
+
+func TestClient_APIVersion(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	url := fmt.Sprintf("%s/subscriptions/%s", defaultNakadiURL, "sub-id")
+
+	t.Run("success without version", func(t *testing.T) {
+		client := New(defaultNakadiURL, nil)
+		client.httpClient = http.DefaultClient
+		httpmock.RegisterResponder("GET", url, func(r *http.Request) (*http.Response, error) {
+			_, ok := r.Header["X-Nakadi-Api-Version"]
+			assert.False(t, ok)
+			return httpmock.NewStringResponse(http.StatusOK, "{}"), nil
+		})
+
+		_, err := NewSubscriptionAPI(client, nil).Get("sub-id")
+		require.NoError(t, err)
+	})
+
+	t.Run("success with version", func(t *testing.T) {
+		var decorated string
+		client := New(defaultNakadiURL, &ClientOptions{APIVersion: "2", RequestDecorators: []RequestDecorator{
+			func(r *http.Request) error {
+				decorated = r.Header.Get("X-Nakadi-Api-Version")
+				return nil
+			}}})
+		client.httpClient = http.DefaultClient
+		httpmock.RegisterResponder("GET", url, func(r *http.Request) (*http.Response, error) {
+			assert.Equal(t, "2", r.Header.Get("X-Nakadi-Api-Version"))
+			return httpmock.NewStringResponse(http.StatusOK, "{}"), nil
+		})
+
+		_, err := NewSubscriptionAPI(client, nil).Get("sub-id")
+		require.NoError(t, err)
+		assert.Equal(t, "2", decorated)
+	})
+}
+
+func TestClient_CheckRedirect(t *testing.T) {
+	regionalURL := "https://regional.example.com"
+	publishPath := "/event-types/test-event/events"
+	streamPath := "/subscriptions/sub-id/events"
+	redirect := func(status int, location string) httpmock.Responder {
+		return func(r *http.Request) (*http.Response, error) {
+			response := httpmock.NewStringResponse(status, "")
+			response.Header.Set("Location", location)
+			return response, nil
+		}
+	}
+	setup := func(options *ClientOptions) (*httpmock.MockTransport, *Client) {
+		transport := httpmock.NewMockTransport()
+		client := New(defaultNakadiURL, options)
+		client.httpClient.Transport = transport
+		client.httpStreamClient.Transport = transport
+		return transport, client
+	}
+
+	t.Run("success follow redirects", func(t *testing.T) {
+		transport, client := setup(nil)
+		transport.RegisterResponder("POST", defaultNakadiURL+publishPath, redirect(http.StatusTemporaryRedirect, regionalURL+publishPath))
+		transport.RegisterResponder("POST", regionalURL+publishPath, func(r *http.Request) (*http.Response, error) {
+			body, err := ioutil.ReadAll(r.Body)
+			require.NoError(t, err)
+			assert.JSONEq(t, `[{"metadata":{"eid":"","occurred_at":"0001-01-01T00:00:00Z"},"test":"redirected"}]`, string(body))
+			return httpmock.NewStringResponse(http.StatusOK, ""), nil
+		})
+		transport.RegisterResponder("GET", defaultNakadiURL+streamPath, redirect(http.StatusPermanentRedirect, regionalURL+streamPath))
+		transport.RegisterResponder("GET", regionalURL+streamPath, httpmock.NewStringResponder(http.StatusOK, ""))
+
+		err := NewPublishAPI(client, "test-event", nil).Publish([]SomeUndefinedEvent{{Test: "redirected"}})
+		require.NoError(t, err)
+		stream, _, err := (&simpleStreamOpener{client: client, subscriptionID: "sub-id"}).openStreamOnce()
+		require.NoError(t, err)
+		stream.closeStream()
+
+		assert.Equal(t, 1, transport.GetCallCountInfo()["POST "+regionalURL+publishPath])
+		assert.Equal(t, 1, transport.GetCallCountInfo()["GET "+regionalURL+streamPath])
+	})
+
+	t.Run("success rewrite redirect", func(t *testing.T) {
+		var redirected []string
+		transport, client := setup(&ClientOptions{
+			TokenProvider: func() (string, error) { return testToken, nil },
+			CheckRedirect: func(r *http.Request, via []*http.Request) error {
+				redirected = append(redirected, r.URL.String())
+				r.URL.Scheme = "http"
+				r.Header.Set("Authorization", via[0].Header.Get("Authorization"))
+				return nil
+			}})
+		transport.RegisterResponder("POST", defaultNakadiURL+publishPath, redirect(http.StatusTemporaryRedirect, regionalURL+publishPath))
+		transport.RegisterResponder("POST", "http://regional.example.com"+publishPath, func(r *http.Request) (*http.Response, error) {
+			assert.Equal(t, "Bearer "+testToken, r.Header.Get("Authorization"))
+			return httpmock.NewStringResponse(http.StatusOK, ""), nil
+		})
+
+		err := NewPublishAPI(client, "test-event", nil).Publish([]SomeUndefinedEvent{{Test: "event"}})
+		require.NoError(t, err)
+		assert.Equal(t, []string{regionalURL + publishPath}, redirected)
+	})
+
+	t.Run("fail stop redirects", func(t *testing.T) {
+		transport, client := setup(&ClientOptions{CheckRedirect: func(*http.Request, []*http.Request) error {
+			return assert.AnError
+		}})
+		transport.RegisterResponder("GET", defaultNakadiURL+streamPath, redirect(http.StatusTemporaryRedirect, regionalURL+streamPath))
+
+		_, _, err := (&simpleStreamOpener{client: client, subscriptionID: "sub-id"}).openStreamOnce()
+		require.Error(t, err)
+		assert.Regexp(t, assert.AnError, err)
+		assert.Equal(t, 0, transport.GetCallCountInfo()["GET "+regionalURL+streamPath])
 	})
 }
 
@@ -75,19 +678,31 @@ func TestClient_httpGET(t *testing.T) {
 		client := setupClient(nil)
 		httpmock.RegisterResponder("GET", url, httpmock.NewErrorResponder(assert.AnError))
 
-		err := client.httpGET(&backoff.StopBackOff{}, url, &body, msg)
+		err := client.httpGET(context.Background(), &backoff.StopBackOff{}, url, &body, msg)
 
 		require.Error(t, err)
 		assert.Regexp(t, assert.AnError, err)
 		assert.Regexp(t, msg, err)
 	})
 
+	t.Run("fail canceled context", func(t *testing.T) {
+		client := setupClient(nil)
+		httpmock.RegisterResponder("GET", url, helperCanceledResponder())
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		err := client.httpGET(ctx, &backoff.ZeroBackOff{}, url, &body, msg)
+
+		require.Error(t, err)
+		assert.Regexp(t, context.Canceled, err)
+	})
+
 	t.Run("fail oauth token", func(t *testing.T) {
 		client := setupClient(nil)
 		client.tokenProvider = func() (string, error) { return "", assert.AnError }
 		httpmock.RegisterResponder("GET", url, httpmock.NewStringResponder(http.StatusOK, encoded))
 
-		err := client.httpGET(&backoff.StopBackOff{}, url, &body, msg)
+		err := client.httpGET(context.Background(), &backoff.StopBackOff{}, url, &body, msg)
 
 		require.Error(t, err)
 		assert.Regexp(t, assert.AnError, err)
@@ -103,7 +718,7 @@ func TestClient_httpGET(t *testing.T) {
 		})
 		httpmock.RegisterResponder("GET", url, responder)
 
-		err := client.httpGET(&backoff.StopBackOff{}, url, &body, msg)
+		err := client.httpGET(context.Background(), &backoff.StopBackOff{}, url, &body, msg)
 
 		require.Error(t, err)
 		assert.Regexp(t, "unable to read response body", err)
@@ -117,7 +732,7 @@ func TestClient_httpGET(t *testing.T) {
 			return httpmock.NewStringResponse(http.StatusOK, encoded), nil
 		})
 
-		err := client.httpGET(&backoff.StopBackOff{}, url, &body, msg)
+		err := client.httpGET(context.Background(), &backoff.StopBackOff{}, url, &body, msg)
 
 		require.NoError(t, err)
 		assert.Equal(t, map[string]string{"key": "value"}, body)
@@ -135,7 +750,7 @@ func TestClient_httpGET(t *testing.T) {
 			return httpmock.NewStringResponse(http.StatusOK, encoded), nil
 		})
 
-		err := client.httpGET(&backoff.ZeroBackOff{}, url, &body, msg)
+		err := client.httpGET(context.Background(), &backoff.ZeroBackOff{}, url, &body, msg)
 
 		require.NoError(t, err)
 		assert.Equal(t, map[string]string{"key": "value"}, body)
@@ -154,7 +769,7 @@ func TestClient_httpGET(t *testing.T) {
 			return httpmock.NewStringResponse(http.StatusOK, encoded), nil
 		})
 
-		err := client.httpGET(&backoff.ZeroBackOff{}, url, &body, msg)
+		err := client.httpGET(context.Background(), &backoff.ZeroBackOff{}, url, &body, msg)
 
 		require.NoError(t, err)
 		assert.Equal(t, map[string]string{"key": "value"}, body)
@@ -165,7 +780,7 @@ func TestClient_httpGET(t *testing.T) {
 		client := setupClient(nil)
 		httpmock.RegisterResponder("GET", url, httpmock.NewStringResponder(http.StatusOK, encoded))
 
-		err := client.httpGET(&backoff.StopBackOff{}, url, &body, msg)
+		err := client.httpGET(context.Background(), &backoff.StopBackOff{}, url, &body, msg)
 
 		require.NoError(t, err)
 		assert.Equal(t, map[string]string{"key": "value"}, body)
@@ -189,7 +804,7 @@ func TestClient_httpPUT(t *testing.T) {
 		client := setupClient(nil)
 		httpmock.RegisterResponder("PUT", url, httpmock.NewStringResponder(200, ""))
 
-		_, err := client.httpPUT(&backoff.StopBackOff{}, url, brokenMarshaler{}, "error message")
+		_, err := client.httpPUT(context.Background(), &backoff.StopBackOff{}, url, brokenMarshaler{}, "error message")
 
 		require.Error(t, err)
 		assert.Regexp(t, assert.AnError, err)
@@ -199,7 +814,7 @@ func TestClient_httpPUT(t *testing.T) {
 		client := setupClient(nil)
 		httpmock.RegisterResponder("PUT", url, httpmock.NewErrorResponder(assert.AnError))
 
-		_, err := client.httpPUT(&backoff.StopBackOff{}, url, &expected, "error message")
+		_, err := client.httpPUT(context.Background(), &backoff.StopBackOff{}, url, &expected, "error message")
 
 		require.Error(t, err)
 		assert.Regexp(t, assert.AnError, err)
@@ -210,7 +825,7 @@ func TestClient_httpPUT(t *testing.T) {
 		client.tokenProvider = func() (string, error) { return "", assert.AnError }
 		httpmock.RegisterResponder("PUT", url, httpmock.NewStringResponder(http.StatusOK, ""))
 
-		_, err := client.httpPUT(&backoff.StopBackOff{}, url, &expected, "error message")
+		_, err := client.httpPUT(context.Background(), &backoff.StopBackOff{}, url, &expected, "error message")
 
 		require.Error(t, err)
 		assert.Regexp(t, assert.AnError, err)
@@ -224,7 +839,7 @@ func TestClient_httpPUT(t *testing.T) {
 			return httpmock.NewStringResponse(http.StatusOK, ""), nil
 		})
 
-		response, err := client.httpPUT(&backoff.StopBackOff{}, url, &expected, "error message")
+		response, err := client.httpPUT(context.Background(), &backoff.StopBackOff{}, url, &expected, "error message")
 
 		require.NoError(t, err)
 		assert.Equal(t, http.StatusOK, response.StatusCode)
@@ -242,7 +857,7 @@ func TestClient_httpPUT(t *testing.T) {
 			return httpmock.NewStringResponse(http.StatusOK, ""), nil
 		})
 
-		response, err := client.httpPUT(&backoff.ZeroBackOff{}, url, &expected, "error message")
+		response, err := client.httpPUT(context.Background(), &backoff.ZeroBackOff{}, url, &expected, "error message")
 
 		require.NoError(t, err)
 		assert.Equal(t, http.StatusOK, response.StatusCode)
@@ -261,7 +876,7 @@ func TestClient_httpPUT(t *testing.T) {
 			return httpmock.NewStringResponse(http.StatusOK, ""), nil
 		})
 
-		response, err := client.httpPUT(&backoff.ZeroBackOff{}, url, &expected, "error message")
+		response, err := client.httpPUT(context.Background(), &backoff.ZeroBackOff{}, url, &expected, "error message")
 
 		require.NoError(t, err)
 		assert.Equal(t, http.StatusOK, response.StatusCode)
@@ -278,7 +893,7 @@ func TestClient_httpPUT(t *testing.T) {
 			return httpmock.NewStringResponse(http.StatusOK, ""), nil
 		})
 
-		response, err := client.httpPUT(&backoff.StopBackOff{}, url, &expected, "error message")
+		response, err := client.httpPUT(context.Background(), &backoff.StopBackOff{}, url, &expected, "error message")
 
 		require.NoError(t, err)
 		assert.Equal(t, http.StatusOK, response.StatusCode)
@@ -302,7 +917,7 @@ func TestClient_httpPOST(t *testing.T) {
 		client := setupClient(nil)
 		httpmock.RegisterResponder("POST", url, httpmock.NewStringResponder(200, ""))
 
-		_, err := client.httpPOST(&backoff.StopBackOff{}, url, brokenMarshaler{}, "error message")
+		_, err := client.httpPOST(context.Background(), &backoff.StopBackOff{}, url, brokenMarshaler{}, "error message")
 
 		require.Error(t, err)
 		assert.Regexp(t, assert.AnError, err)
@@ -312,7 +927,7 @@ func TestClient_httpPOST(t *testing.T) {
 		client := setupClient(nil)
 		httpmock.RegisterResponder("POST", url, httpmock.NewErrorResponder(assert.AnError))
 
-		_, err := client.httpPOST(&backoff.StopBackOff{}, url, &expected, "error message")
+		_, err := client.httpPOST(context.Background(), &backoff.StopBackOff{}, url, &expected, "error message")
 
 		require.Error(t, err)
 		assert.Regexp(t, assert.AnError, err)
@@ -323,7 +938,7 @@ func TestClient_httpPOST(t *testing.T) {
 		client.tokenProvider = func() (string, error) { return "", assert.AnError }
 		httpmock.RegisterResponder("POST", url, httpmock.NewStringResponder(http.StatusOK, ""))
 
-		_, err := client.httpPOST(&backoff.StopBackOff{}, url, &expected, "error message")
+		_, err := client.httpPOST(context.Background(), &backoff.StopBackOff{}, url, &expected, "error message")
 
 		require.Error(t, err)
 		assert.Regexp(t, assert.AnError, err)
@@ -337,7 +952,7 @@ func TestClient_httpPOST(t *testing.T) {
 			return httpmock.NewStringResponse(http.StatusOK, ""), nil
 		})
 
-		response, err := client.httpPOST(&backoff.StopBackOff{}, url, &expected, "error message")
+		response, err := client.httpPOST(context.Background(), &backoff.StopBackOff{}, url, &expected, "error message")
 
 		require.NoError(t, err)
 		assert.Equal(t, http.StatusOK, response.StatusCode)
@@ -355,7 +970,7 @@ func TestClient_httpPOST(t *testing.T) {
 			return httpmock.NewStringResponse(http.StatusOK, ""), nil
 		})
 
-		response, err := client.httpPOST(&backoff.ZeroBackOff{}, url, &expected, "error message")
+		response, err := client.httpPOST(context.Background(), &backoff.ZeroBackOff{}, url, &expected, "error message")
 
 		require.NoError(t, err)
 		assert.Equal(t, http.StatusOK, response.StatusCode)
@@ -374,7 +989,7 @@ func TestClient_httpPOST(t *testing.T) {
 			return httpmock.NewStringResponse(http.StatusOK, ""), nil
 		})
 
-		response, err := client.httpPOST(&backoff.ZeroBackOff{}, url, &expected, "error message")
+		response, err := client.httpPOST(context.Background(), &backoff.ZeroBackOff{}, url, &expected, "error message")
 
 		require.NoError(t, err)
 		assert.Equal(t, http.StatusOK, response.StatusCode)
@@ -391,13 +1006,94 @@ func TestClient_httpPOST(t *testing.T) {
 			return httpmock.NewStringResponse(http.StatusOK, ""), nil
 		})
 
-		response, err := client.httpPOST(&backoff.StopBackOff{}, url, &expected, "error message")
+		response, err := client.httpPOST(context.Background(), &backoff.StopBackOff{}, url, &expected, "error message")
 
 		require.NoError(t, err)
 		assert.Equal(t, http.StatusOK, response.StatusCode)
 	})
 }
 
+func TestClient_httpPATCH(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	expected := map[string]string{"key": "value"}
+	url := "/patch-test"
+
+	setupClient := func(tokenProvider func() (string, error)) *Client {
+		return &Client{
+			tokenProvider: tokenProvider,
+			httpClient:    http.DefaultClient}
+	}
+
+	t.Run("fail encode request body", func(t *testing.T) {
+		client := setupClient(nil)
+		httpmock.RegisterResponder("PATCH", url, httpmock.NewStringResponder(200, ""))
+
+		_, err := client.httpPATCH(context.Background(), &backoff.StopBackOff{}, url, brokenMarshaler{}, "error message")
+
+		require.Error(t, err)
+		assert.Regexp(t, assert.AnError, err)
+	})
+
+	t.Run("fail connection error", func(t *testing.T) {
+		client := setupClient(nil)
+		httpmock.RegisterResponder("PATCH", url, httpmock.NewErrorResponder(assert.AnError))
+
+		_, err := client.httpPATCH(context.Background(), &backoff.StopBackOff{}, url, &expected, "error message")
+
+		require.Error(t, err)
+		assert.Regexp(t, assert.AnError, err)
+	})
+
+	t.Run("fail oauth token", func(t *testing.T) {
+		client := setupClient(nil)
+		client.tokenProvider = func() (string, error) { return "", assert.AnError }
+		httpmock.RegisterResponder("PATCH", url, httpmock.NewStringResponder(http.StatusOK, ""))
+
+		_, err := client.httpPATCH(context.Background(), &backoff.StopBackOff{}, url, &expected, "error message")
+
+		require.Error(t, err)
+		assert.Regexp(t, assert.AnError, err)
+	})
+
+	t.Run("success after 500 and retry", func(t *testing.T) {
+		client := setupClient(nil)
+
+		counter := helperMakeCounter(5)
+		httpmock.RegisterResponder("PATCH", url, func(r *http.Request) (*http.Response, error) {
+			retry := <-counter
+			if retry < 4 {
+				return httpmock.NewStringResponse(http.StatusInternalServerError, ""), nil
+			}
+			return httpmock.NewStringResponse(http.StatusNoContent, ""), nil
+		})
+
+		response, err := client.httpPATCH(context.Background(), &backoff.ZeroBackOff{}, url, &expected, "error message")
+
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, response.StatusCode)
+		assert.Equal(t, 5, <-counter)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		client := setupClient(func() (string, error) { return testToken, nil })
+		httpmock.RegisterResponder("PATCH", url, func(r *http.Request) (*http.Response, error) {
+			assert.Equal(t, "Bearer token", r.Header.Get("Authorization"))
+			body := map[string]string{}
+			err := json.NewDecoder(r.Body).Decode(&body)
+			require.NoError(t, err)
+			assert.Equal(t, expected, body)
+			return httpmock.NewStringResponse(http.StatusNoContent, ""), nil
+		})
+
+		response, err := client.httpPATCH(context.Background(), &backoff.StopBackOff{}, url, &expected, "error message")
+
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusNoContent, response.StatusCode)
+	})
+}
+
 func TestClient_httpDELETE(t *testing.T) {
 	httpmock.Activate()
 	defer httpmock.DeactivateAndReset()
@@ -415,7 +1111,7 @@ func TestClient_httpDELETE(t *testing.T) {
 		client := setupClient(nil)
 		httpmock.RegisterResponder("DELETE", url, httpmock.NewErrorResponder(assert.AnError))
 
-		err := client.httpDELETE(&backoff.StopBackOff{}, url, msg)
+		err := client.httpDELETE(context.Background(), &backoff.StopBackOff{}, url, msg)
 
 		require.Error(t, err)
 		assert.Regexp(t, assert.AnError, err)
@@ -427,7 +1123,7 @@ func TestClient_httpDELETE(t *testing.T) {
 		client.tokenProvider = func() (string, error) { return "", assert.AnError }
 		httpmock.RegisterResponder("DELETE", url, httpmock.NewStringResponder(http.StatusOK, ""))
 
-		err := client.httpDELETE(&backoff.StopBackOff{}, url, msg)
+		err := client.httpDELETE(context.Background(), &backoff.StopBackOff{}, url, msg)
 
 		require.Error(t, err)
 		assert.Regexp(t, assert.AnError, err)
@@ -441,7 +1137,7 @@ func TestClient_httpDELETE(t *testing.T) {
 			return httpmock.NewStringResponse(http.StatusOK, ""), nil
 		})
 
-		err := client.httpDELETE(&backoff.StopBackOff{}, url, msg)
+		err := client.httpDELETE(context.Background(), &backoff.StopBackOff{}, url, msg)
 
 		assert.NoError(t, err)
 	})
@@ -456,7 +1152,7 @@ func TestClient_httpDELETE(t *testing.T) {
 		})
 		httpmock.RegisterResponder("DELETE", url, responder)
 
-		err := client.httpDELETE(&backoff.StopBackOff{}, url, msg)
+		err := client.httpDELETE(context.Background(), &backoff.StopBackOff{}, url, msg)
 
 		require.Error(t, err)
 		assert.Regexp(t, "unable to read response body", err)
@@ -474,7 +1170,7 @@ func TestClient_httpDELETE(t *testing.T) {
 			return httpmock.NewStringResponse(http.StatusOK, ""), nil
 		})
 
-		err := client.httpDELETE(&backoff.ZeroBackOff{}, url, msg)
+		err := client.httpDELETE(context.Background(), &backoff.ZeroBackOff{}, url, msg)
 
 		require.NoError(t, err)
 		assert.Equal(t, 5, <-counter)
@@ -492,7 +1188,7 @@ func TestClient_httpDELETE(t *testing.T) {
 			return httpmock.NewStringResponse(http.StatusOK, ""), nil
 		})
 
-		err := client.httpDELETE(&backoff.ZeroBackOff{}, url, msg)
+		err := client.httpDELETE(context.Background(), &backoff.ZeroBackOff{}, url, msg)
 
 		require.NoError(t, err)
 		assert.Equal(t, 5, <-counter)
@@ -502,7 +1198,7 @@ func TestClient_httpDELETE(t *testing.T) {
 		client := setupClient(nil)
 		httpmock.RegisterResponder("DELETE", url, httpmock.NewStringResponder(http.StatusOK, ""))
 
-		err := client.httpDELETE(&backoff.StopBackOff{}, url, msg)
+		err := client.httpDELETE(context.Background(), &backoff.StopBackOff{}, url, msg)
 
 		assert.NoError(t, err)
 	})
@@ -0,0 +1,175 @@
+package nakadi
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// OffsetStore persists the cursors committed for a subscription on the consumer side. It can be used to
+// mirror the commits of a StreamAPI in order to compare the local progress with the offsets committed
+// to Nakadi.
+type OffsetStore interface {
+	// Save stores the cursors for the subscription and replaces all previously stored cursors.
+	Save(subscriptionID string, cursors []Cursor) error
+	// Load returns the stored cursors for the subscription. If nothing was stored for the subscription
+	// Load returns no cursors and no error.
+	Load(subscriptionID string) ([]Cursor, error)
+}
+
+// NewMemoryOffsetStore creates an OffsetStore which keeps cursors in memory.
+func NewMemoryOffsetStore() *MemoryOffsetStore {
+	return &MemoryOffsetStore{cursors: make(map[string][]Cursor)}
+}
+
+// MemoryOffsetStore is an OffsetStore which keeps cursors in memory. It is safe for concurrent use.
+type MemoryOffsetStore struct {
+	mutex   sync.Mutex
+	cursors map[string][]Cursor
+}
+
+// Save stores the cursors for the subscription.
+func (m *MemoryOffsetStore) Save(subscriptionID string, cursors []Cursor) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.cursors[subscriptionID] = append([]Cursor(nil), cursors...)
+	return nil
+}
+
+// Load returns the stored cursors for the subscription.
+func (m *MemoryOffsetStore) Load(subscriptionID string) ([]Cursor, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	return append([]Cursor(nil), m.cursors[subscriptionID]...), nil
+}
+
+// NewFileOffsetStore creates an OffsetStore which writes the cursors of each subscription as json to a file
+// named after the subscription ID inside the given directory. The directory must exist.
+func NewFileOffsetStore(dir string) *FileOffsetStore {
+	return &FileOffsetStore{dir: dir}
+}
+
+// FileOffsetStore is an OffsetStore which persists cursors in files. Files are replaced atomically, so a
+// crash while saving never leaves a partially written file behind.
+type FileOffsetStore struct {
+	mutex sync.Mutex
+	dir   string
+}
+
+// Save writes the cursors for the subscription to its file.
+func (f *FileOffsetStore) Save(subscriptionID string, cursors []Cursor) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	data, err := json.Marshal(cursors)
+	if err != nil {
+		return errors.Wrap(err, "unable to encode cursors")
+	}
+
+	return errors.Wrap(writeFileAtomic(f.path(subscriptionID), data), "unable to save cursors")
+}
+
+// writeFileAtomic replaces the file at path with the given data. The data is written to a temporary file
+// in the same directory first, which is then renamed, so the file is never partially written.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Load reads the cursors for the subscription from its file.
+func (f *FileOffsetStore) Load(subscriptionID string) ([]Cursor, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	data, err := ioutil.ReadFile(f.path(subscriptionID))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to load cursors")
+	}
+
+	var cursors []Cursor
+	if err := json.Unmarshal(data, &cursors); err != nil {
+		return nil, errors.Wrap(err, "unable to decode cursors")
+	}
+
+	return cursors, nil
+}
+
+func (f *FileOffsetStore) path(subscriptionID string) string {
+	return filepath.Join(f.dir, subscriptionID+".json")
+}
+
+// An OffsetDivergence is a partition for which the cursor stored in an OffsetStore differs from the cursor
+// committed to Nakadi. An offset is empty if the respective side has no cursor for the partition.
+type OffsetDivergence struct {
+	EventType       string
+	Partition       string
+	StoredOffset    string
+	CommittedOffset string
+}
+
+// DetectDivergence compares the cursors stored for the subscription identified by id with the cursors committed
+// to Nakadi and returns the partitions whose offsets differ, ordered by event type and partition. The result is
+// empty if the local progress matches the committed state of Nakadi.
+func (s *SubscriptionAPI) DetectDivergence(id string, store OffsetStore) ([]OffsetDivergence, error) {
+	const errMsg = "unable to detect divergence"
+
+	stored, err := store.Load(id)
+	if err != nil {
+		return nil, errors.Wrap(err, errMsg)
+	}
+	committed, err := s.GetCursors(id)
+	if err != nil {
+		return nil, errors.Wrap(err, errMsg)
+	}
+
+	partitions := make(map[string]*OffsetDivergence)
+	partition := func(cursor Cursor) *OffsetDivergence {
+		key := cursor.EventType + "/" + cursor.Partition
+		if _, ok := partitions[key]; !ok {
+			partitions[key] = &OffsetDivergence{EventType: cursor.EventType, Partition: cursor.Partition}
+		}
+		return partitions[key]
+	}
+	for _, cursor := range stored {
+		partition(cursor).StoredOffset = cursor.Offset
+	}
+	for _, cursor := range committed {
+		partition(cursor).CommittedOffset = cursor.Offset
+	}
+
+	divergences := []OffsetDivergence{}
+	for _, divergence := range partitions {
+		if divergence.StoredOffset != divergence.CommittedOffset {
+			divergences = append(divergences, *divergence)
+		}
+	}
+	sort.Slice(divergences, func(i, j int) bool {
+		if divergences[i].EventType != divergences[j].EventType {
+			return divergences[i].EventType < divergences[j].EventType
+		}
+		return divergences[i].Partition < divergences[j].Partition
+	})
+	return divergences, nil
+}
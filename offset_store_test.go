@@ -0,0 +1,123 @@
+package nakadi
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryOffsetStore(t *testing.T) {
+	store := NewMemoryOffsetStore()
+	cursors := []Cursor{
+		{EventType: "test-event", Partition: "0", Offset: "001-0001-000000000000000001"},
+		{EventType: "test-event", Partition: "1", Offset: "001-0001-000000000000000005"}}
+
+	loaded, err := store.Load("sub-id")
+	require.NoError(t, err)
+	assert.Empty(t, loaded)
+
+	require.NoError(t, store.Save("sub-id", cursors))
+	cursors[0].Offset = "modified"
+
+	loaded, err = store.Load("sub-id")
+	require.NoError(t, err)
+	assert.Equal(t, "001-0001-000000000000000001", loaded[0].Offset)
+	assert.Len(t, loaded, 2)
+}
+
+func TestFileOffsetStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "offset-store")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cursors := []Cursor{
+		{EventType: "test-event", Partition: "0", Offset: "001-0001-000000000000000001", CursorToken: "token"},
+		{EventType: "test-event", Partition: "1", Offset: "001-0001-000000000000000005", CursorToken: "token"}}
+
+	t.Run("load missing file", func(t *testing.T) {
+		store := NewFileOffsetStore(dir)
+
+		loaded, err := store.Load("sub-id")
+		require.NoError(t, err)
+		assert.Empty(t, loaded)
+	})
+
+	t.Run("fail missing directory", func(t *testing.T) {
+		store := NewFileOffsetStore(filepath.Join(dir, "missing"))
+
+		err := store.Save("sub-id", cursors)
+		require.Error(t, err)
+		assert.Regexp(t, "unable to save cursors", err.Error())
+	})
+
+	t.Run("fail invalid file", func(t *testing.T) {
+		store := NewFileOffsetStore(dir)
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "broken.json"), []byte("not json"), 0600))
+
+		_, err := store.Load("broken")
+		require.Error(t, err)
+		assert.Regexp(t, "unable to decode cursors", err.Error())
+	})
+
+	t.Run("save and load", func(t *testing.T) {
+		store := NewFileOffsetStore(dir)
+
+		require.NoError(t, store.Save("sub-id", cursors))
+		require.NoError(t, store.Save("sub-id", cursors[:1]))
+
+		loaded, err := NewFileOffsetStore(dir).Load("sub-id")
+		require.NoError(t, err)
+		assert.Equal(t, cursors[:1], loaded)
+
+		files, err := filepath.Glob(filepath.Join(dir, "sub-id*"))
+		require.NoError(t, err)
+		assert.Len(t, files, 1)
+	})
+}
+
+func TestSubscriptionAPI_DetectDivergence(t *testing.T) {
+	url := defaultNakadiURL + "/subscriptions/sub-id/cursors"
+	setup := func(status int, body string) *SubscriptionAPI {
+		transport := httpmock.NewMockTransport()
+		transport.RegisterResponder("GET", url, httpmock.NewStringResponder(status, body))
+		client := &Client{nakadiURL: defaultNakadiURL, httpClient: &http.Client{Transport: transport}}
+		return NewSubscriptionAPI(client, nil)
+	}
+
+	t.Run("fail load", func(t *testing.T) {
+		_, err := setup(http.StatusOK, `{"items":[]}`).DetectDivergence("sub-id", brokenOffsetStore{})
+		require.Error(t, err)
+		assert.Regexp(t, "unable to detect divergence", err.Error())
+	})
+
+	t.Run("fail request cursors", func(t *testing.T) {
+		_, err := setup(http.StatusNotFound, testProblemJSON).DetectDivergence("sub-id", NewMemoryOffsetStore())
+		require.Error(t, err)
+		assert.Regexp(t, "unable to detect divergence: .*some problem detail", err.Error())
+	})
+
+	t.Run("success", func(t *testing.T) {
+		subAPI := setup(http.StatusOK, `{"items":[
+			{"event_type":"test-event","partition":"0","offset":"001-0001-000000000000000001","cursor_token":"a"},
+			{"event_type":"test-event","partition":"1","offset":"001-0001-000000000000000007","cursor_token":"b"},
+			{"event_type":"test-event","partition":"2","offset":"001-0001-000000000000000003","cursor_token":"c"}]}`)
+		store := NewMemoryOffsetStore()
+		require.NoError(t, store.Save("sub-id", []Cursor{
+			{EventType: "test-event", Partition: "0", Offset: "001-0001-000000000000000001"},
+			{EventType: "test-event", Partition: "1", Offset: "001-0001-000000000000000005"},
+			{EventType: "test-event", Partition: "3", Offset: "001-0001-000000000000000002"}}))
+
+		divergences, err := subAPI.DetectDivergence("sub-id", store)
+		require.NoError(t, err)
+		assert.Equal(t, []OffsetDivergence{
+			{EventType: "test-event", Partition: "1", StoredOffset: "001-0001-000000000000000005", CommittedOffset: "001-0001-000000000000000007"},
+			{EventType: "test-event", Partition: "2", CommittedOffset: "001-0001-000000000000000003"},
+			{EventType: "test-event", Partition: "3", StoredOffset: "001-0001-000000000000000002"}}, divergences)
+	})
+}
@@ -0,0 +1,320 @@
+package nakadi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// PartitionStrategy identifies how Nakadi assigns events of an event type to partitions.
+type PartitionStrategy string
+
+// Partition strategies supported by Nakadi. The constants are untyped, so that they can be used for the
+// field EventType.PartitionStrategy as well as compared with the result of EventType.Strategy.
+const (
+	PartitionStrategyRandom      = "random"
+	PartitionStrategyHash        = "hash"
+	PartitionStrategyUserDefined = "user_defined"
+	// PartitionStrategyCustom is returned by EventType.Strategy for strategies unknown to this package. The
+	// raw value remains available in EventType.PartitionStrategy.
+	PartitionStrategyCustom = "custom"
+)
+
+// Strategy returns the partition strategy of the event type. An empty strategy is reported as
+// PartitionStrategyRandom, which Nakadi uses by default, strategies unknown to this package are reported as
+// PartitionStrategyCustom. The fields used by the strategy "hash" are listed in PartitionKeyFields.
+func (e *EventType) Strategy() PartitionStrategy {
+	switch e.PartitionStrategy {
+	case "":
+		return PartitionStrategyRandom
+	case PartitionStrategyRandom, PartitionStrategyHash, PartitionStrategyUserDefined:
+		return PartitionStrategy(e.PartitionStrategy)
+	default:
+		return PartitionStrategyCustom
+	}
+}
+
+// ErrMissingPartitionKey is returned by publish methods if an event does not contain all partition key
+// fields required by the partition strategy of its event type.
+var ErrMissingPartitionKey = errors.New("missing partition key")
+
+// validatePartitionStrategy checks whether the partition strategy of the event type is known and whether all
+// parameters required by the strategy are present. An empty strategy is valid since Nakadi uses a default.
+func validatePartitionStrategy(eventType *EventType) error {
+	switch eventType.Strategy() {
+	case PartitionStrategyRandom, PartitionStrategyUserDefined:
+		return nil
+	case PartitionStrategyHash:
+		if len(eventType.PartitionKeyFields) == 0 {
+			return errors.New("partition strategy \"hash\" requires partition key fields")
+		}
+		return nil
+	default:
+		return errors.Errorf("unknown partition strategy %q", eventType.PartitionStrategy)
+	}
+}
+
+// PartitionHint describes the partitioning of an event type. It is used to validate events locally before
+// they are published.
+type PartitionHint struct {
+	// The partition strategy of the event type.
+	Strategy string
+	// The partition key fields as dot separated paths. Required for the strategy "hash".
+	KeyFields []string
+	// The category of the event type. For the category "data" the key fields are resolved relative to
+	// the data field of the event.
+	Category string
+}
+
+// partitionHintFromEventType extracts the partitioning information from an event type.
+func partitionHintFromEventType(eventType *EventType) *PartitionHint {
+	return &PartitionHint{
+		Strategy:  eventType.PartitionStrategy,
+		KeyFields: eventType.PartitionKeyFields,
+		Category:  eventType.Category}
+}
+
+// validate checks whether all json encoded events contain the keys required by the partition strategy.
+func (h *PartitionHint) validate(encoded []byte) error {
+	if h.Strategy != PartitionStrategyHash && h.Strategy != PartitionStrategyUserDefined {
+		return nil
+	}
+
+	var events []map[string]interface{}
+	if err := json.Unmarshal(encoded, &events); err != nil {
+		return errors.Wrap(err, "unable to validate partition keys")
+	}
+
+	for i, event := range events {
+		if h.Strategy == PartitionStrategyUserDefined {
+			if partition, ok := lookupPath(event, "metadata.partition"); !ok || partition == "" {
+				return errors.Wrapf(ErrMissingPartitionKey, "event %d: metadata.partition is required", i)
+			}
+			continue
+		}
+
+		for _, field := range h.KeyFields {
+			path := field
+			if h.Category == "data" {
+				path = "data." + field
+			}
+			if value, ok := lookupPath(event, path); !ok || value == nil {
+				return errors.Wrapf(ErrMissingPartitionKey, "event %d: %s is required", i, path)
+			}
+		}
+	}
+
+	return nil
+}
+
+// lookupPath resolves a dot separated path in a decoded json object.
+func lookupPath(object map[string]interface{}, path string) (interface{}, bool) {
+	var current interface{} = object
+	for _, name := range strings.Split(path, ".") {
+		fields, ok := current.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		current, ok = fields[name]
+		if !ok {
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// PartitionKeys extracts the values of the partition key fields from an event, which may be a struct or a
+// map that encodes to a json object. String values are used as they are, all other values in their json
+// encoding. If a key field is missing or null, the returned error is caused by ErrMissingPartitionKey.
+func (h *PartitionHint) PartitionKeys(event interface{}) ([]string, error) {
+	encoded, err := json.Marshal(event)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to extract partition keys")
+	}
+
+	var object map[string]interface{}
+	if err := decodeUsingNumber(encoded, &object); err != nil {
+		return nil, errors.Wrap(err, "unable to extract partition keys")
+	}
+	return h.extractKeys(object)
+}
+
+// extractKeys resolves the partition key fields in a decoded event.
+func (h *PartitionHint) extractKeys(event map[string]interface{}) ([]string, error) {
+	keys := make([]string, 0, len(h.KeyFields))
+	for _, field := range h.KeyFields {
+		path := field
+		if h.Category == "data" {
+			path = "data." + field
+		}
+		value, ok := lookupPath(event, path)
+		if !ok || value == nil {
+			return nil, errors.Wrapf(ErrMissingPartitionKey, "%s is required", path)
+		}
+
+		if key, ok := value.(string); ok {
+			keys = append(keys, key)
+			continue
+		}
+		key, err := json.Marshal(value)
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to encode partition key %s", path)
+		}
+		keys = append(keys, string(key))
+	}
+	return keys, nil
+}
+
+// setPartitionKeys sets metadata.partition_keys of all json encoded events which have no partition keys yet
+// to the values of the partition key fields.
+func (h *PartitionHint) setPartitionKeys(encoded []byte) ([]byte, error) {
+	const errMsg = "unable to set partition keys"
+
+	var events []map[string]interface{}
+	if err := decodeUsingNumber(encoded, &events); err != nil {
+		return nil, errors.Wrap(err, errMsg)
+	}
+
+	for i, event := range events {
+		metadata, _ := event["metadata"].(map[string]interface{})
+		if metadata == nil {
+			metadata = make(map[string]interface{})
+			event["metadata"] = metadata
+		}
+		if keys, ok := metadata["partition_keys"].([]interface{}); ok && len(keys) > 0 {
+			continue
+		}
+
+		keys, err := h.extractKeys(event)
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s: event %d", errMsg, i)
+		}
+		metadata["partition_keys"] = keys
+	}
+
+	return json.Marshal(events)
+}
+
+// decodeUsingNumber decodes json without converting numbers to float64, so that their exact representation
+// is preserved.
+func decodeUsingNumber(encoded []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(encoded))
+	decoder.UseNumber()
+	return decoder.Decode(v)
+}
+
+// ErrUnknownPartition is the cause of errors returned by PublishBatchToPartition if the event type has no
+// partition of the given name.
+var ErrUnknownPartition = errors.New("unknown partition")
+
+// partitionCache holds the partition names of event types requested by a client for defaultPartitionCacheTTL,
+// since partitions may be added to an event type.
+type partitionCache struct {
+	mutex      sync.Mutex
+	partitions map[string]cachedPartitions
+}
+
+// cachedPartitions are the partition names of an event type at a specific time.
+type cachedPartitions struct {
+	names     []string
+	fetchedAt time.Time
+}
+
+// cachedPartitionNames returns the names of the partitions of the event type. The partitions are requested
+// on the first call and cached by the client afterwards.
+func (c *Client) cachedPartitionNames(eventType string) ([]string, error) {
+	if c.partitions != nil {
+		c.partitions.mutex.Lock()
+		cached, ok := c.partitions.partitions[eventType]
+		c.partitions.mutex.Unlock()
+		if ok && time.Since(cached.fetchedAt) < defaultPartitionCacheTTL {
+			return cached.names, nil
+		}
+	}
+
+	partitions, err := NewEventAPI(c, nil).Partitions(eventType)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(partitions))
+	for _, p := range partitions {
+		names = append(names, p.Partition)
+	}
+
+	if c.partitions != nil {
+		c.partitions.mutex.Lock()
+		if c.partitions.partitions == nil {
+			c.partitions.partitions = make(map[string]cachedPartitions)
+		}
+		c.partitions.partitions[eventType] = cachedPartitions{names: names, fetchedAt: time.Now()}
+		c.partitions.mutex.Unlock()
+	}
+	return names, nil
+}
+
+// PublishBatchToPartition emits a batch of events of the given event type which are all assigned to the same
+// partition, so that Nakadi retains their order. For the partition strategy "user_defined" metadata.partition
+// of each event is set to the partition. For the strategy "hash" metadata.partition_keys of each event is set
+// to the name of the partition: the events end up in the same partition, but not necessarily in the partition
+// of this name. Events of other strategies can't be pinned and are rejected. The partition is checked against
+// the partitions of the event type, which are cached by the client for 5 minutes: unknown partitions are
+// rejected with an error caused by ErrUnknownPartition before anything is sent.
+func (c *Client) PublishBatchToPartition(eventType, partition string, events []interface{}) error {
+	const errMsg = "unable to publish events to partition"
+
+	definition, err := c.cachedEventType(eventType)
+	if err != nil {
+		return errors.Wrap(err, errMsg)
+	}
+	var field string
+	var value interface{}
+	switch definition.Strategy() {
+	case PartitionStrategyUserDefined:
+		field, value = "partition", partition
+	case PartitionStrategyHash:
+		field, value = "partition_keys", []string{partition}
+	default:
+		return errors.Errorf("%s: partition strategy %q does not support pinning events", errMsg, definition.PartitionStrategy)
+	}
+
+	names, err := c.cachedPartitionNames(eventType)
+	if err != nil {
+		return errors.Wrap(err, errMsg)
+	}
+	if !containsString(names, partition) {
+		return errors.Wrapf(ErrUnknownPartition, "%s: event type %s has no partition %s", errMsg, eventType, partition)
+	}
+
+	encoded, err := setMetadataField(events, field, value)
+	if err != nil {
+		return errors.Wrap(err, errMsg)
+	}
+	return NewPublishAPI(c, eventType, nil).publishEncoded(context.Background(), encoded)
+}
+
+// setMetadataField encodes the events with the given field of the metadata of each event set to value.
+func setMetadataField(events []interface{}, field string, value interface{}) ([]byte, error) {
+	encoded, err := json.Marshal(events)
+	if err != nil {
+		return nil, err
+	}
+
+	var decoded []map[string]interface{}
+	if err := decodeUsingNumber(encoded, &decoded); err != nil {
+		return nil, err
+	}
+	for _, event := range decoded {
+		metadata, _ := event["metadata"].(map[string]interface{})
+		if metadata == nil {
+			metadata = make(map[string]interface{})
+			event["metadata"] = metadata
+		}
+		metadata[field] = value
+	}
+	return json.Marshal(decoded)
+}
@@ -0,0 +1,231 @@
+package nakadi
+
+import (
+	"io/ioutil"
+	"net/http"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventType_Strategy(t *testing.T) {
+	tests := []struct {
+		raw      string
+		expected PartitionStrategy
+	}{
+		{"", PartitionStrategyRandom},
+		{"random", PartitionStrategyRandom},
+		{"hash", PartitionStrategyHash},
+		{"user_defined", PartitionStrategyUserDefined},
+		{"by_region", PartitionStrategyCustom},
+		{"custom", PartitionStrategyCustom}}
+
+	for _, test := range tests {
+		eventType := &EventType{PartitionStrategy: test.raw}
+		assert.Equal(t, test.expected, eventType.Strategy(), "strategy %q", test.raw)
+		assert.Equal(t, test.raw, eventType.PartitionStrategy)
+	}
+}
+
+func TestPartitionHint_validate(t *testing.T) {
+	t.Run("random strategy", func(t *testing.T) {
+		hint := &PartitionHint{Strategy: PartitionStrategyRandom}
+
+		err := hint.validate([]byte(`[{"metadata":{}}]`))
+		assert.NoError(t, err)
+	})
+
+	t.Run("fail decode events", func(t *testing.T) {
+		hint := &PartitionHint{Strategy: PartitionStrategyHash, KeyFields: []string{"id"}}
+
+		err := hint.validate([]byte(`{"id":1}`))
+		require.Error(t, err)
+		assert.Regexp(t, "unable to validate partition keys", err)
+	})
+
+	t.Run("fail hash missing key", func(t *testing.T) {
+		hint := &PartitionHint{Strategy: PartitionStrategyHash, KeyFields: []string{"order.id"}}
+
+		err := hint.validate([]byte(`[{"order":{"id":"1"}},{"order":{}}]`))
+		require.Error(t, err)
+		assert.Equal(t, ErrMissingPartitionKey, errors.Cause(err))
+		assert.Regexp(t, "event 1: order.id is required", err)
+	})
+
+	t.Run("fail hash null key", func(t *testing.T) {
+		hint := &PartitionHint{Strategy: PartitionStrategyHash, KeyFields: []string{"id"}}
+
+		err := hint.validate([]byte(`[{"id":null}]`))
+		require.Error(t, err)
+		assert.Equal(t, ErrMissingPartitionKey, errors.Cause(err))
+	})
+
+	t.Run("fail hash data category", func(t *testing.T) {
+		hint := &PartitionHint{Strategy: PartitionStrategyHash, KeyFields: []string{"id"}, Category: "data"}
+
+		err := hint.validate([]byte(`[{"id":"1","data":{}}]`))
+		require.Error(t, err)
+		assert.Regexp(t, "event 0: data.id is required", err)
+	})
+
+	t.Run("success hash", func(t *testing.T) {
+		hint := &PartitionHint{Strategy: PartitionStrategyHash, KeyFields: []string{"id", "order.id"}}
+
+		err := hint.validate([]byte(`[{"id":"1","order":{"id":2}}]`))
+		assert.NoError(t, err)
+	})
+
+	t.Run("success hash data category", func(t *testing.T) {
+		hint := &PartitionHint{Strategy: PartitionStrategyHash, KeyFields: []string{"id"}, Category: "data"}
+
+		err := hint.validate([]byte(`[{"data":{"id":"1"}}]`))
+		assert.NoError(t, err)
+	})
+
+	t.Run("fail user defined missing partition", func(t *testing.T) {
+		hint := &PartitionHint{Strategy: PartitionStrategyUserDefined}
+
+		err := hint.validate([]byte(`[{"metadata":{"partition":""}}]`))
+		require.Error(t, err)
+		assert.Equal(t, ErrMissingPartitionKey, errors.Cause(err))
+		assert.Regexp(t, "metadata.partition is required", err)
+	})
+
+	t.Run("success user defined", func(t *testing.T) {
+		hint := &PartitionHint{Strategy: PartitionStrategyUserDefined}
+
+		err := hint.validate([]byte(`[{"metadata":{"partition":"0"}}]`))
+		assert.NoError(t, err)
+	})
+}
+
+func TestLookupPath(t *testing.T) {
+	object := map[string]interface{}{
+		"id":    "1",
+		"order": map[string]interface{}{"id": "2"}}
+
+	value, ok := lookupPath(object, "id")
+	assert.True(t, ok)
+	assert.Equal(t, "1", value)
+
+	value, ok = lookupPath(object, "order.id")
+	assert.True(t, ok)
+	assert.Equal(t, "2", value)
+
+	_, ok = lookupPath(object, "order.number")
+	assert.False(t, ok)
+
+	_, ok = lookupPath(object, "id.number")
+	assert.False(t, ok)
+}
+
+func TestPartitionHint_PartitionKeys(t *testing.T) {
+	hint := &PartitionHint{Strategy: PartitionStrategyHash, KeyFields: []string{"order.id", "count"}, Category: "data"}
+
+	t.Run("fail missing key", func(t *testing.T) {
+		_, err := hint.PartitionKeys(map[string]interface{}{"data": map[string]interface{}{"count": 1}})
+		require.Error(t, err)
+		assert.Equal(t, ErrMissingPartitionKey, errors.Cause(err))
+		assert.Regexp(t, "data.order.id is required", err)
+	})
+
+	t.Run("success struct event", func(t *testing.T) {
+		type order struct {
+			ID string `json:"id"`
+		}
+		type data struct {
+			Order order `json:"order"`
+			Count int64 `json:"count"`
+		}
+
+		keys, err := hint.PartitionKeys(DataChangeEvent{Data: data{Order: order{ID: "o-1"}, Count: 9007199254740993}})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"o-1", "9007199254740993"}, keys)
+	})
+}
+
+func TestPartitionHint_setPartitionKeys(t *testing.T) {
+	hint := &PartitionHint{Strategy: PartitionStrategyHash, KeyFields: []string{"id"}}
+
+	t.Run("fail missing key", func(t *testing.T) {
+		_, err := hint.setPartitionKeys([]byte(`[{"id":"1"},{"metadata":{}}]`))
+		require.Error(t, err)
+		assert.Equal(t, ErrMissingPartitionKey, errors.Cause(err))
+		assert.Regexp(t, "unable to set partition keys: event 1: id is required", err)
+	})
+
+	t.Run("success keep existing keys", func(t *testing.T) {
+		encoded, err := hint.setPartitionKeys([]byte(`[{"id":"1"},{"id":2,"metadata":{"eid":"e","partition_keys":["x"]}}]`))
+		require.NoError(t, err)
+		assert.JSONEq(t, `[{"id":"1","metadata":{"partition_keys":["1"]}},{"id":2,"metadata":{"eid":"e","partition_keys":["x"]}}]`, string(encoded))
+	})
+}
+
+func TestClient_PublishBatchToPartition(t *testing.T) {
+	eventTypeURL := defaultNakadiURL + "/event-types/test-event"
+	events := []interface{}{map[string]string{"test": "first"}, map[string]string{"test": "second"}}
+
+	setup := func(strategy string) (*httpmock.MockTransport, *Client, *[]string) {
+		transport := httpmock.NewMockTransport()
+		client := New(defaultNakadiURL, nil)
+		client.httpClient = &http.Client{Transport: transport}
+
+		transport.RegisterResponder("GET", eventTypeURL, httpmock.NewStringResponder(http.StatusOK,
+			`{"name":"test-event","category":"undefined","partition_strategy":"`+strategy+`","partition_key_fields":["test"]}`))
+		transport.RegisterResponder("GET", eventTypeURL+"/partitions", httpmock.NewStringResponder(http.StatusOK,
+			`[{"partition":"0"},{"partition":"1"}]`))
+		var published []string
+		transport.RegisterResponder("POST", eventTypeURL+"/events", func(r *http.Request) (*http.Response, error) {
+			body, err := ioutil.ReadAll(r.Body)
+			require.NoError(t, err)
+			published = append(published, string(body))
+			return httpmock.NewStringResponse(http.StatusOK, ""), nil
+		})
+		return transport, client, &published
+	}
+
+	t.Run("fail unknown partition", func(t *testing.T) {
+		_, client, published := setup(PartitionStrategyUserDefined)
+
+		err := client.PublishBatchToPartition("test-event", "2", events)
+		require.Error(t, err)
+		assert.Equal(t, ErrUnknownPartition, errors.Cause(err))
+		assert.Regexp(t, "event type test-event has no partition 2", err)
+		assert.Empty(t, *published)
+	})
+
+	t.Run("fail random strategy", func(t *testing.T) {
+		_, client, published := setup(PartitionStrategyRandom)
+
+		err := client.PublishBatchToPartition("test-event", "1", events)
+		require.Error(t, err)
+		assert.Regexp(t, `partition strategy "random" does not support pinning events`, err)
+		assert.Empty(t, *published)
+	})
+
+	t.Run("success user defined", func(t *testing.T) {
+		transport, client, published := setup(PartitionStrategyUserDefined)
+
+		require.NoError(t, client.PublishBatchToPartition("test-event", "1", events))
+		require.NoError(t, client.PublishBatchToPartition("test-event", "0", events[:1]))
+		require.Len(t, *published, 2)
+		assert.JSONEq(t, `[{"test":"first","metadata":{"partition":"1"}},{"test":"second","metadata":{"partition":"1"}}]`, (*published)[0])
+		assert.JSONEq(t, `[{"test":"first","metadata":{"partition":"0"}}]`, (*published)[1])
+
+		// the event type and the partitions are requested once
+		info := transport.GetCallCountInfo()
+		assert.Equal(t, 1, info["GET "+eventTypeURL])
+		assert.Equal(t, 1, info["GET "+eventTypeURL+"/partitions"])
+	})
+
+	t.Run("success hash", func(t *testing.T) {
+		_, client, published := setup(PartitionStrategyHash)
+
+		require.NoError(t, client.PublishBatchToPartition("test-event", "1", events))
+		require.Len(t, *published, 1)
+		assert.JSONEq(t, `[{"test":"first","metadata":{"partition_keys":["1"]}},{"test":"second","metadata":{"partition_keys":["1"]}}]`, (*published)[0])
+	})
+}
@@ -0,0 +1,118 @@
+package nakadi
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/pkg/errors"
+)
+
+// ErrMissingPermission is the cause of errors returned by CheckWritePermission and CheckReadPermission if
+// none of the authorization attributes of an event type applies to the client.
+var ErrMissingPermission = errors.New("client is likely not authorized")
+
+// ErrUnauthorized is the cause of errors returned by CanConsume if Nakadi rejects the token of the client.
+var ErrUnauthorized = errors.New("client is not authorized")
+
+// ErrSubscriptionNotFound is the cause of errors returned by CanConsume and by Get and Delete of the
+// SubscriptionAPI if the subscription does not exist.
+var ErrSubscriptionNotFound = errors.New("subscription does not exist")
+
+// authorizationWildcard is the attribute value which grants access to all clients of a data type.
+const authorizationWildcard = "*"
+
+// CheckWritePermission requests the authorization of the event type with the given name and checks whether
+// the client is likely allowed to publish events to it. Since the permissions of a token can't be inspected
+// by the client in general, the caller decides with hasAttribute whether an authorization attribute, e.g.
+// a service or team, applies to the client. The check is best-effort and meant to detect missing
+// permissions during startup rather than with the first publish request: if none of the writers of the
+// event type applies, the cause of the returned error is ErrMissingPermission. Event types without
+// authorization are accessible by all clients.
+func (c *Client) CheckWritePermission(name string, hasAttribute func(AuthorizationAttribute) bool) error {
+	const errMsg = "unable to check write permission"
+
+	auth, err := c.eventTypeAuthorization(name, hasAttribute)
+	if err != nil {
+		return errors.Wrap(err, errMsg)
+	}
+	if auth == nil || anyAttributeApplies(auth.Writers, hasAttribute) {
+		return nil
+	}
+	return errors.Wrapf(ErrMissingPermission, "%s: no writer of event type %s applies", errMsg, name)
+}
+
+// CheckReadPermission works like CheckWritePermission, but checks whether the client is likely allowed to
+// read events from the event type with the given name.
+func (c *Client) CheckReadPermission(name string, hasAttribute func(AuthorizationAttribute) bool) error {
+	const errMsg = "unable to check read permission"
+
+	auth, err := c.eventTypeAuthorization(name, hasAttribute)
+	if err != nil {
+		return errors.Wrap(err, errMsg)
+	}
+	if auth == nil || anyAttributeApplies(auth.Readers, hasAttribute) {
+		return nil
+	}
+	return errors.Wrapf(ErrMissingPermission, "%s: no reader of event type %s applies", errMsg, name)
+}
+
+// eventTypeAuthorization requests the authorization of an event type, which is nil if the event type is
+// accessible by all clients.
+func (c *Client) eventTypeAuthorization(name string, hasAttribute func(AuthorizationAttribute) bool) (*EventTypeAuthorization, error) {
+	if hasAttribute == nil {
+		return nil, errors.New("attribute check required")
+	}
+
+	eventType, err := NewEventAPI(c, nil).Get(name)
+	if err != nil {
+		return nil, err
+	}
+	return eventType.Authorization, nil
+}
+
+// anyAttributeApplies checks whether one of the attributes is a wildcard or applies according to hasAttribute.
+func anyAttributeApplies(attributes []AuthorizationAttribute, hasAttribute func(AuthorizationAttribute) bool) bool {
+	for _, attribute := range attributes {
+		if attribute.Value == authorizationWildcard || hasAttribute(attribute) {
+			return true
+		}
+	}
+	return false
+}
+
+// CanConsume checks whether the client is allowed to consume from the subscription identified by id, e.g. in
+// readiness probes of consumers. It opens a stream on the subscription and closes it as soon as Nakadi sent
+// the response headers, so no events are read and no cursors are committed. Events which Nakadi sends to the
+// stream anyway remain uncommitted and are streamed again later. If Nakadi rejects the token the cause of
+// the returned error is ErrUnauthorized, if the subscription does not exist it is ErrSubscriptionNotFound.
+// A subscription whose partitions are all consumed by other streams is reported as consumable.
+func (c *Client) CanConsume(id string) error {
+	const errMsg = "unable to consume from subscription"
+
+	opener := &simpleStreamOpener{
+		ctx:                  context.Background(),
+		client:               c,
+		subscriptionID:       id,
+		batchLimit:           1,
+		maxUncommittedEvents: 1,
+		connectTimeout:       c.timeout}
+
+	stream, response, err := opener.openStreamOnce()
+	if err != nil {
+		if response == nil {
+			return errors.Wrap(err, errMsg)
+		}
+		switch response.StatusCode {
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return withSentinel(ErrUnauthorized, err)
+		case http.StatusNotFound:
+			return withSentinel(ErrSubscriptionNotFound, err)
+		case http.StatusConflict:
+			// no free slots, but the subscription exists and the client was authorized
+			return nil
+		}
+		return errors.Wrap(err, errMsg)
+	}
+
+	return stream.closeStream()
+}
@@ -0,0 +1,134 @@
+package nakadi
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_CheckWritePermission(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	client := &Client{nakadiURL: defaultNakadiURL, httpClient: http.DefaultClient}
+	url := fmt.Sprintf("%s/event-types/%s", defaultNakadiURL, "test-event")
+	isService := func(attribute AuthorizationAttribute) bool {
+		return attribute.DataType == "service" && attribute.Value == "test-service"
+	}
+	withAuthorization := func(auth *EventTypeAuthorization) httpmock.Responder {
+		responder, err := httpmock.NewJsonResponder(http.StatusOK, &EventType{Name: "test-event", Authorization: auth})
+		require.NoError(t, err)
+		return responder
+	}
+
+	t.Run("fail without attribute check", func(t *testing.T) {
+		err := client.CheckWritePermission("test-event", nil)
+		require.Error(t, err)
+		assert.Regexp(t, "unable to check write permission: attribute check required", err)
+	})
+
+	t.Run("fail request event type", func(t *testing.T) {
+		httpmock.RegisterResponder("GET", url, httpmock.NewStringResponder(http.StatusNotFound, testProblemJSON))
+
+		err := client.CheckWritePermission("test-event", isService)
+		require.Error(t, err)
+		assert.Regexp(t, "some problem detail", err)
+	})
+
+	t.Run("fail missing permission", func(t *testing.T) {
+		httpmock.RegisterResponder("GET", url, withAuthorization(&EventTypeAuthorization{
+			Readers: []AuthorizationAttribute{{DataType: "service", Value: "test-service"}},
+			Writers: []AuthorizationAttribute{{DataType: "service", Value: "other-service"}}}))
+
+		err := client.CheckWritePermission("test-event", isService)
+		require.Error(t, err)
+		assert.Equal(t, ErrMissingPermission, errors.Cause(err))
+		assert.Regexp(t, "no writer of event type test-event applies", err)
+
+		assert.NoError(t, client.CheckReadPermission("test-event", isService))
+	})
+
+	t.Run("success without authorization", func(t *testing.T) {
+		httpmock.RegisterResponder("GET", url, withAuthorization(nil))
+
+		assert.NoError(t, client.CheckWritePermission("test-event", isService))
+	})
+
+	t.Run("success matching attribute", func(t *testing.T) {
+		httpmock.RegisterResponder("GET", url, withAuthorization(&EventTypeAuthorization{
+			Writers: []AuthorizationAttribute{{DataType: "user", Value: "someone"}, {DataType: "service", Value: "test-service"}}}))
+
+		assert.NoError(t, client.CheckWritePermission("test-event", isService))
+	})
+
+	t.Run("success wildcard", func(t *testing.T) {
+		httpmock.RegisterResponder("GET", url, withAuthorization(&EventTypeAuthorization{
+			Readers: []AuthorizationAttribute{{DataType: "user", Value: "*"}},
+			Writers: []AuthorizationAttribute{{DataType: "*", Value: "*"}}}))
+
+		assert.NoError(t, client.CheckWritePermission("test-event", isService))
+
+		err := client.CheckReadPermission("test-event", func(AuthorizationAttribute) bool { return false })
+		assert.NoError(t, err)
+	})
+}
+
+func TestClient_CanConsume(t *testing.T) {
+	url := fmt.Sprintf("%s/subscriptions/%s/events", defaultNakadiURL, "sub-id")
+	setup := func(responder httpmock.Responder) *Client {
+		transport := httpmock.NewMockTransport()
+		transport.RegisterResponder("GET", `=~^`+url, responder)
+		return &Client{nakadiURL: defaultNakadiURL, httpStreamClient: &http.Client{Transport: transport}}
+	}
+
+	t.Run("fail unauthorized", func(t *testing.T) {
+		client := setup(httpmock.NewStringResponder(http.StatusForbidden, testProblemJSON))
+
+		err := client.CanConsume("sub-id")
+		require.Error(t, err)
+		assert.Equal(t, ErrUnauthorized, errors.Cause(err))
+		assert.Regexp(t, "some problem detail", err)
+	})
+
+	t.Run("fail not found", func(t *testing.T) {
+		client := setup(httpmock.NewStringResponder(http.StatusNotFound, testProblemJSON))
+
+		err := client.CanConsume("sub-id")
+		require.Error(t, err)
+		assert.Equal(t, ErrSubscriptionNotFound, errors.Cause(err))
+	})
+
+	t.Run("fail other error", func(t *testing.T) {
+		client := setup(httpmock.NewStringResponder(http.StatusInternalServerError, testProblemJSON))
+
+		err := client.CanConsume("sub-id")
+		require.Error(t, err)
+		assert.Regexp(t, "unable to consume from subscription: .*some problem detail", err)
+	})
+
+	t.Run("success no free slots", func(t *testing.T) {
+		client := setup(httpmock.NewStringResponder(http.StatusConflict, testProblemJSON))
+
+		assert.NoError(t, client.CanConsume("sub-id"))
+	})
+
+	t.Run("success stream opened", func(t *testing.T) {
+		closed := make(chan struct{})
+		client := setup(func(r *http.Request) (*http.Response, error) {
+			assert.Equal(t, "1", r.URL.Query().Get("batch_limit"))
+			go func() {
+				<-r.Context().Done()
+				close(closed)
+			}()
+			return httpmock.NewStringResponse(http.StatusOK, `{"cursor":{"partition":"0","offset":"1"},"events":[{}]}`), nil
+		})
+
+		assert.NoError(t, client.CanConsume("sub-id"))
+		<-closed
+	})
+}
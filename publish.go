@@ -2,28 +2,49 @@ package nakadi
 
 import (
 	"bytes"
+	"compress/gzip"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
+	"sort"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/cenkalti/backoff/v3"
 	"github.com/pkg/errors"
 )
 
 // EventMetadata represents the meta information which comes along with all Nakadi events. For publishing
-// purposes only the fields eid and occurred_at must be present.
+// purposes only the fields eid and occurred_at must be present. The fields received_at and version are
+// populated by Nakadi and should be left empty when publishing. With PublishOptions.ValidateSchema, business and
+// data events whose occurred_at is not an RFC 3339 timestamp with timezone are rejected.
 type EventMetadata struct {
-	EID        string            `json:"eid"`
-	OccurredAt time.Time         `json:"occurred_at"`
-	EventType  string            `json:"event_type,omitempty"`
-	Partition  string            `json:"partition,omitempty"`
-	ParentEIDs []string          `json:"parent_eids,omitempty"`
-	FlowID     string            `json:"flow_id,omitempty"`
-	ReceivedAt *time.Time        `json:"received_at,omitempty"`
-	SpanCtx    map[string]string `json:"span_ctx,omitempty"`
+	EID                    string            `json:"eid"`
+	OccurredAt             time.Time         `json:"occurred_at"`
+	EventType              string            `json:"event_type,omitempty"`
+	Partition              string            `json:"partition,omitempty"`
+	PartitionCompactionKey string            `json:"partition_compaction_key,omitempty"`
+	PartitionKeys          []string          `json:"partition_keys,omitempty"`
+	ParentEIDs             []string          `json:"parent_eids,omitempty"`
+	FlowID                 string            `json:"flow_id,omitempty"`
+	ReceivedAt             *time.Time        `json:"received_at,omitempty"`
+	Version                string            `json:"version,omitempty"`
+	SpanCtx                map[string]string `json:"span_ctx,omitempty"`
+}
+
+// NewEventMetadata creates the metadata for a new event. The eid is created by the EIDGenerator of the client
+// and occurred_at is set to the current time in UTC, which is encoded in RFC 3339 format with nanoseconds.
+func (c *Client) NewEventMetadata() EventMetadata {
+	generator := c.eidGenerator
+	if generator == nil {
+		generator = newUUID
+	}
+	return EventMetadata{EID: generator(), OccurredAt: time.Now().UTC()}
 }
 
 // UndefinedEvent can be embedded in structs representing Nakadi events from the event category "undefined".
@@ -59,10 +80,82 @@ type PublishOptions struct {
 	// MaxRetryInterval the maximum retry interval. Once the exponential backoff reaches
 	// this value the retry intervals remain constant.
 	MaxRetryInterval time.Duration
-	// MaxElapsedTime is the maximum time spent on retries when publishing events. Once
-	// this value was reached the exponential backoff is halted and the events will not be
-	// published.
+	// MaxElapsedTime is the maximum time spent on retries when publishing events, including
+	// the time spent waiting between attempts. Once this value was reached the exponential
+	// backoff is halted, the events will not be published and the last error is returned.
 	MaxElapsedTime time.Duration
+	// PartitionHint describes the partitioning of the event type. If set, events are checked for
+	// missing partition keys before they are published (default: nil).
+	PartitionHint *PartitionHint
+	// Whether or not the partitioning of the event type is requested from Nakadi. The partitioning
+	// is requested once and used to check events for missing partition keys before they are published.
+	// If set to true PartitionHint has no effect (default: false).
+	FetchPartitionHint bool
+	// Whether or not metadata.partition_keys is populated from the partition key fields of events of event
+	// types with the partition strategy "hash". It requires PartitionHint or FetchPartitionHint. Events which
+	// already have partition keys are sent unchanged, events without a key field are rejected with an error
+	// caused by ErrMissingPartitionKey without sending them to Nakadi (default: false).
+	SetPartitionKeys bool
+	// The maximum number of publish requests of the PublishAPI which are in flight at the same time.
+	// Further calls of publish methods block until a request was completed (default: 0, unlimited).
+	MaxConcurrentPublishes uint
+	// Timeout overrides the connection timeout of the client for publish requests of this PublishAPI.
+	// Like the connection timeout it applies to each single request: if retries are enabled the total
+	// time of a publish call is limited by MaxElapsedTime. PublishContext can be used to limit the total
+	// time of a publish call including retries (default: 0, the connection timeout of the client).
+	Timeout time.Duration
+	// Whether or not publish methods block while Nakadi throttles requests. If set to true, publishing is
+	// retried with exponential backoff as long as Nakadi responds with 429 or 503, until the events
+	// were published or the context passed to PublishContext is done. MaxElapsedTime does not apply to
+	// these retries: without a context that is eventually canceled a publish call may block forever
+	// (default: false).
+	BlockOnThrottle bool
+	// RetryIf overrides the decision which failed publish requests are retried. The predicate receives either
+	// the response of Nakadi or the error of a request that could not be sent, the body of the response can
+	// be read by the predicate. Retries are still limited by MaxElapsedTime and only take place if Retry is
+	// enabled (default: nil, requests are retried on errors and responses with status 5xx).
+	RetryIf func(response *http.Response, err error) bool
+	// Whether or not events are validated against the schema of the event type before they are published.
+	// The schema is requested from Nakadi and events which don't match the schema are rejected with an
+	// error caused by ErrSchemaViolation without sending them to Nakadi. Schemas with references to other
+	// documents or with patterns which can't be compiled by package regexp are not checked, a message is
+	// logged via the Logger of the client and the events are only validated by Nakadi (default: false).
+	ValidateSchema bool
+	// Whether or not metadata.version is set for events of the categories "data" and "business" which have no
+	// version yet. The latest schema version is requested from Nakadi and cached like the schema used by
+	// ValidateSchema. Events with a version newer than the latest schema version are rejected without sending
+	// them to Nakadi (default: false).
+	SetSchemaVersion bool
+	// SchemaCacheTTL is the time for which the schema used by ValidateSchema and SetSchemaVersion is cached. Once the TTL expired
+	// the schema is refreshed in the background while publishing continues with the cached schema. The
+	// schema is dropped immediately if Nakadi rejects events during validation (default: 5m).
+	SchemaCacheTTL time.Duration
+	// Whether or not events which were rejected by Nakadi as part of a partially published batch are published
+	// again. Only the events with the publishing status "failed" or "aborted" are sent again, with exactly the
+	// same content and therefore the same eids. Events which failed validation are not retried. If some events
+	// remain unpublished after MaxPartialRetries attempts, a BatchItemsError with the final status of all events
+	// of the batch is returned (default: false).
+	RetryPartialFailures bool
+	// MaxPartialRetries is the maximum number of attempts to publish the remaining events of a partially
+	// published batch, if RetryPartialFailures is enabled (default: 3).
+	MaxPartialRetries uint
+	// Whether or not publish requests are compressed with gzip and sent with the header Content-Encoding: gzip.
+	// Only batches of at least CompressThreshold bytes are compressed, smaller batches are sent uncompressed
+	// since they hardly benefit from compression (default: false).
+	CompressRequests bool
+	// CompressThreshold is the minimum size of an encoded batch in bytes which is compressed if
+	// CompressRequests is enabled (default: 1024).
+	CompressThreshold uint
+	// CompactionKeyPath is the dot separated path of the field of an event which is used as
+	// metadata.partition_compaction_key if the event type is compacted, e.g. "data.id" for data change events.
+	// Events which already have a compaction key are sent unchanged, events with an empty or missing key are
+	// rejected with an error caused by ErrMissingCompactionKey without sending them to Nakadi. The cleanup
+	// policy of the event type is requested once and cached by the client (default: empty).
+	CompactionKeyPath string
+	// CompactionKey extracts the partition compaction key from a json encoded event like CompactionKeyPath,
+	// for keys which are not a single field of the event. If set CompactionKeyPath has no effect
+	// (default: nil).
+	CompactionKey func(event json.RawMessage) (string, error)
 }
 
 func (o *PublishOptions) withDefaults() *PublishOptions {
@@ -79,6 +172,15 @@ func (o *PublishOptions) withDefaults() *PublishOptions {
 	if copyOptions.MaxElapsedTime == 0 {
 		copyOptions.MaxElapsedTime = defaultMaxElapsedTime
 	}
+	if copyOptions.SchemaCacheTTL == 0 {
+		copyOptions.SchemaCacheTTL = defaultSchemaCacheTTL
+	}
+	if copyOptions.MaxPartialRetries == 0 {
+		copyOptions.MaxPartialRetries = defaultMaxPartialRetries
+	}
+	if copyOptions.CompressThreshold == 0 {
+		copyOptions.CompressThreshold = defaultCompressThreshold
+	}
 	return &copyOptions
 }
 
@@ -90,23 +192,84 @@ func (o *PublishOptions) withDefaults() *PublishOptions {
 func NewPublishAPI(client *Client, eventType string, options *PublishOptions) *PublishAPI {
 	options = options.withDefaults()
 
-	return &PublishAPI{
+	if options.Timeout > 0 {
+		client = client.withTimeout(options.Timeout)
+	}
+	if options.RetryIf != nil {
+		client = client.withRetryIf(options.RetryIf)
+	}
+
+	publishAPI := &PublishAPI{
 		client:     client,
-		publishURL: fmt.Sprintf("%s/event-types/%s/events", client.nakadiURL, eventType),
+		eventType:  eventType,
+		publishURL: client.nakadiURL + eventsPath(eventType),
 		backOffConf: backOffConfiguration{
 			Retry:                options.Retry,
 			InitialRetryInterval: options.InitialRetryInterval,
 			MaxRetryInterval:     options.MaxRetryInterval,
-			MaxElapsedTime:       options.MaxElapsedTime}}
+			MaxElapsedTime:       options.MaxElapsedTime},
+		blockOnThrottle:  options.BlockOnThrottle,
+		setPartitionKeys: options.SetPartitionKeys}
+
+	if options.RetryPartialFailures {
+		publishAPI.partialRetries = options.MaxPartialRetries
+	}
+	if options.CompressRequests {
+		publishAPI.compressThreshold = int(options.CompressThreshold)
+	}
+	if options.CompactionKey != nil {
+		publishAPI.compactionKey = options.CompactionKey
+	} else if options.CompactionKeyPath != "" {
+		publishAPI.compactionKey = compactionKeyAt(options.CompactionKeyPath)
+	}
+
+	if options.MaxConcurrentPublishes > 0 {
+		publishAPI.semaphore = make(chan struct{}, options.MaxConcurrentPublishes)
+	}
+
+	publishAPI.eventAPI = NewEventAPI(client, &EventOptions{
+		Retry:                options.Retry,
+		InitialRetryInterval: options.InitialRetryInterval,
+		MaxRetryInterval:     options.MaxRetryInterval,
+		MaxElapsedTime:       options.MaxElapsedTime})
+	if options.FetchPartitionHint {
+		publishAPI.fetchPartitionHint = true
+	} else {
+		publishAPI.partitionHint = options.PartitionHint
+	}
+	if options.ValidateSchema || options.SetSchemaVersion {
+		publishAPI.schemaCache = &schemaCache{ttl: options.SchemaCacheTTL}
+		publishAPI.validateSchema = options.ValidateSchema
+		publishAPI.setSchemaVersion = options.SetSchemaVersion
+	}
+
+	return publishAPI
 }
 
 // PublishAPI is a sub API for publishing Nakadi events. All publish methods emit events as a single batch. If
 // a publish method returns an error, the caller should check whether the error is a BatchItemsError in order to
-// verify which events of a batch have been published.
+// verify which events of a batch have been published. Events are encoded once per publish call, so all retries
+// of a call send the same eids, which allows Nakadi to recognize events that were published twice.
 type PublishAPI struct {
-	client      *Client
-	publishURL  string
-	backOffConf backOffConfiguration
+	client             *Client
+	eventType          string
+	publishURL         string
+	backOffConf        backOffConfiguration
+	eventAPI           *EventAPI
+	hintMutex          sync.Mutex
+	partitionHint      *PartitionHint
+	categoryMutex      sync.Mutex
+	category           string
+	fetchPartitionHint bool
+	setPartitionKeys   bool
+	schemaCache        *schemaCache
+	validateSchema     bool
+	setSchemaVersion   bool
+	semaphore          chan struct{}
+	blockOnThrottle    bool
+	partialRetries     uint
+	compressThreshold  int
+	compactionKey      func(event json.RawMessage) (string, error)
 }
 
 // PublishDataChangeEvent emits a batch of data change events. Depending on the options used when creating
@@ -125,11 +288,232 @@ func (p *PublishAPI) PublishBusinessEvent(events []BusinessEvent) error {
 
 // Publish is used to emit a batch of undefined events. But can also be used to publish data change or
 // business events. Depending on the options used when creating the PublishAPI this method will retry
-// to publish the events if the were not successfully published.
+// to publish the events if the were not successfully published. If the batch is empty or nil, no request is
+// sent to Nakadi and Publish returns nil. The same applies to all other methods publishing a batch.
 func (p *PublishAPI) Publish(events interface{}) error {
+	return p.PublishContext(context.Background(), events)
+}
+
+// PublishContext emits a batch of events like Publish. The provided context is used to bound the request
+// including all retries. If the number of concurrent publishes is limited, PublishContext blocks until
+// the request can be sent or the context is canceled.
+func (p *PublishAPI) PublishContext(ctx context.Context, events interface{}) error {
+	// the events are encoded only once, so that all attempts to publish them send the same eids and
+	// Nakadi is able to detect duplicates
+	encoded, err := json.Marshal(events)
+	if err != nil {
+		return errors.Wrap(err, "unable to request event types: unable to encode json body")
+	}
+	if spanCtx := SpanContextFromContext(ctx); spanCtx != nil {
+		category, err := p.getCategory()
+		if err != nil {
+			return err
+		}
+		if category == "business" || category == "data" {
+			if encoded, err = injectSpanContext(encoded, spanCtx); err != nil {
+				return err
+			}
+		}
+	}
+	return p.publishEncoded(ctx, encoded)
+}
+
+// PublishRaw emits a batch of events which are already encoded as JSON objects. The events are sent exactly
+// as provided without being encoded again, each event is only checked to be a well-formed JSON object. Apart
+// from that PublishRaw behaves like PublishContext, but the span context of ctx is not added to the events.
+func (p *PublishAPI) PublishRaw(ctx context.Context, payloads [][]byte) error {
+	encoded, err := joinPayloads(payloads)
+	if err != nil {
+		return err
+	}
+	return p.publishEncoded(ctx, encoded)
+}
+
+// PublishRaw emits a batch of already encoded events of the given event type. It uses a PublishAPI with default
+// options, see PublishAPI.PublishRaw for details.
+func (c *Client) PublishRaw(eventType string, payloads [][]byte) error {
+	return NewPublishAPI(c, eventType, nil).PublishRaw(context.Background(), payloads)
+}
+
+// joinPayloads assembles a json array from encoded json objects.
+func joinPayloads(payloads [][]byte) ([]byte, error) {
+	size := 2
+	for i, payload := range payloads {
+		trimmed := bytes.TrimSpace(payload)
+		if len(trimmed) == 0 || trimmed[0] != '{' || !json.Valid(trimmed) {
+			return nil, errors.Errorf("unable to publish events: event %d is not a valid json object", i)
+		}
+		size += len(payload) + 1
+	}
+
+	joined := make([]byte, 0, size)
+	joined = append(joined, '[')
+	for i, payload := range payloads {
+		if i > 0 {
+			joined = append(joined, ',')
+		}
+		joined = append(joined, payload...)
+	}
+	return append(joined, ']'), nil
+}
+
+// setSchemaVersion sets metadata.version of all events without version to the given schema version. Events
+// with a version newer than the schema version are rejected.
+func setSchemaVersion(encoded []byte, version string) ([]byte, error) {
+	const errMsg = "unable to set schema version"
+
+	var events []map[string]json.RawMessage
+	if err := json.Unmarshal(encoded, &events); err != nil {
+		return nil, errors.Wrap(err, errMsg)
+	}
+
+	versionJSON, err := json.Marshal(version)
+	if err != nil {
+		return nil, errors.Wrap(err, errMsg)
+	}
+
+	for i, event := range events {
+		metadata := map[string]json.RawMessage{}
+		if raw, ok := event["metadata"]; ok {
+			if err := json.Unmarshal(raw, &metadata); err != nil {
+				return nil, errors.Wrap(err, errMsg)
+			}
+		}
+
+		var current string
+		if raw, ok := metadata["version"]; ok {
+			if err := json.Unmarshal(raw, &current); err != nil {
+				return nil, errors.Wrapf(err, "%s: event %d", errMsg, i)
+			}
+		}
+		if current != "" {
+			if cmp, ok := compareSchemaVersions(current, version); ok && cmp > 0 {
+				return nil, errors.Errorf("%s: event %d has version %s, but the latest schema version is %s", errMsg, i, current, version)
+			}
+			continue
+		}
+		metadata["version"] = versionJSON
+
+		raw, err := json.Marshal(metadata)
+		if err != nil {
+			return nil, errors.Wrap(err, errMsg)
+		}
+		event["metadata"] = raw
+	}
+
+	return json.Marshal(events)
+}
+
+// compareSchemaVersions compares two schema versions of the form major.minor.patch and returns -1, 0 or 1 if a
+// is lower than, equal to or greater than b. The second return value is false if a version can't be parsed.
+func compareSchemaVersions(a, b string) (int, bool) {
+	partsA, partsB := strings.Split(a, "."), strings.Split(b, ".")
+	if len(partsA) != 3 || len(partsB) != 3 {
+		return 0, false
+	}
+	for i := range partsA {
+		numA, errA := strconv.ParseUint(partsA[i], 10, 64)
+		numB, errB := strconv.ParseUint(partsB[i], 10, 64)
+		if errA != nil || errB != nil {
+			return 0, false
+		}
+		if numA != numB {
+			if numA < numB {
+				return -1, true
+			}
+			return 1, true
+		}
+	}
+	return 0, true
+}
+
+// publishEncoded validates and emits a json encoded batch of events. An empty batch is not sent to Nakadi.
+func (p *PublishAPI) publishEncoded(ctx context.Context, encoded []byte) error {
+	const errMsg = "unable to request event types"
+
+	if isEmptyBatch(encoded) {
+		return nil
+	}
+	ctx = p.client.withAttemptBudget(ctx)
+
+	if p.semaphore != nil {
+		select {
+		case p.semaphore <- struct{}{}:
+			defer func() { <-p.semaphore }()
+		case <-ctx.Done():
+			return errors.Wrap(ctx.Err(), errMsg)
+		}
+	}
+
+	hint, err := p.getPartitionHint()
+	if err != nil {
+		return err
+	}
+	schema, err := p.getSchema()
+	if err != nil {
+		return err
+	}
+	if hint != nil {
+		if err := hint.validate(encoded); err != nil {
+			return err
+		}
+		if p.setPartitionKeys && hint.Strategy == PartitionStrategyHash {
+			if encoded, err = hint.setPartitionKeys(encoded); err != nil {
+				return err
+			}
+		}
+	}
+	if p.compactionKey != nil {
+		if encoded, err = p.setCompactionKeys(encoded); err != nil {
+			return err
+		}
+	}
+	if schema != nil && p.setSchemaVersion && schema.category != "undefined" {
+		if encoded, err = setSchemaVersion(encoded, schema.version); err != nil {
+			return err
+		}
+	}
+	if schema != nil && schema.schema != nil && p.validateSchema {
+		if err := validateEvents(encoded, schema.schema, schema.category); err != nil {
+			return err
+		}
+	}
+
+	err = p.send(ctx, encoded, schema)
+	if items, partial := err.(BatchItemsError); partial && p.partialRetries > 0 {
+		return p.retryPartialFailure(ctx, encoded, items, schema)
+	}
+	return err
+}
+
+// isEmptyBatch checks whether a json encoded batch contains no events, which is the case for an empty array or
+// a nil slice encoded as null.
+func isEmptyBatch(encoded []byte) bool {
+	trimmed := bytes.TrimSpace(encoded)
+	if bytes.Equal(trimmed, []byte("null")) {
+		return true
+	}
+	if len(trimmed) < 2 || trimmed[0] != '[' || trimmed[len(trimmed)-1] != ']' {
+		return false
+	}
+	return len(bytes.TrimSpace(trimmed[1:len(trimmed)-1])) == 0
+}
+
+// send posts a json encoded batch of events to Nakadi once. Depending on the backoff configuration failed
+// requests are retried.
+func (p *PublishAPI) send(ctx context.Context, encoded []byte, schema *cachedSchema) error {
 	const errMsg = "unable to request event types"
 
-	response, err := p.client.httpPOST(p.backOffConf.create(), p.publishURL, events, errMsg)
+	body, err := p.requestBody(encoded)
+	if err != nil {
+		return errors.Wrapf(err, "%s: unable to compress body", errMsg)
+	}
+
+	started := time.Now()
+	response, err := p.post(ctx, body, errMsg)
+	p.client.logSlowRequest(started, OperationPublish, "event_type", p.eventType)
+	p.client.collectRequest(started, RequestMetrics{Operation: OperationPublish, EventType: p.eventType}, response, err)
+	p.client.inspectResponse(OperationPublish, response)
 	if err != nil {
 		return err
 	}
@@ -141,6 +525,10 @@ func (p *PublishAPI) Publish(events interface{}) error {
 		if err != nil {
 			return errors.Wrapf(err, "%s: unable to decode response body", errMsg)
 		}
+		if schema != nil && batchItemError.failedValidation() {
+			// the events matched the cached schema, which is therefore likely outdated
+			p.schemaCache.invalidate()
+		}
 		return batchItemError
 	}
 
@@ -149,12 +537,366 @@ func (p *PublishAPI) Publish(events interface{}) error {
 		if err != nil {
 			return errors.Wrapf(err, "%s: unable to read response body", errMsg)
 		}
-		return decodeResponseToError(buffer, "unable to request event types")
+		return decodeResponseToError(response.StatusCode, buffer, "unable to request event types")
 	}
 
 	return nil
 }
 
+// requestBody returns the body of a publish request: batches of at least the compress threshold are compressed
+// with gzip, others are sent as they are.
+func (p *PublishAPI) requestBody(encoded []byte) (interface{}, error) {
+	if p.compressThreshold == 0 || len(encoded) < p.compressThreshold {
+		return encodedJSON(encoded), nil
+	}
+
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	if _, err := writer.Write(encoded); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return gzipJSON(buffer.Bytes()), nil
+}
+
+// retryPartialFailure publishes the events of a partially published batch again, which were rejected with a
+// retryable publishing status. It returns the aggregated status of all events of the batch if some events
+// remain unpublished.
+func (p *PublishAPI) retryPartialFailure(ctx context.Context, encoded []byte, items BatchItemsError, schema *cachedSchema) error {
+	var events []json.RawMessage
+	if err := json.Unmarshal(encoded, &events); err != nil || len(events) != len(items) {
+		// without a batch item for each event the rejected events can't be identified
+		return items
+	}
+
+	results := append(BatchItemsError(nil), items...)
+	retryBackOff := backoff.WithContext((&backOffConfiguration{
+		Retry:                true,
+		InitialRetryInterval: p.backOffConf.InitialRetryInterval,
+		MaxRetryInterval:     p.backOffConf.MaxRetryInterval}).create(), ctx)
+
+	for attempt := uint(0); attempt < p.partialRetries; attempt++ {
+		var retry []int
+		var payloads [][]byte
+		for i, item := range results {
+			if item.retryable() {
+				retry = append(retry, i)
+				payloads = append(payloads, events[i])
+			}
+		}
+		if len(retry) == 0 || attemptBudgetFrom(ctx).exhausted() {
+			break
+		}
+
+		timer := time.NewTimer(retryBackOff.NextBackOff())
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return results
+		}
+
+		joined, err := joinPayloads(payloads)
+		if err != nil {
+			return err
+		}
+		err = p.send(ctx, joined, schema)
+		switch batch := err.(type) {
+		case nil:
+			for _, i := range retry {
+				results[i] = BatchItemResponse{EID: results[i].EID, PublishingStatus: PublishingStatusSubmitted}
+			}
+		case BatchItemsError:
+			if len(batch) != len(retry) {
+				return results
+			}
+			for j, i := range retry {
+				results[i] = batch[j]
+			}
+		default:
+			return err
+		}
+	}
+
+	for _, item := range results {
+		if item.PublishingStatus != PublishingStatusSubmitted {
+			return results
+		}
+	}
+	return nil
+}
+
+// PublishWithHeaders emits a batch of events like PublishContext and sends the given headers along with the
+// request. This allows to use headers supported by Nakadi for which this package has no dedicated API. The
+// headers Authorization, Content-Type and Accept are set by the client and can not be overridden.
+func (p *PublishAPI) PublishWithHeaders(ctx context.Context, events interface{}, headers http.Header) error {
+	return p.PublishContext(withRequestHeaders(ctx, headers), events)
+}
+
+// PublishBatchWithHeaders emits a batch of events of the given event type and sends the given headers along
+// with the request. It uses a PublishAPI with default options, see PublishWithHeaders for details.
+func (c *Client) PublishBatchWithHeaders(eventType string, events []interface{}, headers http.Header) error {
+	return NewPublishAPI(c, eventType, nil).PublishWithHeaders(context.Background(), events, headers)
+}
+
+// post sends the events to Nakadi. If the PublishAPI blocks on throttling, requests answered with 429 or 503
+// are repeated until they are accepted or the context is done.
+func (p *PublishAPI) post(ctx context.Context, events interface{}, errMsg string) (*http.Response, error) {
+	var throttleBackOff backoff.BackOff
+	for {
+		response, err := p.client.httpPOST(ctx, p.backOffConf.create(), p.publishURL, events, errMsg)
+		if !p.blockOnThrottle || response == nil || !isThrottled(response.StatusCode) || attemptBudgetFrom(ctx).exhausted() {
+			return response, err
+		}
+		if err == nil {
+			// the body of responses with status >= 500 was already closed by httpPOST
+			response.Body.Close()
+		}
+
+		if throttleBackOff == nil {
+			throttleBackOff = (&backOffConfiguration{
+				Retry:                true,
+				InitialRetryInterval: p.backOffConf.InitialRetryInterval,
+				MaxRetryInterval:     p.backOffConf.MaxRetryInterval}).create()
+		}
+
+		timer := time.NewTimer(throttleBackOff.NextBackOff())
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, errors.Wrap(ctx.Err(), errMsg)
+		}
+	}
+}
+
+// isThrottled returns true for status codes Nakadi uses to signal that a client should slow down.
+func isThrottled(status int) bool {
+	return status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable
+}
+
+// getPartitionHint returns the partition hint used to validate events. If the hint is fetched from Nakadi
+// it is requested only once and cached afterwards. The lock is not held while fetching, so that a slow
+// request doesn't block concurrent publishers.
+func (p *PublishAPI) getPartitionHint() (*PartitionHint, error) {
+	p.hintMutex.Lock()
+	hint := p.partitionHint
+	p.hintMutex.Unlock()
+
+	if hint != nil || !p.fetchPartitionHint {
+		return hint, nil
+	}
+
+	eventType, err := p.eventAPI.Get(p.eventType)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to obtain partition strategy")
+	}
+
+	p.hintMutex.Lock()
+	defer p.hintMutex.Unlock()
+	if p.partitionHint == nil {
+		p.partitionHint = partitionHintFromEventType(eventType)
+	}
+	return p.partitionHint, nil
+}
+
+// getCategory returns the category of the event type. The category can't be changed once an event type is
+// created, so it is requested from Nakadi only once.
+func (p *PublishAPI) getCategory() (string, error) {
+	p.categoryMutex.Lock()
+	defer p.categoryMutex.Unlock()
+
+	if p.category != "" {
+		return p.category, nil
+	}
+
+	eventType, err := p.eventAPI.Get(p.eventType)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to obtain event type category")
+	}
+	p.category = eventType.Category
+
+	return p.category, nil
+}
+
+// getSchema returns the compiled schema used to validate events or nil if events are not validated. The
+// schema is requested from Nakadi if no schema is cached. An expired schema is returned while it is
+// refreshed in the background.
+func (p *PublishAPI) getSchema() (*cachedSchema, error) {
+	if p.schemaCache == nil {
+		return nil, nil
+	}
+	return p.schemaCache.get(p.fetchSchema)
+}
+
+// fetchSchema requests the event type from Nakadi and compiles its schema.
+func (p *PublishAPI) fetchSchema() (*cachedSchema, error) {
+	return fetchEventTypeSchema(p.eventAPI, p.eventType)
+}
+
+// fetchEventTypeSchema requests the event type with the given name from Nakadi and compiles its schema.
+func fetchEventTypeSchema(eventAPI *EventAPI, name string) (*cachedSchema, error) {
+	eventType, err := eventAPI.Get(name)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to obtain event type schema")
+	}
+	if eventType.Schema == nil {
+		return nil, errors.Errorf("unable to obtain event type schema: event type %s has no schema", name)
+	}
+	schema, err := compileJSONSchema(eventType.Schema.Schema)
+	if errors.Cause(err) == errUnsupportedSchema {
+		// events are still published, but only validated by Nakadi
+		if logger := eventAPI.client.logger; logger != nil {
+			logger.Printf("unsupported schema, events are not validated: event_type=%s error=%v", name, err)
+		}
+		schema = nil
+	} else if err != nil {
+		return nil, errors.Wrapf(err, "unable to compile schema of event type %s", name)
+	}
+	return &cachedSchema{
+		schema:    schema,
+		category:  eventType.Category,
+		version:   eventType.Schema.Version,
+		fetchedAt: time.Now()}, nil
+}
+
+// VerifyEventTypeSchema checks whether a sample event matches the current schema of the event type with the
+// given name, using the same validation as PublishOptions.ValidateSchema. This allows producers to detect at
+// startup that their events don't match the schema anymore. If the sample doesn't match, the returned error
+// lists all problems found and its cause is ErrSchemaViolation. Like for ValidateSchema, samples of event types
+// whose schema is not supported are not checked.
+func (c *Client) VerifyEventTypeSchema(name string, sampleEvent interface{}) error {
+	schema, err := fetchEventTypeSchema(NewEventAPI(c, nil), name)
+	if err != nil {
+		return err
+	}
+
+	if schema.schema == nil {
+		return nil
+	}
+
+	encoded, err := json.Marshal(sampleEvent)
+	if err != nil {
+		return errors.Wrap(err, "unable to encode sample event")
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(encoded, &decoded); err != nil {
+		return errors.Wrap(err, "unable to decode sample event")
+	}
+
+	if err := validateEvent(decoded, schema.schema, schema.category); err != nil {
+		return errors.Wrapf(err, "sample event does not match version %s of the schema of event type %s", schema.version, name)
+	}
+	return nil
+}
+
+// cachedSchema is a compiled schema of an event type along with its version and the time it was requested.
+// The compiled schema is nil if the schema uses constructs which are not supported by the compiler.
+type cachedSchema struct {
+	schema    *jsonSchema
+	category  string
+	version   string
+	fetchedAt time.Time
+}
+
+// schemaCache holds the schema of an event type for a limited time.
+type schemaCache struct {
+	sync.Mutex
+	ttl        time.Duration
+	current    *cachedSchema
+	refreshing bool
+	fetching   *schemaFetch
+}
+
+// schemaFetch is a synchronous fetch of a schema, whose result is shared by all callers waiting for it.
+type schemaFetch struct {
+	done   chan struct{}
+	schema *cachedSchema
+	err    error
+}
+
+// get returns the cached schema. Without cached schema fetch is called synchronously, concurrent callers
+// wait for the same fetch. If the schema is expired fetch is called in a separate goroutine and the expired
+// schema is returned meanwhile. Failed refreshes are retried on the next call. The lock is not held while
+// fetching, so that a slow request doesn't block publishing with a cached schema.
+func (c *schemaCache) get(fetch func() (*cachedSchema, error)) (*cachedSchema, error) {
+	c.Lock()
+	current := c.current
+	refresh := current != nil && !c.refreshing && time.Since(current.fetchedAt) > c.ttl
+	if refresh {
+		c.refreshing = true
+	}
+	fetching := c.fetching
+	first := current == nil && fetching == nil
+	if first {
+		fetching = &schemaFetch{done: make(chan struct{})}
+		c.fetching = fetching
+	}
+	c.Unlock()
+
+	if current == nil {
+		if first {
+			fetching.schema, fetching.err = fetch()
+
+			c.Lock()
+			c.fetching = nil
+			if fetching.err == nil {
+				c.current = fetching.schema
+			}
+			c.Unlock()
+			close(fetching.done)
+		}
+
+		<-fetching.done
+		if fetching.err != nil {
+			return nil, fetching.err
+		}
+		return fetching.schema, nil
+	}
+
+	if refresh {
+		go func() {
+			schema, err := fetch()
+
+			c.Lock()
+			defer c.Unlock()
+			c.refreshing = false
+			if err == nil && c.current != nil {
+				c.current = schema
+			}
+		}()
+	}
+	return current, nil
+}
+
+// invalidate drops the cached schema, so that it is requested again on the next call of get.
+func (c *schemaCache) invalidate() {
+	c.Lock()
+	defer c.Unlock()
+
+	c.current = nil
+}
+
+// Publishing status of single events in a partially published batch.
+const (
+	PublishingStatusSubmitted = "submitted"
+	PublishingStatusFailed    = "failed"
+	PublishingStatusAborted   = "aborted"
+)
+
+// Steps of the publishing process in Nakadi. The step of a batch item response tells at which step the
+// processing of an event stopped, e.g. whether it failed validation against the schema or could not be
+// written to storage.
+const (
+	PublishingStepNone         = "none"
+	PublishingStepValidating   = "validating"
+	PublishingStepPartitioning = "partitioning"
+	PublishingStepEnriching    = "enriching"
+	PublishingStepPublishing   = "publishing"
+)
+
 // BatchItemResponse if a batch is only published partially each batch item response contains information
 // about whether a singe event was successfully published or not.
 type BatchItemResponse struct {
@@ -176,6 +918,41 @@ func (err BatchItemsError) Error() string {
 	return "one or many events may have not been published"
 }
 
+// retryable returns true if the event was not published, but may be published when it is sent again.
+func (item BatchItemResponse) retryable() bool {
+	switch item.PublishingStatus {
+	case PublishingStatusAborted:
+		return true
+	case PublishingStatusFailed:
+		return item.Step != PublishingStepValidating
+	default:
+		return false
+	}
+}
+
+// failedValidation returns true if Nakadi rejected at least one event of the batch during validation.
+func (err BatchItemsError) failedValidation() bool {
+	for _, item := range err {
+		if item.Step == PublishingStepValidating {
+			return true
+		}
+	}
+	return false
+}
+
+// FailedAtStep returns the responses of all events which were not published and whose processing stopped at the
+// given step, along with the detail Nakadi reported for each of them. Failures at the step "validating" point to
+// events not matching the schema, while failures at "publishing" are usually caused by the storage of Nakadi.
+func FailedAtStep(items []BatchItemResponse, step string) []BatchItemResponse {
+	var failed []BatchItemResponse
+	for _, item := range items {
+		if item.PublishingStatus != PublishingStatusSubmitted && item.Step == step {
+			failed = append(failed, item)
+		}
+	}
+	return failed
+}
+
 // Format implements fmt.Formatter for BatchItemsError
 func (err BatchItemsError) Format(s fmt.State, verb rune) {
 	if err == nil {
@@ -210,3 +987,76 @@ func (err BatchItemsError) Format(s fmt.State, verb rune) {
 		io.WriteString(s, err.Error())
 	}
 }
+
+// PublishGroupError is returned by PublishGrouped if the events of at least one event type were not published.
+// It maps the names of these event types to the respective error.
+type PublishGroupError map[string]error
+
+// Error implements the error interface for PublishGroupError.
+func (err PublishGroupError) Error() string {
+	eventTypes := make([]string, 0, len(err))
+	for eventType := range err {
+		eventTypes = append(eventTypes, eventType)
+	}
+	sort.Strings(eventTypes)
+
+	messages := make([]string, len(eventTypes))
+	for i, eventType := range eventTypes {
+		messages[i] = fmt.Sprintf("%s: %s", eventType, err[eventType])
+	}
+	return fmt.Sprintf("unable to publish events of %d event types: %s", len(err), strings.Join(messages, "; "))
+}
+
+// PublishGrouped publishes the events of several event types. The events of each event type are emitted as a
+// single batch with a PublishAPI with default options. A failure to publish the events of one event type does
+// not prevent the others from being published. If events of an event type were rejected, the batch item
+// responses of the event type are contained in the returned map and the returned PublishGroupError contains
+// the errors of all event types which were not published completely.
+func (c *Client) PublishGrouped(events map[string][]interface{}) (map[string][]BatchItemResponse, error) {
+	responses := make(map[string][]BatchItemResponse)
+	failures := make(PublishGroupError)
+
+	for eventType, batch := range events {
+		err := NewPublishAPI(c, eventType, nil).Publish(batch)
+		if err == nil {
+			continue
+		}
+		if items, ok := err.(BatchItemsError); ok {
+			responses[eventType] = items
+		}
+		failures[eventType] = err
+	}
+
+	if len(failures) > 0 {
+		return responses, failures
+	}
+	return responses, nil
+}
+
+// PublishBatch emits a batch of events of the given event type with a PublishAPI with default options. If Nakadi
+// published the batch only partially or rejected it during validation, the batch item responses of all events
+// are returned along with the BatchItemsError, so that the events which were not published can be identified
+// by their eids and retried. If the batch was published completely the returned responses are nil.
+func (c *Client) PublishBatch(eventType string, events []interface{}) ([]BatchItemResponse, error) {
+	return c.PublishBatchContext(context.Background(), eventType, events)
+}
+
+// PublishBatchContext is like PublishBatch but uses the given context.
+func (c *Client) PublishBatchContext(ctx context.Context, eventType string, events []interface{}) ([]BatchItemResponse, error) {
+	err := NewPublishAPI(c, eventType, nil).PublishContext(ctx, events)
+	if items, ok := err.(BatchItemsError); ok {
+		return items, err
+	}
+	return nil, err
+}
+
+// Publish emits a single event of the given event type with a PublishAPI with default options, see PublishBatch
+// in order to publish several events at once.
+func (c *Client) Publish(eventType string, event interface{}) error {
+	return c.PublishContext(context.Background(), eventType, event)
+}
+
+// PublishContext is like Publish but uses the given context.
+func (c *Client) PublishContext(ctx context.Context, eventType string, event interface{}) error {
+	return NewPublishAPI(c, eventType, nil).PublishContext(ctx, []interface{}{event})
+}
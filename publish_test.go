@@ -1,15 +1,23 @@
 package nakadi
 
 import (
+	"compress/gzip"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 
 	"time"
 
 	"github.com/jarcoal/httpmock"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -50,108 +58,1231 @@ func TestDataChangeEvent_Marshal(t *testing.T) {
 	assert.JSONEq(t, string(expected), string(serialized))
 }
 
+func TestClient_NewEventMetadata(t *testing.T) {
+	t.Run("default generator", func(t *testing.T) {
+		before := time.Now()
+		metadata := New(defaultNakadiURL, nil).NewEventMetadata()
+
+		assert.Regexp(t, "^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$", metadata.EID)
+		assert.False(t, metadata.OccurredAt.Before(before.UTC().Add(-time.Millisecond)))
+		assert.Equal(t, time.UTC, metadata.OccurredAt.Location())
+	})
+
+	t.Run("custom generator", func(t *testing.T) {
+		client := New(defaultNakadiURL, &ClientOptions{EIDGenerator: func() string { return "01ARZ3NDEKTSV4RRFFQ69G5FAV" }})
+
+		assert.Equal(t, "01ARZ3NDEKTSV4RRFFQ69G5FAV", client.NewEventMetadata().EID)
+	})
+
+	t.Run("client without generator", func(t *testing.T) {
+		client := &Client{nakadiURL: defaultNakadiURL}
+
+		assert.NotEmpty(t, client.NewEventMetadata().EID)
+	})
+}
+
+func TestEventMetadata_MarshalOmitEmpty(t *testing.T) {
+	occurredAt := time.Date(2017, 8, 10, 22, 1, 45, 0, time.UTC)
+	metadata := EventMetadata{EID: "4c2e3632-7e06-11e7-bcf8-175536ff3841", OccurredAt: occurredAt}
+
+	serialized, err := json.Marshal(metadata)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"eid":"4c2e3632-7e06-11e7-bcf8-175536ff3841","occurred_at":"2017-08-10T22:01:45Z"}`, string(serialized))
+}
+
 func TestPublishAPI_Publish(t *testing.T) {
 	httpmock.Activate()
 	defer httpmock.DeactivateAndReset()
 
-	events := []SomeUndefinedEvent{}
-	helperLoadTestData(t, "events-undefined-create.json", &events)
-
+	events := []SomeUndefinedEvent{}
+	helperLoadTestData(t, "events-undefined-create.json", &events)
+
+	url := fmt.Sprintf("%s/event-types/%s/events", defaultNakadiURL, "test-event.undefined")
+
+	client := &Client{nakadiURL: defaultNakadiURL, httpClient: http.DefaultClient}
+	publishAPI := NewPublishAPI(client, "test-event.undefined", nil)
+
+	t.Run("fail to connect", func(t *testing.T) {
+		httpmock.RegisterResponder("POST", url, httpmock.NewErrorResponder(assert.AnError))
+
+		err := publishAPI.Publish(events)
+
+		require.Error(t, err)
+		assert.Regexp(t, assert.AnError, err)
+	})
+
+	t.Run("fail decode body", func(t *testing.T) {
+		httpmock.RegisterResponder("POST", url, httpmock.NewStringResponder(http.StatusMultiStatus, ""))
+
+		err := publishAPI.Publish(events)
+		require.Error(t, err)
+		assert.Regexp(t, "unable to decode response body", err)
+
+		httpmock.RegisterResponder("POST", url, httpmock.NewStringResponder(http.StatusUnauthorized, "most-likely-stacktrace"))
+
+		err = publishAPI.Publish(events)
+		require.Error(t, err)
+		assert.Regexp(t, "unable to request event types: most-likely-stacktrace", err)
+	})
+
+	t.Run("fail multi status", func(t *testing.T) {
+		batchItemResp := []BatchItemResponse{{Detail: "error one"}, {Detail: "error two"}}
+		responder, _ := httpmock.NewJsonResponder(http.StatusMultiStatus, batchItemResp)
+		httpmock.RegisterResponder("POST", url, responder)
+
+		err := publishAPI.Publish(events)
+		require.Error(t, err)
+
+		batchItemErr, ok := err.(BatchItemsError)
+		require.True(t, ok)
+		assert.Equal(t, "error one", batchItemErr[0].Detail)
+		assert.Equal(t, "error two", batchItemErr[1].Detail)
+	})
+
+	t.Run("fail unprocessable", func(t *testing.T) {
+		batchItemResp := []BatchItemResponse{{Detail: "error one"}, {Detail: "error two"}}
+		responder, _ := httpmock.NewJsonResponder(http.StatusUnprocessableEntity, batchItemResp)
+		httpmock.RegisterResponder("POST", url, responder)
+
+		err := publishAPI.Publish(events)
+
+		require.Error(t, err)
+		assert.Regexp(t, BatchItemsError{}, err)
+
+		batchItemErr, ok := err.(BatchItemsError)
+		require.True(t, ok)
+		assert.Equal(t, "error one", batchItemErr[0].Detail)
+		assert.Equal(t, "error two", batchItemErr[1].Detail)
+	})
+
+	t.Run("fail unauthorized", func(t *testing.T) {
+		problem := problemJSON{Detail: "not authorized"}
+		responder, _ := httpmock.NewJsonResponder(http.StatusUnauthorized, problem)
+		httpmock.RegisterResponder("POST", url, responder)
+
+		err := publishAPI.Publish(events)
+
+		require.Error(t, err)
+		assert.Regexp(t, "not authorized", err)
+	})
+
+	t.Run("fail to read body", func(t *testing.T) {
+		responder := httpmock.ResponderFromResponse(&http.Response{
+			Status:     strconv.Itoa(http.StatusBadRequest),
+			StatusCode: http.StatusBadRequest,
+			Body:       brokenBodyReader{},
+		})
+		httpmock.RegisterResponder("POST", url, responder)
+
+		err := publishAPI.Publish(events)
+		require.Error(t, err)
+		assert.Regexp(t, "unable to read response body", err)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		httpmock.RegisterResponder("POST", url, httpmock.Responder(func(r *http.Request) (*http.Response, error) {
+			uploaded := []SomeUndefinedEvent{}
+			err := json.NewDecoder(r.Body).Decode(&uploaded)
+			require.NoError(t, err)
+			assert.Equal(t, events, uploaded)
+			return httpmock.NewStringResponse(http.StatusOK, ""), nil
+		}))
+
+		err := publishAPI.Publish(events)
+
+		assert.NoError(t, err)
+	})
+}
+
+func TestPublishAPI_Timeout(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	url := fmt.Sprintf("%s/event-types/%s/events", defaultNakadiURL, "test-event.undefined")
+	client := &Client{nakadiURL: defaultNakadiURL, httpClient: &http.Client{Timeout: time.Hour}}
+
+	blockCh := make(chan struct{})
+	defer close(blockCh)
+	httpmock.RegisterResponder("POST", url, func(r *http.Request) (*http.Response, error) {
+		<-blockCh
+		return httpmock.NewStringResponse(http.StatusOK, ""), nil
+	})
+
+	publishAPI := NewPublishAPI(client, "test-event.undefined", &PublishOptions{Timeout: 10 * time.Millisecond})
+	assert.Equal(t, time.Hour, client.httpClient.Timeout)
+
+	err := publishAPI.Publish([]SomeUndefinedEvent{{Test: "event"}})
+	require.Error(t, err)
+	assert.Regexp(t, "deadline exceeded|Timeout", err)
+}
+
+func TestPublishAPI_PublishEmpty(t *testing.T) {
+	transport := httpmock.NewMockTransport()
+	client := &Client{nakadiURL: defaultNakadiURL, httpClient: &http.Client{Transport: transport}}
+	publishAPI := NewPublishAPI(client, "test-event.undefined", nil)
+
+	require.NoError(t, publishAPI.Publish([]SomeUndefinedEvent{}))
+	require.NoError(t, publishAPI.Publish([]SomeUndefinedEvent(nil)))
+	require.NoError(t, publishAPI.PublishRaw(context.Background(), nil))
+	assert.Equal(t, 0, transport.GetTotalCallCount())
+}
+
+func TestPublishAPI_PublishHeaders(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	url := fmt.Sprintf("%s/event-types/%s/events", defaultNakadiURL, "test-event.undefined")
+
+	t.Run("default headers", func(t *testing.T) {
+		client := New(defaultNakadiURL, nil)
+		client.httpClient = http.DefaultClient
+		httpmock.RegisterResponder("POST", url, func(r *http.Request) (*http.Response, error) {
+			assert.Equal(t, "application/json;charset=UTF-8", r.Header.Get("Content-Type"))
+			assert.Equal(t, "application/json", r.Header.Get("Accept"))
+			return httpmock.NewStringResponse(http.StatusOK, ""), nil
+		})
+
+		err := NewPublishAPI(client, "test-event.undefined", nil).Publish([]SomeUndefinedEvent{{Test: "event"}})
+		assert.NoError(t, err)
+	})
+
+	t.Run("custom headers", func(t *testing.T) {
+		client := New(defaultNakadiURL, &ClientOptions{ContentType: "application/vnd.test+json", Accept: "application/vnd.test+json"})
+		client.httpClient = http.DefaultClient
+		httpmock.RegisterResponder("POST", url, func(r *http.Request) (*http.Response, error) {
+			assert.Equal(t, "application/vnd.test+json", r.Header.Get("Content-Type"))
+			assert.Equal(t, "application/vnd.test+json", r.Header.Get("Accept"))
+			return httpmock.NewStringResponse(http.StatusOK, ""), nil
+		})
+
+		err := NewPublishAPI(client, "test-event.undefined", nil).Publish([]SomeUndefinedEvent{{Test: "event"}})
+		assert.NoError(t, err)
+	})
+}
+
+func TestPublishAPI_PublishWithHeaders(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	url := fmt.Sprintf("%s/event-types/%s/events", defaultNakadiURL, "test-event.undefined")
+	client := New(defaultNakadiURL, &ClientOptions{TokenProvider: func() (string, error) { return testToken, nil }})
+	client.httpClient = http.DefaultClient
+
+	headers := http.Header{}
+	headers.Set("X-Test-Hint", "skip")
+	headers.Add("X-Test-Multi", "a")
+	headers.Add("X-Test-Multi", "b")
+	headers.Set("Authorization", "Bearer other")
+	headers.Set("content-type", "text/plain")
+	headers.Set("Accept", "text/plain")
+
+	assertHeaders := func(t *testing.T, r *http.Request) {
+		assert.Equal(t, "skip", r.Header.Get("X-Test-Hint"))
+		assert.Equal(t, []string{"a", "b"}, r.Header["X-Test-Multi"])
+		assert.Equal(t, []string{"Bearer " + testToken}, r.Header["Authorization"])
+		assert.Equal(t, []string{defaultContentType}, r.Header["Content-Type"])
+		assert.Equal(t, []string{defaultAccept}, r.Header["Accept"])
+	}
+
+	t.Run("publish api", func(t *testing.T) {
+		httpmock.RegisterResponder("POST", url, func(r *http.Request) (*http.Response, error) {
+			assertHeaders(t, r)
+			return httpmock.NewStringResponse(http.StatusOK, ""), nil
+		})
+
+		publishAPI := NewPublishAPI(client, "test-event.undefined", nil)
+		err := publishAPI.PublishWithHeaders(context.Background(), []SomeUndefinedEvent{}, headers)
+		assert.NoError(t, err)
+	})
+
+	t.Run("client", func(t *testing.T) {
+		httpmock.RegisterResponder("POST", url, func(r *http.Request) (*http.Response, error) {
+			assertHeaders(t, r)
+			return httpmock.NewStringResponse(http.StatusOK, ""), nil
+		})
+
+		err := client.PublishBatchWithHeaders("test-event.undefined", []interface{}{}, headers)
+		assert.NoError(t, err)
+	})
+
+	t.Run("no headers on regular publish", func(t *testing.T) {
+		httpmock.RegisterResponder("POST", url, func(r *http.Request) (*http.Response, error) {
+			assert.Empty(t, r.Header.Get("X-Test-Hint"))
+			return httpmock.NewStringResponse(http.StatusOK, ""), nil
+		})
+
+		err := NewPublishAPI(client, "test-event.undefined", nil).Publish([]SomeUndefinedEvent{{Test: "event"}})
+		assert.NoError(t, err)
+	})
+}
+
+func TestPublishAPI_PublishPartitionHint(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	events := []DataChangeEvent{}
+	helperLoadTestData(t, "events-data-create.json", &events)
+
+	url := fmt.Sprintf("%s/event-types/%s/events", defaultNakadiURL, "test-event.change")
+	eventTypeURL := fmt.Sprintf("%s/event-types/%s", defaultNakadiURL, "test-event.change")
+	client := &Client{nakadiURL: defaultNakadiURL, httpClient: http.DefaultClient}
+
+	t.Run("fail missing partition key", func(t *testing.T) {
+		httpmock.Reset()
+		httpmock.RegisterResponder("POST", url, httpmock.NewStringResponder(http.StatusOK, ""))
+		publishAPI := NewPublishAPI(client, "test-event.change", &PublishOptions{
+			PartitionHint: &PartitionHint{Strategy: PartitionStrategyHash, KeyFields: []string{"id"}, Category: "data"}})
+
+		err := publishAPI.Publish(events)
+
+		require.Error(t, err)
+		assert.Equal(t, ErrMissingPartitionKey, errors.Cause(err))
+		assert.Equal(t, 0, httpmock.GetCallCountInfo()["POST "+url])
+	})
+
+	t.Run("success with partition hint", func(t *testing.T) {
+		httpmock.Reset()
+		httpmock.RegisterResponder("POST", url, httpmock.Responder(func(r *http.Request) (*http.Response, error) {
+			uploaded := []DataChangeEvent{}
+			err := json.NewDecoder(r.Body).Decode(&uploaded)
+			require.NoError(t, err)
+			assert.Len(t, uploaded, len(events))
+			return httpmock.NewStringResponse(http.StatusOK, ""), nil
+		}))
+		publishAPI := NewPublishAPI(client, "test-event.change", &PublishOptions{
+			PartitionHint: &PartitionHint{Strategy: PartitionStrategyHash, KeyFields: []string{"test"}, Category: "data"}})
+
+		err := publishAPI.Publish(events)
+
+		assert.NoError(t, err)
+	})
+
+	t.Run("success set partition keys", func(t *testing.T) {
+		httpmock.Reset()
+		httpmock.RegisterResponder("POST", url, httpmock.Responder(func(r *http.Request) (*http.Response, error) {
+			uploaded := []DataChangeEvent{}
+			err := json.NewDecoder(r.Body).Decode(&uploaded)
+			require.NoError(t, err)
+			require.Len(t, uploaded, len(events))
+			for i, event := range uploaded {
+				key := events[i].Data.(map[string]interface{})["test"]
+				assert.Equal(t, []string{key.(string)}, event.Metadata.PartitionKeys)
+			}
+			return httpmock.NewStringResponse(http.StatusOK, ""), nil
+		}))
+		publishAPI := NewPublishAPI(client, "test-event.change", &PublishOptions{
+			PartitionHint:    &PartitionHint{Strategy: PartitionStrategyHash, KeyFields: []string{"test"}, Category: "data"},
+			SetPartitionKeys: true})
+
+		err := publishAPI.Publish(events)
+
+		assert.NoError(t, err)
+		assert.Equal(t, 1, httpmock.GetCallCountInfo()["POST "+url])
+	})
+
+	t.Run("fail fetch partition hint", func(t *testing.T) {
+		httpmock.Reset()
+		httpmock.RegisterResponder("GET", eventTypeURL, httpmock.NewStringResponder(http.StatusNotFound, testProblemJSON))
+		publishAPI := NewPublishAPI(client, "test-event.change", &PublishOptions{FetchPartitionHint: true})
+
+		err := publishAPI.Publish(events)
+
+		require.Error(t, err)
+		assert.Regexp(t, "unable to obtain partition strategy", err)
+	})
+
+	t.Run("success fetch partition hint once", func(t *testing.T) {
+		httpmock.Reset()
+		responder, err := httpmock.NewJsonResponder(http.StatusOK, &EventType{
+			Name:               "test-event.change",
+			Category:           "data",
+			PartitionStrategy:  PartitionStrategyHash,
+			PartitionKeyFields: []string{"test"}})
+		require.NoError(t, err)
+		httpmock.RegisterResponder("GET", eventTypeURL, responder)
+		httpmock.RegisterResponder("POST", url, httpmock.NewStringResponder(http.StatusOK, ""))
+		publishAPI := NewPublishAPI(client, "test-event.change", &PublishOptions{FetchPartitionHint: true})
+
+		require.NoError(t, publishAPI.Publish(events))
+		require.NoError(t, publishAPI.Publish(events))
+
+		assert.Equal(t, 1, httpmock.GetCallCountInfo()["GET "+eventTypeURL])
+		assert.Equal(t, 2, httpmock.GetCallCountInfo()["POST "+url])
+	})
+}
+
+func TestPublishAPI_PublishContext(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	events := []SomeUndefinedEvent{}
+	helperLoadTestData(t, "events-undefined-create.json", &events)
+
+	url := fmt.Sprintf("%s/event-types/%s/events", defaultNakadiURL, "test-event.undefined")
+	client := &Client{nakadiURL: defaultNakadiURL, httpClient: http.DefaultClient}
+
+	t.Run("fail canceled context", func(t *testing.T) {
+		httpmock.RegisterResponder("POST", url, helperCanceledResponder())
+		publishAPI := NewPublishAPI(client, "test-event.undefined", nil)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		err := publishAPI.PublishContext(ctx, events)
+
+		require.Error(t, err)
+		assert.Regexp(t, context.Canceled, err)
+	})
+
+	t.Run("fail canceled while waiting for slot", func(t *testing.T) {
+		publishAPI := NewPublishAPI(client, "test-event.undefined", &PublishOptions{MaxConcurrentPublishes: 1})
+		publishAPI.semaphore <- struct{}{}
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		err := publishAPI.PublishContext(ctx, events)
+
+		require.Error(t, err)
+		assert.Regexp(t, context.DeadlineExceeded, err)
+	})
+
+	t.Run("success limit concurrent publishes", func(t *testing.T) {
+		var mutex sync.Mutex
+		inFlight, maxInFlight := 0, 0
+		httpmock.RegisterResponder("POST", url, func(r *http.Request) (*http.Response, error) {
+			mutex.Lock()
+			inFlight++
+			if inFlight > maxInFlight {
+				maxInFlight = inFlight
+			}
+			mutex.Unlock()
+
+			time.Sleep(5 * time.Millisecond)
+
+			mutex.Lock()
+			inFlight--
+			mutex.Unlock()
+			return httpmock.NewStringResponse(http.StatusOK, ""), nil
+		})
+		publishAPI := NewPublishAPI(client, "test-event.undefined", &PublishOptions{MaxConcurrentPublishes: 2})
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				assert.NoError(t, publishAPI.PublishContext(context.Background(), events))
+			}()
+		}
+		wg.Wait()
+
+		assert.True(t, maxInFlight <= 2)
+		assert.Len(t, publishAPI.semaphore, 0)
+	})
+
+	t.Run("success add span context", func(t *testing.T) {
+		spanCtx := map[string]string{SpanContextTraceParent: "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"}
+		withSpan := make([]SomeUndefinedEvent, len(events))
+		copy(withSpan, events)
+		withSpan[1].Metadata.SpanCtx = map[string]string{SpanContextTraceParent: "existing"}
+
+		eventTypeResponder, err := httpmock.NewJsonResponder(http.StatusOK, &EventType{Name: "test-event.undefined", Category: "business"})
+		require.NoError(t, err)
+		httpmock.RegisterResponder("GET", fmt.Sprintf("%s/event-types/%s", defaultNakadiURL, "test-event.undefined"), eventTypeResponder)
+		httpmock.RegisterResponder("POST", url, func(r *http.Request) (*http.Response, error) {
+			uploaded := []SomeUndefinedEvent{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&uploaded))
+			require.Len(t, uploaded, 2)
+			assert.Equal(t, spanCtx, uploaded[0].Metadata.SpanCtx)
+			assert.Equal(t, "existing", uploaded[1].Metadata.SpanCtx[SpanContextTraceParent])
+			assert.Equal(t, events[0].Test, uploaded[0].Test)
+			return httpmock.NewStringResponse(http.StatusOK, ""), nil
+		})
+		publishAPI := NewPublishAPI(client, "test-event.undefined", nil)
+
+		err = publishAPI.PublishContext(WithSpanContext(context.Background(), spanCtx), withSpan)
+		require.NoError(t, err)
+	})
+
+	t.Run("success span context not added to undefined events", func(t *testing.T) {
+		spanCtx := map[string]string{SpanContextTraceParent: "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"}
+
+		eventTypeResponder, err := httpmock.NewJsonResponder(http.StatusOK, &EventType{Name: "test-event.undefined", Category: "undefined"})
+		require.NoError(t, err)
+		httpmock.RegisterResponder("GET", fmt.Sprintf("%s/event-types/%s", defaultNakadiURL, "test-event.undefined"), eventTypeResponder)
+		httpmock.RegisterResponder("POST", url, func(r *http.Request) (*http.Response, error) {
+			uploaded := []SomeUndefinedEvent{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&uploaded))
+			require.Len(t, uploaded, 2)
+			assert.Nil(t, uploaded[0].Metadata.SpanCtx)
+			assert.Nil(t, uploaded[1].Metadata.SpanCtx)
+			return httpmock.NewStringResponse(http.StatusOK, ""), nil
+		})
+		publishAPI := NewPublishAPI(client, "test-event.undefined", nil)
+
+		err = publishAPI.PublishContext(WithSpanContext(context.Background(), spanCtx), events)
+		require.NoError(t, err)
+	})
+}
+
+func TestPublishAPI_MaxElapsedTime(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	events := []SomeUndefinedEvent{}
+	helperLoadTestData(t, "events-undefined-create.json", &events)
+
+	url := fmt.Sprintf("%s/event-types/%s/events", defaultNakadiURL, "test-event.undefined")
+	client := &Client{nakadiURL: defaultNakadiURL, httpClient: http.DefaultClient}
+
+	var calls int32
+	httpmock.RegisterResponder("POST", url, func(r *http.Request) (*http.Response, error) {
+		atomic.AddInt32(&calls, 1)
+		return httpmock.NewStringResponse(http.StatusInternalServerError, testProblemJSON), nil
+	})
+	publishAPI := NewPublishAPI(client, "test-event.undefined", &PublishOptions{
+		Retry:                true,
+		InitialRetryInterval: 10 * time.Millisecond,
+		MaxRetryInterval:     10 * time.Millisecond,
+		MaxElapsedTime:       50 * time.Millisecond})
+
+	start := time.Now()
+	err := publishAPI.Publish(events)
+	elapsed := time.Since(start)
+
+	require.Error(t, err)
+	assert.Regexp(t, "some problem detail", err)
+	assert.True(t, atomic.LoadInt32(&calls) > 1, "publishing was not retried")
+	assert.True(t, elapsed < 500*time.Millisecond, "retries took %s", elapsed)
+}
+
+func TestPublishAPI_BlockOnThrottle(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	events := []SomeUndefinedEvent{}
+	helperLoadTestData(t, "events-undefined-create.json", &events)
+
+	url := fmt.Sprintf("%s/event-types/%s/events", defaultNakadiURL, "test-event.undefined")
+	client := &Client{nakadiURL: defaultNakadiURL, httpClient: http.DefaultClient}
+	options := &PublishOptions{
+		BlockOnThrottle:      true,
+		InitialRetryInterval: time.Millisecond,
+		MaxRetryInterval:     5 * time.Millisecond,
+		MaxElapsedTime:       time.Millisecond}
+
+	t.Run("fail without blocking", func(t *testing.T) {
+		httpmock.RegisterResponder("POST", url, httpmock.NewStringResponder(http.StatusTooManyRequests, testProblemJSON))
+		publishAPI := NewPublishAPI(client, "test-event.undefined", nil)
+
+		err := publishAPI.Publish(events)
+		require.Error(t, err)
+		assert.Regexp(t, "some problem detail", err)
+	})
+
+	t.Run("fail context done while throttled", func(t *testing.T) {
+		httpmock.RegisterResponder("POST", url, httpmock.NewStringResponder(http.StatusServiceUnavailable, testProblemJSON))
+		publishAPI := NewPublishAPI(client, "test-event.undefined", options)
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		err := publishAPI.PublishContext(ctx, events)
+		require.Error(t, err)
+		assert.Regexp(t, context.DeadlineExceeded, err)
+	})
+
+	t.Run("success after throttling", func(t *testing.T) {
+		var calls int32
+		httpmock.RegisterResponder("POST", url, func(r *http.Request) (*http.Response, error) {
+			switch atomic.AddInt32(&calls, 1) {
+			case 1:
+				return httpmock.NewStringResponse(http.StatusTooManyRequests, testProblemJSON), nil
+			case 2:
+				return httpmock.NewStringResponse(http.StatusServiceUnavailable, testProblemJSON), nil
+			default:
+				return httpmock.NewStringResponse(http.StatusOK, ""), nil
+			}
+		})
+		publishAPI := NewPublishAPI(client, "test-event.undefined", options)
+
+		err := publishAPI.Publish(events)
+		require.NoError(t, err)
+		assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+	})
+}
+
+func TestPublishAPI_MaxTotalAttempts(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	events := []SomeUndefinedEvent{}
+	helperLoadTestData(t, "events-undefined-create.json", &events)
+
+	url := fmt.Sprintf("%s/event-types/%s/events", defaultNakadiURL, "test-event.undefined")
+	client := &Client{nakadiURL: defaultNakadiURL, httpClient: http.DefaultClient, maxAttempts: 3}
+	options := &PublishOptions{
+		Retry:                true,
+		BlockOnThrottle:      true,
+		InitialRetryInterval: time.Millisecond,
+		MaxRetryInterval:     time.Millisecond,
+		MaxElapsedTime:       time.Minute}
+
+	countingResponder := func(calls *int32, status int) httpmock.Responder {
+		return func(r *http.Request) (*http.Response, error) {
+			atomic.AddInt32(calls, 1)
+			return httpmock.NewStringResponse(status, testProblemJSON), nil
+		}
+	}
+
+	t.Run("fail retries exhausted", func(t *testing.T) {
+		var calls int32
+		httpmock.RegisterResponder("POST", url, countingResponder(&calls, http.StatusInternalServerError))
+		publishAPI := NewPublishAPI(client, "test-event.undefined", options)
+
+		err := publishAPI.Publish(events)
+		require.Error(t, err)
+		assert.Regexp(t, "some problem detail", err)
+		assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+	})
+
+	t.Run("fail throttled", func(t *testing.T) {
+		var calls int32
+		httpmock.RegisterResponder("POST", url, countingResponder(&calls, http.StatusTooManyRequests))
+		publishAPI := NewPublishAPI(client, "test-event.undefined", options)
+
+		err := publishAPI.Publish(events)
+		require.Error(t, err)
+		assert.Regexp(t, "some problem detail", err)
+		assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+	})
+
+	t.Run("success budget per publish", func(t *testing.T) {
+		var calls int32
+		httpmock.RegisterResponder("POST", url, countingResponder(&calls, http.StatusOK))
+		publishAPI := NewPublishAPI(client, "test-event.undefined", options)
+
+		for i := 0; i < 4; i++ {
+			require.NoError(t, publishAPI.Publish(events))
+		}
+		assert.Equal(t, int32(4), atomic.LoadInt32(&calls))
+	})
+}
+
+func TestPublishAPI_CompressRequests(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	url := fmt.Sprintf("%s/event-types/%s/events", defaultNakadiURL, "test-event.undefined")
+	client := &Client{nakadiURL: defaultNakadiURL, httpClient: http.DefaultClient}
+	publishAPI := NewPublishAPI(client, "test-event.undefined", &PublishOptions{CompressRequests: true, CompressThreshold: 100})
+
+	var encoding string
+	var received []SomeUndefinedEvent
+	httpmock.RegisterResponder("POST", url, func(r *http.Request) (*http.Response, error) {
+		encoding = r.Header.Get("Content-Encoding")
+		body := io.Reader(r.Body)
+		if encoding == "gzip" {
+			reader, err := gzip.NewReader(r.Body)
+			require.NoError(t, err)
+			body = reader
+		}
+		received = nil
+		require.NoError(t, json.NewDecoder(body).Decode(&received))
+		return httpmock.NewStringResponse(http.StatusOK, ""), nil
+	})
+
+	t.Run("success small batch uncompressed", func(t *testing.T) {
+		events := []SomeUndefinedEvent{{Test: "small"}}
+		require.NoError(t, publishAPI.Publish(events))
+		assert.Empty(t, encoding)
+		assert.Equal(t, events, received)
+	})
+
+	t.Run("success large batch compressed", func(t *testing.T) {
+		events := []SomeUndefinedEvent{{Test: strings.Repeat("large", 50)}}
+		require.NoError(t, publishAPI.Publish(events))
+		assert.Equal(t, "gzip", encoding)
+		assert.Equal(t, events, received)
+	})
+
+	t.Run("success compression disabled", func(t *testing.T) {
+		events := []SomeUndefinedEvent{{Test: strings.Repeat("large", 50)}}
+		require.NoError(t, NewPublishAPI(client, "test-event.undefined", nil).Publish(events))
+		assert.Empty(t, encoding)
+		assert.Equal(t, events, received)
+	})
+}
+
+func TestPublishAPI_RetryIf(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	events := []SomeUndefinedEvent{}
+	helperLoadTestData(t, "events-undefined-create.json", &events)
+
+	url := fmt.Sprintf("%s/event-types/%s/events", defaultNakadiURL, "test-event.undefined")
+	client := &Client{nakadiURL: defaultNakadiURL, httpClient: http.DefaultClient}
+	options := func(retryIf func(*http.Response, error) bool) *PublishOptions {
+		return &PublishOptions{
+			Retry:                true,
+			InitialRetryInterval: time.Millisecond,
+			MaxRetryInterval:     time.Millisecond,
+			MaxElapsedTime:       50 * time.Millisecond,
+			RetryIf:              retryIf}
+	}
+	transientProblem := func(response *http.Response, err error) bool {
+		if response == nil {
+			return true
+		}
+		body, _ := ioutil.ReadAll(response.Body)
+		return response.StatusCode == http.StatusUnprocessableEntity && strings.Contains(string(body), "transient")
+	}
+
+	t.Run("success retry custom status", func(t *testing.T) {
+		var calls int32
+		httpmock.RegisterResponder("POST", url, func(r *http.Request) (*http.Response, error) {
+			if atomic.AddInt32(&calls, 1) == 1 {
+				return httpmock.NewStringResponse(http.StatusUnprocessableEntity, `{"detail":"transient"}`), nil
+			}
+			return httpmock.NewStringResponse(http.StatusOK, ""), nil
+		})
+		publishAPI := NewPublishAPI(client, "test-event.undefined", options(transientProblem))
+
+		err := publishAPI.Publish(events)
+		require.NoError(t, err)
+		assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	})
+
+	t.Run("fail not retried batch items error", func(t *testing.T) {
+		var calls int32
+		batchItemsErr := `[{"publishing_status":"failed","step":"validating","detail":"invalid"}]`
+		httpmock.RegisterResponder("POST", url, func(r *http.Request) (*http.Response, error) {
+			atomic.AddInt32(&calls, 1)
+			return httpmock.NewStringResponse(http.StatusUnprocessableEntity, batchItemsErr), nil
+		})
+		publishAPI := NewPublishAPI(client, "test-event.undefined", options(transientProblem))
+
+		err := publishAPI.Publish(events)
+		require.Error(t, err)
+		assert.IsType(t, BatchItemsError{}, err)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	})
+
+	t.Run("fail server error not retried", func(t *testing.T) {
+		var calls int32
+		httpmock.RegisterResponder("POST", url, func(r *http.Request) (*http.Response, error) {
+			atomic.AddInt32(&calls, 1)
+			return httpmock.NewStringResponse(http.StatusInternalServerError, testProblemJSON), nil
+		})
+		publishAPI := NewPublishAPI(client, "test-event.undefined", options(transientProblem))
+
+		err := publishAPI.Publish(events)
+		require.Error(t, err)
+		assert.Regexp(t, "some problem detail", err)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	})
+
+	t.Run("fail connection error not retried", func(t *testing.T) {
+		var calls int32
+		httpmock.RegisterResponder("POST", url, func(r *http.Request) (*http.Response, error) {
+			atomic.AddInt32(&calls, 1)
+			return nil, assert.AnError
+		})
+		publishAPI := NewPublishAPI(client, "test-event.undefined", options(func(*http.Response, error) bool { return false }))
+
+		err := publishAPI.Publish(events)
+		require.Error(t, err)
+		assert.Regexp(t, assert.AnError.Error(), err)
+		assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	})
+}
+
+// lazyEIDEvent creates a new eid whenever it is encoded.
+type lazyEIDEvent struct{}
+
+func (lazyEIDEvent) MarshalJSON() ([]byte, error) {
+	return json.Marshal(UndefinedEvent{Metadata: EventMetadata{EID: newUUID()}})
+}
+
+func TestPublishAPI_StableEIDs(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	url := fmt.Sprintf("%s/event-types/%s/events", defaultNakadiURL, "test-event.undefined")
+	client := &Client{nakadiURL: defaultNakadiURL, httpClient: http.DefaultClient}
+	events := []lazyEIDEvent{{}, {}}
+
+	recordEIDs := func(statuses ...int) (httpmock.Responder, *[][]string) {
+		var mutex sync.Mutex
+		var attempts [][]string
+		return func(r *http.Request) (*http.Response, error) {
+			var published []UndefinedEvent
+			err := json.NewDecoder(r.Body).Decode(&published)
+			require.NoError(t, err)
+
+			mutex.Lock()
+			defer mutex.Unlock()
+			var eids []string
+			for _, event := range published {
+				eids = append(eids, event.Metadata.EID)
+			}
+			attempts = append(attempts, eids)
+			if len(attempts) <= len(statuses) {
+				return httpmock.NewStringResponse(statuses[len(attempts)-1], testProblemJSON), nil
+			}
+			return httpmock.NewStringResponse(http.StatusOK, ""), nil
+		}, &attempts
+	}
+
+	assertStable := func(t *testing.T, attempts [][]string, expected int) {
+		require.Len(t, attempts, expected)
+		require.Len(t, attempts[0], 2)
+		assert.NotEqual(t, attempts[0][0], attempts[0][1])
+		for _, eids := range attempts[1:] {
+			assert.Equal(t, attempts[0], eids)
+		}
+	}
+
+	t.Run("retry after server errors", func(t *testing.T) {
+		responder, attempts := recordEIDs(http.StatusInternalServerError, http.StatusBadGateway)
+		httpmock.RegisterResponder("POST", url, responder)
+		publishAPI := NewPublishAPI(client, "test-event.undefined", &PublishOptions{
+			Retry:                true,
+			InitialRetryInterval: time.Millisecond,
+			MaxRetryInterval:     time.Millisecond,
+			MaxElapsedTime:       time.Second})
+
+		require.NoError(t, publishAPI.Publish(events))
+		assertStable(t, *attempts, 3)
+	})
+
+	t.Run("retry while throttled", func(t *testing.T) {
+		responder, attempts := recordEIDs(http.StatusTooManyRequests, http.StatusServiceUnavailable)
+		httpmock.RegisterResponder("POST", url, responder)
+		publishAPI := NewPublishAPI(client, "test-event.undefined", &PublishOptions{
+			BlockOnThrottle:      true,
+			InitialRetryInterval: time.Millisecond,
+			MaxRetryInterval:     time.Millisecond})
+
+		require.NoError(t, publishAPI.Publish(events))
+		assertStable(t, *attempts, 3)
+	})
+}
+
+func TestPublishAPI_ValidateSchema(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
 	url := fmt.Sprintf("%s/event-types/%s/events", defaultNakadiURL, "test-event.undefined")
-
+	eventTypeURL := fmt.Sprintf("%s/event-types/%s", defaultNakadiURL, "test-event.undefined")
 	client := &Client{nakadiURL: defaultNakadiURL, httpClient: http.DefaultClient}
-	publishAPI := NewPublishAPI(client, "test-event.undefined", nil)
 
-	t.Run("fail to connect", func(t *testing.T) {
-		httpmock.RegisterResponder("POST", url, httpmock.NewErrorResponder(assert.AnError))
+	valid := []SomeUndefinedEvent{{Test: "valid"}}
+	invalid := []SomeUndefinedEvent{{Test: ""}}
+	schema := `{"type":"object","properties":{"test":{"type":"string","minLength":1}},"required":["test"]}`
+	var fetched int32
+	eventTypeResponder := func(t *testing.T) httpmock.Responder {
+		atomic.StoreInt32(&fetched, 0)
+		responder, err := httpmock.NewJsonResponder(http.StatusOK, &EventType{
+			Name:     "test-event.undefined",
+			Category: "undefined",
+			Schema:   &EventTypeSchema{Type: "json_schema", Schema: schema}})
+		require.NoError(t, err)
+		return func(r *http.Request) (*http.Response, error) {
+			atomic.AddInt32(&fetched, 1)
+			return responder(r)
+		}
+	}
 
-		err := publishAPI.Publish(events)
+	t.Run("fail invalid event", func(t *testing.T) {
+		httpmock.Reset()
+		httpmock.RegisterResponder("GET", eventTypeURL, eventTypeResponder(t))
+		httpmock.RegisterResponder("POST", url, httpmock.NewStringResponder(http.StatusOK, ""))
+		publishAPI := NewPublishAPI(client, "test-event.undefined", &PublishOptions{ValidateSchema: true})
+
+		err := publishAPI.Publish(invalid)
 
 		require.Error(t, err)
-		assert.Regexp(t, assert.AnError, err)
+		assert.Equal(t, ErrSchemaViolation, errors.Cause(err))
+		assert.Regexp(t, "event 0: /test: expected at least 1 characters but got 0", err)
+		assert.Equal(t, 0, httpmock.GetCallCountInfo()["POST "+url])
 	})
 
-	t.Run("fail decode body", func(t *testing.T) {
-		httpmock.RegisterResponder("POST", url, httpmock.NewStringResponder(http.StatusMultiStatus, ""))
+	t.Run("fail fetch schema", func(t *testing.T) {
+		httpmock.Reset()
+		httpmock.RegisterResponder("GET", eventTypeURL, httpmock.NewStringResponder(http.StatusNotFound, testProblemJSON))
+		publishAPI := NewPublishAPI(client, "test-event.undefined", &PublishOptions{ValidateSchema: true})
+
+		err := publishAPI.Publish(valid)
 
-		err := publishAPI.Publish(events)
 		require.Error(t, err)
-		assert.Regexp(t, "unable to decode response body", err)
+		assert.Regexp(t, "unable to obtain event type schema", err)
+	})
 
-		httpmock.RegisterResponder("POST", url, httpmock.NewStringResponder(http.StatusUnauthorized, "most-likely-stacktrace"))
+	t.Run("success schema cached", func(t *testing.T) {
+		httpmock.Reset()
+		httpmock.RegisterResponder("GET", eventTypeURL, eventTypeResponder(t))
+		httpmock.RegisterResponder("POST", url, httpmock.NewStringResponder(http.StatusOK, ""))
+		publishAPI := NewPublishAPI(client, "test-event.undefined", &PublishOptions{ValidateSchema: true})
 
-		err = publishAPI.Publish(events)
-		require.Error(t, err)
-		assert.Regexp(t, "unable to request event types: most-likely-stacktrace", err)
+		require.NoError(t, publishAPI.Publish(valid))
+		require.NoError(t, publishAPI.Publish(valid))
+
+		assert.Equal(t, int32(1), atomic.LoadInt32(&fetched))
+		assert.Equal(t, 2, httpmock.GetCallCountInfo()["POST "+url])
 	})
 
-	t.Run("fail multi status", func(t *testing.T) {
-		batchItemResp := []BatchItemResponse{{Detail: "error one"}, {Detail: "error two"}}
-		responder, _ := httpmock.NewJsonResponder(http.StatusMultiStatus, batchItemResp)
-		httpmock.RegisterResponder("POST", url, responder)
+	t.Run("success refresh expired schema", func(t *testing.T) {
+		httpmock.Reset()
+		httpmock.RegisterResponder("GET", eventTypeURL, eventTypeResponder(t))
+		httpmock.RegisterResponder("POST", url, httpmock.NewStringResponder(http.StatusOK, ""))
+		publishAPI := NewPublishAPI(client, "test-event.undefined", &PublishOptions{
+			ValidateSchema: true,
+			SchemaCacheTTL: time.Millisecond})
 
-		err := publishAPI.Publish(events)
+		require.NoError(t, publishAPI.Publish(valid))
+		time.Sleep(5 * time.Millisecond)
+		require.NoError(t, publishAPI.Publish(valid))
+
+		assert.Eventually(t, func() bool {
+			return atomic.LoadInt32(&fetched) == 2
+		}, time.Second, 5*time.Millisecond)
+	})
+
+	t.Run("success unsupported schema", func(t *testing.T) {
+		httpmock.Reset()
+		responder, err := httpmock.NewJsonResponder(http.StatusOK, &EventType{
+			Name:     "test-event.undefined",
+			Category: "undefined",
+			Schema:   &EventTypeSchema{Type: "json_schema", Schema: `{"properties":{"test":{"pattern":"^(?!invalid)"}}}`}})
+		require.NoError(t, err)
+		httpmock.RegisterResponder("GET", eventTypeURL, responder)
+		httpmock.RegisterResponder("POST", url, httpmock.NewStringResponder(http.StatusOK, ""))
+		logger := &recordingLogger{}
+		client := &Client{nakadiURL: defaultNakadiURL, httpClient: http.DefaultClient, logger: logger}
+		publishAPI := NewPublishAPI(client, "test-event.undefined", &PublishOptions{ValidateSchema: true})
+
+		require.NoError(t, publishAPI.Publish(invalid))
+
+		assert.Equal(t, 1, httpmock.GetCallCountInfo()["POST "+url])
+		require.Len(t, logger.Messages(), 1)
+		assert.Regexp(t, `unsupported schema, events are not validated: event_type=test-event.undefined error=schema at #/properties/test: pattern "\^\(\?!invalid\)"`, logger.Messages()[0])
+	})
+
+	t.Run("success invalidate on validation failure", func(t *testing.T) {
+		httpmock.Reset()
+		httpmock.RegisterResponder("GET", eventTypeURL, eventTypeResponder(t))
+		httpmock.RegisterResponder("POST", url, httpmock.NewStringResponder(http.StatusUnprocessableEntity,
+			`[{"eid":"1","publishing_status":"aborted","step":"validating","detail":"schema changed"}]`))
+		publishAPI := NewPublishAPI(client, "test-event.undefined", &PublishOptions{ValidateSchema: true})
+
+		err := publishAPI.Publish(valid)
+		require.Error(t, err)
+		assert.IsType(t, BatchItemsError{}, err)
+		err = publishAPI.Publish(valid)
 		require.Error(t, err)
 
-		batchItemErr, ok := err.(BatchItemsError)
-		require.True(t, ok)
-		assert.Equal(t, "error one", batchItemErr[0].Detail)
-		assert.Equal(t, "error two", batchItemErr[1].Detail)
+		assert.Equal(t, int32(2), atomic.LoadInt32(&fetched))
 	})
+}
 
-	t.Run("fail unprocessable", func(t *testing.T) {
-		batchItemResp := []BatchItemResponse{{Detail: "error one"}, {Detail: "error two"}}
-		responder, _ := httpmock.NewJsonResponder(http.StatusUnprocessableEntity, batchItemResp)
-		httpmock.RegisterResponder("POST", url, responder)
+func TestSchemaCache_ConcurrentFetch(t *testing.T) {
+	cache := &schemaCache{ttl: time.Hour}
+	release := make(chan struct{})
+	var fetched int32
+	fetch := func() (*cachedSchema, error) {
+		atomic.AddInt32(&fetched, 1)
+		<-release
+		return &cachedSchema{version: "1.0.0", fetchedAt: time.Now()}, nil
+	}
 
-		err := publishAPI.Publish(events)
+	results := make(chan *cachedSchema, 5)
+	for i := 0; i < 5; i++ {
+		go func() {
+			schema, err := cache.get(fetch)
+			assert.NoError(t, err)
+			results <- schema
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+
+	for i := 0; i < 5; i++ {
+		schema := <-results
+		require.NotNil(t, schema)
+		assert.Equal(t, "1.0.0", schema.version)
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&fetched))
+}
+
+func TestPublishAPI_SetSchemaVersion(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	url := fmt.Sprintf("%s/event-types/%s/events", defaultNakadiURL, "test-event.data")
+	eventTypeURL := fmt.Sprintf("%s/event-types/%s", defaultNakadiURL, "test-event.data")
+	client := &Client{nakadiURL: defaultNakadiURL, httpClient: http.DefaultClient}
+
+	// the schema is not used for validation unless ValidateSchema is set
+	eventTypeResponder, err := httpmock.NewJsonResponder(http.StatusOK, &EventType{
+		Name:     "test-event.data",
+		Category: "data",
+		Schema:   &EventTypeSchema{Version: "1.2.0", Type: "json_schema", Schema: `{"required":["missing"]}`}})
+	require.NoError(t, err)
+	event := func(version string) DataChangeEvent {
+		return DataChangeEvent{Metadata: EventMetadata{EID: "eid", Version: version}, Data: map[string]string{}, DataOP: "C", DataType: "test"}
+	}
+
+	t.Run("fail newer version", func(t *testing.T) {
+		httpmock.Reset()
+		httpmock.RegisterResponder("GET", eventTypeURL, eventTypeResponder)
+		httpmock.RegisterResponder("POST", url, httpmock.NewStringResponder(http.StatusOK, ""))
+		publishAPI := NewPublishAPI(client, "test-event.data", &PublishOptions{SetSchemaVersion: true})
+
+		err := publishAPI.Publish([]DataChangeEvent{event(""), event("1.10.0")})
 
 		require.Error(t, err)
-		assert.Regexp(t, BatchItemsError{}, err)
+		assert.Regexp(t, "event 1 has version 1.10.0, but the latest schema version is 1.2.0", err)
+		assert.Equal(t, 0, httpmock.GetCallCountInfo()["POST "+url])
+	})
 
-		batchItemErr, ok := err.(BatchItemsError)
-		require.True(t, ok)
-		assert.Equal(t, "error one", batchItemErr[0].Detail)
-		assert.Equal(t, "error two", batchItemErr[1].Detail)
+	t.Run("success set missing versions", func(t *testing.T) {
+		httpmock.Reset()
+		httpmock.RegisterResponder("GET", eventTypeURL, eventTypeResponder)
+		httpmock.RegisterResponder("POST", url, func(r *http.Request) (*http.Response, error) {
+			var published []DataChangeEvent
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&published))
+			require.Len(t, published, 3)
+			assert.Equal(t, "1.2.0", published[0].Metadata.Version)
+			assert.Equal(t, "1.1.0", published[1].Metadata.Version)
+			assert.Equal(t, "custom", published[2].Metadata.Version)
+			return httpmock.NewStringResponse(http.StatusOK, ""), nil
+		})
+		publishAPI := NewPublishAPI(client, "test-event.data", &PublishOptions{SetSchemaVersion: true})
+
+		err := publishAPI.Publish([]DataChangeEvent{event(""), event("1.1.0"), event("custom")})
+
+		require.NoError(t, err)
+		assert.Equal(t, 1, httpmock.GetCallCountInfo()["POST "+url])
 	})
+}
 
-	t.Run("fail unauthorized", func(t *testing.T) {
-		problem := problemJSON{Detail: "not authorized"}
-		responder, _ := httpmock.NewJsonResponder(http.StatusUnauthorized, problem)
-		httpmock.RegisterResponder("POST", url, responder)
+func TestClient_PublishRaw(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
 
-		err := publishAPI.Publish(events)
+	url := fmt.Sprintf("%s/event-types/%s/events", defaultNakadiURL, "test-event.undefined")
+	client := &Client{nakadiURL: defaultNakadiURL, httpClient: http.DefaultClient}
+
+	t.Run("fail invalid json", func(t *testing.T) {
+		httpmock.Reset()
+		httpmock.RegisterResponder("POST", url, httpmock.NewStringResponder(http.StatusOK, ""))
+
+		err := client.PublishRaw("test-event.undefined", [][]byte{[]byte(`{"test":"a"}`), []byte(`{"test":`)})
 
 		require.Error(t, err)
-		assert.Regexp(t, "not authorized", err)
+		assert.Regexp(t, "event 1 is not a valid json object", err)
+		assert.Equal(t, 0, httpmock.GetCallCountInfo()["POST "+url])
 	})
 
-	t.Run("fail to read body", func(t *testing.T) {
-		responder := httpmock.ResponderFromResponse(&http.Response{
-			Status:     strconv.Itoa(http.StatusBadRequest),
-			StatusCode: http.StatusBadRequest,
-			Body:       brokenBodyReader{},
+	t.Run("fail no object", func(t *testing.T) {
+		err := client.PublishRaw("test-event.undefined", [][]byte{[]byte(`["test"]`)})
+
+		require.Error(t, err)
+		assert.Regexp(t, "event 0 is not a valid json object", err)
+	})
+
+	t.Run("success exact bytes", func(t *testing.T) {
+		payloads := [][]byte{[]byte(`{ "test": "<a>" }`), []byte(`{"test":"b","metadata":{"eid":"1"}}`)}
+		httpmock.Reset()
+		httpmock.RegisterResponder("POST", url, func(r *http.Request) (*http.Response, error) {
+			body, err := ioutil.ReadAll(r.Body)
+			require.NoError(t, err)
+			assert.Equal(t, `[{ "test": "<a>" },{"test":"b","metadata":{"eid":"1"}}]`, string(body))
+			return httpmock.NewStringResponse(http.StatusOK, ""), nil
 		})
-		httpmock.RegisterResponder("POST", url, responder)
 
-		err := publishAPI.Publish(events)
+		err := client.PublishRaw("test-event.undefined", payloads)
+
+		require.NoError(t, err)
+		assert.Equal(t, 1, httpmock.GetCallCountInfo()["POST "+url])
+	})
+
+	t.Run("success partially published", func(t *testing.T) {
+		httpmock.Reset()
+		httpmock.RegisterResponder("POST", url, httpmock.NewStringResponder(http.StatusMultiStatus,
+			`[{"eid":"1","publishing_status":"failed","step":"publishing","detail":"some detail"}]`))
+
+		err := client.PublishRaw("test-event.undefined", [][]byte{[]byte(`{"test":"a"}`)})
+
 		require.Error(t, err)
-		assert.Regexp(t, "unable to read response body", err)
+		assert.Equal(t, BatchItemsError{{EID: "1", PublishingStatus: "failed", Step: "publishing", Detail: "some detail"}}, err)
+	})
+}
+
+func TestClient_VerifyEventTypeSchema(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	eventTypeURL := fmt.Sprintf("%s/event-types/%s", defaultNakadiURL, "test-event.data")
+	client := &Client{nakadiURL: defaultNakadiURL, httpClient: http.DefaultClient}
+	setup := func(schema *EventTypeSchema) {
+		responder, err := httpmock.NewJsonResponder(http.StatusOK, &EventType{
+			Name:     "test-event.data",
+			Category: "data",
+			Schema:   schema})
+		require.NoError(t, err)
+		httpmock.RegisterResponder("GET", eventTypeURL, responder)
+	}
+	schema := &EventTypeSchema{
+		Version: "1.1.0",
+		Type:    "json_schema",
+		Schema:  `{"type":"object","properties":{"test":{"type":"string"}},"required":["test"],"additionalProperties":false}`}
+
+	t.Run("fail get event type", func(t *testing.T) {
+		httpmock.RegisterResponder("GET", eventTypeURL, httpmock.NewStringResponder(http.StatusNotFound, testProblemJSON))
+
+		err := client.VerifyEventTypeSchema("test-event.data", DataChangeEvent{})
+		require.Error(t, err)
+		assert.Regexp(t, "unable to obtain event type schema: .*some problem detail", err)
+	})
+
+	t.Run("fail no schema", func(t *testing.T) {
+		setup(nil)
+
+		err := client.VerifyEventTypeSchema("test-event.data", DataChangeEvent{})
+		require.Error(t, err)
+		assert.Regexp(t, "event type test-event.data has no schema", err)
+	})
+
+	t.Run("fail invalid schema", func(t *testing.T) {
+		setup(&EventTypeSchema{Type: "json_schema", Schema: `{"type":"text"}`})
+
+		err := client.VerifyEventTypeSchema("test-event.data", DataChangeEvent{})
+		require.Error(t, err)
+		assert.Regexp(t, "unable to compile schema of event type test-event.data", err)
+	})
+
+	t.Run("fail mismatch", func(t *testing.T) {
+		setup(schema)
+		sample := DataChangeEvent{Data: map[string]interface{}{"test": 1, "other": true}, DataOP: "C"}
+
+		err := client.VerifyEventTypeSchema("test-event.data", sample)
+		require.Error(t, err)
+		assert.Equal(t, ErrSchemaViolation, errors.Cause(err))
+		assert.Regexp(t, "sample event does not match version 1.1.0 of the schema of event type test-event.data", err)
+		assert.Regexp(t, "/: additional property other is not allowed; /test: expected string but got integer", err)
 	})
 
 	t.Run("success", func(t *testing.T) {
-		httpmock.RegisterResponder("POST", url, httpmock.Responder(func(r *http.Request) (*http.Response, error) {
+		setup(schema)
+		sample := DataChangeEvent{Data: map[string]string{"test": "test"}, DataOP: "C"}
+
+		err := client.VerifyEventTypeSchema("test-event.data", sample)
+		assert.NoError(t, err)
+	})
+}
+
+func TestPublishAPI_RetryPartialFailures(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	url := fmt.Sprintf("%s/event-types/%s/events", defaultNakadiURL, "test-event.undefined")
+	client := &Client{nakadiURL: defaultNakadiURL, httpClient: http.DefaultClient}
+	events := make([]SomeUndefinedEvent, 3)
+	for i := range events {
+		events[i].Metadata.EID = strconv.Itoa(i + 1)
+		events[i].Test = "test"
+	}
+	options := &PublishOptions{RetryPartialFailures: true, InitialRetryInterval: time.Millisecond}
+
+	// responder expects the eids of the events in each request and answers with the respective response
+	type exchange struct {
+		eids     []string
+		status   int
+		response string
+	}
+	responder := func(t *testing.T, exchanges ...exchange) httpmock.Responder {
+		var mutex sync.Mutex
+		return func(r *http.Request) (*http.Response, error) {
+			mutex.Lock()
+			defer mutex.Unlock()
+			require.NotEmpty(t, exchanges, "unexpected request")
+			next := exchanges[0]
+			exchanges = exchanges[1:]
+
 			uploaded := []SomeUndefinedEvent{}
-			err := json.NewDecoder(r.Body).Decode(&uploaded)
-			require.NoError(t, err)
-			assert.Equal(t, events, uploaded)
-			return httpmock.NewStringResponse(http.StatusOK, ""), nil
-		}))
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&uploaded))
+			var eids []string
+			for _, event := range uploaded {
+				eids = append(eids, event.Metadata.EID)
+			}
+			assert.Equal(t, next.eids, eids)
+			return httpmock.NewStringResponse(next.status, next.response), nil
+		}
+	}
 
-		err := publishAPI.Publish(events)
+	t.Run("success retry failed events", func(t *testing.T) {
+		httpmock.RegisterResponder("POST", url, responder(t,
+			exchange{eids: []string{"1", "2", "3"}, status: http.StatusMultiStatus, response: `[
+				{"eid":"1","publishing_status":"submitted"},
+				{"eid":"2","publishing_status":"failed","step":"publishing"},
+				{"eid":"3","publishing_status":"aborted","step":"publishing"}]`},
+			exchange{eids: []string{"2", "3"}, status: http.StatusMultiStatus, response: `[
+				{"eid":"2","publishing_status":"submitted"},
+				{"eid":"3","publishing_status":"failed","step":"publishing"}]`},
+			exchange{eids: []string{"3"}, status: http.StatusOK}))
 
+		err := NewPublishAPI(client, "test-event.undefined", options).Publish(events)
 		assert.NoError(t, err)
 	})
+
+	t.Run("fail retries exhausted", func(t *testing.T) {
+		failed := `[{"eid":"3","publishing_status":"failed","step":"publishing","detail":"timeout"}]`
+		httpmock.RegisterResponder("POST", url, responder(t,
+			exchange{eids: []string{"1", "2", "3"}, status: http.StatusMultiStatus, response: `[
+				{"eid":"1","publishing_status":"submitted"},
+				{"eid":"2","publishing_status":"submitted"},
+				{"eid":"3","publishing_status":"failed","step":"publishing"}]`},
+			exchange{eids: []string{"3"}, status: http.StatusMultiStatus, response: failed},
+			exchange{eids: []string{"3"}, status: http.StatusMultiStatus, response: failed}))
+
+		opts := *options
+		opts.MaxPartialRetries = 2
+		err := NewPublishAPI(client, "test-event.undefined", &opts).Publish(events)
+		require.Error(t, err)
+		assert.Equal(t, BatchItemsError{
+			{EID: "1", PublishingStatus: PublishingStatusSubmitted},
+			{EID: "2", PublishingStatus: PublishingStatusSubmitted},
+			{EID: "3", PublishingStatus: PublishingStatusFailed, Step: "publishing", Detail: "timeout"}}, err)
+	})
+
+	t.Run("fail no retry of invalid events", func(t *testing.T) {
+		httpmock.RegisterResponder("POST", url, responder(t,
+			exchange{eids: []string{"1", "2", "3"}, status: http.StatusUnprocessableEntity, response: `[
+				{"eid":"1","publishing_status":"aborted","step":"validating"},
+				{"eid":"2","publishing_status":"failed","step":"validating","detail":"invalid"},
+				{"eid":"3","publishing_status":"aborted","step":"validating"}]`},
+			exchange{eids: []string{"1", "3"}, status: http.StatusOK}))
+
+		err := NewPublishAPI(client, "test-event.undefined", options).Publish(events)
+		require.Error(t, err)
+		assert.Equal(t, BatchItemsError{
+			{EID: "1", PublishingStatus: PublishingStatusSubmitted},
+			{EID: "2", PublishingStatus: PublishingStatusFailed, Step: "validating", Detail: "invalid"},
+			{EID: "3", PublishingStatus: PublishingStatusSubmitted}}, err)
+	})
+
+	t.Run("fail without retry of partial failures", func(t *testing.T) {
+		httpmock.RegisterResponder("POST", url, responder(t,
+			exchange{eids: []string{"1", "2", "3"}, status: http.StatusMultiStatus, response: `[
+				{"eid":"1","publishing_status":"submitted"},
+				{"eid":"2","publishing_status":"submitted"},
+				{"eid":"3","publishing_status":"failed","step":"publishing"}]`}))
+
+		err := NewPublishAPI(client, "test-event.undefined", nil).Publish(events)
+		require.Error(t, err)
+		assert.IsType(t, BatchItemsError{}, err)
+	})
 }
 
 func TestPublishAPI_PublishDataChangeEvent(t *testing.T) {
@@ -215,6 +1346,9 @@ func TestPublishOptions_withDefaults(t *testing.T) {
 				InitialRetryInterval: defaultInitialRetryInterval,
 				MaxRetryInterval:     defaultMaxRetryInterval,
 				MaxElapsedTime:       defaultMaxElapsedTime,
+				SchemaCacheTTL:       defaultSchemaCacheTTL,
+				MaxPartialRetries:    defaultMaxPartialRetries,
+				CompressThreshold:    defaultCompressThreshold,
 			},
 		},
 		{
@@ -223,6 +1357,9 @@ func TestPublishOptions_withDefaults(t *testing.T) {
 				InitialRetryInterval: time.Hour,
 				MaxRetryInterval:     defaultMaxRetryInterval,
 				MaxElapsedTime:       defaultMaxElapsedTime,
+				SchemaCacheTTL:       defaultSchemaCacheTTL,
+				MaxPartialRetries:    defaultMaxPartialRetries,
+				CompressThreshold:    defaultCompressThreshold,
 			},
 		},
 		{
@@ -231,6 +1368,9 @@ func TestPublishOptions_withDefaults(t *testing.T) {
 				InitialRetryInterval: defaultInitialRetryInterval,
 				MaxRetryInterval:     time.Hour,
 				MaxElapsedTime:       defaultMaxElapsedTime,
+				SchemaCacheTTL:       defaultSchemaCacheTTL,
+				MaxPartialRetries:    defaultMaxPartialRetries,
+				CompressThreshold:    defaultCompressThreshold,
 			},
 		},
 		{
@@ -239,6 +1379,9 @@ func TestPublishOptions_withDefaults(t *testing.T) {
 				InitialRetryInterval: defaultInitialRetryInterval,
 				MaxRetryInterval:     defaultMaxRetryInterval,
 				MaxElapsedTime:       time.Hour,
+				SchemaCacheTTL:       defaultSchemaCacheTTL,
+				MaxPartialRetries:    defaultMaxPartialRetries,
+				CompressThreshold:    defaultCompressThreshold,
 			},
 		},
 		{
@@ -248,6 +1391,9 @@ func TestPublishOptions_withDefaults(t *testing.T) {
 				InitialRetryInterval: defaultInitialRetryInterval,
 				MaxRetryInterval:     defaultMaxRetryInterval,
 				MaxElapsedTime:       defaultMaxElapsedTime,
+				SchemaCacheTTL:       defaultSchemaCacheTTL,
+				MaxPartialRetries:    defaultMaxPartialRetries,
+				CompressThreshold:    defaultCompressThreshold,
 			},
 		}, {
 			Options: &PublishOptions{Retry: true},
@@ -256,6 +1402,31 @@ func TestPublishOptions_withDefaults(t *testing.T) {
 				InitialRetryInterval: defaultInitialRetryInterval,
 				MaxRetryInterval:     defaultMaxRetryInterval,
 				MaxElapsedTime:       defaultMaxElapsedTime,
+				SchemaCacheTTL:       defaultSchemaCacheTTL,
+				MaxPartialRetries:    defaultMaxPartialRetries,
+				CompressThreshold:    defaultCompressThreshold,
+			},
+		},
+		{
+			Options: &PublishOptions{SchemaCacheTTL: time.Hour},
+			Expected: &PublishOptions{
+				InitialRetryInterval: defaultInitialRetryInterval,
+				MaxRetryInterval:     defaultMaxRetryInterval,
+				MaxElapsedTime:       defaultMaxElapsedTime,
+				SchemaCacheTTL:       time.Hour,
+				MaxPartialRetries:    defaultMaxPartialRetries,
+				CompressThreshold:    defaultCompressThreshold,
+			},
+		},
+		{
+			Options: &PublishOptions{MaxPartialRetries: 10},
+			Expected: &PublishOptions{
+				InitialRetryInterval: defaultInitialRetryInterval,
+				MaxRetryInterval:     defaultMaxRetryInterval,
+				MaxElapsedTime:       defaultMaxElapsedTime,
+				SchemaCacheTTL:       defaultSchemaCacheTTL,
+				MaxPartialRetries:    10,
+				CompressThreshold:    defaultCompressThreshold,
 			},
 		},
 	}
@@ -265,6 +1436,26 @@ func TestPublishOptions_withDefaults(t *testing.T) {
 	}
 }
 
+func TestFailedAtStep(t *testing.T) {
+	var items BatchItemsError
+	err := json.Unmarshal([]byte(`[
+		{"eid":"1","publishing_status":"failed","step":"validating","detail":"#/id: expected type: String"},
+		{"eid":"2","publishing_status":"aborted","step":"none","detail":""},
+		{"eid":"3","publishing_status":"failed","step":"publishing","detail":"timed out"},
+		{"eid":"4","publishing_status":"submitted","step":"publishing","detail":""},
+		{"eid":"5","publishing_status":"failed","step":"validating","detail":"#/name: required"}]`), &items)
+	require.NoError(t, err)
+
+	assert.Equal(t, []BatchItemResponse{
+		{EID: "1", PublishingStatus: PublishingStatusFailed, Step: PublishingStepValidating, Detail: "#/id: expected type: String"},
+		{EID: "5", PublishingStatus: PublishingStatusFailed, Step: PublishingStepValidating, Detail: "#/name: required"}},
+		FailedAtStep(items, PublishingStepValidating))
+	assert.Equal(t, []BatchItemResponse{
+		{EID: "3", PublishingStatus: PublishingStatusFailed, Step: PublishingStepPublishing, Detail: "timed out"}},
+		FailedAtStep(items, PublishingStepPublishing))
+	assert.Empty(t, FailedAtStep(items, PublishingStepEnriching))
+}
+
 func TestBatchItemsError_Format(t *testing.T) {
 	t.Run("format nil error", func(t *testing.T) {
 		var batchItemErr BatchItemsError
@@ -291,3 +1482,117 @@ func TestBatchItemsError_Format(t *testing.T) {
 		assert.Regexp(t, "errors occurred while publishing events:", fmt.Sprintf("%+v", batchItemErr))
 	})
 }
+
+func TestClient_PublishGrouped(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	urlOf := func(eventType string) string {
+		return fmt.Sprintf("%s/event-types/%s/events", defaultNakadiURL, eventType)
+	}
+	client := &Client{nakadiURL: defaultNakadiURL, httpClient: http.DefaultClient}
+	batchItems := []BatchItemResponse{{EID: "1", PublishingStatus: "failed", Step: "validating", Detail: "invalid"}}
+
+	t.Run("success all event types", func(t *testing.T) {
+		httpmock.RegisterResponder("POST", urlOf("test-event.first"), httpmock.NewStringResponder(http.StatusOK, ""))
+		httpmock.RegisterResponder("POST", urlOf("test-event.second"), httpmock.NewStringResponder(http.StatusOK, ""))
+
+		responses, err := client.PublishGrouped(map[string][]interface{}{
+			"test-event.first":  {SomeUndefinedEvent{Test: "first"}},
+			"test-event.second": {SomeUndefinedEvent{Test: "second"}}})
+		require.NoError(t, err)
+		assert.Empty(t, responses)
+	})
+
+	t.Run("fail partially", func(t *testing.T) {
+		httpmock.RegisterResponder("POST", urlOf("test-event.first"), httpmock.NewStringResponder(http.StatusOK, ""))
+		responder, _ := httpmock.NewJsonResponder(http.StatusUnprocessableEntity, batchItems)
+		httpmock.RegisterResponder("POST", urlOf("test-event.second"), responder)
+		httpmock.RegisterResponder("POST", urlOf("test-event.third"), httpmock.NewStringResponder(http.StatusForbidden, testProblemJSON))
+
+		responses, err := client.PublishGrouped(map[string][]interface{}{
+			"test-event.first":  {SomeUndefinedEvent{Test: "first"}},
+			"test-event.second": {SomeUndefinedEvent{Test: "second"}},
+			"test-event.third":  {SomeUndefinedEvent{Test: "third"}}})
+		require.Error(t, err)
+		require.IsType(t, PublishGroupError{}, err)
+		assert.Equal(t, map[string][]BatchItemResponse{"test-event.second": batchItems}, responses)
+
+		groupErr := err.(PublishGroupError)
+		assert.Len(t, groupErr, 2)
+		assert.IsType(t, BatchItemsError{}, groupErr["test-event.second"])
+		assert.Regexp(t, "some problem detail", groupErr["test-event.third"])
+		assert.Regexp(t, "^unable to publish events of 2 event types: test-event.second: .*; test-event.third: ", err.Error())
+		assert.Equal(t, 1, httpmock.GetCallCountInfo()["POST "+urlOf("test-event.first")])
+	})
+}
+
+func TestClient_PublishBatch(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	url := fmt.Sprintf("%s/event-types/%s/events", defaultNakadiURL, "test-event.data")
+	client := &Client{nakadiURL: defaultNakadiURL, httpClient: http.DefaultClient}
+	events := []interface{}{SomeUndefinedEvent{Test: "first"}, SomeUndefinedEvent{Test: "second"}}
+
+	t.Run("fail partially published", func(t *testing.T) {
+		batchItems := []BatchItemResponse{
+			{EID: "1", PublishingStatus: "submitted"},
+			{EID: "2", PublishingStatus: "failed", Step: "publishing", Detail: "timeout"}}
+		responder, _ := httpmock.NewJsonResponder(http.StatusMultiStatus, batchItems)
+		httpmock.RegisterResponder("POST", url, responder)
+
+		responses, err := client.PublishBatch("test-event.data", events)
+		require.Error(t, err)
+		assert.IsType(t, BatchItemsError{}, err)
+		assert.Equal(t, batchItems, responses)
+	})
+
+	t.Run("fail validation", func(t *testing.T) {
+		batchItems := []BatchItemResponse{
+			{EID: "1", PublishingStatus: "aborted", Step: "validating"},
+			{EID: "2", PublishingStatus: "failed", Step: "validating", Detail: "invalid"}}
+		responder, _ := httpmock.NewJsonResponder(http.StatusUnprocessableEntity, batchItems)
+		httpmock.RegisterResponder("POST", url, responder)
+
+		responses, err := client.PublishBatch("test-event.data", events)
+		require.Error(t, err)
+		assert.IsType(t, BatchItemsError{}, err)
+		assert.Equal(t, batchItems, responses)
+	})
+
+	t.Run("fail other error", func(t *testing.T) {
+		httpmock.RegisterResponder("POST", url, httpmock.NewStringResponder(http.StatusForbidden, testProblemJSON))
+
+		responses, err := client.PublishBatch("test-event.data", events)
+		require.Error(t, err)
+		assert.Regexp(t, "some problem detail", err)
+		assert.Nil(t, responses)
+	})
+
+	t.Run("fail canceled context", func(t *testing.T) {
+		httpmock.RegisterResponder("POST", url, helperCanceledResponder())
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		responses, err := client.PublishBatchContext(ctx, "test-event.data", events)
+		require.Error(t, err)
+		assert.Regexp(t, context.Canceled, err)
+		assert.Nil(t, responses)
+
+		err = client.PublishContext(ctx, "test-event.data", SomeUndefinedEvent{Test: "single"})
+		require.Error(t, err)
+		assert.Regexp(t, context.Canceled, err)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		httpmock.RegisterResponder("POST", url, httpmock.NewStringResponder(http.StatusOK, ""))
+
+		responses, err := client.PublishBatch("test-event.data", events)
+		require.NoError(t, err)
+		assert.Nil(t, responses)
+
+		err = client.Publish("test-event.data", SomeUndefinedEvent{Test: "single"})
+		assert.NoError(t, err)
+	})
+}
@@ -0,0 +1,66 @@
+package nakadi
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// recreatingOpener recreates the subscription of a stream if Nakadi reports it as missing when the stream is
+// opened.
+type recreatingOpener struct {
+	streamOpener
+	ctx          context.Context
+	client       *Client
+	subscription *Subscription
+	recreated    func(id string)
+}
+
+func (r *recreatingOpener) openStream() (streamer, error) {
+	stream, err := r.streamOpener.openStream()
+	if err == nil || !isNotFound(err) {
+		return stream, err
+	}
+
+	definition := *r.subscription
+	definition.ID = ""
+	definition.CreatedAt = time.Time{}
+	if definition.ReadFrom == "" {
+		definition.ReadFrom = ReadFromEnd
+	}
+	subscription, err := NewSubscriptionAPI(r.client, nil).SubscribeOrGet(&definition)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to recreate missing subscription")
+	}
+
+	r.recreated(subscription.ID)
+	if guard, ok := r.streamOpener.(*guardedOpener); ok {
+		guard.subscriptionID = subscription.ID
+		r.client.streams.register(r.ctx, subscription.ID)
+	}
+	return r.streamOpener.openStream()
+}
+
+// SubscriptionID returns the id of the subscription the stream consumes from. The id only changes if the
+// subscription was recreated because of StreamOptions.RecreateOnMissing.
+func (s *StreamAPI) SubscriptionID() string {
+	s.subscriptionMutex.Lock()
+	defer s.subscriptionMutex.Unlock()
+	return s.subscriptionID
+}
+
+// recreated switches the stream to the recreated subscription with the given id.
+func (s *StreamAPI) recreated(id string) {
+	s.subscriptionMutex.Lock()
+	previous := s.subscriptionID
+	s.subscriptionID = id
+	s.subscriptionMutex.Unlock()
+
+	if committer, ok := s.committer.(*simpleCommitter); ok {
+		committer.setSubscriptionID(id)
+	}
+	if s.logger != nil {
+		s.logger.Printf("recreated missing subscription: subscription=%s previous=%s", id, previous)
+	}
+}
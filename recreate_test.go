@@ -0,0 +1,74 @@
+package nakadi
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamAPI_RecreateOnMissing(t *testing.T) {
+	definition := &Subscription{ID: "old-id", OwningApplication: "test-app", EventTypes: []string{"test-event"}}
+
+	setup := func(options *StreamOptions) (*httpmock.MockTransport, *recordingLogger, *StreamAPI) {
+		transport := httpmock.NewMockTransport()
+		logger := &recordingLogger{}
+		client := New(defaultNakadiURL, &ClientOptions{Logger: logger})
+		client.httpClient = &http.Client{Transport: transport}
+		client.httpStreamClient = &http.Client{Transport: transport}
+		transport.RegisterResponder("GET", `=~^`+defaultNakadiURL+`/subscriptions/old-id/events`,
+			httpmock.NewStringResponder(http.StatusNotFound, testProblemJSON))
+		streamAPI, _ := newStreamAPI(context.Background(), client, "old-id", options)
+		return transport, logger, streamAPI
+	}
+
+	t.Run("fail without recreation", func(t *testing.T) {
+		_, _, streamAPI := setup(&StreamOptions{NotReadyRetryTime: time.Millisecond})
+		defer streamAPI.Close()
+
+		_, err := streamAPI.opener.openStream()
+		require.Error(t, err)
+		assert.True(t, isNotFound(err))
+		assert.Equal(t, "old-id", streamAPI.SubscriptionID())
+	})
+
+	t.Run("fail recreation", func(t *testing.T) {
+		transport, _, streamAPI := setup(&StreamOptions{NotReadyRetryTime: time.Millisecond, RecreateOnMissing: definition})
+		defer streamAPI.Close()
+		transport.RegisterResponder("POST", defaultNakadiURL+"/subscriptions",
+			httpmock.NewStringResponder(http.StatusUnprocessableEntity, testProblemJSON))
+
+		_, err := streamAPI.opener.openStream()
+		require.Error(t, err)
+		assert.Regexp(t, "unable to recreate missing subscription", err)
+		assert.Equal(t, "old-id", streamAPI.SubscriptionID())
+	})
+
+	t.Run("success recreate subscription", func(t *testing.T) {
+		transport, logger, streamAPI := setup(&StreamOptions{NotReadyRetryTime: time.Millisecond, RecreateOnMissing: definition})
+		defer streamAPI.Close()
+		transport.RegisterResponder("POST", defaultNakadiURL+"/subscriptions", func(r *http.Request) (*http.Response, error) {
+			created := &Subscription{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(created))
+			assert.Empty(t, created.ID)
+			assert.Equal(t, ReadFromEnd, created.ReadFrom)
+			created.ID = "new-id"
+			return httpmock.NewJsonResponse(http.StatusCreated, created)
+		})
+		transport.RegisterResponder("GET", `=~^`+defaultNakadiURL+`/subscriptions/new-id/events`,
+			httpmock.NewStringResponder(http.StatusOK, ""))
+
+		stream, err := streamAPI.opener.openStream()
+		require.NoError(t, err)
+		defer stream.closeStream()
+
+		assert.Equal(t, "new-id", streamAPI.SubscriptionID())
+		assert.Equal(t, "new-id", streamAPI.committer.(*simpleCommitter).subscriptionID)
+		assert.Equal(t, []string{"recreated missing subscription: subscription=new-id previous=old-id"}, logger.Messages())
+	})
+}
@@ -0,0 +1,204 @@
+package nakadi
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Replay resets the cursors of the subscription identified by id to the provided cursors and consumes the
+// subscription until all its events are consumed. Each received batch is passed to the handler and the
+// respective cursor is committed as soon as the handler returns without an error. Whether the subscription
+// caught up is checked in the interval configured by ClientOptions.CatchUpPollInterval. Replay refuses to run
+// and returns ErrActiveStreams if any stream is currently consuming from the subscription. An error
+// returned by the handler stops the replay without committing the batch. Replay also stops if the stream
+// can't be opened because of an error which won't go away on retry, e.g. because the subscription was
+// deleted or the client is not authorized to consume from it.
+func (c *Client) Replay(id string, from []Cursor, handler func(StreamBatch) error) error {
+	return c.ReplayContext(context.Background(), id, from, handler)
+}
+
+// ReplayContext is like Replay but uses the given context and stops the replay once it is done.
+func (c *Client) ReplayContext(ctx context.Context, id string, from []Cursor, handler func(StreamBatch) error) error {
+	const errMsg = "unable to replay subscription"
+	subAPI := NewSubscriptionAPI(c, nil)
+
+	stats, err := subAPI.GetStatsContext(ctx, id)
+	if err != nil {
+		return errors.Wrap(err, errMsg)
+	}
+	if hasActiveStreams(stats) {
+		return errors.Wrap(ErrActiveStreams, errMsg)
+	}
+
+	if err := subAPI.ResetCursorsContext(ctx, id, from); err != nil {
+		return errors.Wrap(err, errMsg)
+	}
+
+	caughtUp, err := replayCaughtUp(ctx, subAPI, id)
+	if err != nil || caughtUp {
+		return err
+	}
+
+	interval := c.catchUpInterval
+	if interval == 0 {
+		interval = defaultCatchUpPollInterval
+	}
+
+	// errors opening the stream are only reported to NotifyErr, since the stream retries on its own
+	openErrCh := make(chan error, 1)
+	stream := NewStreamContext(ctx, c, id, &StreamOptions{NotifyErr: func(err error, _ time.Duration) {
+		if isPersistentError(err) {
+			select {
+			case openErrCh <- err:
+			default:
+			}
+		}
+	}})
+	defer stream.Close()
+
+	checkedAt := time.Now()
+	for {
+		select {
+		case err := <-openErrCh:
+			return errors.Wrap(err, errMsg)
+		default:
+		}
+
+		nextCtx, cancel := context.WithTimeout(ctx, interval)
+		batch, err := stream.Next(nextCtx)
+		cancel()
+		if ctx.Err() != nil {
+			return errors.Wrap(ctx.Err(), errMsg)
+		}
+
+		switch {
+		case err == nil:
+			if err := handler(batch); err != nil {
+				return err
+			}
+			if err := stream.CommitCursor(batch.Cursor); err != nil {
+				return errors.Wrap(err, errMsg)
+			}
+		case isPersistentError(err):
+			return errors.Wrap(err, errMsg)
+		default:
+			// no batch within the interval or the stream reconnects on its own
+		}
+
+		if time.Since(checkedAt) >= interval {
+			caughtUp, err := replayCaughtUp(ctx, subAPI, id)
+			if err != nil || caughtUp {
+				return err
+			}
+			checkedAt = time.Now()
+		}
+	}
+}
+
+// SeekToEnd resets the cursors of the subscription identified by id to the newest available offsets of all
+// its event types, which discards all unconsumed events. SeekToEnd refuses to run and returns
+// ErrActiveStreams if any stream is currently consuming from the subscription.
+func (c *Client) SeekToEnd(id string) error {
+	const errMsg = "unable to seek to end of subscription"
+	subAPI := NewSubscriptionAPI(c, nil)
+	eventAPI := NewEventAPI(c, nil)
+
+	stats, err := subAPI.GetStats(id)
+	if err != nil {
+		return errors.Wrap(err, errMsg)
+	}
+	if hasActiveStreams(stats) {
+		return errors.Wrap(ErrActiveStreams, errMsg)
+	}
+
+	subscription, err := subAPI.Get(id)
+	if err != nil {
+		return errors.Wrap(err, errMsg)
+	}
+
+	var cursors []Cursor
+	for _, eventType := range subscription.EventTypes {
+		partitions, err := eventAPI.Partitions(eventType)
+		if err != nil {
+			return errors.Wrap(err, errMsg)
+		}
+		for _, p := range partitions {
+			cursors = append(cursors, Cursor{Partition: p.Partition, Offset: p.NewestAvailableOffset, EventType: eventType})
+		}
+	}
+
+	// the newest offsets are available, so checking them again is not necessary
+	if err := subAPI.resetCursors(context.Background(), id, cursors); err != nil {
+		return errors.Wrap(err, errMsg)
+	}
+
+	return nil
+}
+
+// replayCaughtUp checks whether a subscription has no unconsumed events left.
+func replayCaughtUp(ctx context.Context, subAPI *SubscriptionAPI, id string) (bool, error) {
+	stats, err := subAPI.GetStatsContext(ctx, id)
+	if err != nil {
+		return false, errors.Wrap(err, "unable to replay subscription")
+	}
+	return allConsumed(stats), nil
+}
+
+// WaitForCatchUp blocks until all events of the subscription identified by id were consumed, which is the
+// case when no partition of the subscription has unconsumed events. The statistics of the subscription are
+// requested in the interval configured by ClientOptions.CatchUpPollInterval. WaitForCatchUp returns an
+// error if the context is done before the subscription caught up or if requesting the statistics fails,
+// e.g. because the subscription was deleted.
+func (c *Client) WaitForCatchUp(ctx context.Context, id string) error {
+	const errMsg = "unable to wait for subscription to catch up"
+	subAPI := NewSubscriptionAPI(c, nil)
+
+	interval := c.catchUpInterval
+	if interval == 0 {
+		interval = defaultCatchUpPollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		stats, err := subAPI.GetStats(id)
+		if err != nil {
+			return errors.Wrap(err, errMsg)
+		}
+		if allConsumed(stats) {
+			return nil
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return errors.Wrap(ctx.Err(), errMsg)
+		}
+	}
+}
+
+// allConsumed checks whether no partition of the subscription has unconsumed events.
+func allConsumed(stats []*SubscriptionStats) bool {
+	for _, s := range stats {
+		for _, p := range s.Partitions {
+			if p.UnconsumedEvents > 0 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// hasActiveStreams checks whether any partition of the subscription is assigned to a stream.
+func hasActiveStreams(stats []*SubscriptionStats) bool {
+	for _, s := range stats {
+		for _, p := range s.Partitions {
+			if p.StreamID != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
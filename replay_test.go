@@ -0,0 +1,317 @@
+package nakadi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_Replay(t *testing.T) {
+	id := "7dd69d58-7f20-11e7-9748-133d6a0dbfb3"
+	from := []Cursor{{Partition: "0", Offset: "BEGIN", EventType: "test-event"}}
+	statsURL := fmt.Sprintf("%s/subscriptions/%s/stats", defaultNakadiURL, id)
+	cursorsURL := fmt.Sprintf("%s/subscriptions/%s/cursors", defaultNakadiURL, id)
+	streamURL := fmt.Sprintf("%s/subscriptions/%s/events", defaultNakadiURL, id)
+
+	// the stream of a replay is closed asynchronously, a separate transport for each test prevents
+	// it from interfering with other tests
+	setup := func() (*httpmock.MockTransport, *Client) {
+		transport := httpmock.NewMockTransport()
+		return transport, &Client{
+			nakadiURL:        defaultNakadiURL,
+			httpClient:       &http.Client{Transport: transport},
+			httpStreamClient: &http.Client{Transport: transport},
+			catchUpInterval:  10 * time.Millisecond}
+	}
+
+	stats := func(unconsumed int, streamID string) *statsResponse {
+		return &statsResponse{Items: []*SubscriptionStats{{
+			EventType:  "test-event",
+			Partitions: []*PartitionStats{{Partition: "0", UnconsumedEvents: unconsumed, StreamID: streamID}}}}}
+	}
+
+	t.Run("fail with active streams", func(t *testing.T) {
+		transport, client := setup()
+		responder, err := httpmock.NewJsonResponder(http.StatusOK, stats(10, "stream-id"))
+		require.NoError(t, err)
+		transport.RegisterResponder("GET", statsURL, responder)
+
+		err = client.Replay(id, from, func(StreamBatch) error { return nil })
+		require.Error(t, err)
+		assert.Equal(t, ErrActiveStreams, errors.Cause(err))
+		assert.Equal(t, 0, transport.GetCallCountInfo()["PATCH "+cursorsURL])
+	})
+
+	t.Run("fail reset cursors", func(t *testing.T) {
+		transport, client := setup()
+		responder, err := httpmock.NewJsonResponder(http.StatusOK, stats(10, ""))
+		require.NoError(t, err)
+		transport.RegisterResponder("GET", statsURL, responder)
+		transport.RegisterResponder("PATCH", cursorsURL, httpmock.NewStringResponder(http.StatusUnprocessableEntity, testProblemJSON))
+
+		err = client.Replay(id, from, func(StreamBatch) error { return nil })
+		require.Error(t, err)
+		assert.Regexp(t, "some problem detail", err)
+	})
+
+	t.Run("fail with handler error", func(t *testing.T) {
+		transport, client := setup()
+		responder, err := httpmock.NewJsonResponder(http.StatusOK, stats(10, ""))
+		require.NoError(t, err)
+		transport.RegisterResponder("GET", statsURL, responder)
+		transport.RegisterResponder("PATCH", cursorsURL, httpmock.NewStringResponder(http.StatusNoContent, ""))
+		transport.RegisterResponder("GET", streamURL, helperStreamResponder(t))
+
+		transport.RegisterResponder("POST", cursorsURL, func(r *http.Request) (*http.Response, error) {
+			assert.Fail(t, "cursor must not be committed")
+			return httpmock.NewStringResponse(http.StatusNoContent, ""), nil
+		})
+
+		err = client.Replay(id, from, func(StreamBatch) error { return assert.AnError })
+		assert.Equal(t, assert.AnError, err)
+	})
+
+	t.Run("success already caught up", func(t *testing.T) {
+		transport, client := setup()
+		responder, err := httpmock.NewJsonResponder(http.StatusOK, stats(0, ""))
+		require.NoError(t, err)
+		transport.RegisterResponder("GET", statsURL, responder)
+		transport.RegisterResponder("PATCH", cursorsURL, httpmock.NewStringResponder(http.StatusNoContent, ""))
+
+		err = client.Replay(id, from, func(StreamBatch) error {
+			assert.Fail(t, "handler must not be called")
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, 1, transport.GetCallCountInfo()["PATCH "+cursorsURL])
+	})
+
+	t.Run("fail persistent stream error", func(t *testing.T) {
+		transport, client := setup()
+		responder, err := httpmock.NewJsonResponder(http.StatusOK, stats(10, ""))
+		require.NoError(t, err)
+		transport.RegisterResponder("GET", statsURL, responder)
+		transport.RegisterResponder("PATCH", cursorsURL, httpmock.NewStringResponder(http.StatusNoContent, ""))
+		transport.RegisterResponder("GET", streamURL, httpmock.NewStringResponder(http.StatusForbidden, testProblemJSON))
+
+		err = client.Replay(id, from, func(StreamBatch) error { return nil })
+		require.Error(t, err)
+		assert.Regexp(t, "unable to replay subscription: .*some problem detail", err)
+		status, ok := ResponseStatus(err)
+		assert.True(t, ok)
+		assert.Equal(t, http.StatusForbidden, status)
+	})
+
+	t.Run("fail canceled context", func(t *testing.T) {
+		transport, client := setup()
+		responder, err := httpmock.NewJsonResponder(http.StatusOK, stats(10, ""))
+		require.NoError(t, err)
+		transport.RegisterResponder("GET", statsURL, responder)
+		transport.RegisterResponder("PATCH", cursorsURL, httpmock.NewStringResponder(http.StatusNoContent, ""))
+		transport.RegisterResponder("GET", streamURL, httpmock.NewStringResponder(http.StatusServiceUnavailable, testProblemJSON))
+
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		err = client.ReplayContext(ctx, id, from, func(StreamBatch) error { return nil })
+		require.Error(t, err)
+		assert.Equal(t, context.DeadlineExceeded, errors.Cause(err))
+	})
+
+	t.Run("fail canceled context during reset", func(t *testing.T) {
+		transport, client := setup()
+		responder, err := httpmock.NewJsonResponder(http.StatusOK, stats(10, ""))
+		require.NoError(t, err)
+		transport.RegisterResponder("GET", statsURL, responder)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		var resetErr error
+		transport.RegisterResponder("PATCH", cursorsURL, func(r *http.Request) (*http.Response, error) {
+			cancel()
+			resetErr = r.Context().Err()
+			return httpmock.NewStringResponse(http.StatusNoContent, ""), nil
+		})
+
+		err = client.ReplayContext(ctx, id, from, func(StreamBatch) error { return nil })
+		require.Error(t, err)
+		assert.Equal(t, context.Canceled, resetErr)
+	})
+
+	t.Run("success consume until caught up", func(t *testing.T) {
+		transport, client := setup()
+		var commits int32
+		transport.RegisterResponder("GET", statsURL, func(r *http.Request) (*http.Response, error) {
+			return httpmock.NewJsonResponse(http.StatusOK, stats(3-int(atomic.LoadInt32(&commits)), ""))
+		})
+		transport.RegisterResponder("PATCH", cursorsURL, httpmock.NewStringResponder(http.StatusNoContent, ""))
+		transport.RegisterResponder("POST", cursorsURL, func(r *http.Request) (*http.Response, error) {
+			atomic.AddInt32(&commits, 1)
+			return httpmock.NewStringResponse(http.StatusNoContent, ""), nil
+		})
+		// the events are streamed once, reconnects wait until the stream is closed
+		var opened int32
+		transport.RegisterResponder("GET", streamURL, func(r *http.Request) (*http.Response, error) {
+			if atomic.AddInt32(&opened, 1) == 1 {
+				return helperStreamResponder(t)(r)
+			}
+			<-r.Context().Done()
+			return nil, r.Context().Err()
+		})
+
+		var batches []StreamBatch
+		err := client.Replay(id, from, func(batch StreamBatch) error {
+			batches = append(batches, batch)
+			return nil
+		})
+		require.NoError(t, err)
+		require.Len(t, batches, 3)
+		assert.Equal(t, "1", batches[0].Cursor.Offset)
+		assert.Equal(t, "3", batches[2].Cursor.Offset)
+		assert.Equal(t, int32(3), atomic.LoadInt32(&commits))
+	})
+}
+
+func helperStreamResponder(t *testing.T) httpmock.Responder {
+	return func(r *http.Request) (*http.Response, error) {
+		response := httpmock.NewBytesResponse(http.StatusOK, helperLoadTestData(t, "data-event-stream.json", nil))
+		response.Header.Set("X-Nakadi-StreamId", "stream-id")
+		return response, nil
+	}
+}
+
+func TestClient_SeekToEnd(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	id := "7dd69d58-7f20-11e7-9748-133d6a0dbfb3"
+	subURL := fmt.Sprintf("%s/subscriptions/%s", defaultNakadiURL, id)
+	partitionsURL := fmt.Sprintf("%s/event-types/%s/partitions", defaultNakadiURL, "test-event.change")
+
+	client := &Client{nakadiURL: defaultNakadiURL, httpClient: http.DefaultClient}
+
+	setup := func(streamID string) {
+		httpmock.Reset()
+		stats, err := httpmock.NewJsonResponder(http.StatusOK, &statsResponse{Items: []*SubscriptionStats{{
+			EventType:  "test-event.change",
+			Partitions: []*PartitionStats{{Partition: "0", StreamID: streamID}}}}})
+		require.NoError(t, err)
+		httpmock.RegisterResponder("GET", subURL+"/stats", stats)
+		subscription, err := httpmock.NewJsonResponder(http.StatusOK, &Subscription{ID: id, EventTypes: []string{"test-event.change"}})
+		require.NoError(t, err)
+		httpmock.RegisterResponder("GET", subURL, subscription)
+		httpmock.RegisterResponder("GET", partitionsURL, httpmock.NewBytesResponder(http.StatusOK,
+			helperLoadTestData(t, "event-type-partitions.json", nil)))
+	}
+
+	t.Run("fail with active streams", func(t *testing.T) {
+		setup("stream-id")
+
+		err := client.SeekToEnd(id)
+		require.Error(t, err)
+		assert.Equal(t, ErrActiveStreams, errors.Cause(err))
+		assert.Equal(t, 0, httpmock.GetCallCountInfo()["PATCH "+subURL+"/cursors"])
+	})
+
+	t.Run("fail reset conflict", func(t *testing.T) {
+		setup("")
+		httpmock.RegisterResponder("PATCH", subURL+"/cursors", httpmock.NewStringResponder(http.StatusConflict, testProblemJSON))
+
+		err := client.SeekToEnd(id)
+		require.Error(t, err)
+		assert.Equal(t, ErrActiveStreams, errors.Cause(err))
+	})
+
+	t.Run("fail request partitions", func(t *testing.T) {
+		setup("")
+		httpmock.RegisterResponder("GET", partitionsURL, httpmock.NewStringResponder(http.StatusNotFound, testProblemJSON))
+
+		err := client.SeekToEnd(id)
+		require.Error(t, err)
+		assert.Regexp(t, "unable to seek to end of subscription: unable to request partitions", err)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		setup("")
+		httpmock.RegisterResponder("PATCH", subURL+"/cursors", func(r *http.Request) (*http.Response, error) {
+			body := resetRequest{}
+			err := json.NewDecoder(r.Body).Decode(&body)
+			require.NoError(t, err)
+			expected := []SubscriptionCursor{
+				{Partition: "0", Offset: "001-0001-000000000000000042", EventType: "test-event.change"},
+				{Partition: "1", Offset: "BEGIN", EventType: "test-event.change"}}
+			assert.Equal(t, expected, body.Items)
+			return httpmock.NewStringResponse(http.StatusNoContent, ""), nil
+		})
+
+		err := client.SeekToEnd(id)
+		assert.NoError(t, err)
+	})
+}
+
+func TestClient_WaitForCatchUp(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	id := "7dd69d58-7f20-11e7-9748-133d6a0dbfb3"
+	statsURL := fmt.Sprintf("%s/subscriptions/%s/stats", defaultNakadiURL, id)
+	client := &Client{nakadiURL: defaultNakadiURL, httpClient: http.DefaultClient, catchUpInterval: time.Millisecond}
+
+	stats := func(unconsumed ...int) *statsResponse {
+		partitions := make([]*PartitionStats, 0, len(unconsumed))
+		for i, n := range unconsumed {
+			partitions = append(partitions, &PartitionStats{Partition: strconv.Itoa(i), UnconsumedEvents: n})
+		}
+		return &statsResponse{Items: []*SubscriptionStats{{EventType: "test-event", Partitions: partitions}}}
+	}
+
+	t.Run("fail subscription deleted", func(t *testing.T) {
+		var calls int32
+		httpmock.RegisterResponder("GET", statsURL, func(r *http.Request) (*http.Response, error) {
+			if atomic.AddInt32(&calls, 1) == 1 {
+				return httpmock.NewJsonResponse(http.StatusOK, stats(0, 5))
+			}
+			return httpmock.NewStringResponse(http.StatusNotFound, testProblemJSON), nil
+		})
+
+		err := client.WaitForCatchUp(context.Background(), id)
+		require.Error(t, err)
+		assert.Regexp(t, "unable to wait for subscription to catch up: .*some problem detail", err)
+	})
+
+	t.Run("fail context done", func(t *testing.T) {
+		responder, err := httpmock.NewJsonResponder(http.StatusOK, stats(3))
+		require.NoError(t, err)
+		httpmock.RegisterResponder("GET", statsURL, responder)
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+
+		err = client.WaitForCatchUp(ctx, id)
+		require.Error(t, err)
+		assert.Equal(t, context.DeadlineExceeded, errors.Cause(err))
+	})
+
+	t.Run("success", func(t *testing.T) {
+		var calls int32
+		httpmock.RegisterResponder("GET", statsURL, func(r *http.Request) (*http.Response, error) {
+			if atomic.AddInt32(&calls, 1) < 3 {
+				return httpmock.NewJsonResponse(http.StatusOK, stats(0, 2))
+			}
+			return httpmock.NewJsonResponse(http.StatusOK, stats(0, 0))
+		})
+
+		err := client.WaitForCatchUp(context.Background(), id)
+		require.NoError(t, err)
+		assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+	})
+}
@@ -0,0 +1,142 @@
+package nakadi
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/cenkalti/backoff/v3"
+)
+
+// RetryPolicy configures the retries of requests which fail with a transient error, like a dropped connection
+// or a response with which Nakadi sheds load. It is applied by the http client created by New to every single
+// request of all sub APIs, below the retries configured by the options of a sub API. Requests are only
+// retried if their body can be sent again, which is the case for all requests of this package since their
+// bodies are encoded before the first attempt. Streams are not retried by the policy, since a stream reopens
+// itself after failures.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts of a request including the first one. Retries count
+	// against ClientOptions.MaxTotalAttempts (default: 3).
+	MaxAttempts int
+	// InitialInterval is the time to wait before the first retry, which is doubled for every further retry
+	// (default: 10ms).
+	InitialInterval time.Duration
+	// MaxInterval is the maximum time to wait between two attempts. A Retry-After header sent by Nakadi
+	// with status 429 replaces the backoff, if it exceeds MaxInterval the response is returned without
+	// further retries (default: 1s).
+	MaxInterval time.Duration
+	// RetryStatusCodes are the status codes of responses which are retried. Requests which could not be sent,
+	// e.g. because the connection dropped, are always retried (default: 429, 500 and 503).
+	RetryStatusCodes []int
+}
+
+func (p *RetryPolicy) withDefaults() *RetryPolicy {
+	copyPolicy := *p
+	if copyPolicy.MaxAttempts == 0 {
+		copyPolicy.MaxAttempts = defaultRetryMaxAttempts
+	}
+	if copyPolicy.InitialInterval == 0 {
+		copyPolicy.InitialInterval = defaultInitialRetryInterval
+	}
+	if copyPolicy.MaxInterval == 0 {
+		copyPolicy.MaxInterval = defaultRetryMaxInterval
+	}
+	if copyPolicy.RetryStatusCodes == nil {
+		copyPolicy.RetryStatusCodes = []int{http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusServiceUnavailable}
+	}
+	return &copyPolicy
+}
+
+// retryable returns true if the response with the given status code is retried.
+func (p *RetryPolicy) retryable(status int) bool {
+	for _, code := range p.RetryStatusCodes {
+		if code == status {
+			return true
+		}
+	}
+	return false
+}
+
+// retryTransport retries requests according to a retry policy. The attempts are counted against the attempt
+// budget of the context of the request and waiting between attempts stops once the context is done.
+type retryTransport struct {
+	next   http.RoundTripper
+	policy *RetryPolicy
+}
+
+func (t *retryTransport) RoundTrip(request *http.Request) (*http.Response, error) {
+	back := backoff.NewExponentialBackOff()
+	back.InitialInterval = t.policy.InitialInterval
+	back.MaxInterval = t.policy.MaxInterval
+	back.MaxElapsedTime = 0
+	back.Reset()
+
+	budget := attemptBudgetFrom(request.Context())
+	for attempt := 1; ; attempt++ {
+		response, err := t.next.RoundTrip(request)
+		if err == nil && !t.policy.retryable(response.StatusCode) {
+			return response, nil
+		}
+		if attempt >= t.policy.MaxAttempts || (request.Body != nil && request.GetBody == nil) {
+			return response, err
+		}
+
+		wait := back.NextBackOff()
+		if response != nil {
+			if retryAfter, ok := parseRetryAfter(response); ok {
+				if retryAfter > t.policy.MaxInterval {
+					return response, nil
+				}
+				wait = retryAfter
+			}
+		}
+		// the attempt is taken before the response is discarded, so that the last response or error is
+		// returned if the budget is used up
+		if !budget.take() {
+			return response, err
+		}
+		if response != nil {
+			io.Copy(ioutil.Discard, response.Body)
+			response.Body.Close()
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-request.Context().Done():
+			timer.Stop()
+			return nil, request.Context().Err()
+		}
+
+		if request.GetBody != nil {
+			body, err := request.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			retried := *request
+			retried.Body = body
+			request = &retried
+		}
+	}
+}
+
+// parseRetryAfter returns the time to wait which Nakadi sent in the Retry-After header of a response with
+// status 429, either in seconds or as a date.
+func parseRetryAfter(response *http.Response) (time.Duration, bool) {
+	header := response.Header.Get("Retry-After")
+	if response.StatusCode != http.StatusTooManyRequests || header == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(header); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
@@ -0,0 +1,159 @@
+package nakadi
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRetryPolicy_withDefaults(t *testing.T) {
+	policy := (&RetryPolicy{}).withDefaults()
+	assert.Equal(t, &RetryPolicy{
+		MaxAttempts:      defaultRetryMaxAttempts,
+		InitialInterval:  defaultInitialRetryInterval,
+		MaxInterval:      defaultRetryMaxInterval,
+		RetryStatusCodes: []int{http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusServiceUnavailable}}, policy)
+
+	policy = (&RetryPolicy{MaxAttempts: 5, RetryStatusCodes: []int{}}).withDefaults()
+	assert.Equal(t, 5, policy.MaxAttempts)
+	assert.Empty(t, policy.RetryStatusCodes)
+}
+
+func TestClient_RetryPolicy(t *testing.T) {
+	url := defaultNakadiURL + "/event-types/test-event.data/events"
+	events := []interface{}{SomeUndefinedEvent{Test: "test"}}
+
+	setup := func(policy *RetryPolicy, maxAttempts int) (*httpmock.MockTransport, *PublishAPI) {
+		transport := httpmock.NewMockTransport()
+		client := &Client{
+			nakadiURL:   defaultNakadiURL,
+			httpClient:  &http.Client{Transport: &retryTransport{next: transport, policy: policy.withDefaults()}},
+			maxAttempts: maxAttempts}
+		return transport, NewPublishAPI(client, "test-event.data", nil)
+	}
+	sequence := func(responders ...httpmock.Responder) httpmock.Responder {
+		calls := 0
+		return func(r *http.Request) (*http.Response, error) {
+			responder := responders[len(responders)-1]
+			if calls < len(responders) {
+				responder = responders[calls]
+			}
+			calls++
+			return responder(r)
+		}
+	}
+
+	t.Run("fail after max attempts", func(t *testing.T) {
+		transport, publishAPI := setup(&RetryPolicy{InitialInterval: time.Millisecond}, 0)
+		transport.RegisterResponder("POST", url, httpmock.NewStringResponder(http.StatusServiceUnavailable, testProblemJSON))
+
+		err := publishAPI.Publish(events)
+		require.Error(t, err)
+		assert.Regexp(t, "some problem detail", err)
+		assert.Equal(t, 3, transport.GetTotalCallCount())
+	})
+
+	t.Run("fail not retryable", func(t *testing.T) {
+		transport, publishAPI := setup(&RetryPolicy{InitialInterval: time.Millisecond}, 0)
+		transport.RegisterResponder("POST", url, httpmock.NewStringResponder(http.StatusForbidden, testProblemJSON))
+
+		err := publishAPI.Publish(events)
+		require.Error(t, err)
+		assert.Equal(t, 1, transport.GetTotalCallCount())
+	})
+
+	t.Run("fail retry after exceeds max interval", func(t *testing.T) {
+		transport, publishAPI := setup(&RetryPolicy{InitialInterval: time.Millisecond}, 0)
+		transport.RegisterResponder("POST", url, func(r *http.Request) (*http.Response, error) {
+			response := httpmock.NewStringResponse(http.StatusTooManyRequests, testProblemJSON)
+			response.Header.Set("Retry-After", "60")
+			return response, nil
+		})
+
+		err := publishAPI.Publish(events)
+		require.Error(t, err)
+		assert.Equal(t, 1, transport.GetTotalCallCount())
+	})
+
+	t.Run("fail canceled context", func(t *testing.T) {
+		transport, publishAPI := setup(&RetryPolicy{InitialInterval: time.Minute, MaxInterval: time.Minute}, 0)
+		transport.RegisterResponder("POST", url, httpmock.NewStringResponder(http.StatusServiceUnavailable, testProblemJSON))
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+
+		start := time.Now()
+		err := publishAPI.PublishContext(ctx, events)
+		require.Error(t, err)
+		assert.Regexp(t, context.DeadlineExceeded, err)
+		assert.True(t, time.Since(start) < 10*time.Second)
+		assert.Equal(t, 1, transport.GetTotalCallCount())
+	})
+
+	t.Run("fail max total attempts", func(t *testing.T) {
+		transport, publishAPI := setup(&RetryPolicy{MaxAttempts: 5, InitialInterval: time.Millisecond}, 2)
+		transport.RegisterResponder("POST", url, httpmock.NewStringResponder(http.StatusServiceUnavailable, testProblemJSON))
+
+		err := publishAPI.Publish(events)
+		require.Error(t, err)
+		assert.Equal(t, 2, transport.GetTotalCallCount())
+	})
+
+	t.Run("fail max total attempts used up while waiting", func(t *testing.T) {
+		transport, publishAPI := setup(&RetryPolicy{InitialInterval: 50 * time.Millisecond}, 2)
+		transport.RegisterResponder("POST", url, func(r *http.Request) (*http.Response, error) {
+			// another request of the same publish uses up the budget during the wait
+			time.AfterFunc(5*time.Millisecond, func() { attemptBudgetFrom(r.Context()).take() })
+			return httpmock.NewStringResponse(http.StatusServiceUnavailable, testProblemJSON), nil
+		})
+
+		err := publishAPI.Publish(events)
+		require.Error(t, err)
+		assert.Regexp(t, "some problem detail", err)
+		status, ok := ResponseStatus(err)
+		assert.True(t, ok)
+		assert.Equal(t, http.StatusServiceUnavailable, status)
+	})
+
+	t.Run("success retry after", func(t *testing.T) {
+		transport, publishAPI := setup(&RetryPolicy{InitialInterval: time.Minute, MaxInterval: time.Minute}, 0)
+		throttled := func(r *http.Request) (*http.Response, error) {
+			response := httpmock.NewStringResponse(http.StatusTooManyRequests, testProblemJSON)
+			response.Header.Set("Retry-After", "0")
+			return response, nil
+		}
+		transport.RegisterResponder("POST", url, sequence(throttled, httpmock.NewStringResponder(http.StatusOK, "")))
+
+		err := publishAPI.Publish(events)
+		require.NoError(t, err)
+		assert.Equal(t, 2, transport.GetTotalCallCount())
+	})
+
+	t.Run("success after network error", func(t *testing.T) {
+		transport, publishAPI := setup(&RetryPolicy{InitialInterval: time.Millisecond}, 0)
+		var bodies []string
+		transport.RegisterResponder("POST", url, sequence(
+			func(r *http.Request) (*http.Response, error) {
+				body, _ := ioutil.ReadAll(r.Body)
+				bodies = append(bodies, string(body))
+				return nil, errors.New("connection reset")
+			},
+			func(r *http.Request) (*http.Response, error) {
+				body, _ := ioutil.ReadAll(r.Body)
+				bodies = append(bodies, string(body))
+				return httpmock.NewStringResponse(http.StatusOK, ""), nil
+			}))
+
+		err := publishAPI.Publish(events)
+		require.NoError(t, err)
+		require.Len(t, bodies, 2)
+		assert.Equal(t, bodies[0], bodies[1])
+		assert.Contains(t, bodies[1], `"test":"test"`)
+	})
+}
@@ -0,0 +1,205 @@
+package nakadi
+
+import (
+	"encoding"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	rawJSONType       = reflect.TypeOf(json.RawMessage{})
+	marshalerType     = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// SchemaFromStruct generates a JSON Schema for the json encoding of v which can be used as schema of an event
+// type. The value must be a struct or a pointer to a struct. Fields are named according to their json tags
+// and fields without the omitempty option are required. Since nil pointers, slices and maps are encoded as
+// null, their schemas also allow null. Fields with the string option are strings. Nested structs, slices,
+// arrays, maps with string keys and time.Time are supported. Fields of types with a custom json encoding are
+// not restricted.
+// For event types of the categories "data" and "business" the schema must not contain the metadata of the
+// event, so v should be the data of the event, not a struct embedding the metadata.
+func SchemaFromStruct(v interface{}) (string, error) {
+	t := reflect.TypeOf(v)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t == nil || t.Kind() != reflect.Struct {
+		return "", errors.Errorf("unable to generate schema: %v is not a struct", t)
+	}
+
+	schema, err := schemaOf(t, map[reflect.Type]bool{})
+	if err != nil {
+		return "", errors.Wrap(err, "unable to generate schema")
+	}
+
+	encoded, err := json.Marshal(schema)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to encode schema")
+	}
+	return string(encoded), nil
+}
+
+// schemaOf creates the schema of a type. The visited types are used to detect recursive types.
+func schemaOf(t reflect.Type, visited map[reflect.Type]bool) (map[string]interface{}, error) {
+	schema, err := nonNullSchemaOf(t, visited)
+	if err != nil {
+		return nil, err
+	}
+	return allowNull(schema, t), nil
+}
+
+// allowNull adds null to the type of a schema if t is a pointer, slice or map, whose nil values are encoded as
+// null. Schemas without type already allow null.
+func allowNull(schema map[string]interface{}, t reflect.Type) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice, reflect.Map:
+		if name, ok := schema["type"].(string); ok {
+			schema["type"] = []string{name, "null"}
+		}
+	}
+	return schema
+}
+
+// nonNullSchemaOf creates the schema of the values of a type which are not encoded as null.
+func nonNullSchemaOf(t reflect.Type, visited map[reflect.Type]bool) (map[string]interface{}, error) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}, nil
+	case t == rawJSONType || t.Implements(marshalerType) || reflect.PtrTo(t).Implements(marshalerType):
+		return map[string]interface{}{}, nil
+	case t.Implements(textMarshalerType) || reflect.PtrTo(t).Implements(textMarshalerType):
+		return map[string]interface{}{"type": "string"}, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}, nil
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}, nil
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}, nil
+	case reflect.Interface:
+		return map[string]interface{}{}, nil
+	case reflect.Slice, reflect.Array:
+		if t.Kind() == reflect.Slice && t.Elem().Kind() == reflect.Uint8 {
+			// byte slices are encoded as base64 strings, byte arrays as arrays of numbers
+			return map[string]interface{}{"type": "string"}, nil
+		}
+		items, err := schemaOf(t.Elem(), visited)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"type": "array", "items": items}, nil
+	case reflect.Map:
+		if t.Key().Kind() != reflect.String {
+			return nil, errors.Errorf("unsupported map key type %v", t.Key())
+		}
+		values, err := schemaOf(t.Elem(), visited)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"type": "object", "additionalProperties": values}, nil
+	case reflect.Struct:
+		if visited[t] {
+			return nil, errors.Errorf("recursive type %v", t)
+		}
+		visited[t] = true
+		defer delete(visited, t)
+
+		properties := map[string]interface{}{}
+		required := []string{}
+		if err := structProperties(t, visited, properties, &required); err != nil {
+			return nil, err
+		}
+
+		schema := map[string]interface{}{"type": "object", "properties": properties}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		return schema, nil
+	default:
+		return nil, errors.Errorf("unsupported type %v", t)
+	}
+}
+
+// structProperties adds the schemas of all encoded fields of a struct to properties. The fields of embedded
+// structs without json tag are added as if they were fields of the struct itself.
+func structProperties(t reflect.Type, visited map[reflect.Type]bool, properties map[string]interface{}, required *[]string) error {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts := tag, ""
+		if idx := strings.Index(tag, ","); idx >= 0 {
+			name, opts = tag[:idx], tag[idx+1:]
+		}
+
+		fieldType := field.Type
+		for fieldType.Kind() == reflect.Ptr {
+			fieldType = fieldType.Elem()
+		}
+		if field.Anonymous && name == "" && fieldType.Kind() == reflect.Struct {
+			if err := structProperties(fieldType, visited, properties, required); err != nil {
+				return err
+			}
+			continue
+		}
+		if field.PkgPath != "" {
+			// unexported field
+			continue
+		}
+
+		if name == "" {
+			name = field.Name
+		}
+		schema, err := schemaOf(field.Type, visited)
+		if err != nil {
+			return errors.Wrapf(err, "field %s", field.Name)
+		}
+		if hasOption(opts, "string") && isQuotable(fieldType.Kind()) {
+			// the value is encoded as json string
+			schema = allowNull(map[string]interface{}{"type": "string"}, field.Type)
+		}
+		properties[name] = schema
+		if !hasOption(opts, "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+	return nil
+}
+
+// isQuotable checks whether the string option of a json tag applies to values of the given kind.
+func isQuotable(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Bool, reflect.String, reflect.Float32, reflect.Float64,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return true
+	}
+	return false
+}
+
+func hasOption(opts, option string) bool {
+	for _, opt := range strings.Split(opts, ",") {
+		if opt == option {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,119 @@
+package nakadi
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type schemaAddress struct {
+	Street string `json:"street"`
+	Zip    string `json:"zip,omitempty"`
+}
+
+type schemaBase struct {
+	ID int64 `json:"id"`
+}
+
+type schemaOrder struct {
+	schemaBase
+	Number     string         `json:"order_number"`
+	Paid       bool           `json:"paid"`
+	Total      float64        `json:"total,omitempty"`
+	Items      []string       `json:"items"`
+	Address    *schemaAddress `json:"address,omitempty"`
+	Labels     map[string]int `json:"labels,omitempty"`
+	Payload    []byte         `json:"payload,omitempty"`
+	Checksum   [2]byte        `json:"checksum"`
+	Host       net.IP         `json:"host,omitempty"`
+	Extra      interface{}    `json:"extra,omitempty"`
+	CreatedAt  time.Time      `json:"created_at"`
+	Count      int64          `json:"count,string"`
+	Limit      *float64       `json:"limit,string,omitempty"`
+	Untagged   string         `json:",omitempty"`
+	Ignored    string         `json:"-"`
+	unexported string
+	Nested     []map[string]*struct{} `json:"nested,omitempty"`
+}
+
+type schemaRecursive struct {
+	Children []schemaRecursive `json:"children"`
+}
+
+func TestSchemaFromStruct(t *testing.T) {
+	t.Run("fail no struct", func(t *testing.T) {
+		_, err := SchemaFromStruct("foo")
+		require.Error(t, err)
+		assert.Regexp(t, "string is not a struct", err)
+
+		_, err = SchemaFromStruct(nil)
+		require.Error(t, err)
+	})
+
+	t.Run("fail recursive type", func(t *testing.T) {
+		_, err := SchemaFromStruct(schemaRecursive{})
+		require.Error(t, err)
+		assert.Regexp(t, "recursive type", err)
+	})
+
+	t.Run("fail unsupported map key", func(t *testing.T) {
+		_, err := SchemaFromStruct(&struct {
+			Values map[int]string `json:"values"`
+		}{})
+		require.Error(t, err)
+		assert.Regexp(t, "field Values: unsupported map key type int", err)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		schema, err := SchemaFromStruct(&schemaOrder{unexported: "unused"})
+		require.NoError(t, err)
+
+		expected := `{
+			"type": "object",
+			"properties": {
+				"id": {"type": "integer"},
+				"order_number": {"type": "string"},
+				"paid": {"type": "boolean"},
+				"total": {"type": "number"},
+				"items": {"type": ["array", "null"], "items": {"type": "string"}},
+				"address": {
+					"type": ["object", "null"],
+					"properties": {"street": {"type": "string"}, "zip": {"type": "string"}},
+					"required": ["street"]
+				},
+				"labels": {"type": ["object", "null"], "additionalProperties": {"type": "integer"}},
+				"payload": {"type": ["string", "null"]},
+				"checksum": {"type": "array", "items": {"type": "integer"}},
+				"host": {"type": ["string", "null"]},
+				"extra": {},
+				"created_at": {"type": "string", "format": "date-time"},
+				"count": {"type": "string"},
+				"limit": {"type": ["string", "null"]},
+				"Untagged": {"type": "string"},
+				"nested": {
+					"type": ["array", "null"],
+					"items": {"type": ["object", "null"], "additionalProperties": {"type": ["object", "null"], "properties": {}}}
+				}
+			},
+			"required": ["id", "order_number", "paid", "items", "checksum", "created_at", "count"]
+		}`
+		assert.JSONEq(t, expected, schema)
+	})
+
+	t.Run("success zero value matches schema", func(t *testing.T) {
+		schema, err := SchemaFromStruct(&schemaOrder{})
+		require.NoError(t, err)
+		compiled, err := compileJSONSchema(schema)
+		require.NoError(t, err)
+
+		encoded, err := json.Marshal(&schemaOrder{})
+		require.NoError(t, err)
+		var decoded interface{}
+		require.NoError(t, json.Unmarshal(encoded, &decoded))
+		assert.Empty(t, compiled.validate(decoded))
+	})
+}
@@ -0,0 +1,176 @@
+package nakadi
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// Names of feature toggles of Nakadi which are relevant for clients.
+const (
+	FeatureDisableEventTypeCreation    = "DISABLE_EVENT_TYPE_CREATION"
+	FeatureDisableEventTypeDeletion    = "DISABLE_EVENT_TYPE_DELETION"
+	FeatureDisableSubscriptionCreation = "DISABLE_SUBSCRIPTION_CREATION"
+	FeatureDisableDBWriteOperations    = "DISABLE_DB_WRITE_OPERATIONS"
+)
+
+// ErrFloodSettingsNotSupported is the cause of errors returned by GetFloodSettings if Nakadi does not expose
+// its flood settings to the client, i.e. if the endpoint is missing or the client is not authorized to read it.
+var ErrFloodSettingsNotSupported = errors.New("flood settings not supported")
+
+// Settings contains the cluster wide settings of a Nakadi instance which are exposed to clients.
+type Settings struct {
+	// Features maps the names of all feature toggles to their state.
+	Features map[string]bool
+	// PartitionStrategies contains the partition strategies supported by Nakadi.
+	PartitionStrategies []string
+	// EnrichmentStrategies contains the enrichment strategies supported by Nakadi.
+	EnrichmentStrategies []string
+}
+
+// FeatureEnabled returns true if the feature toggle with the given name is enabled. Unknown features
+// are reported as disabled.
+func (s *Settings) FeatureEnabled(feature string) bool {
+	return s.Features[feature]
+}
+
+// settingsCache holds the settings of a client once they were fetched.
+type settingsCache struct {
+	mutex    sync.Mutex
+	settings *Settings
+}
+
+// Settings returns the settings of the Nakadi instance. The settings are fetched on the first call and
+// cached by the client afterwards. Use RefreshSettings in order to fetch the current settings again.
+func (c *Client) Settings() (*Settings, error) {
+	if c.settings == nil {
+		return c.fetchSettings()
+	}
+
+	c.settings.mutex.Lock()
+	defer c.settings.mutex.Unlock()
+
+	if c.settings.settings != nil {
+		return c.settings.settings, nil
+	}
+	settings, err := c.fetchSettings()
+	if err != nil {
+		return nil, err
+	}
+	c.settings.settings = settings
+	return settings, nil
+}
+
+// RefreshSettings fetches the current settings of the Nakadi instance and replaces the settings cached by
+// the client.
+func (c *Client) RefreshSettings() (*Settings, error) {
+	settings, err := c.fetchSettings()
+	if err != nil {
+		return nil, err
+	}
+
+	if c.settings != nil {
+		c.settings.mutex.Lock()
+		c.settings.settings = settings
+		c.settings.mutex.Unlock()
+	}
+	return settings, nil
+}
+
+func (c *Client) fetchSettings() (*Settings, error) {
+	backOffConf := backOffConfiguration{}
+
+	features := struct {
+		Items []struct {
+			Feature string `json:"feature"`
+			Enabled bool   `json:"enabled"`
+		} `json:"items"`
+	}{}
+	err := c.httpGET(context.Background(), backOffConf.create(), c.nakadiURL+"/settings/features", &features, "unable to request features")
+	if err != nil {
+		return nil, err
+	}
+
+	settings := &Settings{Features: make(map[string]bool, len(features.Items))}
+	for _, item := range features.Items {
+		settings.Features[item.Feature] = item.Enabled
+	}
+
+	err = c.httpGET(context.Background(), backOffConf.create(), c.nakadiURL+"/registry/partition-strategies", &settings.PartitionStrategies, "unable to request partition strategies")
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.httpGET(context.Background(), backOffConf.create(), c.nakadiURL+"/registry/enrichment-strategies", &settings.EnrichmentStrategies, "unable to request enrichment strategies")
+	if err != nil {
+		return nil, err
+	}
+
+	return settings, nil
+}
+
+// FloodSettings contains the producers and consumers which Nakadi blocks because they flooded the cluster.
+// Requests of a blocked application or for a blocked event type are rejected by Nakadi, so producers can
+// check the settings before publishing. Nakadi does not report numeric rate limits: throttling is signaled
+// per request with the status 429.
+type FloodSettings struct {
+	Producers FloodBlocklist
+	Consumers FloodBlocklist
+}
+
+// FloodBlocklist contains the event types and applications which are blocked for producing or consuming.
+type FloodBlocklist struct {
+	EventTypes []string `json:"event_types"`
+	Apps       []string `json:"apps"`
+}
+
+// Blocked returns true if either the event type or the application is blocked.
+func (b FloodBlocklist) Blocked(eventType, app string) bool {
+	return containsString(b.EventTypes, eventType) || containsString(b.Apps, app)
+}
+
+// GetFloodSettings requests the flood settings of the Nakadi instance. Not all Nakadi instances expose them:
+// if Nakadi responds with 403 or 404 the returned error is caused by ErrFloodSettingsNotSupported, so that
+// callers can fall back to reacting on throttled requests. The settings are not cached.
+func (c *Client) GetFloodSettings() (*FloodSettings, error) {
+	const errMsg = "unable to request flood settings"
+
+	request, err := http.NewRequest("GET", c.nakadiURL+"/settings/blacklist", nil)
+	if err != nil {
+		return nil, errors.Wrap(err, errMsg)
+	}
+	c.setContentHeaders(request, false)
+	if err := c.decorateRequest(request); err != nil {
+		return nil, errors.Wrap(err, errMsg)
+	}
+
+	response, err := c.httpClient.Do(request)
+	if err != nil {
+		return nil, errors.Wrap(err, errMsg)
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		buffer, err := ioutil.ReadAll(response.Body)
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s: unable to read response body", errMsg)
+		}
+		err = decodeResponseToError(response.StatusCode, buffer, errMsg)
+		if response.StatusCode == http.StatusForbidden || response.StatusCode == http.StatusNotFound {
+			return nil, withSentinel(ErrFloodSettingsNotSupported, err)
+		}
+		return nil, err
+	}
+
+	decoded := struct {
+		Producers FloodBlocklist `json:"producers"`
+		Consumers FloodBlocklist `json:"consumers"`
+	}{}
+	if err := c.decodeJSON(response.Body, &decoded); err != nil {
+		return nil, errors.Wrapf(err, "%s: unable to decode response body", errMsg)
+	}
+	return &FloodSettings{Producers: decoded.Producers, Consumers: decoded.Consumers}, nil
+}
@@ -0,0 +1,134 @@
+package nakadi
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_Settings(t *testing.T) {
+	featuresURL := defaultNakadiURL + "/settings/features"
+	partitionStrategiesURL := defaultNakadiURL + "/registry/partition-strategies"
+	enrichmentStrategiesURL := defaultNakadiURL + "/registry/enrichment-strategies"
+	features := helperLoadTestData(t, "settings-features.json", nil)
+
+	setup := func() (*httpmock.MockTransport, *Client) {
+		transport := httpmock.NewMockTransport()
+		client := New(defaultNakadiURL, nil)
+		client.httpClient = &http.Client{Transport: transport}
+		transport.RegisterResponder("GET", featuresURL, httpmock.NewBytesResponder(http.StatusOK, features))
+		transport.RegisterResponder("GET", partitionStrategiesURL, httpmock.NewStringResponder(http.StatusOK, `["random","user_defined","hash"]`))
+		transport.RegisterResponder("GET", enrichmentStrategiesURL, httpmock.NewStringResponder(http.StatusOK, `["metadata_enrichment"]`))
+		return transport, client
+	}
+
+	t.Run("fail request features", func(t *testing.T) {
+		transport, client := setup()
+		transport.RegisterResponder("GET", featuresURL, httpmock.NewStringResponder(http.StatusForbidden, testProblemJSON))
+
+		_, err := client.Settings()
+		require.Error(t, err)
+		assert.Regexp(t, "unable to request features", err.Error())
+	})
+
+	t.Run("fail request partition strategies", func(t *testing.T) {
+		transport, client := setup()
+		transport.RegisterResponder("GET", partitionStrategiesURL, httpmock.NewStringResponder(http.StatusNotFound, testProblemJSON))
+
+		_, err := client.Settings()
+		require.Error(t, err)
+		assert.Regexp(t, "unable to request partition strategies", err.Error())
+	})
+
+	t.Run("success", func(t *testing.T) {
+		_, client := setup()
+
+		settings, err := client.Settings()
+		require.NoError(t, err)
+		assert.True(t, settings.FeatureEnabled(FeatureDisableEventTypeDeletion))
+		assert.False(t, settings.FeatureEnabled(FeatureDisableSubscriptionCreation))
+		assert.False(t, settings.FeatureEnabled("UNKNOWN_FEATURE"))
+		assert.Equal(t, []string{"random", "user_defined", "hash"}, settings.PartitionStrategies)
+		assert.Equal(t, []string{"metadata_enrichment"}, settings.EnrichmentStrategies)
+	})
+
+	t.Run("success cached", func(t *testing.T) {
+		transport, client := setup()
+
+		first, err := client.Settings()
+		require.NoError(t, err)
+		second, err := client.Settings()
+		require.NoError(t, err)
+
+		assert.Same(t, first, second)
+		assert.Equal(t, 1, transport.GetCallCountInfo()["GET "+featuresURL])
+	})
+
+	t.Run("success refresh", func(t *testing.T) {
+		transport, client := setup()
+
+		_, err := client.Settings()
+		require.NoError(t, err)
+
+		transport.RegisterResponder("GET", featuresURL, httpmock.NewStringResponder(http.StatusOK,
+			`{"items":[{"feature":"DISABLE_EVENT_TYPE_DELETION","enabled":false}]}`))
+		refreshed, err := client.RefreshSettings()
+		require.NoError(t, err)
+		assert.False(t, refreshed.FeatureEnabled(FeatureDisableEventTypeDeletion))
+
+		cached, err := client.Settings()
+		require.NoError(t, err)
+		assert.Same(t, refreshed, cached)
+	})
+}
+
+func TestClient_GetFloodSettings(t *testing.T) {
+	url := defaultNakadiURL + "/settings/blacklist"
+
+	setup := func(responder httpmock.Responder) *Client {
+		transport := httpmock.NewMockTransport()
+		transport.RegisterResponder("GET", url, responder)
+		client := New(defaultNakadiURL, nil)
+		client.httpClient = &http.Client{Transport: transport}
+		return client
+	}
+
+	for _, status := range []int{http.StatusForbidden, http.StatusNotFound} {
+		t.Run("fail not supported "+http.StatusText(status), func(t *testing.T) {
+			client := setup(httpmock.NewStringResponder(status, testProblemJSON))
+
+			_, err := client.GetFloodSettings()
+			require.Error(t, err)
+			assert.Equal(t, ErrFloodSettingsNotSupported, errors.Cause(err))
+			assert.Regexp(t, "unable to request flood settings: some problem detail", err)
+		})
+	}
+
+	t.Run("fail request", func(t *testing.T) {
+		client := setup(httpmock.NewStringResponder(http.StatusInternalServerError, testProblemJSON))
+
+		_, err := client.GetFloodSettings()
+		require.Error(t, err)
+		assert.NotEqual(t, ErrFloodSettingsNotSupported, errors.Cause(err))
+		assert.Regexp(t, "some problem detail", err)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		client := setup(httpmock.NewStringResponder(http.StatusOK, `{
+			"producers":{"event_types":["test-event.flood"],"apps":["flooding-app"]},
+			"consumers":{"event_types":[],"apps":["greedy-app"]}}`))
+
+		settings, err := client.GetFloodSettings()
+		require.NoError(t, err)
+		assert.Equal(t, []string{"test-event.flood"}, settings.Producers.EventTypes)
+		assert.Equal(t, []string{"greedy-app"}, settings.Consumers.Apps)
+		assert.True(t, settings.Producers.Blocked("test-event.flood", "test-app"))
+		assert.True(t, settings.Producers.Blocked("test-event", "flooding-app"))
+		assert.False(t, settings.Producers.Blocked("test-event", "test-app"))
+		assert.False(t, settings.Consumers.Blocked("test-event.flood", "test-app"))
+	})
+}
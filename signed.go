@@ -0,0 +1,39 @@
+package nakadi
+
+import (
+	"context"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// A SignedEvent is an event whose payload is protected by a signature, e.g. in order to verify its integrity
+// after it was consumed. Payload contains the exact bytes which were signed, like a canonicalized JSON
+// document, and Signature the signature of the payload in an encoding chosen by the producer. The event is
+// encoded as a JSON object with the members "metadata", "payload" and "signature", so the event type must
+// be of the category "undefined" or "business" with a schema that defines payload and signature as strings.
+//
+// Only the payload is covered by the signature. Nakadi enriches the metadata of each event and encodes events
+// again before they are delivered to consumers, and the client may set partition keys, the schema version
+// or compaction keys before publishing. Therefore the bytes of the whole event are not preserved, but the
+// payload is: it is embedded as a JSON string, which decodes to the same bytes however the event was encoded.
+type SignedEvent struct {
+	Metadata  EventMetadata `json:"metadata"`
+	Payload   string        `json:"payload"`
+	Signature string        `json:"signature,omitempty"`
+}
+
+// PublishSigned emits a batch of signed events like PublishContext. The payload of each event is sent
+// unchanged as a JSON string. Events with an empty payload or a payload which is not valid UTF-8, which
+// can't be embedded without changing its bytes, are rejected without sending them to Nakadi.
+func (p *PublishAPI) PublishSigned(ctx context.Context, events []SignedEvent) error {
+	for i, event := range events {
+		if event.Payload == "" {
+			return errors.Errorf("unable to publish signed events: event %d has no payload", i)
+		}
+		if !utf8.ValidString(event.Payload) {
+			return errors.Errorf("unable to publish signed events: payload of event %d is not valid UTF-8", i)
+		}
+	}
+	return p.PublishContext(ctx, events)
+}
@@ -0,0 +1,57 @@
+package nakadi
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublishAPI_PublishSigned(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	url := fmt.Sprintf("%s/event-types/%s/events", defaultNakadiURL, "test-event.signed")
+	client := &Client{nakadiURL: defaultNakadiURL, httpClient: http.DefaultClient}
+	publishAPI := NewPublishAPI(client, "test-event.signed", nil)
+	metadata := EventMetadata{EID: "9cd1a3c2-308a-4bb4-a8a2-aac3ba5c8cc4", OccurredAt: time.Date(2017, 8, 10, 20, 1, 45, 0, time.UTC)}
+
+	t.Run("fail empty payload", func(t *testing.T) {
+		err := publishAPI.PublishSigned(context.Background(), []SignedEvent{{Metadata: metadata}})
+		require.Error(t, err)
+		assert.Regexp(t, "event 0 has no payload", err)
+	})
+
+	t.Run("fail invalid payload", func(t *testing.T) {
+		err := publishAPI.PublishSigned(context.Background(), []SignedEvent{
+			{Metadata: metadata, Payload: `{"a":1}`},
+			{Metadata: metadata, Payload: "\xff"}})
+		require.Error(t, err)
+		assert.Regexp(t, "payload of event 1 is not valid UTF-8", err)
+	})
+
+	t.Run("success payload unchanged", func(t *testing.T) {
+		payload := `{"z": 1,  "a": "<b>&amp;</b>",
+	"nested": {"list": [3, 1, 2]}}`
+
+		var received []SignedEvent
+		httpmock.RegisterResponder("POST", url, func(r *http.Request) (*http.Response, error) {
+			received = nil
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+			return httpmock.NewStringResponse(http.StatusOK, ""), nil
+		})
+
+		events := []SignedEvent{{Metadata: metadata, Payload: payload, Signature: "c2lnbmF0dXJl"}}
+		require.NoError(t, publishAPI.PublishSigned(context.Background(), events))
+		require.Len(t, received, 1)
+		assert.Equal(t, payload, received[0].Payload)
+		assert.Equal(t, "c2lnbmF0dXJl", received[0].Signature)
+		assert.Equal(t, metadata.EID, received[0].Metadata.EID)
+	})
+}
@@ -3,6 +3,7 @@ package nakadi
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -10,55 +11,222 @@ import (
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/cenkalti/backoff/v3"
 	"github.com/pkg/errors"
 )
 
+const (
+	notReadyInitialRetryInterval = 50 * time.Millisecond
+	notReadyMaxRetryInterval     = 500 * time.Millisecond
+)
+
 // simpleStreamOpener implements the streamOpener interface.
 type simpleStreamOpener struct {
+	ctx                  context.Context
 	client               *Client
 	subscriptionID       string
 	batchLimit           uint
 	flushTimeout         uint
 	maxUncommittedEvents uint
+	streamKeepAliveLimit uint
+	streamTimeout        uint
+	connectTimeout       time.Duration
+	codec                Codec
+	notReadyRetryTime    time.Duration
+	retryIf              func(*http.Response, error) bool
+	bytesRead            *int64
+	// eventType and cursors are used instead of the subscription to open low level streams
+	eventType string
+	cursors   func() []Cursor
+	// partitions are requested explicitly instead of being assigned by Nakadi if set
+	partitions []streamPartition
+}
+
+// streamPartition identifies a partition which is requested for a stream of a subscription.
+type streamPartition struct {
+	EventType string `json:"event_type"`
+	Partition string `json:"partition"`
 }
 
 func (so *simpleStreamOpener) openStream() (streamer, error) {
-	req, err := http.NewRequest("GET", so.streamURL(so.subscriptionID), nil)
+	if so.notReadyRetryTime <= 0 {
+		stream, _, err := so.openStreamOnce()
+		return stream, err
+	}
+
+	ctx := so.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	notReadyBackOff := (&backOffConfiguration{
+		Retry:                true,
+		InitialRetryInterval: notReadyInitialRetryInterval,
+		MaxRetryInterval:     notReadyMaxRetryInterval,
+		MaxElapsedTime:       so.notReadyRetryTime}).create()
+
+	var stream streamer
+	err := backoff.Retry(func() error {
+		var response *http.Response
+		var err error
+		stream, response, err = so.openStreamOnce()
+		if err != nil && !so.retryOpen(response, err) {
+			return backoff.Permanent(err)
+		}
+		return err
+	}, backoff.WithContext(notReadyBackOff, ctx))
+
+	return stream, err
+}
+
+// retryOpen decides whether a failed attempt to open the stream is retried right away.
+func (so *simpleStreamOpener) retryOpen(response *http.Response, err error) bool {
+	if so.retryIf != nil {
+		return so.retryIf(response, err)
+	}
+	return response != nil && (response.StatusCode == http.StatusNotFound || response.StatusCode == http.StatusConflict)
+}
+
+// openStreamOnce makes a single attempt to open the stream. On errors caused by a response of Nakadi the response
+// is returned along with the error, its body can still be read.
+func (so *simpleStreamOpener) openStreamOnce() (streamer, *http.Response, error) {
+	req, err := so.newRequest()
 	if err != nil {
-		return nil, errors.Wrap(err, "unable to create request")
+		return nil, nil, errors.Wrap(err, "unable to create request")
 	}
+	ctx := so.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	req = req.WithContext(ctx)
 
-	if so.client.tokenProvider != nil {
-		token, err := so.client.tokenProvider()
-		if err != nil {
-			return nil, errors.Wrap(err, "unable to open stream")
+	if so.codec != nil && so.codec.MediaType() != "" {
+		req.Header.Set("Accept", so.codec.MediaType())
+	}
+	if so.cursors != nil {
+		if cursors := so.cursors(); len(cursors) > 0 {
+			header, err := encodeCursorsHeader(cursors)
+			if err != nil {
+				cancel()
+				return nil, nil, errors.Wrap(err, "unable to open stream")
+			}
+			req.Header.Set("X-Nakadi-Cursors", header)
 		}
-		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if err := so.client.decorateRequest(req); err != nil {
+		cancel()
+		return nil, nil, errors.Wrap(err, "unable to open stream")
 	}
 
+	var timer *time.Timer
+	if so.connectTimeout > 0 {
+		timer = time.AfterFunc(so.connectTimeout, cancel)
+	}
+
+	started := time.Now()
 	response, err := so.client.httpStreamClient.Do(req)
+	// the timer has already canceled the request if it can't be stopped anymore
+	timedOut := timer != nil && !timer.Stop()
+	if timedOut && err == nil {
+		response.Body.Close()
+		err = ErrStreamConnectTimeout
+	}
+	if so.eventType != "" {
+		so.client.logSlowRequest(started, OperationOpenStream, "event_type", so.eventType)
+	} else {
+		so.client.logSlowRequest(started, OperationOpenStream, "subscription", so.subscriptionID)
+	}
+	so.client.collectRequest(started, RequestMetrics{
+		Operation:      OperationOpenStream,
+		EventType:      so.eventType,
+		SubscriptionID: so.subscriptionID}, response, err)
+	so.client.inspectResponse(OperationOpenStream, response)
 	if err != nil {
-		return nil, errors.Wrap(err, "unable to create stream")
+		cancel()
+		if timedOut {
+			return nil, nil, errors.Wrap(ErrStreamConnectTimeout, "unable to create stream")
+		}
+		return nil, nil, errors.Wrap(err, "unable to create stream")
 	}
 
 	if response.StatusCode >= 400 {
+		defer cancel()
 		buffer, err := ioutil.ReadAll(response.Body)
 		if err != nil {
-			return nil, errors.Wrap(err, "unable to read response body")
+			return nil, nil, errors.Wrap(err, "unable to read response body")
+		}
+		response.Body = ioutil.NopCloser(bytes.NewReader(buffer))
+		err = decodeResponseToError(response.StatusCode, buffer, "unable to open stream")
+		if response.StatusCode == http.StatusNotFound {
+			err = notFoundError{err}
 		}
-		return nil, decodeResponseToError(buffer, "unable to open stream")
+		return nil, response, err
+	}
+
+	var body io.Reader = response.Body
+	if so.bytesRead != nil {
+		body = &countingReader{reader: body, count: so.bytesRead}
+	}
+
+	encoding := response.Header.Get("Content-Encoding")
+	if encoding == "" && response.Uncompressed {
+		encoding = "gzip"
 	}
+	info := StreamInfo{
+		StreamID:        response.Header.Get("X-Nakadi-StreamId"),
+		ConnectedAt:     time.Now(),
+		ContentType:     response.Header.Get("Content-Type"),
+		ContentEncoding: encoding,
+		StatusCode:      response.StatusCode}
 
 	s := &simpleStream{
-		nakadiStreamID: response.Header.Get("X-Nakadi-StreamId"),
-		buffer:         bufio.NewReader(response.Body),
-		closer:         response.Body,
+		nakadiStreamID: info.StreamID,
+		info:           info,
+		buffer:         bufio.NewReader(body),
+		closer:         cancelCloser{Closer: response.Body, cancel: cancel},
 		readTimeout:    2 * nakadiHeartbeatInterval,
+		codec:          so.codec,
 	}
 
-	return s, nil
+	return s, nil, nil
+}
+
+// newRequest creates the request which opens the stream. Streams of specific partitions are requested with a
+// body which contains the partitions as well as all stream parameters.
+func (so *simpleStreamOpener) newRequest() (*http.Request, error) {
+	if so.eventType != "" {
+		return http.NewRequest("GET", so.eventTypeStreamURL(), nil)
+	}
+	if len(so.partitions) == 0 {
+		return http.NewRequest("GET", so.streamURL(so.subscriptionID), nil)
+	}
+
+	body, err := json.Marshal(struct {
+		Partitions           []streamPartition `json:"partitions"`
+		BatchLimit           uint              `json:"batch_limit,omitempty"`
+		FlushTimeout         uint              `json:"batch_flush_timeout,omitempty"`
+		MaxUncommittedEvents uint              `json:"max_uncommitted_events,omitempty"`
+		StreamKeepAliveLimit uint              `json:"stream_keep_alive_limit,omitempty"`
+		StreamTimeout        uint              `json:"stream_timeout,omitempty"`
+	}{so.partitions, so.batchLimit, so.flushTimeout, so.maxUncommittedEvents, so.streamKeepAliveLimit, so.streamTimeout})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", fmt.Sprintf("%s/subscriptions/%s/events", so.client.nakadiURL, so.subscriptionID), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	contentType := so.client.contentType
+	if contentType == "" {
+		contentType = defaultContentType
+	}
+	req.Header.Set("Content-Type", contentType)
+	return req, nil
 }
 
 func (so *simpleStreamOpener) streamURL(id string) string {
@@ -72,16 +240,85 @@ func (so *simpleStreamOpener) streamURL(id string) string {
 	if so.maxUncommittedEvents > 0 {
 		queryParams.Add("max_uncommitted_events", strconv.FormatUint(uint64(so.maxUncommittedEvents), 10))
 	}
+	if so.streamKeepAliveLimit > 0 {
+		queryParams.Add("stream_keep_alive_limit", strconv.FormatUint(uint64(so.streamKeepAliveLimit), 10))
+	}
+	if so.streamTimeout > 0 {
+		queryParams.Add("stream_timeout", strconv.FormatUint(uint64(so.streamTimeout), 10))
+	}
 
 	return fmt.Sprintf("%s/subscriptions/%s/events?%s", so.client.nakadiURL, id, queryParams.Encode())
 }
 
+func (so *simpleStreamOpener) eventTypeStreamURL() string {
+	queryParams := url.Values{}
+	if so.batchLimit > 0 {
+		queryParams.Add("batch_limit", strconv.FormatUint(uint64(so.batchLimit), 10))
+	}
+	if so.flushTimeout > 0 {
+		queryParams.Add("batch_flush_timeout", strconv.FormatUint(uint64(so.flushTimeout), 10))
+	}
+	if so.streamKeepAliveLimit > 0 {
+		queryParams.Add("stream_keep_alive_limit", strconv.FormatUint(uint64(so.streamKeepAliveLimit), 10))
+	}
+
+	return fmt.Sprintf("%s%s?%s", so.client.nakadiURL, eventsPath(so.eventType), queryParams.Encode())
+}
+
+// encodeCursorsHeader encodes the cursors for the X-Nakadi-Cursors header of low level streams, which only
+// accepts the partition and the offset of each cursor.
+func encodeCursorsHeader(cursors []Cursor) (string, error) {
+	type headerCursor struct {
+		Partition string `json:"partition"`
+		Offset    string `json:"offset"`
+	}
+	headerCursors := make([]headerCursor, len(cursors))
+	for i, cursor := range cursors {
+		headerCursors[i] = headerCursor{Partition: cursor.Partition, Offset: cursor.Offset}
+	}
+
+	encoded, err := json.Marshal(headerCursors)
+	if err != nil {
+		return "", errors.Wrap(err, "unable to encode cursors")
+	}
+	return string(encoded), nil
+}
+
+// cancelCloser closes the body of a response and releases the context of the respective request.
+type cancelCloser struct {
+	io.Closer
+	cancel context.CancelFunc
+}
+
+func (c cancelCloser) Close() error {
+	defer c.cancel()
+	return c.Closer.Close()
+}
+
+// countingReader adds the number of bytes read from the underlying reader to a counter.
+type countingReader struct {
+	reader io.Reader
+	count  *int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	atomic.AddInt64(r.count, int64(n))
+	return n, err
+}
+
 // simpleStream implements the streamer interface.
 type simpleStream struct {
 	nakadiStreamID string
+	info           StreamInfo
 	buffer         *bufio.Reader
 	closer         io.Closer
 	readTimeout    time.Duration
+	codec          Codec
+}
+
+func (s *simpleStream) streamInfo() StreamInfo {
+	return s.info
 }
 
 func (s *simpleStream) nextEvents() (Cursor, []byte, error) {
@@ -89,42 +326,20 @@ func (s *simpleStream) nextEvents() (Cursor, []byte, error) {
 		return Cursor{}, nil, errors.New("failed to read next batch: stream is closed")
 	}
 
-	fragment, isPrefix, err := s.readLineTimeout()
-	if err != nil {
-		return Cursor{}, nil, errors.Wrap(err, "failed to read next batch")
+	codec := s.codec
+	if codec == nil {
+		codec = JSONCodec{}
 	}
-	line := make([]byte, len(fragment))
-	copy(line, fragment)
 
-	for isPrefix {
-		var add []byte
-		add, isPrefix, err = s.readLineTimeout()
-		if err != nil {
-			return Cursor{}, nil, errors.Wrap(err, "failed to read next batch")
-		}
-		line = append(line, add...)
-	}
-
-	batch := struct {
-		Cursor Cursor           `json:"cursor"`
-		Events *json.RawMessage `json:"events"`
-	}{}
-	err = json.Unmarshal(line, &batch)
+	timer := time.AfterFunc(s.readTimeout, func() { s.closer.Close() })
+	cursor, events, err := codec.ReadBatch(s.buffer)
+	timer.Stop()
 	if err != nil {
-		return Cursor{}, nil, errors.Wrap(err, "failed to unmarshal next batch")
+		return Cursor{}, nil, err
 	}
-	batch.Cursor.NakadiStreamID = s.nakadiStreamID
+	cursor.NakadiStreamID = s.nakadiStreamID
 
-	if batch.Events == nil {
-		return batch.Cursor, nil, nil
-	}
-	return batch.Cursor, []byte(*batch.Events), nil
-}
-
-func (s *simpleStream) readLineTimeout() ([]byte, bool, error) {
-	timer := time.AfterFunc(s.readTimeout, func() { s.closer.Close() })
-	defer timer.Stop()
-	return s.buffer.ReadLine()
+	return cursor, events, nil
 }
 
 func (s *simpleStream) closeStream() error {
@@ -135,35 +350,63 @@ func (s *simpleStream) closeStream() error {
 // simpleCommitter implements the committer interface.
 type simpleCommitter struct {
 	client         *Client
+	idMutex        sync.Mutex
 	subscriptionID string
+	timeout        time.Duration
+	allOrNothing   bool
 }
 
-func (s *simpleCommitter) commitCursor(cursor Cursor) error {
-	wrap := &struct {
-		Items []Cursor `json:"items"`
-	}{Items: []Cursor{cursor}}
+// setSubscriptionID changes the subscription to which cursors are committed, e.g. after it was recreated.
+func (s *simpleCommitter) setSubscriptionID(id string) {
+	s.idMutex.Lock()
+	defer s.idMutex.Unlock()
+	s.subscriptionID = id
+}
 
-	data, err := json.Marshal(wrap)
+func (s *simpleCommitter) commitCursors(cursors []Cursor) error {
+	if len(cursors) == 0 {
+		return nil
+	}
+
+	s.idMutex.Lock()
+	subscriptionID := s.subscriptionID
+	s.idMutex.Unlock()
+
+	data, err := encodeCommitBody(s.client.commitBody, cursors)
 	if err != nil {
 		return errors.Wrap(err, "unable to unmarshal cursor")
 	}
 
-	req, err := http.NewRequest("POST", s.commitURL(s.subscriptionID), bytes.NewReader(data))
+	req, err := http.NewRequest("POST", s.commitURL(subscriptionID), bytes.NewReader(data))
 	if err != nil {
 		return errors.Wrap(err, "unable to create request")
 	}
-	req.Header.Set("Content-Type", "application/json;charset=UTF-8")
-	req.Header.Set("X-Nakadi-StreamId", cursor.NakadiStreamID)
-	if s.client.tokenProvider != nil {
-		token, err := s.client.tokenProvider()
-		if err != nil {
-			return errors.Wrap(err, "unable to commit cursor")
-		}
-		req.Header.Set("Authorization", "Bearer "+token)
+	s.client.setContentHeaders(req, true)
+	req.Header.Set("X-Nakadi-StreamId", cursors[0].NakadiStreamID)
+	if s.timeout > 0 {
+		ctx, cancel := context.WithTimeout(req.Context(), s.timeout)
+		defer cancel()
+		req = req.WithContext(ctx)
+	}
+	if err := s.client.decorateRequest(req); err != nil {
+		return errors.Wrap(err, "unable to commit cursor")
 	}
 
+	started := time.Now()
 	response, err := s.client.httpClient.Do(req)
+	eventTypes := cursorEventTypes(cursors)
+	s.client.logSlowRequest(started, OperationCommit, "subscription", subscriptionID, "event_type", strings.Join(eventTypes, ","))
+	for _, eventType := range eventTypes {
+		s.client.collectRequest(started, RequestMetrics{
+			Operation:      OperationCommit,
+			EventType:      eventType,
+			SubscriptionID: subscriptionID}, response, err)
+	}
+	s.client.inspectResponse(OperationCommit, response)
 	if err != nil {
+		if req.Context().Err() == context.DeadlineExceeded {
+			return errors.Wrap(ErrCommitTimeout, "unable to commit cursor")
+		}
 		return errors.Wrap(err, "unable to commit cursor")
 	}
 	defer response.Body.Close()
@@ -171,14 +414,55 @@ func (s *simpleCommitter) commitCursor(cursor Cursor) error {
 	if response.StatusCode >= 400 {
 		buffer, err := ioutil.ReadAll(response.Body)
 		if err != nil {
+			if req.Context().Err() == context.DeadlineExceeded {
+				return errors.Wrap(ErrCommitTimeout, "unable to commit cursor")
+			}
 			return errors.Wrap(err, "unable to read response body")
 		}
-		return decodeResponseToError(buffer, "unable to commit cursor")
+		return decodeResponseToError(response.StatusCode, buffer, "unable to commit cursor")
 	}
 
+	// Nakadi answers with 200 and the result of each cursor if some cursors were not committed
+	if s.allOrNothing && response.StatusCode == http.StatusOK {
+		return checkCommitResults(response.Body, cursors[0].NakadiStreamID)
+	}
 	return nil
 }
 
+// checkCommitResults decodes the results of a commit and returns a CommitResultError unless all cursors were
+// committed.
+func checkCommitResults(body io.Reader, streamID string) error {
+	results := struct {
+		Items []CommitResult `json:"items"`
+	}{}
+	if err := json.NewDecoder(body).Decode(&results); err != nil && err != io.EOF {
+		return errors.Wrap(err, "unable to decode commit results")
+	}
+
+	committed := true
+	for i, r := range results.Items {
+		results.Items[i].Cursor.NakadiStreamID = streamID
+		committed = committed && r.Result == CommitResultCommitted
+	}
+	if !committed {
+		return CommitResultError{Results: results.Items}
+	}
+	return nil
+}
+
+// cursorEventTypes returns the distinct event types of the cursors in order of their first occurrence.
+func cursorEventTypes(cursors []Cursor) []string {
+	var eventTypes []string
+	seen := make(map[string]bool)
+	for _, cursor := range cursors {
+		if !seen[cursor.EventType] {
+			seen[cursor.EventType] = true
+			eventTypes = append(eventTypes, cursor.EventType)
+		}
+	}
+	return eventTypes
+}
+
 func (s *simpleCommitter) commitURL(id string) string {
 	return fmt.Sprintf("%s/subscriptions/%s/cursors", s.client.nakadiURL, id)
 }
@@ -2,14 +2,21 @@ package nakadi
 
 import (
 	"bufio"
+	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"net/url"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/jarcoal/httpmock"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -51,6 +58,56 @@ func TestSimpleStreamOpener_openStream(t *testing.T) {
 		assert.Regexp(t, assert.AnError.Error(), err.Error())
 	})
 
+	t.Run("fail canceled context", func(t *testing.T) {
+		opener := setupOpener()
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		opener.ctx = ctx
+		httpmock.RegisterResponder("GET", url, helperCanceledResponder())
+
+		_, err := opener.openStream()
+		require.Error(t, err)
+		assert.Regexp(t, context.Canceled.Error(), err.Error())
+	})
+
+	t.Run("fail connect timeout", func(t *testing.T) {
+		opener := setupOpener()
+		opener.connectTimeout = 10 * time.Millisecond
+		httpmock.RegisterResponder("GET", url, helperCanceledResponder())
+
+		_, err := opener.openStream()
+		require.Error(t, err)
+		assert.Equal(t, ErrStreamConnectTimeout, errors.Cause(err))
+	})
+
+	t.Run("fail connect timeout elapsed with response", func(t *testing.T) {
+		opener := setupOpener()
+		opener.connectTimeout = 10 * time.Millisecond
+		httpmock.RegisterResponder("GET", url, func(r *http.Request) (*http.Response, error) {
+			time.Sleep(20 * time.Millisecond)
+			return httpmock.NewStringResponse(200, ""), nil
+		})
+
+		_, err := opener.openStream()
+		require.Error(t, err)
+		assert.Equal(t, ErrStreamConnectTimeout, errors.Cause(err))
+	})
+
+	t.Run("success with connect timeout", func(t *testing.T) {
+		opener := setupOpener()
+		opener.connectTimeout = 10 * time.Millisecond
+		events := helperLoadTestData(t, "data-event-stream.json", nil)
+		httpmock.RegisterResponder("GET", url, httpmock.NewBytesResponder(200, events))
+
+		stream, err := opener.openStream()
+		require.NoError(t, err)
+		defer stream.closeStream()
+
+		time.Sleep(20 * time.Millisecond)
+		_, _, err = stream.nextEvents()
+		assert.NoError(t, err)
+	})
+
 	t.Run("fail connect error", func(t *testing.T) {
 		opener := setupOpener()
 		httpmock.RegisterResponder("GET", url, httpmock.NewErrorResponder(assert.AnError))
@@ -84,6 +141,100 @@ func TestSimpleStreamOpener_openStream(t *testing.T) {
 		assert.Regexp(t, "unable to read response body", err.Error())
 	})
 
+	t.Run("success after subscription not ready", func(t *testing.T) {
+		opener := setupOpener()
+		opener.notReadyRetryTime = time.Second
+		calls := 0
+		httpmock.RegisterResponder("GET", url, func(r *http.Request) (*http.Response, error) {
+			calls++
+			if calls == 1 {
+				return httpmock.NewJsonResponse(404, &problemJSON{Detail: "subscription not found"})
+			}
+			return httpmock.NewJsonResponse(200, sub)
+		})
+
+		stream, err := opener.openStream()
+		require.NoError(t, err)
+		require.NotNil(t, stream)
+		assert.Equal(t, 2, calls)
+	})
+
+	t.Run("fail subscription not ready without retry", func(t *testing.T) {
+		opener := setupOpener()
+		calls := 0
+		httpmock.RegisterResponder("GET", url, func(r *http.Request) (*http.Response, error) {
+			calls++
+			return httpmock.NewJsonResponse(409, &problemJSON{Detail: "no free slots"})
+		})
+
+		_, err := opener.openStream()
+		require.Error(t, err)
+		assert.Regexp(t, "no free slots", err.Error())
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("fail not ready retry only on 404 and 409", func(t *testing.T) {
+		opener := setupOpener()
+		opener.notReadyRetryTime = time.Second
+		calls := 0
+		httpmock.RegisterResponder("GET", url, func(r *http.Request) (*http.Response, error) {
+			calls++
+			return httpmock.NewJsonResponse(400, &problemJSON{Detail: "foo problem detail"})
+		})
+
+		_, err := opener.openStream()
+		require.Error(t, err)
+		assert.Regexp(t, "foo problem detail", err.Error())
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("success retry with custom predicate", func(t *testing.T) {
+		opener := setupOpener()
+		opener.notReadyRetryTime = time.Second
+		opener.retryIf = func(response *http.Response, err error) bool {
+			return response != nil && response.StatusCode == http.StatusUnprocessableEntity
+		}
+		calls := 0
+		httpmock.RegisterResponder("GET", url, func(r *http.Request) (*http.Response, error) {
+			calls++
+			if calls == 1 {
+				return httpmock.NewJsonResponse(422, &problemJSON{Detail: "transient problem"})
+			}
+			return httpmock.NewJsonResponse(200, sub)
+		})
+
+		stream, err := opener.openStream()
+		require.NoError(t, err)
+		require.NotNil(t, stream)
+		assert.Equal(t, 2, calls)
+	})
+
+	t.Run("fail custom predicate overrides not ready retry", func(t *testing.T) {
+		opener := setupOpener()
+		opener.notReadyRetryTime = time.Second
+		opener.retryIf = func(*http.Response, error) bool { return false }
+		calls := 0
+		httpmock.RegisterResponder("GET", url, func(r *http.Request) (*http.Response, error) {
+			calls++
+			return httpmock.NewJsonResponse(404, &problemJSON{Detail: "subscription not found"})
+		})
+
+		_, err := opener.openStream()
+		require.Error(t, err)
+		assert.Equal(t, 1, calls)
+	})
+
+	t.Run("fail subscription not ready after retries", func(t *testing.T) {
+		opener := setupOpener()
+		opener.notReadyRetryTime = 100 * time.Millisecond
+		responder, _ := httpmock.NewJsonResponder(404, &problemJSON{Detail: "subscription not found"})
+		httpmock.RegisterResponder("GET", url, responder)
+
+		_, err := opener.openStream()
+		require.Error(t, err)
+		assert.Regexp(t, "subscription not found", err.Error())
+	})
+
 	t.Run("success without token", func(t *testing.T) {
 		opener := setupOpener()
 		responder, _ := httpmock.NewJsonResponder(200, sub)
@@ -94,6 +245,36 @@ func TestSimpleStreamOpener_openStream(t *testing.T) {
 		require.NotNil(t, stream)
 	})
 
+	t.Run("success count bytes read", func(t *testing.T) {
+		opener := setupOpener()
+		var bytesRead int64
+		opener.bytesRead = &bytesRead
+		events := helperLoadTestData(t, "data-event-stream.json", nil)
+		httpmock.RegisterResponder("GET", url, httpmock.NewBytesResponder(200, events))
+
+		stream, err := opener.openStream()
+		require.NoError(t, err)
+		defer stream.closeStream()
+
+		for err == nil {
+			_, _, err = stream.nextEvents()
+		}
+		assert.Equal(t, int64(len(events)), atomic.LoadInt64(&bytesRead))
+	})
+
+	t.Run("success with codec media type", func(t *testing.T) {
+		opener := setupOpener()
+		opener.codec = lengthPrefixCodec{}
+		httpmock.RegisterResponder("GET", url, func(r *http.Request) (*http.Response, error) {
+			assert.Equal(t, "application/x-test-binary", r.Header.Get("Accept"))
+			return httpmock.NewStringResponse(200, ""), nil
+		})
+
+		stream, err := opener.openStream()
+		require.NoError(t, err)
+		require.NotNil(t, stream)
+	})
+
 	t.Run("success with token", func(t *testing.T) {
 		opener := setupOpener()
 		responder, _ := httpmock.NewJsonResponder(200, sub)
@@ -103,6 +284,61 @@ func TestSimpleStreamOpener_openStream(t *testing.T) {
 		require.NoError(t, err)
 		require.NotNil(t, stream)
 	})
+
+	t.Run("success stream info", func(t *testing.T) {
+		opener := setupOpener()
+		httpmock.RegisterResponder("GET", url, func(r *http.Request) (*http.Response, error) {
+			response := httpmock.NewStringResponse(200, "")
+			response.Header.Set("X-Nakadi-StreamId", "stream-id")
+			response.Header.Set("Content-Type", "application/x-json-stream")
+			response.Header.Set("Content-Encoding", "gzip")
+			return response, nil
+		})
+
+		started := time.Now()
+		stream, err := opener.openStream()
+		require.NoError(t, err)
+		defer stream.closeStream()
+
+		info := stream.(*simpleStream).streamInfo()
+		assert.Equal(t, "stream-id", info.StreamID)
+		assert.Equal(t, "application/x-json-stream", info.ContentType)
+		assert.Equal(t, "gzip", info.ContentEncoding)
+		assert.Equal(t, http.StatusOK, info.StatusCode)
+		assert.False(t, info.ConnectedAt.Before(started))
+
+		streamAPI := &StreamAPI{}
+		assert.Equal(t, StreamInfo{}, streamAPI.Info())
+		streamAPI.setInfo(stream)
+		assert.Equal(t, info, streamAPI.Info())
+		streamAPI.setInfo(&mockStreamer{})
+		assert.Equal(t, info, streamAPI.Info())
+	})
+}
+
+func TestSimpleStreamOpener_streamURL(t *testing.T) {
+	opener := &simpleStreamOpener{
+		client:               &Client{nakadiURL: defaultNakadiURL},
+		batchLimit:           10,
+		flushTimeout:         5,
+		maxUncommittedEvents: 20,
+		streamKeepAliveLimit: 3,
+		streamTimeout:        60}
+
+	streamURL, err := url.Parse(opener.streamURL("sub-id"))
+	require.NoError(t, err)
+
+	assert.Equal(t, "/subscriptions/sub-id/events", streamURL.Path)
+	assert.Equal(t, "10", streamURL.Query().Get("batch_limit"))
+	assert.Equal(t, "5", streamURL.Query().Get("batch_flush_timeout"))
+	assert.Equal(t, "20", streamURL.Query().Get("max_uncommitted_events"))
+	assert.Equal(t, "3", streamURL.Query().Get("stream_keep_alive_limit"))
+	assert.Equal(t, "60", streamURL.Query().Get("stream_timeout"))
+
+	opener = &simpleStreamOpener{client: &Client{nakadiURL: defaultNakadiURL}}
+	streamURL, err = url.Parse(opener.streamURL("sub-id"))
+	require.NoError(t, err)
+	assert.Empty(t, streamURL.RawQuery)
 }
 
 func TestSimpleStream_nextEvents(t *testing.T) {
@@ -141,6 +377,21 @@ func TestSimpleStream_nextEvents(t *testing.T) {
 		assert.Regexp(t, "EOF", err.Error())
 	})
 
+	t.Run("fail truncated batch", func(t *testing.T) {
+		batch := `{"cursor":{"partition":"0","offset":"1"},"events":[{"metadata":{"eid":"74450ab6-5461-11e7-9dd2-87c3afa8811f"}}]}`
+		stream := setupStream(httpmock.NewStringResponder(200, batch+"\n"+batch[:len(batch)/2]))
+
+		cursor, events, err := stream.nextEvents()
+		require.NoError(t, err)
+		assert.Equal(t, "1", cursor.Offset)
+		assert.NotEmpty(t, events)
+
+		_, events, err = stream.nextEvents()
+		require.Error(t, err)
+		assert.Equal(t, io.ErrUnexpectedEOF, errors.Cause(err))
+		assert.Nil(t, events)
+	})
+
 	t.Run("fail unmarshal event", func(t *testing.T) {
 		stream := setupStream(httpmock.NewStringResponder(200, "not\n a\n event\n"))
 
@@ -159,6 +410,59 @@ func TestSimpleStream_nextEvents(t *testing.T) {
 			assert.Equal(t, "stream-id", cursor.NakadiStreamID)
 		}
 	})
+
+	t.Run("successfully read with custom codec", func(t *testing.T) {
+		stream := setupStream(httpmock.NewStringResponder(200, "\x013\x04\x00\x01\x02\x03"))
+		stream.codec = lengthPrefixCodec{}
+
+		cursor, events, err := stream.nextEvents()
+		require.NoError(t, err)
+		assert.Equal(t, "3", cursor.Partition)
+		assert.Equal(t, "stream-id", cursor.NakadiStreamID)
+		assert.Equal(t, []byte{0, 1, 2, 3}, events)
+	})
+
+	t.Run("successfully read large batch", func(t *testing.T) {
+		payload := strings.Repeat("x", 1024*1024)
+		events := fmt.Sprintf(`[{"metadata":{"eid":"74450ab6-5461-11e7-9dd2-87c3afa8811f"},"payload":"%s"}]`, payload)
+		batch := fmt.Sprintf(`{"cursor":{"partition":"0","offset":"1"},"events":%s}`, events)
+		stream := setupStream(httpmock.NewStringResponder(200, batch+"\n"+batch+"\n"))
+
+		for i := 0; i < 2; i++ {
+			cursor, received, err := stream.nextEvents()
+			require.NoError(t, err)
+			assert.Equal(t, "1", cursor.Offset)
+			assert.Equal(t, events, string(received))
+		}
+	})
+}
+
+// lengthPrefixCodec is a Codec for a binary test format: each batch consists of the partition and the
+// events of the batch, both prefixed by their length as single byte.
+type lengthPrefixCodec struct{}
+
+func (lengthPrefixCodec) MediaType() string { return "application/x-test-binary" }
+
+func (lengthPrefixCodec) ReadBatch(reader *bufio.Reader) (Cursor, []byte, error) {
+	read := func() ([]byte, error) {
+		length, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		data := make([]byte, length)
+		_, err = io.ReadFull(reader, data)
+		return data, err
+	}
+
+	partition, err := read()
+	if err != nil {
+		return Cursor{}, nil, err
+	}
+	events, err := read()
+	if err != nil {
+		return Cursor{}, nil, err
+	}
+	return Cursor{Partition: string(partition)}, events, nil
 }
 
 type fakeCloser struct {
@@ -205,7 +509,7 @@ func TestSimpleCommitter_commitEvents(t *testing.T) {
 		stream := setupCommitter(httpmock.NewStringResponder(200, ""))
 		stream.client.tokenProvider = func() (string, error) { return "", assert.AnError }
 
-		err := stream.commitCursor(Cursor{})
+		err := stream.commitCursors([]Cursor{{}})
 		require.Error(t, err)
 		assert.Regexp(t, assert.AnError, err)
 	})
@@ -213,7 +517,7 @@ func TestSimpleCommitter_commitEvents(t *testing.T) {
 	t.Run("fail connect error", func(t *testing.T) {
 		stream := setupCommitter(httpmock.NewErrorResponder(assert.AnError))
 
-		err := stream.commitCursor(Cursor{})
+		err := stream.commitCursors([]Cursor{{}})
 		require.Error(t, err)
 		assert.Regexp(t, assert.AnError, err)
 	})
@@ -223,7 +527,7 @@ func TestSimpleCommitter_commitEvents(t *testing.T) {
 		responder, _ := httpmock.NewJsonResponder(400, &problem)
 		stream := setupCommitter(responder)
 
-		err := stream.commitCursor(Cursor{})
+		err := stream.commitCursors([]Cursor{{}})
 		require.Error(t, err)
 		assert.Regexp(t, problem.Detail, err)
 	})
@@ -235,15 +539,112 @@ func TestSimpleCommitter_commitEvents(t *testing.T) {
 		})
 		stream := setupCommitter(responder)
 
-		err := stream.commitCursor(Cursor{})
+		err := stream.commitCursors([]Cursor{{}})
 		require.Error(t, err)
 		assert.Regexp(t, "unable to read response body", err)
 	})
 
+	t.Run("fail commit timeout", func(t *testing.T) {
+		stream := setupCommitter(func(r *http.Request) (*http.Response, error) {
+			select {
+			case <-r.Context().Done():
+				return nil, r.Context().Err()
+			case <-time.After(time.Second):
+				return httpmock.NewStringResponse(204, ""), nil
+			}
+		})
+		stream.timeout = 20 * time.Millisecond
+
+		started := time.Now()
+		err := stream.commitCursors([]Cursor{{}})
+		require.Error(t, err)
+		assert.Equal(t, ErrCommitTimeout, errors.Cause(err))
+		assert.True(t, time.Since(started) < time.Second)
+	})
+
+	t.Run("commit only the given cursors", func(t *testing.T) {
+		cursor := Cursor{EventType: "test", Partition: "1", Offset: "3", NakadiStreamID: "stream-id"}
+		stream := setupCommitter(func(r *http.Request) (*http.Response, error) {
+			body := &struct {
+				Items []Cursor `json:"items"`
+			}{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(body))
+			assert.Equal(t, []Cursor{{EventType: "test", Partition: "1", Offset: "3"}}, body.Items)
+			assert.Equal(t, "stream-id", r.Header.Get("X-Nakadi-StreamId"))
+			return httpmock.NewStringResponse(204, ""), nil
+		})
+
+		err := stream.commitCursors([]Cursor{cursor})
+		require.NoError(t, err)
+	})
+
+	t.Run("commit body shapes", func(t *testing.T) {
+		cursor := Cursor{EventType: "test", Partition: "1", Offset: "3", NakadiStreamID: "stream-id"}
+		shapes := map[CommitBodyShape]string{
+			CommitBodyItems: `{"items":[{"partition":"1","offset":"3","event_type":"test","cursor_token":""}]}`,
+			CommitBodyArray: `[{"partition":"1","offset":"3","event_type":"test","cursor_token":""}]`}
+
+		for shape, expected := range shapes {
+			stream := setupCommitter(func(r *http.Request) (*http.Response, error) {
+				body, err := ioutil.ReadAll(r.Body)
+				require.NoError(t, err)
+				assert.JSONEq(t, expected, string(body))
+				return httpmock.NewStringResponse(204, ""), nil
+			})
+			stream.client.commitBody = shape
+
+			err := stream.commitCursors([]Cursor{cursor})
+			require.NoError(t, err)
+		}
+	})
+
+	t.Run("commit cursor token of received batch", func(t *testing.T) {
+		batch := `{"cursor":{"partition":"0","offset":"001-0001-000000000000000004","event_type":"test","cursor_token":"b75c3102-98a4-4385-a5fd-b96f1d7872f2"},"events":[]}`
+		cursor, _, err := JSONCodec{}.ReadBatch(bufio.NewReader(strings.NewReader(batch + "\n")))
+		require.NoError(t, err)
+
+		stream := setupCommitter(func(r *http.Request) (*http.Response, error) {
+			body, err := ioutil.ReadAll(r.Body)
+			require.NoError(t, err)
+			assert.JSONEq(t, `{"items":[{"partition":"0","offset":"001-0001-000000000000000004","event_type":"test","cursor_token":"b75c3102-98a4-4385-a5fd-b96f1d7872f2"}]}`, string(body))
+			return httpmock.NewStringResponse(204, ""), nil
+		})
+
+		err = stream.commitCursors([]Cursor{cursor})
+		require.NoError(t, err)
+	})
+
+	t.Run("commit all or nothing", func(t *testing.T) {
+		cursors := []Cursor{
+			{EventType: "test", Partition: "0", Offset: "3", NakadiStreamID: "stream-id"},
+			{EventType: "test", Partition: "1", Offset: "5", NakadiStreamID: "stream-id"}}
+		results := `{"items":[
+			{"cursor":{"partition":"0","offset":"3","event_type":"test"},"result":"committed"},
+			{"cursor":{"partition":"1","offset":"5","event_type":"test"},"result":"outdated"}]}`
+
+		stream := setupCommitter(httpmock.NewStringResponder(200, results))
+		require.NoError(t, stream.commitCursors(cursors))
+
+		stream.allOrNothing = true
+		err := stream.commitCursors(cursors)
+		require.Error(t, err)
+		resultErr, ok := err.(CommitResultError)
+		require.True(t, ok)
+		assert.Equal(t, []CommitResult{
+			{Cursor: Cursor{EventType: "test", Partition: "0", Offset: "3", NakadiStreamID: "stream-id"}, Result: CommitResultCommitted},
+			{Cursor: Cursor{EventType: "test", Partition: "1", Offset: "5", NakadiStreamID: "stream-id"}, Result: CommitResultOutdated}}, resultErr.Results)
+		assert.EqualError(t, err, "unable to commit cursors: 1 of 2 cursors were not committed (partition 1 of test: outdated)")
+		assert.Equal(t, []Cursor{{EventType: "test", Partition: "1", Offset: "5", NakadiStreamID: "stream-id"}}, resultErr.Outdated())
+
+		stream = setupCommitter(httpmock.NewStringResponder(204, ""))
+		stream.allOrNothing = true
+		require.NoError(t, stream.commitCursors(cursors))
+	})
+
 	t.Run("successful commit", func(t *testing.T) {
 		stream := setupCommitter(httpmock.NewStringResponder(200, ""))
 
-		err := stream.commitCursor(Cursor{})
+		err := stream.commitCursors([]Cursor{{}})
 		require.NoError(t, err)
 	})
 }
@@ -0,0 +1,126 @@
+package nakadi
+
+import (
+	"context"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// SubscriptionSnapshot combines the committed cursors, the statistics and the newest available offsets of all
+// partitions of a subscription. Errors contains the errors of the requests which failed, the fields of the
+// partitions which are filled from these requests are left empty.
+type SubscriptionSnapshot struct {
+	SubscriptionID string
+	Partitions     []*PartitionSnapshot
+	Errors         []error
+}
+
+// PartitionSnapshot describes a single partition of a subscription. StreamID is empty if the partition is not
+// assigned to a stream and CommittedOffset is empty if no cursor was committed for the partition yet.
+type PartitionSnapshot struct {
+	EventType             string
+	Partition             string
+	CommittedOffset       string
+	NewestAvailableOffset string
+	UnconsumedEvents      int
+	ConsumerLagSeconds    int
+	State                 string
+	StreamID              string
+}
+
+// committedCursorsResponse is the response of Nakadi to a request for the committed cursors of a subscription.
+type committedCursorsResponse struct {
+	Items []struct {
+		SubscriptionCursor
+		CursorToken string `json:"cursor_token"`
+	} `json:"items"`
+}
+
+// SubscriptionSnapshot requests the committed cursors and the statistics of the subscription identified by id
+// along with the newest available offsets of its event types and combines them per partition, e.g. in order
+// to display the progress of a subscription on a dashboard. If some of the requests fail, the snapshot
+// contains the data of the successful requests and the errors of the failed ones. An error is only returned
+// if neither the cursors nor the statistics could be requested.
+func (c *Client) SubscriptionSnapshot(id string) (*SubscriptionSnapshot, error) {
+	const errMsg = "unable to request snapshot of subscription"
+	subAPI := NewSubscriptionAPI(c, nil)
+	eventAPI := NewEventAPI(c, nil)
+
+	snapshot := &SubscriptionSnapshot{SubscriptionID: id}
+	partitions := make(map[string]*PartitionSnapshot)
+	var eventTypes []string
+	partition := func(eventType, name string) *PartitionSnapshot {
+		key := eventType + "/" + name
+		if p, ok := partitions[key]; ok {
+			return p
+		}
+		if !containsString(eventTypes, eventType) {
+			eventTypes = append(eventTypes, eventType)
+		}
+		p := &PartitionSnapshot{EventType: eventType, Partition: name}
+		partitions[key] = p
+		return p
+	}
+
+	cursors := &committedCursorsResponse{}
+	cursorsErr := c.httpGET(context.Background(), subAPI.backOffConf.create(), subAPI.subURL(id)+"/cursors", cursors, "unable to request committed cursors")
+	if cursorsErr != nil {
+		snapshot.Errors = append(snapshot.Errors, cursorsErr)
+	}
+	for _, cursor := range cursors.Items {
+		partition(cursor.EventType, cursor.Partition).CommittedOffset = cursor.Offset
+	}
+
+	stats, statsErr := subAPI.GetStats(id)
+	if statsErr != nil {
+		snapshot.Errors = append(snapshot.Errors, statsErr)
+	}
+	for _, s := range stats {
+		for _, p := range s.Partitions {
+			snapshotPartition := partition(s.EventType, p.Partition)
+			snapshotPartition.UnconsumedEvents = p.UnconsumedEvents
+			snapshotPartition.ConsumerLagSeconds = p.ConsumerLagSeconds
+			snapshotPartition.State = p.State
+			snapshotPartition.StreamID = p.StreamID
+		}
+	}
+
+	if cursorsErr != nil && statsErr != nil {
+		return nil, errors.Wrap(statsErr, errMsg)
+	}
+
+	for _, eventType := range eventTypes {
+		tails, err := eventAPI.Partitions(eventType)
+		if err != nil {
+			snapshot.Errors = append(snapshot.Errors, errors.Wrapf(err, "event type %s", eventType))
+			continue
+		}
+		for _, tail := range tails {
+			partition(eventType, tail.Partition).NewestAvailableOffset = tail.NewestAvailableOffset
+		}
+	}
+
+	for _, p := range partitions {
+		snapshot.Partitions = append(snapshot.Partitions, p)
+	}
+	sort.Slice(snapshot.Partitions, func(i, j int) bool {
+		a, b := snapshot.Partitions[i], snapshot.Partitions[j]
+		if a.EventType != b.EventType {
+			return a.EventType < b.EventType
+		}
+		return a.Partition < b.Partition
+	})
+
+	return snapshot, nil
+}
+
+// containsString checks whether values contains the value.
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
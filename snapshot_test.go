@@ -0,0 +1,79 @@
+package nakadi
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClient_SubscriptionSnapshot(t *testing.T) {
+	subscriptionURL := defaultNakadiURL + "/subscriptions/sub-id"
+	partitionsURL := defaultNakadiURL + "/event-types/test-event/partitions"
+	cursors := `{"items":[{"event_type":"test-event","partition":"0","offset":"001-0001-000000000000000002","cursor_token":"token"}]}`
+	stats := `{"items":[{"event_type":"test-event","partitions":[
+		{"partition":"0","state":"assigned","unconsumed_events":3,"consumer_lag_seconds":10,"stream_id":"stream-id"},
+		{"partition":"1","state":"unassigned","unconsumed_events":0}]}]}`
+	partitions := `[
+		{"partition":"0","oldest_available_offset":"001-0001-000000000000000000","newest_available_offset":"001-0001-000000000000000005"},
+		{"partition":"1","oldest_available_offset":"001-0001-000000000000000000","newest_available_offset":"BEGIN"}]`
+
+	setup := func() (*httpmock.MockTransport, *Client) {
+		transport := httpmock.NewMockTransport()
+		return transport, &Client{nakadiURL: defaultNakadiURL, httpClient: &http.Client{Transport: transport}}
+	}
+
+	t.Run("fail cursors and stats", func(t *testing.T) {
+		transport, client := setup()
+		transport.RegisterResponder("GET", subscriptionURL+"/cursors", httpmock.NewStringResponder(http.StatusNotFound, testProblemJSON))
+		transport.RegisterResponder("GET", subscriptionURL+"/stats", httpmock.NewStringResponder(http.StatusNotFound, testProblemJSON))
+
+		_, err := client.SubscriptionSnapshot("sub-id")
+		require.Error(t, err)
+		assert.Regexp(t, "unable to request snapshot of subscription: .*some problem detail", err)
+	})
+
+	t.Run("success partial", func(t *testing.T) {
+		transport, client := setup()
+		transport.RegisterResponder("GET", subscriptionURL+"/cursors", httpmock.NewStringResponder(http.StatusOK, cursors))
+		transport.RegisterResponder("GET", subscriptionURL+"/stats", httpmock.NewStringResponder(http.StatusInternalServerError, testProblemJSON))
+		transport.RegisterResponder("GET", partitionsURL, httpmock.NewStringResponder(http.StatusOK, partitions))
+
+		snapshot, err := client.SubscriptionSnapshot("sub-id")
+		require.NoError(t, err)
+		require.Len(t, snapshot.Errors, 1)
+		assert.Regexp(t, "unable to get stats for subscription", snapshot.Errors[0])
+		assert.Equal(t, []*PartitionSnapshot{
+			{EventType: "test-event", Partition: "0", CommittedOffset: "001-0001-000000000000000002", NewestAvailableOffset: "001-0001-000000000000000005"},
+			{EventType: "test-event", Partition: "1", NewestAvailableOffset: "BEGIN"}}, snapshot.Partitions)
+	})
+
+	t.Run("success combined", func(t *testing.T) {
+		transport, client := setup()
+		transport.RegisterResponder("GET", subscriptionURL+"/cursors", httpmock.NewStringResponder(http.StatusOK, cursors))
+		transport.RegisterResponder("GET", subscriptionURL+"/stats", httpmock.NewStringResponder(http.StatusOK, stats))
+		transport.RegisterResponder("GET", partitionsURL, httpmock.NewStringResponder(http.StatusOK, partitions))
+
+		snapshot, err := client.SubscriptionSnapshot("sub-id")
+		require.NoError(t, err)
+		assert.Empty(t, snapshot.Errors)
+		assert.Equal(t, "sub-id", snapshot.SubscriptionID)
+		assert.Equal(t, []*PartitionSnapshot{
+			{
+				EventType:             "test-event",
+				Partition:             "0",
+				CommittedOffset:       "001-0001-000000000000000002",
+				NewestAvailableOffset: "001-0001-000000000000000005",
+				UnconsumedEvents:      3,
+				ConsumerLagSeconds:    10,
+				State:                 "assigned",
+				StreamID:              "stream-id"},
+			{
+				EventType:             "test-event",
+				Partition:             "1",
+				NewestAvailableOffset: "BEGIN",
+				State:                 "unassigned"}}, snapshot.Partitions)
+	})
+}
@@ -0,0 +1,134 @@
+package nakadi
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// ErrTooManyStreams is the cause of errors returned when a stream is opened with StreamGuardError while the
+// client already consumes as many streams of the subscription as it has partitions.
+var ErrTooManyStreams = errors.New("more streams than partitions of the subscription")
+
+// A StreamGuard defines what happens when a client opens more streams of a subscription than the subscription
+// has partitions. Nakadi accepts such streams, but they don't receive any events.
+type StreamGuard int
+
+// Guards against opening more streams than partitions.
+const (
+	// StreamGuardOff opens streams without checking the number of partitions.
+	StreamGuardOff StreamGuard = iota
+	// StreamGuardWarn logs a message via the Logger of the client before the superfluous stream is opened.
+	StreamGuardWarn
+	// StreamGuardError fails to open the superfluous stream with an error caused by ErrTooManyStreams. Like
+	// other errors the attempt is retried, so the stream is opened once another stream was closed.
+	StreamGuardError
+)
+
+// streamRegistry counts the streams of each subscription opened by a client along with the cached partition
+// counts of the subscriptions.
+type streamRegistry struct {
+	mutex      sync.Mutex
+	streams    map[string]int
+	partitions map[string]partitionCount
+}
+
+// partitionCount is the number of partitions of a subscription at a specific time.
+type partitionCount struct {
+	count     int
+	fetchedAt time.Time
+}
+
+func newStreamRegistry() *streamRegistry {
+	return &streamRegistry{streams: make(map[string]int), partitions: make(map[string]partitionCount)}
+}
+
+// register counts a stream of the subscription until ctx is done.
+func (r *streamRegistry) register(ctx context.Context, subscriptionID string) {
+	r.mutex.Lock()
+	r.streams[subscriptionID]++
+	r.mutex.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		r.mutex.Lock()
+		defer r.mutex.Unlock()
+		if r.streams[subscriptionID]--; r.streams[subscriptionID] <= 0 {
+			delete(r.streams, subscriptionID)
+		}
+	}()
+}
+
+func (r *streamRegistry) count(subscriptionID string) int {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.streams[subscriptionID]
+}
+
+// partitionCount returns the cached number of partitions of the subscription and fetches it if it is not
+// cached or outdated. Errors of fetch are not cached.
+func (r *streamRegistry) partitionCount(subscriptionID string, fetch func() (int, error)) (int, error) {
+	r.mutex.Lock()
+	cached, ok := r.partitions[subscriptionID]
+	r.mutex.Unlock()
+	if ok && time.Since(cached.fetchedAt) < defaultPartitionCacheTTL {
+		return cached.count, nil
+	}
+
+	count, err := fetch()
+	if err != nil {
+		return 0, err
+	}
+	r.mutex.Lock()
+	r.partitions[subscriptionID] = partitionCount{count: count, fetchedAt: time.Now()}
+	r.mutex.Unlock()
+	return count, nil
+}
+
+// guardedOpener checks the number of streams of a subscription before a stream is opened.
+type guardedOpener struct {
+	streamOpener
+	ctx            context.Context
+	client         *Client
+	subscriptionID string
+	guard          StreamGuard
+}
+
+func (g *guardedOpener) openStream() (streamer, error) {
+	if err := g.check(); err != nil {
+		return nil, err
+	}
+	return g.streamOpener.openStream()
+}
+
+// check compares the number of streams of the subscription with its number of partitions. The check is best
+// effort: if the partitions can't be requested, the stream is opened without a warning.
+func (g *guardedOpener) check() error {
+	registry := g.client.streams
+	partitions, err := registry.partitionCount(g.subscriptionID, func() (int, error) {
+		stats, err := NewSubscriptionAPI(g.client, nil).GetStatsContext(g.ctx, g.subscriptionID)
+		if err != nil {
+			return 0, err
+		}
+		count := 0
+		for _, s := range stats {
+			count += len(s.Partitions)
+		}
+		return count, nil
+	})
+	streams := registry.count(g.subscriptionID)
+	if err != nil || partitions == 0 || streams <= partitions {
+		return nil
+	}
+
+	if g.guard == StreamGuardError {
+		return errors.Wrapf(ErrTooManyStreams, "unable to open stream: subscription %s has %d partitions and %d streams",
+			g.subscriptionID, partitions, streams)
+	}
+	if g.client.logger != nil {
+		g.client.logger.Printf("too many streams: subscription=%s partitions=%d streams=%d", g.subscriptionID, partitions, streams)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package nakadi
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamAPI_StreamGuard(t *testing.T) {
+	statsURL := defaultNakadiURL + "/subscriptions/sub-id/stats"
+	stats := `{"items":[{"event_type":"test-event","partitions":[{"partition":"0","state":"assigned"}]}]}`
+
+	setup := func(guard StreamGuard) (*httpmock.MockTransport, *recordingLogger, []*StreamAPI) {
+		transport := httpmock.NewMockTransport()
+		logger := &recordingLogger{}
+		client := New(defaultNakadiURL, &ClientOptions{Logger: logger})
+		client.httpClient = &http.Client{Transport: transport}
+		var streams []*StreamAPI
+		for i := 0; i < 2; i++ {
+			streamAPI, _ := newStreamAPI(context.Background(), client, "sub-id", &StreamOptions{StreamGuard: guard})
+			streams = append(streams, streamAPI)
+		}
+		return transport, logger, streams
+	}
+
+	t.Run("fail too many streams", func(t *testing.T) {
+		transport, _, streams := setup(StreamGuardError)
+		transport.RegisterResponder("GET", statsURL, httpmock.NewStringResponder(http.StatusOK, stats))
+		defer streams[1].Close()
+
+		_, err := streams[1].opener.openStream()
+		require.Error(t, err)
+		assert.Equal(t, ErrTooManyStreams, errors.Cause(err))
+		assert.Regexp(t, "subscription sub-id has 1 partitions and 2 streams", err)
+
+		guard := streams[1].opener.(*guardedOpener)
+		streams[0].Close()
+		assert.Eventually(t, func() bool { return guard.check() == nil }, time.Second, time.Millisecond)
+		assert.Equal(t, 1, transport.GetTotalCallCount())
+	})
+
+	t.Run("success warn", func(t *testing.T) {
+		transport, logger, streams := setup(StreamGuardWarn)
+		transport.RegisterResponder("GET", statsURL, httpmock.NewStringResponder(http.StatusOK, stats))
+		defer streams[0].Close()
+		defer streams[1].Close()
+
+		require.NoError(t, streams[1].opener.(*guardedOpener).check())
+		assert.Equal(t, []string{"too many streams: subscription=sub-id partitions=1 streams=2"}, logger.Messages())
+	})
+
+	t.Run("success skip check if stats fail", func(t *testing.T) {
+		transport, logger, streams := setup(StreamGuardError)
+		transport.RegisterResponder("GET", statsURL, httpmock.NewStringResponder(http.StatusNotFound, testProblemJSON))
+		defer streams[0].Close()
+		defer streams[1].Close()
+
+		require.NoError(t, streams[1].opener.(*guardedOpener).check())
+		assert.Empty(t, logger.Messages())
+	})
+
+	t.Run("success guard off", func(t *testing.T) {
+		_, _, streams := setup(StreamGuardOff)
+		defer streams[0].Close()
+		defer streams[1].Close()
+
+		_, guarded := streams[1].opener.(*guardedOpener)
+		assert.False(t, guarded)
+	})
+}
@@ -0,0 +1,74 @@
+package nakadi
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// StreamState is the position of a StreamAPI which can be persisted in order to resume consuming after the
+// process was restarted. The cursors committed to Nakadi remain the source of truth: the stored cursors are
+// only used by ResumeStream if the subscription had to be created again, e.g. after it was deleted.
+type StreamState struct {
+	SubscriptionID string   `json:"subscription_id"`
+	Cursors        []Cursor `json:"cursors"`
+}
+
+// State returns the current state of the stream consisting of the subscription id and the last committed
+// cursor of each partition.
+func (s *StreamAPI) State() *StreamState {
+	return &StreamState{SubscriptionID: s.SubscriptionID(), Cursors: s.CommittedCursors()}
+}
+
+// SaveStreamState writes the state as json to the file at path. The file is replaced atomically, so a crash
+// while saving never leaves a partially written file behind.
+func SaveStreamState(path string, state *StreamState) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return errors.Wrap(err, "unable to encode stream state")
+	}
+	return errors.Wrap(writeFileAtomic(path, data), "unable to save stream state")
+}
+
+// LoadStreamState reads a state written by SaveStreamState from the file at path. If the file does not exist
+// LoadStreamState returns no state and no error.
+func LoadStreamState(path string) (*StreamState, error) {
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to load stream state")
+	}
+
+	state := &StreamState{}
+	if err := json.Unmarshal(data, state); err != nil {
+		return nil, errors.Wrap(err, "unable to decode stream state")
+	}
+	return state, nil
+}
+
+// ResumeStream obtains the subscription via SubscriptionAPI.SubscribeOrGet and opens a stream on it. If the
+// subscription already exists, the stream continues at the cursors committed to Nakadi and the state is not
+// used. Only if the subscription was created by this call and the state contains cursors, the cursors of the
+// new subscription are reset to the stored cursors before the stream is opened, so that consuming continues
+// where the previous process stopped instead of at the position given by ReadFrom. The state may be nil.
+func (c *Client) ResumeStream(subscription *Subscription, state *StreamState, options *StreamOptions) (*StreamAPI, error) {
+	const errMsg = "unable to resume stream"
+	subAPI := NewSubscriptionAPI(c, nil)
+
+	subscription, created, err := subAPI.SubscribeOrGetWithStatus(subscription)
+	if err != nil {
+		return nil, errors.Wrap(err, errMsg)
+	}
+
+	if created && state != nil && len(state.Cursors) > 0 {
+		if err := subAPI.ResetCursors(subscription.ID, state.Cursors); err != nil {
+			return nil, errors.Wrap(err, errMsg)
+		}
+	}
+
+	return NewStream(c, subscription.ID, options), nil
+}
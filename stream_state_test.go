@@ -0,0 +1,111 @@
+package nakadi
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStreamState_SaveLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stream-state")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	state := &StreamState{SubscriptionID: "sub-id", Cursors: []Cursor{
+		{EventType: "test-event", Partition: "0", Offset: "001-0001-000000000000000001", CursorToken: "token"}}}
+
+	t.Run("load missing file", func(t *testing.T) {
+		loaded, err := LoadStreamState(filepath.Join(dir, "missing.json"))
+		require.NoError(t, err)
+		assert.Nil(t, loaded)
+	})
+
+	t.Run("fail missing directory", func(t *testing.T) {
+		err := SaveStreamState(filepath.Join(dir, "missing", "state.json"), state)
+		require.Error(t, err)
+		assert.Regexp(t, "unable to save stream state", err.Error())
+	})
+
+	t.Run("fail invalid file", func(t *testing.T) {
+		path := filepath.Join(dir, "broken.json")
+		require.NoError(t, ioutil.WriteFile(path, []byte("not json"), 0600))
+
+		_, err := LoadStreamState(path)
+		require.Error(t, err)
+		assert.Regexp(t, "unable to decode stream state", err.Error())
+	})
+
+	t.Run("save and load", func(t *testing.T) {
+		path := filepath.Join(dir, "state.json")
+
+		require.NoError(t, SaveStreamState(path, &StreamState{SubscriptionID: "other-id"}))
+		require.NoError(t, SaveStreamState(path, state))
+
+		loaded, err := LoadStreamState(path)
+		require.NoError(t, err)
+		assert.Equal(t, state, loaded)
+	})
+}
+
+func TestClient_ResumeStream(t *testing.T) {
+	subscription := &Subscription{OwningApplication: "test-app", EventTypes: []string{"test-event"}}
+	state := &StreamState{SubscriptionID: "old-sub-id", Cursors: []Cursor{
+		{EventType: "test-event", Partition: "0", Offset: "BEGIN"}}}
+	url := fmt.Sprintf("%s/subscriptions", defaultNakadiURL)
+	cursorsURL := fmt.Sprintf("%s/subscriptions/%s/cursors", defaultNakadiURL, "sub-id")
+
+	// the stream is opened asynchronously, a separate transport for each test prevents it from
+	// interfering with other tests
+	setup := func(status int) (*httpmock.MockTransport, *Client) {
+		transport := httpmock.NewMockTransport()
+		transport.RegisterResponder("POST", url, httpmock.NewStringResponder(status, `{"id":"sub-id"}`))
+		transport.RegisterResponder("GET", url+"/sub-id/events", httpmock.NewStringResponder(http.StatusOK, ""))
+		return transport, &Client{
+			nakadiURL:        defaultNakadiURL,
+			httpClient:       &http.Client{Transport: transport},
+			httpStreamClient: &http.Client{Transport: transport}}
+	}
+
+	t.Run("fail create subscription", func(t *testing.T) {
+		_, client := setup(http.StatusBadRequest)
+
+		_, err := client.ResumeStream(subscription, state, nil)
+		require.Error(t, err)
+		assert.Regexp(t, "unable to resume stream", err)
+	})
+
+	t.Run("success existing subscription", func(t *testing.T) {
+		transport, client := setup(http.StatusOK)
+		transport.RegisterResponder("PATCH", cursorsURL, httpmock.NewStringResponder(http.StatusNoContent, ""))
+
+		stream, err := client.ResumeStream(subscription, state, nil)
+		require.NoError(t, err)
+		defer stream.Close()
+		assert.Equal(t, "sub-id", stream.State().SubscriptionID)
+		assert.Equal(t, 0, transport.GetCallCountInfo()["PATCH "+cursorsURL])
+	})
+
+	t.Run("success created subscription", func(t *testing.T) {
+		transport, client := setup(http.StatusCreated)
+		transport.RegisterResponder("PATCH", cursorsURL, func(r *http.Request) (*http.Response, error) {
+			body := map[string][]map[string]string{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&body))
+			expected := []map[string]string{{"partition": "0", "offset": "BEGIN", "event_type": "test-event"}}
+			assert.Equal(t, expected, body["items"])
+			return httpmock.NewStringResponse(http.StatusNoContent, ""), nil
+		})
+
+		stream, err := client.ResumeStream(subscription, state, nil)
+		require.NoError(t, err)
+		defer stream.Close()
+		assert.Equal(t, 1, transport.GetCallCountInfo()["PATCH "+cursorsURL])
+	})
+}
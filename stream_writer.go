@@ -0,0 +1,58 @@
+package nakadi
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// StreamToWriter consumes the subscription and writes the events of each received batch as newline delimited
+// JSON to the writer, which is useful to follow a subscription for debugging purposes. The cursor of each batch
+// is committed once its events were written. StreamToWriter blocks until the context is canceled, in which
+// case the stream is closed and nil is returned. Errors of the stream are retried like with any other stream,
+// but failing to write events or to commit a cursor stops StreamToWriter with an error. The options may be nil.
+func (c *Client) StreamToWriter(ctx context.Context, sub *Subscription, w io.Writer, options *StreamOptions) error {
+	const errMsg = "unable to write stream"
+
+	stream := NewStreamContext(ctx, c, sub.ID, options)
+	defer stream.Close()
+
+	for {
+		cursor, events, err := stream.NextEvents()
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			// the stream reconnects on its own
+			continue
+		}
+
+		if err := writeEventLines(w, events); err != nil {
+			return errors.Wrap(err, errMsg)
+		}
+
+		if err := stream.CommitCursor(cursor); err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return errors.Wrap(err, errMsg)
+		}
+	}
+}
+
+// writeEventLines writes each event of a json encoded batch as a separate line.
+func writeEventLines(w io.Writer, events []byte) error {
+	var decoded []json.RawMessage
+	if err := json.Unmarshal(events, &decoded); err != nil {
+		return errors.Wrap(err, "unable to decode events")
+	}
+
+	for _, event := range decoded {
+		if _, err := w.Write(append(event, '\n')); err != nil {
+			return errors.Wrap(err, "unable to write event")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package nakadi
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/jarcoal/httpmock"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// cancelingWriter buffers written data and cancels a context once the expected number of lines was written.
+type cancelingWriter struct {
+	bytes.Buffer
+	lines  int
+	cancel context.CancelFunc
+}
+
+func (w *cancelingWriter) Write(p []byte) (int, error) {
+	n, err := w.Buffer.Write(p)
+	if strings.Count(w.String(), "\n") >= w.lines {
+		w.cancel()
+	}
+	return n, err
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) {
+	return 0, assert.AnError
+}
+
+func TestClient_StreamToWriter(t *testing.T) {
+	sub := &Subscription{ID: "7dd69d58-7f20-11e7-9748-133d6a0dbfb3"}
+	cursorsURL := fmt.Sprintf("%s/subscriptions/%s/cursors", defaultNakadiURL, sub.ID)
+	streamURL := fmt.Sprintf("%s/subscriptions/%s/events", defaultNakadiURL, sub.ID)
+
+	// the stream is closed asynchronously, a separate transport for each test prevents it from
+	// interfering with other tests
+	setup := func() (*httpmock.MockTransport, *Client) {
+		transport := httpmock.NewMockTransport()
+		transport.RegisterResponder("GET", streamURL, helperStreamResponder(t))
+		return transport, &Client{
+			nakadiURL:        defaultNakadiURL,
+			httpClient:       &http.Client{Transport: transport},
+			httpStreamClient: &http.Client{Transport: transport}}
+	}
+
+	t.Run("fail write events", func(t *testing.T) {
+		transport, client := setup()
+		transport.RegisterResponder("POST", cursorsURL, httpmock.NewStringResponder(http.StatusNoContent, ""))
+
+		err := client.StreamToWriter(context.Background(), sub, failingWriter{}, nil)
+		require.Error(t, err)
+		assert.Regexp(t, "unable to write stream: unable to write event", err)
+	})
+
+	t.Run("fail commit", func(t *testing.T) {
+		transport, client := setup()
+		transport.RegisterResponder("POST", cursorsURL, httpmock.NewStringResponder(http.StatusForbidden, testProblemJSON))
+
+		err := client.StreamToWriter(context.Background(), sub, &bytes.Buffer{}, nil)
+		require.Error(t, err)
+		assert.Regexp(t, "unable to write stream: unable to commit cursor: some problem detail", err)
+	})
+
+	t.Run("success until canceled", func(t *testing.T) {
+		transport, client := setup()
+		transport.RegisterResponder("POST", cursorsURL, httpmock.NewStringResponder(http.StatusNoContent, ""))
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		writer := &cancelingWriter{lines: 3, cancel: cancel}
+
+		err := client.StreamToWriter(ctx, sub, writer, nil)
+		require.NoError(t, err)
+
+		lines := strings.Split(strings.TrimSpace(writer.String()), "\n")
+		require.Len(t, lines, 3)
+		for _, line := range lines {
+			event := DataChangeEvent{}
+			require.NoError(t, json.Unmarshal([]byte(line), &event))
+			assert.Equal(t, "C", event.DataOP)
+		}
+		assert.True(t, transport.GetCallCountInfo()["POST "+cursorsURL] >= 2)
+	})
+}
@@ -2,13 +2,96 @@ package nakadi
 
 import (
 	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/cenkalti/backoff/v3"
+	"github.com/pkg/errors"
 )
 
+// ErrStreamConnectTimeout is the cause of errors returned when a stream could not be opened within the
+// configured connect timeout.
+var ErrStreamConnectTimeout = errors.New("timeout while opening stream")
+
+// ErrCommitTimeout is the cause of errors returned when a commit request was not completed within the
+// configured commit timeout.
+var ErrCommitTimeout = errors.New("timeout while committing cursors")
+
+// ErrStaleStream is the cause of errors returned when cursors of a stream are committed after the StreamAPI
+// reconnected. Nakadi only accepts commits on the stream the cursors were received from, such cursors can not
+// be committed anymore: the respective events are delivered again on the new stream.
+var ErrStaleStream = errors.New("cursor belongs to a stream which was already closed")
+
+// CommitOrderError is returned by CommitCursor and CommitCursors if commit ordering is enforced and a cursor
+// is behind the cursor which was already committed for the same partition on the current stream.
+type CommitOrderError struct {
+	Cursor    Cursor
+	Committed Cursor
+}
+
+// Error implements the error interface for CommitOrderError.
+func (err CommitOrderError) Error() string {
+	return fmt.Sprintf("unable to commit cursor: offset %s of partition %s of %s is behind the committed offset %s",
+		err.Cursor.Offset, err.Cursor.Partition, err.Cursor.EventType, err.Committed.Offset)
+}
+
+// Results of single cursors of a commit as reported by Nakadi.
+const (
+	CommitResultCommitted = "committed"
+	CommitResultOutdated  = "outdated"
+)
+
+// CommitResult is the result Nakadi reported for a single cursor of a commit.
+type CommitResult struct {
+	Cursor Cursor `json:"cursor"`
+	Result string `json:"result"`
+}
+
+// CommitResultError is returned by CommitCursor and CommitCursors of streams with StreamOptions.CommitAllOrNothing
+// if Nakadi did not report the result "committed" for every cursor of a commit. Nakadi commits each cursor on
+// its own, so the cursors with the result "committed" were committed nonetheless. The StreamAPI treats the
+// commit as failed, the respective batches are considered uncommitted.
+type CommitResultError struct {
+	Results []CommitResult
+}
+
+// Error implements the error interface for CommitResultError.
+func (err CommitResultError) Error() string {
+	var rejected []string
+	for _, r := range err.Results {
+		if r.Result != CommitResultCommitted {
+			rejected = append(rejected, fmt.Sprintf("partition %s of %s: %s", r.Cursor.Partition, r.Cursor.EventType, r.Result))
+		}
+	}
+	return fmt.Sprintf("unable to commit cursors: %d of %d cursors were not committed (%s)",
+		len(rejected), len(err.Results), strings.Join(rejected, ", "))
+}
+
+// Outdated returns the cursors which Nakadi reported as "outdated", i.e. which are behind the committed position
+// of their partition. A commit which only contains committed and outdated cursors did not lose any progress,
+// so callers may treat it as success although the stream considers it failed.
+func (err CommitResultError) Outdated() []Cursor {
+	var outdated []Cursor
+	for _, r := range err.Results {
+		if r.Result == CommitResultOutdated {
+			outdated = append(outdated, r.Cursor)
+		}
+	}
+	return outdated
+}
+
 // A Cursor marks the current read position in a stream. It returned along with each received batch of
-// events and is furthermore used to commit a batch of events (as well as all previous events).
+// events and is furthermore used to commit a batch of events (as well as all previous events). A commit
+// sends the CursorToken of the received cursor back to Nakadi, which is required by clusters validating
+// commits, therefore cursors should be committed as they were received.
 type Cursor struct {
 	Partition      string `json:"partition"`
 	Offset         string `json:"offset"`
@@ -17,6 +100,54 @@ type Cursor struct {
 	NakadiStreamID string `json:"-"`
 }
 
+// Before reports whether the cursor points to a position before the other cursor. Both cursors must belong
+// to the same partition of the same event type, otherwise an error is returned. An error is also returned
+// if the offsets of the cursors can not be compared.
+func (c Cursor) Before(other Cursor) (bool, error) {
+	if c.EventType != other.EventType || c.Partition != other.Partition {
+		return false, errors.Errorf("unable to compare cursors of different partitions: %s/%s and %s/%s",
+			c.EventType, c.Partition, other.EventType, other.Partition)
+	}
+	cmp, ok := compareOffsets(c.Offset, other.Offset)
+	if !ok {
+		return false, errors.Errorf("unable to compare offsets %s and %s", c.Offset, other.Offset)
+	}
+	return cmp < 0, nil
+}
+
+// compareOffsets compares two offsets of the same partition and returns -1, 0 or 1 if a is before, equal to
+// or after b. The offset "BEGIN" is before all other offsets. The second return value is false if the
+// offsets can not be compared.
+func compareOffsets(a, b string) (int, bool) {
+	switch {
+	case a == b:
+		return 0, true
+	case a == "BEGIN":
+		return -1, true
+	case b == "BEGIN":
+		return 1, true
+	case len(a) == len(b):
+		return strings.Compare(a, b), true
+	}
+
+	numA, errA := strconv.ParseUint(a, 10, 64)
+	numB, errB := strconv.ParseUint(b, 10, 64)
+	if errA != nil || errB != nil {
+		return 0, false
+	}
+	if numA < numB {
+		return -1, true
+	}
+	return 1, true
+}
+
+// A StreamBatch is a single batch of events received from a stream along with the cursor that has to be
+// committed once the events were processed.
+type StreamBatch struct {
+	Cursor Cursor
+	Events []byte
+}
+
 // StreamOptions contains optional parameters that are used to create a StreamAPI.
 type StreamOptions struct {
 	// The maximum number of Events in each chunk (and therefore per partition) of the stream (default: 1)
@@ -27,6 +158,66 @@ type StreamOptions struct {
 	// state and commit comes - the stream will resume. If MaxUncommittedEvents is lower than BatchLimit,
 	// effective batch size will be upperbound by MaxUncommittedEvents. (default: 10, minimum: 1)
 	MaxUncommittedEvents uint
+	// ChannelBufferSize is the number of batches which are read from the stream ahead of NextEvents or
+	// Channel and held in memory until the consumer receives them. A pointer to 0 means synchronous handoff:
+	// the next batch is not read before the previous one was received, so a slow consumer applies
+	// backpressure to the stream instead of letting batches pile up (default: nil, a buffer of 10 batches).
+	ChannelBufferSize *uint
+	// The maximum number of consecutive keep-alive batches after which Nakadi closes the stream. The
+	// value should match the subscription's stream configuration. If set, the stream is reconnected
+	// shortly before the limit is reached. The reconnect is jittered, so that many consumers of the
+	// same subscription don't reconnect at the same time (default: 0, no limit).
+	StreamKeepAliveLimit uint
+	// The maximum time in seconds after which Nakadi closes the stream regardless of its activity. The
+	// StreamAPI reopens the stream afterwards, so the timeout only limits the lifetime of each connection,
+	// e.g. in order to spread consumers over the nodes of a cluster (default: 0, no timeout).
+	StreamTimeout uint
+	// Whether or not keep-alive batches, which carry the current cursor of a partition but no events, are
+	// delivered by NextEvents, Next, Channel and ForEach with empty events. Otherwise they are dropped by the
+	// StreamAPI. Keep-alive batches are not counted by BatchesRead and are not subject to MaxInflightBatches
+	// (default: false).
+	DeliverKeepAlives bool
+	// ConnectTimeout is the maximum time to wait for Nakadi to respond when a stream is opened. Once the
+	// stream is established it has no effect. When the timeout expires the attempt to open the stream
+	// fails with an error caused by ErrStreamConnectTimeout and is retried (default: 0, no timeout).
+	ConnectTimeout time.Duration
+	// MaxStreamLifetime is the maximum duration a stream is kept open. Once the lifetime has passed, no more
+	// batches are read from the stream. The stream is closed and reopened as soon as the cursors of all
+	// batches delivered by the stream were committed, but at the latest after Nakadi's default commit
+	// timeout of 60 seconds (default: 0, disabled).
+	MaxStreamLifetime time.Duration
+	// BackOffResetAfter keeps the backoff of reconnects across streams. If set, a stream which fails is reopened
+	// after the next delay of an exponential backoff, which grows with every failure. Once a stream delivered
+	// batches for BackOffResetAfter before it failed, the backoff is reset to InitialRetryInterval, so that a
+	// single earlier flap doesn't inflate future delays (default: 0, a failed stream is reopened immediately).
+	BackOffResetAfter time.Duration
+	// StreamGuard checks before a stream is opened whether the client already consumes as many streams of the
+	// subscription as it has partitions, e.g. because more consumer instances than partitions were started.
+	// The number of partitions is requested from the statistics of the subscription and cached for 5 minutes,
+	// if the request fails the check is skipped. Only streams opened by the same client are counted
+	// (default: StreamGuardOff).
+	StreamGuard StreamGuard
+	// MaxInflightBatches is the maximum number of batches which were delivered by NextEvents, Channel or ForEach
+	// but not committed yet. Once the limit is reached, the next batch is not delivered before a commit covers
+	// one of the outstanding batches, which bounds the memory used by consumers processing batches
+	// concurrently and the number of batches redelivered after a crash (default: 0, no limit).
+	MaxInflightBatches uint
+	// Codec decodes the batches received from the stream. A custom codec can be used to consume event
+	// types which are not encoded as JSON (default: JSONCodec).
+	Codec Codec
+	// NotReadyRetryTime is the maximum time spent on retrying to open the stream if Nakadi responds with
+	// 404 or 409. Such responses are common right after a subscription was created, because it takes a
+	// moment until the subscription is ready. Only once the time has passed, the failed attempt is reported
+	// via NotifyErr and the stream is retried with the regular backoff. Since a missing subscription or a
+	// stream without free slots is reported with the same status codes, the retries are disabled unless a
+	// positive time is set (default: 0, no retries).
+	NotReadyRetryTime time.Duration
+	// RetryIf overrides the decision which failed attempts to open the stream are retried right away within
+	// NotReadyRetryTime. The predicate receives either the response of Nakadi or the error of a request that
+	// could not be sent, the body of the response can be read by the predicate. Attempts which are not
+	// retried right away are reported via NotifyErr and retried with the regular backoff (default: nil,
+	// responses with status 404 and 409 are retried).
+	RetryIf func(response *http.Response, err error) bool
 	// The initial (minimal) retry interval used for the exponential backoff. This value is applied for
 	// stream initialization as well as for cursor commits.
 	InitialRetryInterval time.Duration
@@ -41,12 +232,99 @@ type StreamOptions struct {
 	// set to true InitialRetryInterval, MaxRetryInterval, and CommitMaxElapsedTime have
 	// no effect for commit requests (default: false).
 	CommitRetry bool
+	// CommitTimeout is the maximum time a single commit request may take, independent of the connection
+	// timeout of the client. A commit which takes longer fails with an error caused by ErrCommitTimeout and is
+	// retried if CommitRetry is set (default: 0, only the connection timeout of the client applies).
+	CommitTimeout time.Duration
+	// CommitKeepAlive is the interval in which the last committed cursors of the stream are committed
+	// again while a batch is processed, that is between receiving the batch from NextEvents and the
+	// next call of CommitCursor. This prevents Nakadi from reassigning partitions of the stream when
+	// processing a batch takes longer than the commit timeout of the subscription. The repeated commits
+	// only keep the current position of the stream and don't commit any progress. Nothing is committed
+	// before the first cursor of the stream was committed (default: 0, disabled).
+	CommitKeepAlive time.Duration
+	// Whether or not a commit succeeds only if Nakadi reports the result "committed" for every cursor. Nakadi
+	// commits each cursor on its own and reports cursors which are behind the committed position of their
+	// partition as "outdated", which is otherwise treated as success. With CommitAllOrNothing such commits
+	// fail with a CommitResultError and are not retried (default: false).
+	CommitAllOrNothing bool
+	// Whether or not commits of cursors which are behind the cursor already committed for the same partition
+	// on the current stream are rejected with a CommitOrderError. Otherwise such cursors are skipped
+	// silently. Enforcing the order helps to detect consumers which commit batches in the wrong order
+	// (default: false).
+	EnforceCommitOrder bool
+	// OffsetStore is used to mirror commits: after each successful commit the committed cursors of the
+	// stream are saved to the store. Errors of the store are reported via NotifyErr and don't affect the
+	// commit. SubscriptionAPI.DetectDivergence compares the store with the cursors committed to Nakadi
+	// (default: nil, commits are not mirrored).
+	OffsetStore OffsetStore
+	// OnCommit is called after each successful commit of CommitCursor or CommitCursors with the cursors which
+	// were committed, e.g. to export the progress of the stream as metrics. It is called outside of all locks
+	// of the StreamAPI, but blocks the respective commit call until it returns. Repeated commits of the
+	// CommitKeepAlive don't trigger OnCommit (default: nil).
+	OnCommit func(cursors []Cursor)
+	// OnSharedSubscription enables the detection of other streams consuming from the same subscription. After
+	// the first batch of each new stream was received, the statistics of the subscription are requested in
+	// the background and OnSharedSubscription is called with the ids of all other streams which have
+	// partitions assigned. This helps to detect consumers which were accidentally started several times,
+	// while consumers which are scaled out on purpose should not set it. Errors while requesting the
+	// statistics are reported via NotifyErr (default: nil, disabled).
+	OnSharedSubscription func(otherStreamIDs []string)
+	// RecreateOnMissing is the definition of the subscription which is used to recreate it if Nakadi reports
+	// the subscription as missing when the stream is opened, e.g. because an operator deleted it. The new
+	// subscription has a new id, which is returned by StreamAPI.SubscriptionID and used for all commits,
+	// statistics and OffsetStore keys afterwards. Without ReadFrom the subscription is recreated reading from
+	// "end". The committed cursors of the deleted subscription are lost: starting from "end" skips all events
+	// which were not consumed before the deletion as well as the events published until the recreation,
+	// while starting from "begin" consumes events again (default: nil, missing subscriptions are not recreated).
+	RecreateOnMissing *Subscription
+	// RecoverPanics makes ForEach recover from panics of the handler. A panic is logged via the Logger of the
+	// client and treated like an error returned by the handler: ForEach stops without committing the batch
+	// and returns an error caused by ErrHandlerPanic (default: false, panics are not recovered).
+	RecoverPanics bool
+	// HandlerTimeout is the maximum time ForEach and ForEachContext wait for the handler to process a batch. The
+	// context passed to the handler of ForEachContext is canceled once the timeout expires. A handler which
+	// did not return by then is treated as failed: ForEach stops without committing the batch and returns an
+	// error caused by ErrHandlerTimeout, while the handler continues in the background until it returns
+	// (default: 0, no timeout).
+	HandlerTimeout time.Duration
+	// MaxEventsPerSecond limits the rate at which ForEach and ForEachContext pass events to the handler. Before a
+	// batch is passed on, ForEach waits until the rate permits its events, so that processing is paced even if
+	// Nakadi delivers faster. Batches are still committed only after they were processed. For streams which
+	// don't use JSONCodec each batch counts as a single event (default: 0, no limit).
+	MaxEventsPerSecond uint
+	// MaxEvents stops ForEach and ForEachContext once at least this number of events was passed to the handler,
+	// e.g. in order to read a sample of a subscription. Batches are never split: the batch which reaches the
+	// limit is passed on and committed completely, afterwards the stream is closed. For streams which don't use
+	// JSONCodec each batch counts as a single event (default: 0, no limit).
+	MaxEvents uint
+	// ThroughputWindow is the period over which the rates returned by StreamAPI.Throughput are averaged. If
+	// the MetricsCollector of the client implements ThroughputCollector, the throughput is reported to it
+	// (default: 1m).
+	ThroughputWindow time.Duration
+	// ObserveLatency enables the latency metric: if the MetricsCollector of the client implements LatencyCollector,
+	// the time between metadata.received_at of the events of each batch and the delivery of the batch by
+	// NextEvents is reported to it. Only streams using JSONCodec are observed (default: false).
+	ObserveLatency bool
+	// ObserveCommitLag enables the commit lag metric: if the MetricsCollector of the client implements
+	// CommitLagCollector, the time between the delivery of batches by NextEvents and the commit of their
+	// cursors is reported to it per partition after each successful commit (default: false).
+	ObserveCommitLag bool
+	// OnCommitLag is called after each successful commit of CommitCursor or CommitCursors with the commit lag
+	// of each committed partition, e.g. to log whether the commit interval is too long. Setting it enables the
+	// tracking of delivery times independently of ObserveCommitLag (default: nil).
+	OnCommitLag func(lags []CommitLag)
 	// NotifyErr is called when an error occurs that leads to a retry. This notify function can be used to
 	// detect unhealthy streams.
 	NotifyErr func(error, time.Duration)
 	// NotifyOK is called whenever a successful operation was completed. This notify function can be used
 	// to detect that a stream is healthy again.
 	NotifyOK func()
+	// OnReconnect is called before each attempt to re-establish the stream. It receives the number of
+	// the attempt, the error which caused the reconnect and the delay before the attempt is made. The
+	// last committed cursors can be obtained from StreamAPI.CommittedCursors. Reconnects are also logged
+	// via the Logger of the client.
+	OnReconnect func(attempt int, lastErr error, delay time.Duration)
 }
 
 func (o *StreamOptions) withDefaults() *StreamOptions {
@@ -69,9 +347,22 @@ func (o *StreamOptions) withDefaults() *StreamOptions {
 	if copyOptions.NotifyOK == nil {
 		copyOptions.NotifyOK = func() {}
 	}
+	if copyOptions.OnReconnect == nil {
+		copyOptions.OnReconnect = func(_ int, _ error, _ time.Duration) {}
+	}
+	if copyOptions.Codec == nil {
+		copyOptions.Codec = JSONCodec{}
+	}
 	if copyOptions.MaxUncommittedEvents == 0 {
 		copyOptions.MaxUncommittedEvents = 10
 	}
+	if copyOptions.ThroughputWindow == 0 {
+		copyOptions.ThroughputWindow = defaultThroughputWindow
+	}
+	if copyOptions.ChannelBufferSize == nil {
+		bufferSize := uint(defaultChannelBufferSize)
+		copyOptions.ChannelBufferSize = &bufferSize
+	}
 	return &copyOptions
 }
 
@@ -80,21 +371,100 @@ func (o *StreamOptions) withDefaults() *StreamOptions {
 // provided. Use the SubscriptionAPI in order to obtain subscriptions. The options parameter can be used
 // to configure the behavior of the stream. The options may be nil.
 func NewStream(client *Client, subscriptionID string, options *StreamOptions) *StreamAPI {
+	return NewStreamContext(context.Background(), client, subscriptionID, options)
+}
+
+// NewStreamContext instantiates a new stream processing sub API like NewStream. The stream is bound to the
+// provided context: cancelling the context aborts a pending attempt to open the stream and closes the
+// stream the same way Close does.
+func NewStreamContext(ctx context.Context, client *Client, subscriptionID string, options *StreamOptions) *StreamAPI {
+	streamAPI, _ := newStreamAPI(ctx, client, subscriptionID, options)
+
+	go streamAPI.startStream()
+
+	return streamAPI
+}
+
+// AcquirePartitions opens a stream on the subscription identified by subscriptionID which receives only the
+// given partitions instead of the partitions assigned by Nakadi. This allows custom schemes of assigning
+// partitions to consumers on top of subscriptions. Each partition is requested for all event types of the
+// subscription which have a partition with this name, partitions which don't exist in any of the event types
+// are rejected. Cursors are committed like for streams created with NewStream. The partitions are held until
+// the stream is closed: while another stream consumes one of the partitions, Nakadi rejects the stream and
+// opening it is retried like after any other error. The options may be nil.
+func (c *Client) AcquirePartitions(subscriptionID string, partitions []string, options *StreamOptions) (*StreamAPI, error) {
+	const errMsg = "unable to acquire partitions"
+	if len(partitions) == 0 {
+		return nil, errors.New(errMsg + ": no partitions requested")
+	}
+
+	subscription, err := NewSubscriptionAPI(c, nil).Get(subscriptionID)
+	if err != nil {
+		return nil, errors.Wrap(err, errMsg)
+	}
+
+	requested := make(map[string]bool, len(partitions))
+	for _, partition := range partitions {
+		requested[partition] = false
+	}
+
+	eventAPI := NewEventAPI(c, nil)
+	var assignments []streamPartition
+	for _, eventType := range subscription.EventTypes {
+		available, err := eventAPI.Partitions(eventType)
+		if err != nil {
+			return nil, errors.Wrap(err, errMsg)
+		}
+		for _, p := range available {
+			if _, ok := requested[p.Partition]; ok {
+				requested[p.Partition] = true
+				assignments = append(assignments, streamPartition{EventType: eventType, Partition: p.Partition})
+			}
+		}
+	}
+
+	for _, partition := range partitions {
+		if !requested[partition] {
+			return nil, errors.Errorf("%s: partition %s does not belong to the event types of subscription %s", errMsg, partition, subscriptionID)
+		}
+	}
+
+	streamAPI, opener := newStreamAPI(context.Background(), c, subscriptionID, options)
+	opener.partitions = assignments
+
+	go streamAPI.startStream()
+
+	return streamAPI, nil
+}
+
+// newStreamAPI creates a StreamAPI and its opener without starting to consume the stream.
+func newStreamAPI(ctx context.Context, client *Client, subscriptionID string, options *StreamOptions) (*StreamAPI, *simpleStreamOpener) {
 	options = options.withDefaults()
 
-	ctx, cancel := context.WithCancel(context.Background())
+	ctx, cancel := context.WithCancel(ctx)
+
+	opener := &simpleStreamOpener{
+		ctx:                  ctx,
+		client:               client,
+		subscriptionID:       subscriptionID,
+		batchLimit:           options.BatchLimit,
+		flushTimeout:         options.FlushTimeout,
+		maxUncommittedEvents: options.MaxUncommittedEvents,
+		streamKeepAliveLimit: options.StreamKeepAliveLimit,
+		streamTimeout:        options.StreamTimeout,
+		connectTimeout:       options.ConnectTimeout,
+		codec:                options.Codec,
+		notReadyRetryTime:    options.NotReadyRetryTime,
+		retryIf:              options.RetryIf}
 
 	streamAPI := &StreamAPI{
-		opener: &simpleStreamOpener{
-			client:               client,
-			subscriptionID:       subscriptionID,
-			batchLimit:           options.BatchLimit,
-			flushTimeout:         options.FlushTimeout,
-			maxUncommittedEvents: options.MaxUncommittedEvents},
+		opener: opener,
 		committer: &simpleCommitter{
 			client:         client,
-			subscriptionID: subscriptionID},
-		eventCh: make(chan eventsOrError, 10),
+			subscriptionID: subscriptionID,
+			timeout:        options.CommitTimeout,
+			allOrNothing:   options.CommitAllOrNothing},
+		eventCh: make(chan eventsOrError, *options.ChannelBufferSize),
 		ctx:     ctx,
 		cancel:  cancel,
 		streamBackOffConf: backOffConfiguration{
@@ -108,66 +478,675 @@ func NewStream(client *Client, subscriptionID string, options *StreamOptions) *S
 			MaxRetryInterval:     options.MaxRetryInterval,
 			MaxElapsedTime:       options.CommitMaxElapsedTime,
 		},
-		notifyErr: options.NotifyErr,
-		notifyOK:  options.NotifyOK}
+		keepAliveLimit:     options.StreamKeepAliveLimit,
+		deliverKeepAlives:  options.DeliverKeepAlives,
+		maxStreamLifetime:  options.MaxStreamLifetime,
+		backOffReset:       options.BackOffResetAfter,
+		maxInflight:        int(options.MaxInflightBatches),
+		lifetimeCommitWait: nakadiCommitTimeout,
+		commitSignal:       make(chan struct{}, 1),
+		commitKeepAlive:    options.CommitKeepAlive,
+		subscriptionID:     subscriptionID,
+		offsetStore:        options.OffsetStore,
+		enforceCommitOrder: options.EnforceCommitOrder,
+		onCommit:           options.OnCommit,
+		onCommitLag:        options.OnCommitLag,
+		onShared:           options.OnSharedSubscription,
+		notifyErr:          options.NotifyErr,
+		notifyOK:           options.NotifyOK,
+		onReconnect:        options.OnReconnect,
+		recoverPanic:       options.RecoverPanics,
+		handlerTimeout:     options.HandlerTimeout,
+		maxEvents:          int64(options.MaxEvents),
+		logger:             client.logger,
+		maxAttempts:        client.maxAttempts}
+	opener.bytesRead = &streamAPI.bytesRead
+	streamAPI.getStats = func() ([]*SubscriptionStats, error) {
+		return NewSubscriptionAPI(client, nil).GetStatsContext(ctx, streamAPI.SubscriptionID())
+	}
+	_, streamAPI.jsonCodec = options.Codec.(JSONCodec)
+	streamAPI.throughput = newThroughputMeter(options.ThroughputWindow, time.Now())
+	streamAPI.throughputSink, _ = client.metrics.(ThroughputCollector)
+	if options.MaxEventsPerSecond > 0 {
+		streamAPI.limiter = newEventLimiter(options.MaxEventsPerSecond, time.Now())
+	}
+	if options.ObserveLatency && streamAPI.jsonCodec {
+		streamAPI.latencySink, _ = client.metrics.(LatencyCollector)
+	}
+	if options.ObserveCommitLag {
+		streamAPI.commitLagSink, _ = client.metrics.(CommitLagCollector)
+	}
+	if streamAPI.commitLagSink != nil || streamAPI.onCommitLag != nil {
+		streamAPI.commitLag = newCommitLagTracker()
+	}
+	if options.StreamGuard != StreamGuardOff && client.streams != nil {
+		client.streams.register(ctx, subscriptionID)
+		streamAPI.opener = &guardedOpener{
+			streamOpener:   opener,
+			ctx:            ctx,
+			client:         client,
+			subscriptionID: subscriptionID,
+			guard:          options.StreamGuard}
+	}
+	if options.RecreateOnMissing != nil {
+		streamAPI.opener = &recreatingOpener{
+			streamOpener: streamAPI.opener,
+			ctx:          ctx,
+			client:       client,
+			subscription: options.RecreateOnMissing,
+			recreated: func(id string) {
+				opener.subscriptionID = id
+				streamAPI.recreated(id)
+			}}
+	}
 
-	go streamAPI.startStream()
+	return streamAPI, opener
+}
 
-	return streamAPI
+// StreamInfo describes the connection of a stream as reported by Nakadi when the stream was opened, e.g. in
+// order to log the id of the stream when debugging connection issues. ContentEncoding is "gzip" if the
+// stream is compressed and empty otherwise.
+type StreamInfo struct {
+	StreamID        string
+	ConnectedAt     time.Time
+	ContentType     string
+	ContentEncoding string
+	StatusCode      int
 }
 
 // A StreamAPI is a sub API which is used to consume events from a specific subscription using Nakadi's
 // high level stream API. In order to ensure that only successfully processed events are committed, it is
 // crucial to commit cursors of respective event batches in the same order they were received.
 type StreamAPI struct {
-	opener            streamOpener
-	committer         committer
-	eventCh           chan eventsOrError
-	ctx               context.Context
-	cancel            context.CancelFunc
-	commitBackOffConf backOffConfiguration
-	streamBackOffConf backOffConfiguration
-	notifyErr         func(error, time.Duration)
-	notifyOK          func()
+	// the counters are accessed atomically and must be the first fields for 64-bit alignment
+	bytesRead          int64
+	batchesRead        int64
+	eventsRead         int64
+	opener             streamOpener
+	committer          committer
+	eventCh            chan eventsOrError
+	ctx                context.Context
+	cancel             context.CancelFunc
+	commitBackOffConf  backOffConfiguration
+	streamBackOffConf  backOffConfiguration
+	keepAliveLimit     uint
+	deliverKeepAlives  bool
+	maxStreamLifetime  time.Duration
+	backOffReset       time.Duration
+	maxInflight        int
+	inflightMutex      sync.Mutex
+	inflight           []Cursor
+	lifetimeCommitWait time.Duration
+	commitSignal       chan struct{}
+	commitKeepAlive    time.Duration
+	keepAliveMutex     sync.Mutex
+	stopKeepAlive      chan struct{}
+	subscriptionMutex  sync.Mutex
+	subscriptionID     string
+	offsetStore        OffsetStore
+	storeMutex         sync.Mutex
+	enforceCommitOrder bool
+	onCommit           func([]Cursor)
+	onCommitLag        func([]CommitLag)
+	commitLag          *commitLagTracker
+	commitLagSink      CommitLagCollector
+	onShared           func([]string)
+	getStats           func() ([]*SubscriptionStats, error)
+	notifyErr          func(error, time.Duration)
+	notifyOK           func()
+	onReconnect        func(int, error, time.Duration)
+	commitLock         sync.RWMutex
+	committedMutex     sync.Mutex
+	committed          map[string]Cursor
+	commitFailures     map[string]commitFailure
+	failedCommits      int
+	streamID           string
+	infoMutex          sync.Mutex
+	info               StreamInfo
+	pauseMutex         sync.Mutex
+	resumeCh           chan struct{}
+	jsonCodec          bool
+	throughput         *throughputMeter
+	throughputSink     ThroughputCollector
+	latencySink        LatencyCollector
+	limiter            *eventLimiter
+	maxEvents          int64
+	recoverPanic       bool
+	handlerTimeout     time.Duration
+	logger             Logger
+	maxAttempts        int
+}
+
+// commitFailure is the error of a failed commit of a partition, seq orders the failures of a stream.
+type commitFailure struct {
+	seq int
+	err error
 }
 
 // NextEvents reads the next batch of events from the stream and returns the encoded events along with the
 // respective cursor. It blocks until the batch of events can be read from the stream, or the stream is closed.
 func (s *StreamAPI) NextEvents() (Cursor, []byte, error) {
+	return s.nextEvents(nil)
+}
+
+// nextEvents reads the next batch like NextEvents, but also returns context.Canceled once done is closed.
+func (s *StreamAPI) nextEvents(done <-chan struct{}) (Cursor, []byte, error) {
 	select {
 	case <-s.ctx.Done():
 		return Cursor{}, nil, context.Canceled
-	case next := <-s.eventCh:
+	case <-done:
+		return Cursor{}, nil, context.Canceled
+	case next, ok := <-s.eventCh:
+		if !ok {
+			// the stream was closed
+			return Cursor{}, nil, context.Canceled
+		}
+		if next.err == nil && s.commitKeepAlive > 0 {
+			s.startCommitKeepAlive(next.cursor.NakadiStreamID)
+		}
+		if next.err == nil && len(next.events) > 0 {
+			s.observeLatency(next.cursor, next.events)
+			if s.commitLag != nil {
+				s.commitLag.delivered(next.cursor, time.Now())
+			}
+		}
 		return next.cursor, next.events, next.err
 	}
 }
 
-// CommitCursor commits a cursor to Nakadi.
+// Next reads the next batch of the stream like NextEvents, but stops waiting once ctx is done and returns the
+// error of ctx. After the stream was closed Next returns io.EOF, which allows to consume the batches in a loop
+// until the stream ends. Errors while reading from the stream are returned as well, but the stream reconnects
+// on its own, e.g. after Nakadi closed it because the stream timeout expired, so Next can be called again.
+func (s *StreamAPI) Next(ctx context.Context) (StreamBatch, error) {
+	cursor, events, err := s.nextEvents(ctx.Done())
+	if err == context.Canceled {
+		if ctx.Err() != nil {
+			return StreamBatch{}, ctx.Err()
+		}
+		return StreamBatch{}, io.EOF
+	}
+	if err != nil {
+		return StreamBatch{}, err
+	}
+	return StreamBatch{Cursor: cursor, Events: events}, nil
+}
+
+// BytesRead returns the number of bytes read from all streams opened by the StreamAPI so far, including the
+// cursors of the batches and keep alive batches. Together with the statistics of the subscription this can be
+// used to report the progress of the consumption.
+func (s *StreamAPI) BytesRead() int64 {
+	return atomic.LoadInt64(&s.bytesRead)
+}
+
+// BatchesRead returns the number of batches with events read from all streams opened by the StreamAPI so far.
+func (s *StreamAPI) BatchesRead() int64 {
+	return atomic.LoadInt64(&s.batchesRead)
+}
+
+// Info returns the connection details of the stream which is currently open or was open last. They are
+// updated every time the stream (re)connects. Before the first stream was opened Info returns a zero
+// StreamInfo.
+func (s *StreamAPI) Info() StreamInfo {
+	s.infoMutex.Lock()
+	defer s.infoMutex.Unlock()
+	return s.info
+}
+
+// setInfo updates the connection details with the ones of a newly opened stream. Streams which don't report
+// details leave the previous details unchanged.
+func (s *StreamAPI) setInfo(stream streamer) {
+	reporter, ok := stream.(interface{ streamInfo() StreamInfo })
+	if !ok {
+		return
+	}
+	s.infoMutex.Lock()
+	defer s.infoMutex.Unlock()
+	s.info = reporter.streamInfo()
+}
+
+// Channel provides the batches of the stream via a channel as an alternative to NextEvents. Errors which
+// occur while reading from the stream are sent to the error channel; the stream reconnects after such
+// errors and continues to deliver batches. Channel spawns a goroutine which owns both channels and closes
+// them once the stream is closed. Callers must receive from both channels until they are closed, otherwise
+// the goroutine blocks until Close is called. Channel must not be combined with NextEvents or called more
+// than once, since all of them consume from the same stream.
+func (s *StreamAPI) Channel() (<-chan StreamBatch, <-chan error) {
+	batchCh := make(chan StreamBatch)
+	errCh := make(chan error)
+
+	go func() {
+		defer close(batchCh)
+		defer close(errCh)
+
+		for {
+			cursor, events, err := s.NextEvents()
+			if err == context.Canceled {
+				return
+			}
+
+			if err != nil {
+				select {
+				case errCh <- err:
+				case <-s.ctx.Done():
+					return
+				}
+				continue
+			}
+
+			select {
+			case batchCh <- StreamBatch{Cursor: cursor, Events: events}:
+			case <-s.ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return batchCh, errCh
+}
+
+// CommitCursor commits a cursor to Nakadi. If an equal or later cursor of the same partition was already
+// committed on the current stream, the commit is skipped and CommitCursor returns immediately.
 func (s *StreamAPI) CommitCursor(cursor Cursor) error {
+	return s.CommitCursors([]Cursor{cursor})
+}
+
+// CommitCursors commits the cursors of several partitions to Nakadi in a single request. The cursors may
+// belong to an arbitrary subset of the partitions consumed by the stream, only the given cursors are sent.
+// Cursors for which an equal or later cursor was already committed on the current stream are skipped, unless
+// commit ordering is enforced: then cursors behind the committed cursor are rejected with a CommitOrderError
+// and nothing is committed. All cursors must originate from the same stream. Cursors of a stream which was
+// replaced by a new stream when the StreamAPI reconnected are rejected with an error caused by ErrStaleStream.
+func (s *StreamAPI) CommitCursors(cursors []Cursor) error {
+	committed, err := s.commitCursors(cursors)
+	if err == nil && len(committed) > 0 {
+		s.observeCommitLag(committed)
+		if s.onCommit != nil {
+			s.onCommit(committed)
+		}
+	}
+	return err
+}
+
+// commitCursors commits the cursors and returns the cursors which were actually committed.
+func (s *StreamAPI) commitCursors(cursors []Cursor) ([]Cursor, error) {
+	s.stopCommitKeepAlive()
+
+	s.commitLock.RLock()
+	defer s.commitLock.RUnlock()
+
+	var pending []Cursor
+	for _, cursor := range cursors {
+		if cursor.NakadiStreamID != cursors[0].NakadiStreamID {
+			return nil, errors.New("unable to commit cursors of different streams at once")
+		}
+		if s.isStale(cursor.NakadiStreamID) {
+			return nil, errors.Wrapf(ErrStaleStream, "unable to commit cursor of stream %s", cursor.NakadiStreamID)
+		}
+		committed, cmp, ok := s.compareCommitted(cursor)
+		if ok && cmp < 0 && s.enforceCommitOrder {
+			return nil, CommitOrderError{Cursor: cursor, Committed: committed}
+		}
+		if !ok || cmp > 0 {
+			pending = append(pending, cursor)
+		}
+	}
+	if len(pending) == 0 {
+		return nil, nil
+	}
+
 	var err error
 
-	commitBackOff := backoff.WithContext(s.commitBackOffConf.create(), s.ctx)
+	budget := newAttemptBudget(s.maxAttempts)
+	commitBackOff := backoff.WithContext(budget.limit(s.commitBackOffConf.create()), s.ctx)
 	backoff.RetryNotify(func() error {
-		err = s.committer.commitCursor(cursor)
+		budget.take()
+		err = s.committer.commitCursors(pending)
+		if _, ok := err.(CommitResultError); ok {
+			// the same cursors would be rejected again
+			return backoff.Permanent(err)
+		}
 		return err
 	}, commitBackOff, s.notifyErr)
 
+	s.committedMutex.Lock()
+	if err != nil {
+		s.failedCommits++
+		if s.commitFailures == nil {
+			s.commitFailures = make(map[string]commitFailure)
+		}
+		for _, cursor := range pending {
+			s.commitFailures[cursor.EventType+"/"+cursor.Partition] = commitFailure{seq: s.failedCommits, err: err}
+		}
+	}
+	var stored []Cursor
 	if err == nil {
-		s.notifyOK()
+		if s.committed == nil {
+			s.committed = make(map[string]Cursor)
+		}
+		for _, cursor := range pending {
+			// a concurrent commit may have stored a later cursor of the partition in the meantime
+			key := cursor.EventType + "/" + cursor.Partition
+			delete(s.commitFailures, key)
+			if last, ok := s.committed[key]; ok && last.NakadiStreamID == cursor.NakadiStreamID {
+				if cmp, ok := compareOffsets(cursor.Offset, last.Offset); ok && cmp <= 0 {
+					continue
+				}
+			}
+			s.committed[key] = cursor
+			stored = append(stored, cursor)
+		}
 	}
+	s.committedMutex.Unlock()
 
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case s.commitSignal <- struct{}{}:
+	default:
+	}
+	s.notifyOK()
+	if s.offsetStore != nil {
+		s.mirrorCommittedCursors()
+	}
+
+	return stored, nil
+}
+
+// mirrorCommittedCursors saves the committed cursors to the offset store. Concurrent commits save one after
+// another and the cursors are read while saving, so that a save never replaces newer cursors with older ones.
+func (s *StreamAPI) mirrorCommittedCursors() {
+	s.storeMutex.Lock()
+	defer s.storeMutex.Unlock()
+
+	if err := s.offsetStore.Save(s.SubscriptionID(), s.CommittedCursors()); err != nil {
+		s.notifyErr(errors.Wrap(err, "unable to mirror committed cursors"), 0)
+	}
+}
+
+// isStale reports whether the stream with the given id was replaced by another stream after a reconnect.
+func (s *StreamAPI) isStale(streamID string) bool {
+	s.committedMutex.Lock()
+	defer s.committedMutex.Unlock()
+
+	return streamID != "" && s.streamID != "" && streamID != s.streamID
+}
+
+// setStreamID records the id of the current stream and reports whether the id changed.
+func (s *StreamAPI) setStreamID(streamID string) bool {
+	if streamID == "" {
+		return false
+	}
+	s.committedMutex.Lock()
+	defer s.committedMutex.Unlock()
+
+	changed := s.streamID != streamID
+	s.streamID = streamID
+	return changed
+}
+
+// checkSharedSubscription requests the statistics of the subscription and passes the ids of all streams other
+// than the given stream which have partitions assigned to onShared.
+func (s *StreamAPI) checkSharedSubscription(streamID string) {
+	stats, err := s.getStats()
+	if err != nil {
+		s.notifyErr(errors.Wrap(err, "unable to check for other streams of the subscription"), 0)
+		return
+	}
+
+	var others []string
+	seen := map[string]bool{streamID: true, "": true}
+	for _, eventTypeStats := range stats {
+		for _, p := range eventTypeStats.Partitions {
+			if !seen[p.StreamID] {
+				seen[p.StreamID] = true
+				others = append(others, p.StreamID)
+			}
+		}
+	}
+	if len(others) > 0 {
+		sort.Strings(others)
+		s.onShared(others)
+	}
+}
+
+// alreadyCommitted checks whether the offset of the cursor is lower or equal than the offset of a cursor
+// which was previously committed on the same stream.
+func (s *StreamAPI) alreadyCommitted(cursor Cursor) bool {
+	_, cmp, ok := s.compareCommitted(cursor)
+	return ok && cmp <= 0
+}
+
+// compareCommitted compares the offset of the cursor with the offset of the cursor which was previously committed
+// for the same partition on the same stream. It returns the committed cursor and -1, 0 or 1 if the cursor is
+// before, equal to or after the committed cursor. The last return value is false if no cursor was committed or
+// the offsets can not be compared.
+func (s *StreamAPI) compareCommitted(cursor Cursor) (Cursor, int, bool) {
+	s.committedMutex.Lock()
+	defer s.committedMutex.Unlock()
+
+	last, ok := s.committed[cursor.EventType+"/"+cursor.Partition]
+	if !ok || last.NakadiStreamID != cursor.NakadiStreamID {
+		return Cursor{}, 0, false
+	}
+	cmp, ok := compareOffsets(cursor.Offset, last.Offset)
+	return last, cmp, ok
+}
+
+// startCommitKeepAlive starts a background routine which periodically commits the last committed cursors of
+// the stream with the given id until stopCommitKeepAlive is called or the stream is closed.
+func (s *StreamAPI) startCommitKeepAlive(streamID string) {
+	s.stopCommitKeepAlive()
+
+	s.keepAliveMutex.Lock()
+	defer s.keepAliveMutex.Unlock()
+	stop := make(chan struct{})
+	s.stopKeepAlive = stop
+
+	go func() {
+		ticker := time.NewTicker(s.commitKeepAlive)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-s.ctx.Done():
+				return
+			case <-ticker.C:
+				err := s.recommitCursors(streamID, stop)
+				if errors.Cause(err) == ErrStaleStream {
+					return
+				}
+				if err != nil {
+					s.notifyErr(err, s.commitKeepAlive)
+				}
+			}
+		}
+	}()
+}
+
+// recommitCursors commits the last committed cursors of the stream with the given id again. Like CommitCursors
+// it holds the commit lock, so that Drain waits for it, and rejects cursors of a stale stream. Nothing is
+// committed if the keep alive was stopped by a commit in the meantime. Cursors reported as outdated are not
+// treated as failure, even with CommitAllOrNothing.
+func (s *StreamAPI) recommitCursors(streamID string, stop chan struct{}) error {
+	s.commitLock.RLock()
+	defer s.commitLock.RUnlock()
+
+	select {
+	case <-stop:
+		return nil
+	default:
+	}
+	if s.isStale(streamID) {
+		return errors.Wrapf(ErrStaleStream, "unable to commit cursor of stream %s", streamID)
+	}
+
+	var cursors []Cursor
+	for _, cursor := range s.CommittedCursors() {
+		if cursor.NakadiStreamID == streamID {
+			cursors = append(cursors, cursor)
+		}
+	}
+	if len(cursors) == 0 {
+		return nil
+	}
+
+	err := s.committer.commitCursors(cursors)
+	if result, ok := err.(CommitResultError); ok {
+		// the cursors were committed before, so Nakadi reports them as outdated once they were superseded
+		for _, r := range result.Results {
+			if r.Result != CommitResultCommitted && r.Result != CommitResultOutdated {
+				return err
+			}
+		}
+		return nil
+	}
 	return err
 }
 
+// stopCommitKeepAlive stops the routine started by startCommitKeepAlive.
+func (s *StreamAPI) stopCommitKeepAlive() {
+	s.keepAliveMutex.Lock()
+	defer s.keepAliveMutex.Unlock()
+
+	if s.stopKeepAlive != nil {
+		close(s.stopKeepAlive)
+		s.stopKeepAlive = nil
+	}
+}
+
+// CommittedCursors returns the last successfully committed cursor of each partition, ordered by event type
+// and partition.
+func (s *StreamAPI) CommittedCursors() []Cursor {
+	s.committedMutex.Lock()
+	defer s.committedMutex.Unlock()
+
+	cursors := make([]Cursor, 0, len(s.committed))
+	for _, c := range s.committed {
+		cursors = append(cursors, c)
+	}
+	sort.Slice(cursors, func(i, j int) bool {
+		if cursors[i].EventType != cursors[j].EventType {
+			return cursors[i].EventType < cursors[j].EventType
+		}
+		return cursors[i].Partition < cursors[j].Partition
+	})
+
+	return cursors
+}
+
+// Pause stops reading batches from the stream until Resume is called. The stream stays open, so once Nakadi
+// sent MaxUncommittedEvents uncommitted events it stops sending further events. Batches which were already
+// read are still returned by NextEvents. If the pause lasts long, e.g. longer than the stream timeout of the
+// subscription or the commit timeout with uncommitted events, Nakadi may close the stream. In this case the
+// stream is reopened after Resume was called. Pause has no effect if the stream is already paused.
+func (s *StreamAPI) Pause() {
+	s.pauseMutex.Lock()
+	defer s.pauseMutex.Unlock()
+
+	if s.resumeCh == nil {
+		s.resumeCh = make(chan struct{})
+	}
+}
+
+// Resume continues reading batches from the stream after it was paused with Pause. Resume has no effect if the
+// stream is not paused.
+func (s *StreamAPI) Resume() {
+	s.pauseMutex.Lock()
+	defer s.pauseMutex.Unlock()
+
+	if s.resumeCh != nil {
+		close(s.resumeCh)
+		s.resumeCh = nil
+	}
+}
+
+// Paused reports whether the stream was paused with Pause.
+func (s *StreamAPI) Paused() bool {
+	s.pauseMutex.Lock()
+	defer s.pauseMutex.Unlock()
+
+	return s.resumeCh != nil
+}
+
+// waitWhilePaused blocks while the stream is paused. It returns false if the stream was closed meanwhile.
+func (s *StreamAPI) waitWhilePaused() bool {
+	s.pauseMutex.Lock()
+	resumeCh := s.resumeCh
+	s.pauseMutex.Unlock()
+
+	if resumeCh == nil {
+		return true
+	}
+	select {
+	case <-resumeCh:
+		return true
+	case <-s.ctx.Done():
+		return false
+	}
+}
+
 // Close ends the stream.
 func (s *StreamAPI) Close() error {
 	s.cancel()
 	return nil
 }
 
+// Drain ends the stream gracefully. In contrast to Close, Drain waits until all commits which are in
+// progress are completed before the stream is closed. If the context is done before that, the stream is
+// closed anyway and the error of the context is returned. Otherwise Drain returns the error of the most
+// recent failed commit, unless later commits of the same partitions succeeded.
+func (s *StreamAPI) Drain(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.commitLock.Lock()
+		close(done)
+		s.commitLock.Unlock()
+	}()
+
+	var err error
+	select {
+	case <-done:
+		s.committedMutex.Lock()
+		var latest commitFailure
+		for _, failure := range s.commitFailures {
+			if failure.seq > latest.seq {
+				latest = failure
+			}
+		}
+		err = latest.err
+		s.committedMutex.Unlock()
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	s.Close()
+	return err
+}
+
+// reconnect reports an attempt to re-establish the stream via the logger of the client and OnReconnect.
+func (s *StreamAPI) reconnect(attempt int, err error, delay time.Duration) {
+	if s.logger != nil {
+		s.logger.Printf("reconnecting stream: subscription=%s attempt=%d delay=%s error=%v", s.SubscriptionID(), attempt, delay, err)
+	}
+	s.onReconnect(attempt, err, delay)
+}
+
 // startStream is used to start a background routine which consumes events using a streamOpener and streamer.
 // this routine will never terminate (not even on errors) unless the stream is closed.
 func (s *StreamAPI) startStream() {
+	attempt := 0
+	reconnectBackOff := s.streamBackOffConf.create()
+	notify := func(err error, delay time.Duration) {
+		s.notifyErr(err, delay)
+		attempt++
+		s.reconnect(attempt, err, delay)
+	}
+
 	for {
 		var err error
 		var stream streamer
@@ -176,7 +1155,7 @@ func (s *StreamAPI) startStream() {
 		backoff.RetryNotify(func() error {
 			stream, err = s.opener.openStream()
 			return err
-		}, streamBackOff, s.notifyErr)
+		}, streamBackOff, notify)
 
 		if err != nil {
 			select {
@@ -186,20 +1165,69 @@ func (s *StreamAPI) startStream() {
 				continue
 			}
 		}
+		s.setInfo(stream)
 		s.notifyOK()
+		attempt = 0
+		s.resetInflight()
 
 		var cursor Cursor
 		var events []byte
+		var healthySince time.Time
+		var delay time.Duration
+		keepAlives, keepAliveThreshold := 0, keepAliveThreshold(s.keepAliveLimit)
+		openedAt := time.Now()
+		delivered := make(map[string]Cursor)
 		for {
-			select {
-			case <-s.ctx.Done():
+			if s.maxStreamLifetime > 0 && time.Since(openedAt) >= s.maxStreamLifetime {
+				// recycle the stream once all delivered batches are committed
+				s.awaitCommits(delivered)
+				break
+			}
+
+			if !s.waitWhilePaused() {
 				err = context.Canceled
-			default:
-				cursor, events, err = stream.nextEvents()
+			} else {
+				select {
+				case <-s.ctx.Done():
+					err = context.Canceled
+				default:
+					cursor, events, err = stream.nextEvents()
+				}
+			}
+			if err == nil {
+				// the id becomes current before batches of the stream are delivered
+				if s.setStreamID(cursor.NakadiStreamID) && s.onShared != nil {
+					go s.checkSharedSubscription(cursor.NakadiStreamID)
+				}
 			}
 
 			if err == nil && len(events) == 0 {
-				continue
+				keepAlives++
+				if keepAliveThreshold > 0 && keepAlives >= keepAliveThreshold {
+					// reconnect before Nakadi closes the stream
+					break
+				}
+				if !s.deliverKeepAlives {
+					continue
+				}
+			} else {
+				keepAlives = 0
+			}
+			if err == nil && len(events) > 0 {
+				if healthySince.IsZero() {
+					healthySince = time.Now()
+				}
+				delivered[cursor.EventType+"/"+cursor.Partition] = cursor
+				atomic.AddInt64(&s.batchesRead, 1)
+				if s.jsonCodec {
+					atomic.AddInt64(&s.eventsRead, countEvents(events))
+				}
+				s.recordThroughput()
+				if !s.awaitInflight(cursor) {
+					stream.closeStream()
+					close(s.eventCh)
+					return
+				}
 			}
 
 			select {
@@ -215,12 +1243,120 @@ func (s *StreamAPI) startStream() {
 					close(s.eventCh)
 					return
 				}
+				attempt++
+				delay = s.reconnectDelay(reconnectBackOff, healthySince)
+				s.reconnect(attempt, err, delay)
 				break
 			}
 		}
 
 		stream.closeStream()
+		if delay > 0 {
+			timer := time.NewTimer(delay)
+			select {
+			case <-timer.C:
+			case <-s.ctx.Done():
+				timer.Stop()
+			}
+		}
+	}
+}
+
+// reconnectDelay returns the delay before a failed stream is reopened. The backoff is reset if the stream
+// delivered batches since healthySince for at least the backoff reset duration of the stream.
+func (s *StreamAPI) reconnectDelay(reconnectBackOff backoff.BackOff, healthySince time.Time) time.Duration {
+	if s.backOffReset <= 0 {
+		return 0
+	}
+	if !healthySince.IsZero() && time.Since(healthySince) >= s.backOffReset {
+		reconnectBackOff.Reset()
+	}
+	return reconnectBackOff.NextBackOff()
+}
+
+// awaitCommits blocks until the delivered cursors were committed, the commit wait time has passed or the
+// stream was closed.
+func (s *StreamAPI) awaitCommits(delivered map[string]Cursor) {
+	timer := time.NewTimer(s.lifetimeCommitWait)
+	defer timer.Stop()
+
+	for {
+		pending := false
+		for _, cursor := range delivered {
+			if !s.alreadyCommitted(cursor) {
+				pending = true
+				break
+			}
+		}
+		if !pending {
+			return
+		}
+
+		select {
+		case <-s.commitSignal:
+		case <-timer.C:
+			return
+		case <-s.ctx.Done():
+			return
+		}
+	}
+}
+
+// InflightBatches returns the number of batches which were delivered but not committed yet. Batches are only
+// tracked if StreamOptions.MaxInflightBatches is set, otherwise InflightBatches returns 0.
+func (s *StreamAPI) InflightBatches() int {
+	s.inflightMutex.Lock()
+	defer s.inflightMutex.Unlock()
+
+	pending := s.inflight[:0]
+	for _, cursor := range s.inflight {
+		if !s.alreadyCommitted(cursor) {
+			pending = append(pending, cursor)
+		}
+	}
+	s.inflight = pending
+	return len(s.inflight)
+}
+
+// awaitInflight blocks until fewer than the maximum number of batches are in flight and adds the cursor of
+// the next delivered batch. It returns false if the stream is closed before.
+func (s *StreamAPI) awaitInflight(cursor Cursor) bool {
+	if s.maxInflight <= 0 {
+		return true
+	}
+	for s.InflightBatches() >= s.maxInflight {
+		select {
+		case <-s.commitSignal:
+		case <-s.ctx.Done():
+			return false
+		}
+	}
+
+	s.inflightMutex.Lock()
+	s.inflight = append(s.inflight, cursor)
+	s.inflightMutex.Unlock()
+	return true
+}
+
+// resetInflight forgets the batches in flight when a new stream is opened, since batches of a closed stream
+// can't be committed anymore.
+func (s *StreamAPI) resetInflight() {
+	s.inflightMutex.Lock()
+	s.inflight = nil
+	s.inflightMutex.Unlock()
+}
+
+// keepAliveThreshold returns the number of consecutive keep-alive batches after which a stream is reconnected
+// in order to anticipate the keep-alive limit. The threshold is jittered and zero if no reconnect is possible.
+func keepAliveThreshold(limit uint) int {
+	if limit < 2 {
+		return 0
+	}
+	threshold := int(limit) - 1 - rand.Intn(int(limit)/5+1)
+	if threshold < 1 {
+		threshold = 1
 	}
+	return threshold
 }
 
 // streamOpener is a internally used interface which is used to establish a new stream.
@@ -236,7 +1372,7 @@ type streamer interface {
 
 // committer is a internally used interface which is used to commit cursors.
 type committer interface {
-	commitCursor(cursor Cursor) error
+	commitCursors(cursors []Cursor) error
 }
 
 // eventsOrError is used to represent a successful or failed batch read.
@@ -2,9 +2,17 @@ package nakadi
 
 import (
 	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/jarcoal/httpmock"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
@@ -60,6 +68,235 @@ func TestStreamAPI_startStream(t *testing.T) {
 	}
 }
 
+func TestStreamAPI_onReconnect(t *testing.T) {
+	type reconnect struct {
+		attempt int
+		err     error
+		delay   time.Duration
+	}
+	reconnectCh := make(chan reconnect, 10)
+	blockCh := make(chan time.Time, 1)
+	stream := &mockStreamer{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	opener := &mockStreamOpener{}
+	logger := &recordingLogger{}
+	streamAPI := &StreamAPI{
+		opener:         opener,
+		subscriptionID: "sub-id",
+		logger:         logger,
+		eventCh:        make(chan eventsOrError, 10),
+		ctx:            ctx,
+		cancel:         cancel,
+		streamBackOffConf: backOffConfiguration{
+			Retry:                true,
+			InitialRetryInterval: 1 * time.Millisecond,
+			MaxRetryInterval:     1 * time.Millisecond},
+		notifyErr: func(_ error, _ time.Duration) {},
+		notifyOK:  func() {},
+		onReconnect: func(attempt int, err error, delay time.Duration) {
+			reconnectCh <- reconnect{attempt: attempt, err: err, delay: delay}
+		}}
+
+	opener.On("openStream").Once().Return(nil, assert.AnError)
+	opener.On("openStream").Once().Return(nil, assert.AnError)
+	opener.On("openStream").Return(stream, nil)
+	stream.On("nextEvents").Return(Cursor{}, nil, assert.AnError).WaitUntil(blockCh)
+	stream.On("closeStream").Return(nil)
+
+	go streamAPI.startStream()
+
+	first := <-reconnectCh
+	assert.Equal(t, 1, first.attempt)
+	assert.Equal(t, assert.AnError, first.err)
+	assert.True(t, first.delay > 0)
+
+	second := <-reconnectCh
+	assert.Equal(t, 2, second.attempt)
+
+	blockCh <- time.Now()
+	broken := <-reconnectCh
+	assert.Equal(t, 1, broken.attempt)
+	assert.Equal(t, assert.AnError, broken.err)
+	assert.Equal(t, time.Duration(0), broken.delay)
+
+	messages := logger.Messages()
+	require.True(t, len(messages) >= 3)
+	assert.Regexp(t, "^reconnecting stream: subscription=sub-id attempt=1 delay=.* error="+assert.AnError.Error(), messages[0])
+	assert.Equal(t, "reconnecting stream: subscription=sub-id attempt=1 delay=0s error="+assert.AnError.Error(), messages[2])
+}
+
+func TestStreamAPI_backOffReset(t *testing.T) {
+	delayCh := make(chan time.Duration, 10)
+	blockCh := make(chan time.Time)
+	defer close(blockCh)
+	streamAPI, opener, _ := newMockStream(nil, nil)
+	defer streamAPI.Close()
+	streamAPI.backOffReset = 50 * time.Millisecond
+	streamAPI.streamBackOffConf.InitialRetryInterval = 10 * time.Millisecond
+	streamAPI.streamBackOffConf.MaxRetryInterval = time.Second
+	streamAPI.onReconnect = func(_ int, _ error, delay time.Duration) {
+		delayCh <- delay
+	}
+
+	stream := &mockStreamer{}
+	opener.On("openStream").Return(stream, nil)
+	stream.On("nextEvents").Times(4).Return(Cursor{}, nil, assert.AnError)
+	stream.On("nextEvents").Once().Return(Cursor{Partition: "0"}, []byte(`[{"test":"event"}]`), nil)
+	stream.On("nextEvents").Once().After(60*time.Millisecond).Return(Cursor{}, nil, assert.AnError)
+	stream.On("nextEvents").Return(Cursor{}, nil, assert.AnError).WaitUntil(blockCh)
+	stream.On("closeStream").Return(nil)
+
+	go streamAPI.startStream()
+
+	var delays []time.Duration
+	for i := 0; i < 5; i++ {
+		delays = append(delays, <-delayCh)
+	}
+	// with a randomization factor of 0.5 the fourth delay is at least 10ms * 1.5^3 * 0.5
+	assert.True(t, delays[0] <= 15*time.Millisecond, "first delay %s", delays[0])
+	assert.True(t, delays[3] > 15*time.Millisecond, "growing delay %s", delays[3])
+	assert.True(t, delays[4] <= 15*time.Millisecond, "delay after healthy stream %s", delays[4])
+}
+
+func TestStreamAPI_MaxInflightBatches(t *testing.T) {
+	first := Cursor{NakadiStreamID: "stream-id", EventType: "test-event", Partition: "0", Offset: "001-0001-000000000000000001"}
+	second := Cursor{NakadiStreamID: "stream-id", EventType: "test-event", Partition: "0", Offset: "001-0001-000000000000000002"}
+	streamAPI, opener, committer := newMockStream(nil, nil)
+	defer streamAPI.Close()
+	streamAPI.maxInflight = 1
+	streamAPI.commitSignal = make(chan struct{}, 1)
+
+	stream := &mockStreamer{}
+	opener.On("openStream").Return(stream, nil)
+	stream.On("nextEvents").Once().Return(first, []byte(`[{"test":"event"}]`), nil)
+	stream.On("nextEvents").Once().Return(second, []byte(`[{"test":"event"}]`), nil)
+	stream.On("nextEvents").Return(Cursor{}, []byte(nil), nil)
+	stream.On("closeStream").Return(nil)
+	committer.On("commitCursors", []Cursor{first}).Return(nil)
+
+	go streamAPI.startStream()
+
+	cursor, _, err := streamAPI.NextEvents()
+	require.NoError(t, err)
+	assert.Equal(t, first, cursor)
+
+	received := make(chan Cursor, 1)
+	go func() {
+		cursor, _, _ := streamAPI.NextEvents()
+		received <- cursor
+	}()
+	select {
+	case <-received:
+		assert.Fail(t, "batch must not be delivered before the previous batch is committed")
+	case <-time.After(50 * time.Millisecond):
+	}
+	assert.Equal(t, 1, streamAPI.InflightBatches())
+
+	require.NoError(t, streamAPI.CommitCursor(first))
+	select {
+	case cursor := <-received:
+		assert.Equal(t, second, cursor)
+	case <-time.After(time.Second):
+		assert.Fail(t, "batch was not delivered after the commit")
+	}
+	assert.Equal(t, 1, streamAPI.InflightBatches())
+}
+
+func TestStreamAPI_keepAliveLimit(t *testing.T) {
+	okCh := make(chan struct{}, 10)
+	streamAPI, opener, _ := setupMockStream(nil, okCh)
+	streamAPI.keepAliveLimit = 2
+
+	stream := &mockStreamer{}
+	opener.On("openStream").Return(stream, nil)
+	stream.On("nextEvents").Return(Cursor{}, []byte{}, nil)
+	stream.On("closeStream").Return(nil)
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-okCh:
+			// nothing
+		case <-time.After(100 * time.Millisecond):
+			assert.Fail(t, "stream was not reconnected")
+		}
+	}
+	streamAPI.Close()
+
+	stream.AssertCalled(t, "closeStream")
+}
+
+func TestStreamAPI_maxStreamLifetime(t *testing.T) {
+	t.Run("reconnect after delivered batches are committed", func(t *testing.T) {
+		streamAPI, opener, committer := newMockStream(nil, nil)
+		streamAPI.maxStreamLifetime = 20 * time.Millisecond
+		streamAPI.lifetimeCommitWait = 10 * time.Second
+
+		var opened, closed int32
+		cursor := Cursor{EventType: "test-event", Partition: "0", Offset: "1", NakadiStreamID: "stream-id"}
+		stream := &mockStreamer{}
+		opener.On("openStream").Return(stream, nil).Run(func(_ mock.Arguments) { atomic.AddInt32(&opened, 1) })
+		stream.On("nextEvents").Return(cursor, []byte(`[{}]`), nil)
+		stream.On("closeStream").Return(nil).Run(func(_ mock.Arguments) { atomic.AddInt32(&closed, 1) })
+		committer.On("commitCursors", mock.Anything).Return(nil)
+
+		go streamAPI.startStream()
+		defer streamAPI.Close()
+
+		start := time.Now()
+		for time.Since(start) < 100*time.Millisecond {
+			next, _, err := streamAPI.NextEvents()
+			require.NoError(t, err)
+			require.NoError(t, streamAPI.CommitCursor(next))
+		}
+
+		assert.True(t, atomic.LoadInt32(&closed) > 0, "stream was not closed")
+		assert.True(t, atomic.LoadInt32(&opened) >= 2, "stream was not reconnected")
+	})
+
+	t.Run("reconnect after commit wait time", func(t *testing.T) {
+		okCh := make(chan struct{}, 100)
+		streamAPI, opener, _ := newMockStream(nil, okCh)
+		streamAPI.maxStreamLifetime = 10 * time.Millisecond
+		streamAPI.lifetimeCommitWait = 10 * time.Millisecond
+
+		stream := &mockStreamer{}
+		opener.On("openStream").Return(stream, nil)
+		stream.On("nextEvents").Return(Cursor{EventType: "test-event", Partition: "0", Offset: "1"}, []byte(`[{}]`), nil)
+		stream.On("closeStream").Return(nil)
+
+		go streamAPI.startStream()
+		defer streamAPI.Close()
+
+		for i := 0; i < 2; i++ {
+			select {
+			case <-okCh:
+			case <-time.After(time.Second):
+				require.Fail(t, "stream was not reconnected")
+			}
+			for len(okCh) == 0 {
+				select {
+				case <-streamAPI.eventCh:
+				case <-time.After(time.Second):
+					require.Fail(t, "stream was not reconnected")
+				}
+			}
+		}
+	})
+}
+
+func TestKeepAliveThreshold(t *testing.T) {
+	assert.Equal(t, 0, keepAliveThreshold(0))
+	assert.Equal(t, 0, keepAliveThreshold(1))
+	assert.Equal(t, 1, keepAliveThreshold(2))
+
+	for i := 0; i < 100; i++ {
+		threshold := keepAliveThreshold(20)
+		assert.True(t, threshold >= 15 && threshold <= 19, "threshold %d", threshold)
+	}
+}
+
 func TestStreamAPI_NextEvents(t *testing.T) {
 	expectedCursor := Cursor{NakadiStreamID: "stream-id"}
 	expectedEvents := []byte(`"events":[{"metadata":{"eid":"74450ab6-5461-11e7-9dd2-87c3afa8811f"})]`)
@@ -125,6 +362,59 @@ func TestStreamAPI_NextEvents(t *testing.T) {
 	})
 }
 
+func TestStreamAPI_Channel(t *testing.T) {
+	expectedCursor := Cursor{NakadiStreamID: "stream-id"}
+	expectedEvents := []byte(`"events":[{"metadata":{"eid":"74450ab6-5461-11e7-9dd2-87c3afa8811f"})]`)
+
+	stream := &mockStreamer{}
+	streamAPI, opener, _ := setupMockStream(nil, nil)
+
+	opener.On("openStream").Return(stream, nil)
+	stream.On("nextEvents").Once().Return(expectedCursor, expectedEvents, nil)
+	stream.On("nextEvents").Once().Return(Cursor{}, nil, assert.AnError)
+	stream.On("nextEvents").Return(expectedCursor, expectedEvents, nil)
+	stream.On("closeStream").Return(nil)
+
+	batchCh, errCh := streamAPI.Channel()
+
+	batch := <-batchCh
+	assert.Equal(t, StreamBatch{Cursor: expectedCursor, Events: expectedEvents}, batch)
+
+	err := <-errCh
+	assert.Equal(t, assert.AnError, err)
+
+	batch = <-batchCh
+	assert.Equal(t, StreamBatch{Cursor: expectedCursor, Events: expectedEvents}, batch)
+
+	streamAPI.Close()
+
+	for range batchCh {
+		// drain until closed
+	}
+	_, ok := <-errCh
+	assert.False(t, ok)
+}
+
+func TestStreamAPI_ChannelBufferSize(t *testing.T) {
+	t.Run("success buffer size option", func(t *testing.T) {
+		client := &Client{nakadiURL: defaultNakadiURL, httpStreamClient: &http.Client{Transport: httpmock.NewMockTransport()}}
+
+		streamAPI := NewStream(client, "sub-id", nil)
+		assert.Equal(t, defaultChannelBufferSize, cap(streamAPI.eventCh))
+		streamAPI.Close()
+
+		bufferSize := uint(5)
+		streamAPI = NewStream(client, "sub-id", &StreamOptions{ChannelBufferSize: &bufferSize})
+		assert.Equal(t, 5, cap(streamAPI.eventCh))
+		streamAPI.Close()
+
+		bufferSize = 0
+		streamAPI = NewStream(client, "sub-id", &StreamOptions{ChannelBufferSize: &bufferSize})
+		assert.Equal(t, 0, cap(streamAPI.eventCh))
+		streamAPI.Close()
+	})
+}
+
 func TestStreamAPI_CommitCursor(t *testing.T) {
 	retryCh := make(chan error, 1)
 	okCh := make(chan struct{}, 1)
@@ -136,7 +426,7 @@ func TestStreamAPI_CommitCursor(t *testing.T) {
 	t.Run("fail with time out", func(t *testing.T) {
 		streamAPI, opener, committer := setupMockStream(retryCh, okCh)
 		opener.On("openStream").WaitUntil(blockStreamer)
-		committer.On("commitCursor", expectedCursor).WaitUntil(blockCh).Twice().Return(assert.AnError)
+		committer.On("commitCursors", []Cursor{expectedCursor}).WaitUntil(blockCh).Twice().Return(assert.AnError)
 
 		go func() {
 			err := streamAPI.CommitCursor(expectedCursor)
@@ -158,7 +448,7 @@ func TestStreamAPI_CommitCursor(t *testing.T) {
 	t.Run("fail retry succeed", func(t *testing.T) {
 		streamAPI, opener, committer := setupMockStream(retryCh, okCh)
 		opener.On("openStream").WaitUntil(blockStreamer)
-		committer.On("commitCursor", expectedCursor).WaitUntil(blockCh).Once().Return(assert.AnError)
+		committer.On("commitCursors", []Cursor{expectedCursor}).WaitUntil(blockCh).Once().Return(assert.AnError)
 
 		go func() {
 			err := streamAPI.CommitCursor(expectedCursor)
@@ -170,7 +460,7 @@ func TestStreamAPI_CommitCursor(t *testing.T) {
 		err := <-retryCh
 		assert.EqualError(t, assert.AnError, err.Error())
 
-		committer.On("commitCursor", expectedCursor).WaitUntil(blockCh).Once().Return(nil)
+		committer.On("commitCursors", []Cursor{expectedCursor}).WaitUntil(blockCh).Once().Return(nil)
 		blockCh <- time.Now()
 
 		err = <-errorCh
@@ -187,7 +477,7 @@ func TestStreamAPI_CommitCursor(t *testing.T) {
 	t.Run("success", func(t *testing.T) {
 		streamAPI, opener, committer := setupMockStream(nil, nil)
 		opener.On("openStream").WaitUntil(blockStreamer)
-		committer.On("commitCursor", expectedCursor).Once().Return(nil).WaitUntil(blockCh)
+		committer.On("commitCursors", []Cursor{expectedCursor}).Once().Return(nil).WaitUntil(blockCh)
 		blockCh <- time.Now()
 		err := streamAPI.CommitCursor(expectedCursor)
 
@@ -195,6 +485,500 @@ func TestStreamAPI_CommitCursor(t *testing.T) {
 	})
 }
 
+func TestStreamAPI_CommitCursorDeduplication(t *testing.T) {
+	streamAPI, opener, committer := setupMockStream(nil, nil)
+	opener.On("openStream").WaitUntil(make(chan time.Time))
+	committer.On("commitCursors", mock.Anything).Return(nil)
+
+	cursor := Cursor{EventType: "test-event", Partition: "0", Offset: "001-0001-000000000000000002", NakadiStreamID: "stream-id"}
+	earlier := Cursor{EventType: "test-event", Partition: "0", Offset: "001-0001-000000000000000001", NakadiStreamID: "stream-id"}
+	otherPartition := Cursor{EventType: "test-event", Partition: "1", Offset: "001-0001-000000000000000001", NakadiStreamID: "stream-id"}
+	otherStream := Cursor{EventType: "test-event", Partition: "0", Offset: "001-0001-000000000000000001", NakadiStreamID: "other-stream-id"}
+
+	require.NoError(t, streamAPI.CommitCursor(cursor))
+	require.NoError(t, streamAPI.CommitCursor(cursor))
+	require.NoError(t, streamAPI.CommitCursor(cursor))
+	committer.AssertNumberOfCalls(t, "commitCursors", 1)
+
+	require.NoError(t, streamAPI.CommitCursor(earlier))
+	committer.AssertNumberOfCalls(t, "commitCursors", 1)
+
+	require.NoError(t, streamAPI.CommitCursor(otherPartition))
+	committer.AssertNumberOfCalls(t, "commitCursors", 2)
+
+	require.NoError(t, streamAPI.CommitCursor(otherStream))
+	committer.AssertNumberOfCalls(t, "commitCursors", 3)
+}
+
+func TestStreamAPI_CommitCursors(t *testing.T) {
+	t.Run("commit a subset of partitions", func(t *testing.T) {
+		streamAPI, opener, committer := setupMockStream(nil, nil)
+		opener.On("openStream").WaitUntil(make(chan time.Time))
+
+		first := Cursor{EventType: "test-event", Partition: "0", Offset: "001-0001-000000000000000001", NakadiStreamID: "stream-id"}
+		second := Cursor{EventType: "test-event", Partition: "2", Offset: "001-0001-000000000000000005", NakadiStreamID: "stream-id"}
+		committer.On("commitCursors", []Cursor{second}).Once().Return(nil)
+		committer.On("commitCursors", []Cursor{first}).Once().Return(nil)
+
+		require.NoError(t, streamAPI.CommitCursors([]Cursor{second}))
+		require.NoError(t, streamAPI.CommitCursors([]Cursor{first, second}))
+
+		committer.AssertExpectations(t)
+		assert.Equal(t, []Cursor{first, second}, streamAPI.CommittedCursors())
+	})
+
+	t.Run("fail cursors of different streams", func(t *testing.T) {
+		streamAPI, opener, committer := setupMockStream(nil, nil)
+		opener.On("openStream").WaitUntil(make(chan time.Time))
+
+		err := streamAPI.CommitCursors([]Cursor{
+			{EventType: "test-event", Partition: "0", Offset: "1", NakadiStreamID: "stream-id"},
+			{EventType: "test-event", Partition: "1", Offset: "1", NakadiStreamID: "other-stream-id"}})
+		require.Error(t, err)
+		committer.AssertNotCalled(t, "commitCursors", mock.Anything)
+	})
+
+	t.Run("nothing to commit", func(t *testing.T) {
+		streamAPI, opener, committer := setupMockStream(nil, nil)
+		opener.On("openStream").WaitUntil(make(chan time.Time))
+
+		require.NoError(t, streamAPI.CommitCursors(nil))
+		committer.AssertNotCalled(t, "commitCursors", mock.Anything)
+	})
+
+	t.Run("fail commit results without retry", func(t *testing.T) {
+		streamAPI, opener, committer := setupMockStream(nil, nil)
+		opener.On("openStream").WaitUntil(make(chan time.Time))
+
+		cursor := Cursor{EventType: "test-event", Partition: "0", Offset: "001-0001-000000000000000001", NakadiStreamID: "stream-id"}
+		resultErr := CommitResultError{Results: []CommitResult{{Cursor: cursor, Result: CommitResultOutdated}}}
+		committer.On("commitCursors", []Cursor{cursor}).Once().Return(resultErr)
+
+		err := streamAPI.CommitCursors([]Cursor{cursor})
+		assert.Equal(t, resultErr, err)
+		committer.AssertExpectations(t)
+		assert.Empty(t, streamAPI.CommittedCursors())
+	})
+
+	t.Run("fail commit after max total attempts", func(t *testing.T) {
+		streamAPI, opener, committer := setupMockStream(nil, nil)
+		opener.On("openStream").WaitUntil(make(chan time.Time))
+		streamAPI.maxAttempts = 2
+
+		cursor := Cursor{EventType: "test-event", Partition: "0", Offset: "001-0001-000000000000000001", NakadiStreamID: "stream-id"}
+		committer.On("commitCursors", []Cursor{cursor}).Return(assert.AnError)
+
+		err := streamAPI.CommitCursors([]Cursor{cursor})
+		assert.Equal(t, assert.AnError, err)
+		committer.AssertNumberOfCalls(t, "commitCursors", 2)
+	})
+}
+
+func TestStreamAPI_BatchesRead(t *testing.T) {
+	stream := &mockStreamer{}
+	streamAPI, opener, _ := newMockStream(nil, nil)
+	opener.On("openStream").Return(stream, nil)
+	stream.On("nextEvents").Return(Cursor{NakadiStreamID: "stream-id"}, []byte(`[{}]`), nil).Twice()
+	stream.On("nextEvents").Return(Cursor{NakadiStreamID: "stream-id"}, []byte{}, nil).Once()
+	stream.On("nextEvents").Return(Cursor{}, []byte{}, nil).WaitUntil(make(chan time.Time))
+	stream.On("closeStream").Return(nil)
+	streamAPI.bytesRead = 42
+
+	go streamAPI.startStream()
+	defer streamAPI.Close()
+
+	for i := 0; i < 2; i++ {
+		_, _, err := streamAPI.NextEvents()
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, int64(2), streamAPI.BatchesRead())
+	assert.Equal(t, int64(42), streamAPI.BytesRead())
+}
+
+func TestStreamAPI_Next(t *testing.T) {
+	cursor := Cursor{NakadiStreamID: "stream-id", Partition: "0", Offset: "001-0001-000000000000000001"}
+	keepAlive := Cursor{NakadiStreamID: "stream-id", Partition: "1", Offset: "001-0001-000000000000000007"}
+
+	setup := func(deliverKeepAlives bool) *StreamAPI {
+		stream := &mockStreamer{}
+		streamAPI, opener, _ := newMockStream(nil, nil)
+		streamAPI.deliverKeepAlives = deliverKeepAlives
+		opener.On("openStream").Return(stream, nil)
+		stream.On("nextEvents").Return(keepAlive, []byte{}, nil).Once()
+		stream.On("nextEvents").Return(cursor, []byte(`[{}]`), nil).Once()
+		stream.On("nextEvents").Return(Cursor{}, []byte(nil), nil).WaitUntil(make(chan time.Time))
+		stream.On("closeStream").Return(nil)
+		go streamAPI.startStream()
+		return streamAPI
+	}
+
+	t.Run("success skip keep-alive batches", func(t *testing.T) {
+		streamAPI := setup(false)
+		defer streamAPI.Close()
+
+		batch, err := streamAPI.Next(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, StreamBatch{Cursor: cursor, Events: []byte(`[{}]`)}, batch)
+	})
+
+	t.Run("success deliver keep-alive batches", func(t *testing.T) {
+		streamAPI := setup(true)
+		defer streamAPI.Close()
+
+		batch, err := streamAPI.Next(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, keepAlive, batch.Cursor)
+		assert.Empty(t, batch.Events)
+
+		batch, err = streamAPI.Next(context.Background())
+		require.NoError(t, err)
+		assert.Equal(t, cursor, batch.Cursor)
+		assert.Equal(t, int64(1), streamAPI.BatchesRead())
+	})
+
+	t.Run("fail context done", func(t *testing.T) {
+		streamAPI := setup(false)
+		defer streamAPI.Close()
+		_, err := streamAPI.Next(context.Background())
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		_, err = streamAPI.Next(ctx)
+		assert.Equal(t, context.DeadlineExceeded, err)
+	})
+
+	t.Run("success end of stream", func(t *testing.T) {
+		streamAPI := setup(false)
+		streamAPI.Close()
+
+		_, err := streamAPI.Next(context.Background())
+		assert.Equal(t, io.EOF, err)
+	})
+
+	t.Run("success end of closed event channel", func(t *testing.T) {
+		streamAPI, _, _ := newMockStream(nil, nil)
+		streamAPI.cancel()
+		close(streamAPI.eventCh)
+
+		for i := 0; i < 100; i++ {
+			_, err := streamAPI.Next(context.Background())
+			require.Equal(t, io.EOF, err)
+		}
+	})
+}
+
+func TestStreamAPI_ConcurrentCommitOrder(t *testing.T) {
+	streamAPI, opener, committer := setupMockStream(nil, nil)
+	opener.On("openStream").WaitUntil(make(chan time.Time))
+
+	newer := Cursor{EventType: "test-event", Partition: "0", Offset: "001-0001-000000000000000005", NakadiStreamID: "stream-id"}
+	older := Cursor{EventType: "test-event", Partition: "0", Offset: "001-0001-000000000000000002", NakadiStreamID: "stream-id"}
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	committer.On("commitCursors", []Cursor{older}).Run(func(mock.Arguments) {
+		close(started)
+		<-release
+	}).Return(nil)
+	committer.On("commitCursors", []Cursor{newer}).Return(nil)
+
+	done := make(chan error)
+	go func() { done <- streamAPI.CommitCursor(older) }()
+
+	<-started
+	require.NoError(t, streamAPI.CommitCursor(newer))
+	close(release)
+	require.NoError(t, <-done)
+
+	assert.Equal(t, []Cursor{newer}, streamAPI.CommittedCursors())
+}
+
+func TestStreamAPI_EnforceCommitOrder(t *testing.T) {
+	newer := Cursor{EventType: "test-event", Partition: "0", Offset: "001-0001-000000000000000005", NakadiStreamID: "stream-id"}
+	older := Cursor{EventType: "test-event", Partition: "0", Offset: "001-0001-000000000000000002", NakadiStreamID: "stream-id"}
+	other := Cursor{EventType: "test-event", Partition: "1", Offset: "001-0001-000000000000000001", NakadiStreamID: "stream-id"}
+
+	t.Run("skip older cursors by default", func(t *testing.T) {
+		streamAPI, opener, committer := setupMockStream(nil, nil)
+		opener.On("openStream").WaitUntil(make(chan time.Time))
+		committer.On("commitCursors", []Cursor{newer}).Once().Return(nil)
+
+		require.NoError(t, streamAPI.CommitCursor(newer))
+		require.NoError(t, streamAPI.CommitCursor(older))
+
+		committer.AssertExpectations(t)
+		assert.Equal(t, []Cursor{newer}, streamAPI.CommittedCursors())
+	})
+
+	t.Run("reject older cursors", func(t *testing.T) {
+		streamAPI, opener, committer := setupMockStream(nil, nil)
+		opener.On("openStream").WaitUntil(make(chan time.Time))
+		committer.On("commitCursors", []Cursor{newer}).Once().Return(nil)
+		streamAPI.enforceCommitOrder = true
+
+		require.NoError(t, streamAPI.CommitCursor(newer))
+		err := streamAPI.CommitCursors([]Cursor{older, other})
+		require.Error(t, err)
+		assert.Equal(t, CommitOrderError{Cursor: older, Committed: newer}, err)
+		assert.Regexp(t, "offset 001-0001-000000000000000002 of partition 0 of test-event is behind", err)
+
+		committer.AssertExpectations(t)
+		assert.Equal(t, []Cursor{newer}, streamAPI.CommittedCursors())
+	})
+
+	t.Run("accept equal cursors", func(t *testing.T) {
+		streamAPI, opener, committer := setupMockStream(nil, nil)
+		opener.On("openStream").WaitUntil(make(chan time.Time))
+		committer.On("commitCursors", []Cursor{newer}).Once().Return(nil)
+		streamAPI.enforceCommitOrder = true
+
+		require.NoError(t, streamAPI.CommitCursor(newer))
+		require.NoError(t, streamAPI.CommitCursor(newer))
+
+		committer.AssertExpectations(t)
+	})
+
+	t.Run("accept older cursors of a new stream", func(t *testing.T) {
+		streamAPI, opener, committer := setupMockStream(nil, nil)
+		opener.On("openStream").WaitUntil(make(chan time.Time))
+		olderOfNewStream := older
+		olderOfNewStream.NakadiStreamID = "new-stream-id"
+		committer.On("commitCursors", []Cursor{newer}).Once().Return(nil)
+		committer.On("commitCursors", []Cursor{olderOfNewStream}).Once().Return(nil)
+		streamAPI.enforceCommitOrder = true
+
+		require.NoError(t, streamAPI.CommitCursor(newer))
+		require.NoError(t, streamAPI.CommitCursor(olderOfNewStream))
+
+		committer.AssertExpectations(t)
+	})
+}
+
+func TestStreamAPI_OffsetStore(t *testing.T) {
+	t.Run("mirror commits", func(t *testing.T) {
+		streamAPI, opener, committer := setupMockStream(nil, nil)
+		opener.On("openStream").WaitUntil(make(chan time.Time))
+		committer.On("commitCursors", mock.Anything).Return(nil)
+		store := NewMemoryOffsetStore()
+		streamAPI.subscriptionID = "sub-id"
+		streamAPI.offsetStore = store
+
+		first := Cursor{EventType: "test-event", Partition: "0", Offset: "001-0001-000000000000000001", NakadiStreamID: "stream-id"}
+		second := Cursor{EventType: "test-event", Partition: "1", Offset: "001-0001-000000000000000002", NakadiStreamID: "stream-id"}
+		require.NoError(t, streamAPI.CommitCursor(first))
+		require.NoError(t, streamAPI.CommitCursor(second))
+
+		stored, err := store.Load("sub-id")
+		require.NoError(t, err)
+		assert.Equal(t, []Cursor{first, second}, stored)
+	})
+
+	t.Run("mirror concurrent commits in order", func(t *testing.T) {
+		streamAPI, opener, committer := setupMockStream(nil, nil)
+		opener.On("openStream").WaitUntil(make(chan time.Time))
+		committer.On("commitCursors", mock.Anything).Return(nil)
+		store := NewMemoryOffsetStore()
+		streamAPI.subscriptionID = "sub-id"
+		streamAPI.offsetStore = store
+
+		var commits sync.WaitGroup
+		for i := 1; i <= 20; i++ {
+			commits.Add(1)
+			go func(partition int) {
+				defer commits.Done()
+				cursor := Cursor{EventType: "test-event", Partition: strconv.Itoa(partition), Offset: "1", NakadiStreamID: "stream-id"}
+				assert.NoError(t, streamAPI.CommitCursor(cursor))
+			}(i)
+		}
+		commits.Wait()
+
+		stored, err := store.Load("sub-id")
+		require.NoError(t, err)
+		assert.Len(t, stored, 20)
+	})
+
+	t.Run("report store errors", func(t *testing.T) {
+		errCh := make(chan error, 1)
+		streamAPI, opener, committer := setupMockStream(errCh, nil)
+		opener.On("openStream").WaitUntil(make(chan time.Time))
+		committer.On("commitCursors", mock.Anything).Return(nil)
+		streamAPI.offsetStore = brokenOffsetStore{}
+
+		err := streamAPI.CommitCursor(Cursor{EventType: "test-event", Partition: "0", Offset: "1", NakadiStreamID: "stream-id"})
+		require.NoError(t, err)
+
+		select {
+		case err := <-errCh:
+			assert.Regexp(t, "unable to mirror committed cursors", err.Error())
+		default:
+			t.Error("store error was not reported")
+		}
+	})
+}
+
+type brokenOffsetStore struct{}
+
+func (brokenOffsetStore) Save(_ string, _ []Cursor) error { return assert.AnError }
+func (brokenOffsetStore) Load(_ string) ([]Cursor, error) { return nil, assert.AnError }
+
+func TestCursor_Before(t *testing.T) {
+	cursor := Cursor{EventType: "test-event", Partition: "0", Offset: "001-0001-000000000000000002"}
+
+	t.Run("fail different partitions", func(t *testing.T) {
+		_, err := cursor.Before(Cursor{EventType: "test-event", Partition: "1", Offset: "BEGIN"})
+		require.Error(t, err)
+		assert.Regexp(t, "different partitions", err)
+	})
+
+	t.Run("fail different event types", func(t *testing.T) {
+		_, err := cursor.Before(Cursor{EventType: "other-event", Partition: "0", Offset: "BEGIN"})
+		require.Error(t, err)
+		assert.Regexp(t, "different partitions", err)
+	})
+
+	t.Run("fail incomparable offsets", func(t *testing.T) {
+		_, err := cursor.Before(Cursor{EventType: "test-event", Partition: "0", Offset: "12"})
+		require.Error(t, err)
+		assert.Regexp(t, "unable to compare offsets", err)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		later := Cursor{EventType: "test-event", Partition: "0", Offset: "001-0001-000000000000000010"}
+
+		before, err := cursor.Before(later)
+		require.NoError(t, err)
+		assert.True(t, before)
+
+		before, err = later.Before(cursor)
+		require.NoError(t, err)
+		assert.False(t, before)
+
+		before, err = cursor.Before(cursor)
+		require.NoError(t, err)
+		assert.False(t, before)
+	})
+}
+
+func TestCompareOffsets(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+		ok       bool
+	}{
+		{"BEGIN", "BEGIN", 0, true},
+		{"BEGIN", "001-0001-000000000000000001", -1, true},
+		{"001-0001-000000000000000001", "BEGIN", 1, true},
+		{"001-0001-000000000000000002", "001-0001-000000000000000010", -1, true},
+		{"001-0001-000000000000000010", "001-0001-000000000000000002", 1, true},
+		{"9", "10", -1, true},
+		{"10", "9", 1, true},
+		{"001-0001-1", "10", 0, false},
+	}
+
+	for _, test := range tests {
+		cmp, ok := compareOffsets(test.a, test.b)
+		assert.Equal(t, test.ok, ok, "%s <> %s", test.a, test.b)
+		assert.Equal(t, test.expected, cmp, "%s <> %s", test.a, test.b)
+	}
+}
+
+func TestStreamAPI_CommittedCursors(t *testing.T) {
+	streamAPI, opener, committer := setupMockStream(nil, nil)
+	opener.On("openStream").WaitUntil(make(chan time.Time))
+
+	cursors := []Cursor{
+		{EventType: "test-event", Partition: "1", Offset: "1"},
+		{EventType: "test-event", Partition: "0", Offset: "1"},
+		{EventType: "test-event", Partition: "0", Offset: "2"}}
+	committer.On("commitCursors", mock.Anything).Return(nil)
+
+	assert.Empty(t, streamAPI.CommittedCursors())
+
+	for _, c := range cursors {
+		require.NoError(t, streamAPI.CommitCursor(c))
+	}
+
+	assert.Equal(t, []Cursor{cursors[2], cursors[0]}, streamAPI.CommittedCursors())
+}
+
+func TestStreamAPI_CommitKeepAlive(t *testing.T) {
+	streamAPI, opener, committer := setupMockStream(nil, nil)
+	streamAPI.commitKeepAlive = 5 * time.Millisecond
+	defer streamAPI.Close()
+
+	committed := Cursor{EventType: "test-event", Partition: "0", Offset: "1", NakadiStreamID: "stream-id"}
+	next := Cursor{EventType: "test-event", Partition: "0", Offset: "2", NakadiStreamID: "stream-id"}
+
+	stream := &mockStreamer{}
+	opener.On("openStream").Return(stream, nil)
+	stream.On("nextEvents").Return(next, []byte(`[{}]`), nil)
+	stream.On("closeStream").Return(nil)
+
+	var keepAlives int32
+	committer.On("commitCursors", []Cursor{committed}).Return(nil).Run(func(_ mock.Arguments) {
+		atomic.AddInt32(&keepAlives, 1)
+	})
+	committer.On("commitCursors", []Cursor{next}).Return(nil)
+
+	require.NoError(t, streamAPI.CommitCursor(committed))
+	atomic.StoreInt32(&keepAlives, 0)
+
+	_, _, err := streamAPI.NextEvents()
+	require.NoError(t, err)
+	time.Sleep(30 * time.Millisecond)
+
+	require.NoError(t, streamAPI.CommitCursor(next))
+	time.Sleep(10 * time.Millisecond)
+	count := atomic.LoadInt32(&keepAlives)
+	assert.True(t, count >= 2, "only %d keep alive commits", count)
+
+	time.Sleep(30 * time.Millisecond)
+	assert.Equal(t, count, atomic.LoadInt32(&keepAlives))
+
+	t.Run("no keep alive of stale stream", func(t *testing.T) {
+		streamAPI, opener, committer := setupMockStream(nil, nil)
+		defer streamAPI.Close()
+		opener.On("openStream").Return(nil, assert.AnError)
+		streamAPI.commitKeepAlive = 5 * time.Millisecond
+		committer.On("commitCursors", []Cursor{committed}).Return(nil)
+		require.NoError(t, streamAPI.CommitCursor(committed))
+
+		streamAPI.setStreamID("other-stream-id")
+		streamAPI.startCommitKeepAlive("stream-id")
+		time.Sleep(30 * time.Millisecond)
+		committer.AssertNumberOfCalls(t, "commitCursors", 1)
+	})
+
+	t.Run("outdated keep alive commits with all or nothing", func(t *testing.T) {
+		streamAPI, opener, committer := newMockStream(nil, nil)
+		var errCount int32
+		streamAPI.notifyErr = func(err error, _ time.Duration) {
+			if _, ok := err.(CommitResultError); ok {
+				atomic.AddInt32(&errCount, 1)
+			}
+		}
+		opener.On("openStream").Return(nil, assert.AnError)
+		go streamAPI.startStream()
+		defer streamAPI.Close()
+		streamAPI.commitKeepAlive = 5 * time.Millisecond
+		committer.On("commitCursors", []Cursor{committed}).Once().Return(nil)
+		var keepAlives int32
+		committer.On("commitCursors", []Cursor{committed}).Return(CommitResultError{Results: []CommitResult{
+			{Cursor: committed, Result: CommitResultOutdated}}}).Run(func(_ mock.Arguments) {
+			atomic.AddInt32(&keepAlives, 1)
+		})
+		require.NoError(t, streamAPI.CommitCursor(committed))
+
+		streamAPI.startCommitKeepAlive("stream-id")
+		time.Sleep(30 * time.Millisecond)
+		streamAPI.stopCommitKeepAlive()
+		assert.True(t, atomic.LoadInt32(&keepAlives) > 0)
+		assert.Equal(t, int32(0), atomic.LoadInt32(&errCount))
+	})
+}
+
 func TestStreamAPI_Close(t *testing.T) {
 	errorCh := make(chan error, 1)
 	blockCh := make(chan time.Time, 1)
@@ -221,7 +1005,336 @@ func TestStreamAPI_Close(t *testing.T) {
 	stream.AssertCalled(t, "closeStream")
 }
 
+func TestClient_AcquirePartitions(t *testing.T) {
+	subscriptionURL := defaultNakadiURL + "/subscriptions/sub-id"
+	setup := func() (*httpmock.MockTransport, *Client) {
+		transport := httpmock.NewMockTransport()
+		transport.RegisterResponder("GET", subscriptionURL, httpmock.NewStringResponder(http.StatusOK,
+			`{"id":"sub-id","event_types":["event-a","event-b"]}`))
+		transport.RegisterResponder("GET", defaultNakadiURL+"/event-types/event-a/partitions", httpmock.NewStringResponder(http.StatusOK,
+			`[{"partition":"0"},{"partition":"1"},{"partition":"2"}]`))
+		transport.RegisterResponder("GET", defaultNakadiURL+"/event-types/event-b/partitions", httpmock.NewStringResponder(http.StatusOK,
+			`[{"partition":"0"}]`))
+		return transport, &Client{
+			nakadiURL:        defaultNakadiURL,
+			httpClient:       &http.Client{Transport: transport},
+			httpStreamClient: &http.Client{Transport: transport}}
+	}
+
+	t.Run("fail no partitions", func(t *testing.T) {
+		_, client := setup()
+
+		_, err := client.AcquirePartitions("sub-id", nil, nil)
+		require.Error(t, err)
+		assert.Regexp(t, "unable to acquire partitions: no partitions requested", err)
+	})
+
+	t.Run("fail unknown partition", func(t *testing.T) {
+		_, client := setup()
+
+		_, err := client.AcquirePartitions("sub-id", []string{"1", "7"}, nil)
+		require.Error(t, err)
+		assert.Regexp(t, "partition 7 does not belong to the event types of subscription sub-id", err)
+	})
+
+	t.Run("success stream requested partitions", func(t *testing.T) {
+		transport, client := setup()
+		bodies := make(chan string, 1)
+		var calls int32
+		transport.RegisterResponder("POST", subscriptionURL+"/events", func(r *http.Request) (*http.Response, error) {
+			if atomic.AddInt32(&calls, 1) > 1 {
+				return helperCanceledResponder()(r)
+			}
+			body, err := ioutil.ReadAll(r.Body)
+			require.NoError(t, err)
+			bodies <- string(body)
+			return httpmock.NewStringResponse(http.StatusOK, `{"cursor":{"partition":"0","offset":"1","event_type":"event-b"},"events":[{}]}`+"\n"), nil
+		})
+
+		streamAPI, err := client.AcquirePartitions("sub-id", []string{"0"}, &StreamOptions{BatchLimit: 5})
+		require.NoError(t, err)
+		defer streamAPI.Close()
+
+		assert.JSONEq(t, `{"partitions":[{"event_type":"event-a","partition":"0"},{"event_type":"event-b","partition":"0"}],"batch_limit":5,"max_uncommitted_events":10}`, <-bodies)
+		cursor, _, err := streamAPI.NextEvents()
+		require.NoError(t, err)
+		assert.Equal(t, "event-b", cursor.EventType)
+	})
+}
+
+func TestNewStreamContext(t *testing.T) {
+	client := &Client{nakadiURL: defaultNakadiURL, httpStreamClient: &http.Client{Transport: httpmock.NewMockTransport()}}
+	ctx, cancel := context.WithCancel(context.Background())
+
+	streamAPI := NewStreamContext(ctx, client, "7dd69d58-7f20-11e7-9748-133d6a0dbfb3", nil)
+	cancel()
+
+	_, _, err := streamAPI.NextEvents()
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestStreamAPI_Drain(t *testing.T) {
+	expectedCursor := Cursor{NakadiStreamID: "stream-id"}
+
+	t.Run("success wait for commit", func(t *testing.T) {
+		blockCh := make(chan time.Time)
+		streamAPI, opener, committer := setupMockStream(nil, nil)
+		opener.On("openStream").WaitUntil(make(chan time.Time))
+		committer.On("commitCursors", []Cursor{expectedCursor}).Once().Return(nil).WaitUntil(blockCh)
+
+		commitCh := make(chan error, 1)
+		go func() { commitCh <- streamAPI.CommitCursor(expectedCursor) }()
+		time.Sleep(10 * time.Millisecond)
+
+		drainCh := make(chan error, 1)
+		go func() { drainCh <- streamAPI.Drain(context.Background()) }()
+
+		select {
+		case <-drainCh:
+			assert.Fail(t, "drain returned before commit completed")
+		case <-time.After(20 * time.Millisecond):
+			// nothing
+		}
+
+		close(blockCh)
+		assert.NoError(t, <-commitCh)
+		assert.NoError(t, <-drainCh)
+		assert.Error(t, streamAPI.ctx.Err())
+	})
+
+	t.Run("fail with commit error", func(t *testing.T) {
+		streamAPI, opener, committer := setupMockStream(nil, nil)
+		opener.On("openStream").WaitUntil(make(chan time.Time))
+		streamAPI.commitBackOffConf.Retry = false
+		committer.On("commitCursors", []Cursor{expectedCursor}).Once().Return(assert.AnError)
+
+		assert.Error(t, streamAPI.CommitCursor(expectedCursor))
+
+		err := streamAPI.Drain(context.Background())
+		assert.Equal(t, assert.AnError, err)
+		assert.Error(t, streamAPI.ctx.Err())
+	})
+
+	t.Run("success with superseded commit error", func(t *testing.T) {
+		failed := Cursor{EventType: "test-event", Partition: "0", Offset: "001-0001-000000000000000001", NakadiStreamID: "stream-id"}
+		later := Cursor{EventType: "test-event", Partition: "0", Offset: "001-0001-000000000000000002", NakadiStreamID: "stream-id"}
+		streamAPI, opener, committer := setupMockStream(nil, nil)
+		opener.On("openStream").WaitUntil(make(chan time.Time))
+		streamAPI.commitBackOffConf.Retry = false
+		committer.On("commitCursors", []Cursor{failed}).Once().Return(assert.AnError)
+		committer.On("commitCursors", []Cursor{later}).Once().Return(nil)
+
+		assert.Error(t, streamAPI.CommitCursor(failed))
+		assert.NoError(t, streamAPI.CommitCursor(later))
+
+		assert.NoError(t, streamAPI.Drain(context.Background()))
+	})
+
+	t.Run("fail with commit error of other partition", func(t *testing.T) {
+		failed := Cursor{EventType: "test-event", Partition: "0", Offset: "001-0001-000000000000000001", NakadiStreamID: "stream-id"}
+		other := Cursor{EventType: "test-event", Partition: "1", Offset: "001-0001-000000000000000001", NakadiStreamID: "stream-id"}
+		streamAPI, opener, committer := setupMockStream(nil, nil)
+		opener.On("openStream").WaitUntil(make(chan time.Time))
+		streamAPI.commitBackOffConf.Retry = false
+		committer.On("commitCursors", []Cursor{failed}).Once().Return(assert.AnError)
+		committer.On("commitCursors", []Cursor{other}).Once().Return(nil)
+
+		assert.Error(t, streamAPI.CommitCursor(failed))
+		assert.NoError(t, streamAPI.CommitCursor(other))
+
+		assert.Equal(t, assert.AnError, streamAPI.Drain(context.Background()))
+	})
+
+	t.Run("fail with deadline", func(t *testing.T) {
+		blockCh := make(chan time.Time)
+		defer close(blockCh)
+		streamAPI, opener, committer := setupMockStream(nil, nil)
+		opener.On("openStream").WaitUntil(make(chan time.Time))
+		committer.On("commitCursors", []Cursor{expectedCursor}).Once().Return(nil).WaitUntil(blockCh)
+
+		go streamAPI.CommitCursor(expectedCursor)
+		time.Sleep(10 * time.Millisecond)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		err := streamAPI.Drain(ctx)
+		assert.Equal(t, context.DeadlineExceeded, err)
+		assert.Error(t, streamAPI.ctx.Err())
+	})
+}
+
+func TestStreamAPI_OnCommit(t *testing.T) {
+	first := Cursor{EventType: "test-event", Partition: "0", Offset: "1", NakadiStreamID: "stream-id"}
+	second := Cursor{EventType: "test-event", Partition: "1", Offset: "1", NakadiStreamID: "stream-id"}
+
+	streamAPI, opener, committer := setupMockStream(nil, nil)
+	opener.On("openStream").WaitUntil(make(chan time.Time))
+	committer.On("commitCursors", []Cursor{first}).Once().Return(nil)
+	committer.On("commitCursors", []Cursor{second}).Once().Return(assert.AnError)
+	streamAPI.commitBackOffConf.Retry = false
+
+	var calls [][]Cursor
+	streamAPI.onCommit = func(cursors []Cursor) {
+		// the callback must not be called while the commit lock is held
+		streamAPI.commitLock.Lock()
+		streamAPI.commitLock.Unlock()
+		calls = append(calls, cursors)
+	}
+
+	require.NoError(t, streamAPI.CommitCursors([]Cursor{first}))
+	require.NoError(t, streamAPI.CommitCursor(first))
+	require.Error(t, streamAPI.CommitCursor(second))
+
+	assert.Equal(t, [][]Cursor{{first}}, calls)
+	committer.AssertExpectations(t)
+}
+
+func TestStreamAPI_CommitStaleStream(t *testing.T) {
+	oldCursor := Cursor{EventType: "test-event", Partition: "0", Offset: "1", NakadiStreamID: "old-stream-id"}
+	newCursor := Cursor{EventType: "test-event", Partition: "0", Offset: "1", NakadiStreamID: "new-stream-id"}
+
+	streamAPI, opener, committer := newMockStream(nil, nil)
+	defer streamAPI.Close()
+	oldStream, newStream := &mockStreamer{}, &mockStreamer{}
+	opener.On("openStream").Once().Return(oldStream, nil)
+	opener.On("openStream").Once().Return(newStream, nil)
+	oldStream.On("nextEvents").Once().Return(oldCursor, []byte(`[{}]`), nil)
+	reconnectCh := make(chan time.Time)
+	oldStream.On("nextEvents").Once().Return(Cursor{}, []byte{}, assert.AnError).WaitUntil(reconnectCh)
+	oldStream.On("closeStream").Return(nil)
+	newStream.On("nextEvents").Once().Return(newCursor, []byte(`[{}]`), nil)
+	newStream.On("nextEvents").Return(Cursor{}, []byte{}, nil).WaitUntil(make(chan time.Time))
+	newStream.On("closeStream").Return(nil)
+	committer.On("commitCursors", []Cursor{oldCursor}).Once().Return(nil)
+	committer.On("commitCursors", []Cursor{newCursor}).Once().Return(nil)
+	go streamAPI.startStream()
+
+	cursor, _, err := streamAPI.NextEvents()
+	require.NoError(t, err)
+	require.Equal(t, oldCursor, cursor)
+	require.NoError(t, streamAPI.CommitCursor(cursor))
+
+	// commit the batch of the old stream again after the stream was reconnected
+	close(reconnectCh)
+	_, _, err = streamAPI.NextEvents()
+	require.Equal(t, assert.AnError, err)
+	cursor, _, err = streamAPI.NextEvents()
+	require.NoError(t, err)
+	require.Equal(t, newCursor, cursor)
+
+	err = streamAPI.CommitCursor(oldCursor)
+	require.Error(t, err)
+	assert.Equal(t, ErrStaleStream, errors.Cause(err))
+	assert.Regexp(t, "unable to commit cursor of stream old-stream-id", err)
+
+	require.NoError(t, streamAPI.CommitCursor(newCursor))
+	committer.AssertExpectations(t)
+}
+
+func TestStreamAPI_PauseResume(t *testing.T) {
+	expected := Cursor{EventType: "test-event", Partition: "0", Offset: "1", NakadiStreamID: "stream-id"}
+
+	streamAPI, opener, _ := newMockStream(nil, nil)
+	defer streamAPI.Close()
+	stream := &mockStreamer{}
+	opener.On("openStream").Return(stream, nil)
+	stream.On("nextEvents").Once().Return(expected, []byte(`[{}]`), nil)
+	stream.On("nextEvents").Return(Cursor{}, []byte{}, nil).WaitUntil(make(chan time.Time))
+	stream.On("closeStream").Return(nil)
+
+	streamAPI.Pause()
+	streamAPI.Pause()
+	assert.True(t, streamAPI.Paused())
+	go streamAPI.startStream()
+
+	time.Sleep(50 * time.Millisecond)
+	stream.AssertNotCalled(t, "nextEvents")
+
+	streamAPI.Resume()
+	streamAPI.Resume()
+	assert.False(t, streamAPI.Paused())
+
+	cursor, _, err := streamAPI.NextEvents()
+	require.NoError(t, err)
+	assert.Equal(t, expected, cursor)
+
+	t.Run("close while paused", func(t *testing.T) {
+		streamAPI, opener, _ := newMockStream(nil, nil)
+		stream := &mockStreamer{}
+		opener.On("openStream").Return(stream, nil)
+		stream.On("closeStream").Return(nil)
+
+		streamAPI.Pause()
+		done := make(chan struct{})
+		go func() {
+			streamAPI.startStream()
+			close(done)
+		}()
+		time.Sleep(50 * time.Millisecond)
+		streamAPI.Close()
+
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("stream was not closed")
+		}
+		stream.AssertNotCalled(t, "nextEvents")
+	})
+}
+
+func TestStreamAPI_OnSharedSubscription(t *testing.T) {
+	first := Cursor{EventType: "test-event", Partition: "0", Offset: "1", NakadiStreamID: "stream-id"}
+	second := Cursor{EventType: "test-event", Partition: "0", Offset: "2", NakadiStreamID: "stream-id"}
+
+	streamAPI, opener, _ := newMockStream(nil, nil)
+	defer streamAPI.Close()
+	stream := &mockStreamer{}
+	opener.On("openStream").Return(stream, nil)
+	stream.On("nextEvents").Once().Return(first, []byte(`[{}]`), nil)
+	stream.On("nextEvents").Once().Return(second, []byte(`[{}]`), nil)
+	stream.On("nextEvents").Return(Cursor{}, []byte{}, nil).WaitUntil(make(chan time.Time))
+	stream.On("closeStream").Return(nil)
+
+	var requested int32
+	streamAPI.getStats = func() ([]*SubscriptionStats, error) {
+		atomic.AddInt32(&requested, 1)
+		return []*SubscriptionStats{
+			{EventType: "test-event", Partitions: []*PartitionStats{
+				{Partition: "0", StreamID: "stream-id"},
+				{Partition: "1", StreamID: "other-stream-b"},
+				{Partition: "2"}}},
+			{EventType: "other-event", Partitions: []*PartitionStats{
+				{Partition: "0", StreamID: "other-stream-a"},
+				{Partition: "1", StreamID: "other-stream-b"}}}}, nil
+	}
+	sharedCh := make(chan []string, 1)
+	streamAPI.onShared = func(others []string) { sharedCh <- others }
+	go streamAPI.startStream()
+
+	for i := 0; i < 2; i++ {
+		_, _, err := streamAPI.NextEvents()
+		require.NoError(t, err)
+	}
+
+	select {
+	case others := <-sharedCh:
+		assert.Equal(t, []string{"other-stream-a", "other-stream-b"}, others)
+	case <-time.After(time.Second):
+		t.Fatal("other streams were not reported")
+	}
+	assert.Equal(t, int32(1), atomic.LoadInt32(&requested))
+}
+
 func setupMockStream(errCh chan error, okCh chan struct{}) (*StreamAPI, *mockStreamOpener, *mockCommitter) {
+	stream, opener, committer := newMockStream(errCh, okCh)
+
+	go stream.startStream()
+
+	return stream, opener, committer
+}
+
+func newMockStream(errCh chan error, okCh chan struct{}) (*StreamAPI, *mockStreamOpener, *mockCommitter) {
 	ctx, cancel := context.WithCancel(context.Background())
 
 	opener := &mockStreamOpener{}
@@ -254,10 +1367,9 @@ func setupMockStream(errCh chan error, okCh chan struct{}) (*StreamAPI, *mockStr
 				okCh <- struct{}{}
 			}
 		},
+		onReconnect: func(_ int, _ error, _ time.Duration) {},
 	}
 
-	go stream.startStream()
-
 	return stream, opener, committer
 }
 
@@ -293,6 +1405,6 @@ type mockCommitter struct {
 	mock.Mock
 }
 
-func (c *mockCommitter) commitCursor(cursor Cursor) error {
-	return c.Called(cursor).Error(0)
+func (c *mockCommitter) commitCursors(cursors []Cursor) error {
+	return c.Called(cursors).Error(0)
 }
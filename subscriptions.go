@@ -1,10 +1,15 @@
 package nakadi
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/pkg/errors"
@@ -22,15 +27,63 @@ type SubscriptionAuthorization struct {
 	Readers []AuthorizationAttribute `json:"readers"`
 }
 
-// Subscription represents a subscription as used by the Nakadi high level API.
+// defaultConsumerGroup is the consumer group Nakadi uses for subscriptions without consumer group.
+const defaultConsumerGroup = "default"
+
+// auditConsumerGroupPrefix is prepended to the owning application to form the consumer group of audit
+// subscriptions.
+const auditConsumerGroupPrefix = "audit-"
+
+// Positions from which a new subscription starts to read.
+const (
+	ReadFromBegin   = "begin"
+	ReadFromEnd     = "end"
+	ReadFromCursors = "cursors"
+)
+
+// SubscriptionCursor is a cursor without cursor token. It is used to define the positions of a subscription,
+// e.g. the initial cursors of a new subscription.
+type SubscriptionCursor struct {
+	Partition string `json:"partition"`
+	Offset    string `json:"offset"`
+	EventType string `json:"event_type"`
+}
+
+// Subscription represents a subscription as used by the Nakadi high level API. If ReadFrom is "cursors" the
+// subscription starts to read from the positions given by InitialCursors. Subscriptions returned by Nakadi
+// carry the ReadFrom and InitialCursors they were created with, which shows where a new consumer started.
+// Filter is a server-side filter expression, streams of the subscription only receive the events matching the
+// expression. Filters are only supported by Nakadi clusters with event filtering enabled, the syntax of the
+// expression is defined by the cluster. Clusters which don't know the field may ignore it, the Filter of the
+// returned subscription shows whether it was applied.
 type Subscription struct {
 	ID                string                     `json:"id,omitempty"`
 	OwningApplication string                     `json:"owning_application"`
 	EventTypes        []string                   `json:"event_types"`
 	ConsumerGroup     string                     `json:"consumer_group,omitempty"`
 	ReadFrom          string                     `json:"read_from,omitempty"`
+	InitialCursors    []SubscriptionCursor       `json:"initial_cursors,omitempty"`
 	CreatedAt         time.Time                  `json:"created_at,omitempty"`
 	Authorization     *SubscriptionAuthorization `json:"authorization,omitempty"`
+	Filter            string                     `json:"filter,omitempty"`
+}
+
+// subscriptionResponse decodes a subscription from a response of Nakadi. Older versions of Nakadi use the
+// field event_type with a single event type instead of the array event_types.
+type subscriptionResponse struct {
+	*Subscription
+	EventType string `json:"event_type,omitempty"`
+}
+
+// normalized returns the decoded subscription with the single event type moved into EventTypes.
+func (r *subscriptionResponse) normalized() *Subscription {
+	if r.Subscription == nil {
+		r.Subscription = &Subscription{}
+	}
+	if len(r.EventTypes) == 0 && r.EventType != "" {
+		r.EventTypes = []string{r.EventType}
+	}
+	return r.Subscription
 }
 
 // SubscriptionOptions is a set of optional parameters used to configure the SubscriptionAPI.
@@ -49,6 +102,23 @@ type SubscriptionOptions struct {
 	// Once this value was reached the exponential backoff is halted and the request will
 	// fail with an error.
 	MaxElapsedTime time.Duration
+	// Timeout overrides the connection timeout of the client for requests of this SubscriptionAPI, which
+	// allows slow management operations to use a longer timeout than e.g. publishing. Like the connection
+	// timeout it applies to each single request. The context passed to methods like GetContext limits the
+	// total time of a call including retries (default: 0, the connection timeout of the client).
+	Timeout time.Duration
+	// ClampToAvailable makes ResetCursors move cursors which point before the oldest available offset of their
+	// partition to the beginning of the partition. Otherwise such cursors are rejected with an error caused by
+	// ErrOffsetUnavailable (default: false).
+	ClampToAvailable bool
+	// Whether or not subscriptions without consumer group are created with an explicit empty consumer_group.
+	// By default the field is omitted, so that Nakadi uses its default consumer group, since some clusters
+	// reject an empty consumer group. With the option an empty consumer_group is sent, for clusters which
+	// distinguish it from the default consumer group (default: false).
+	SendEmptyConsumerGroup bool
+	// Whether or not GetStats requests the time lag of partitions, which populates ConsumerLagSeconds of the
+	// returned PartitionStats. Computing the time lag is more expensive for Nakadi (default: false).
+	ShowTimeLag bool
 }
 
 func (o *SubscriptionOptions) withDefaults() *SubscriptionOptions {
@@ -74,74 +144,692 @@ func (o *SubscriptionOptions) withDefaults() *SubscriptionOptions {
 func NewSubscriptionAPI(client *Client, options *SubscriptionOptions) *SubscriptionAPI {
 	options = options.withDefaults()
 
+	if options.Timeout > 0 {
+		client = client.withTimeout(options.Timeout)
+	}
+
 	return &SubscriptionAPI{
 		client: client,
 		backOffConf: backOffConfiguration{
 			Retry:                options.Retry,
 			InitialRetryInterval: options.InitialRetryInterval,
 			MaxRetryInterval:     options.MaxRetryInterval,
-			MaxElapsedTime:       options.MaxElapsedTime}}
+			MaxElapsedTime:       options.MaxElapsedTime},
+		clampToAvailable: options.ClampToAvailable,
+		sendEmptyGroup:   options.SendEmptyConsumerGroup,
+		showTimeLag:      options.ShowTimeLag}
 }
 
 // SubscriptionAPI is a sub API that is used to manage subscriptions.
 type SubscriptionAPI struct {
-	client      *Client
-	backOffConf backOffConfiguration
+	client           *Client
+	backOffConf      backOffConfiguration
+	clampToAvailable bool
+	sendEmptyGroup   bool
+	showTimeLag      bool
 }
 
-// List returns all available subscriptions.
+// List returns all available subscriptions. All pages of the result are requested from Nakadi.
 func (s *SubscriptionAPI) List() ([]*Subscription, error) {
-	subscriptions := struct {
-		Items []*Subscription `json:"items"`
+	return s.ListFiltered(nil)
+}
+
+// A Link is a reference to a related resource as it is used by Nakadi for navigation.
+type Link struct {
+	Href string `json:"href"`
+}
+
+// Links contains the references to the previous and next page of a paginated result.
+type Links struct {
+	Prev *Link `json:"prev,omitempty"`
+	Next *Link `json:"next,omitempty"`
+}
+
+// SubscriptionPage is a single page of a paginated list of subscriptions. Nakadi does not report the total
+// number of subscriptions along with a page, counting them requires all pages as returned by ListFiltered.
+type SubscriptionPage struct {
+	Items []*Subscription `json:"items"`
+	Links Links           `json:"_links"`
+}
+
+// SubscriptionFilter contains optional parameters used to narrow down the subscriptions returned by
+// ListFiltered. Empty fields are not sent to Nakadi.
+type SubscriptionFilter struct {
+	// Only return subscriptions owned by this application.
+	OwningApplication string
+	// Only return subscriptions which read from all of the given event types.
+	EventTypes []string
+	// Only return subscriptions the given reader is authorized for, e.g. "service:test-service".
+	Reader string
+	// The maximum number of subscriptions returned by Nakadi per page (default: server side default).
+	Limit uint
+	// The number of subscriptions to skip, used in combination with Limit for paging.
+	Offset uint
+	// Sort the returned subscriptions by their creation date, oldest first. Nakadi does not
+	// support sorting so the subscriptions are sorted on the client side.
+	SortByCreatedAt bool
+}
+
+func (f *SubscriptionFilter) query() url.Values {
+	query := url.Values{}
+	if f == nil {
+		return query
+	}
+	if f.OwningApplication != "" {
+		query.Set("owning_application", f.OwningApplication)
+	}
+	for _, eventType := range f.EventTypes {
+		query.Add("event_type", eventType)
+	}
+	if f.Reader != "" {
+		query.Set("reader", f.Reader)
+	}
+	if f.Limit > 0 {
+		query.Set("limit", strconv.FormatUint(uint64(f.Limit), 10))
+	}
+	if f.Offset > 0 {
+		query.Set("offset", strconv.FormatUint(uint64(f.Offset), 10))
+	}
+	return query
+}
+
+// ListFiltered returns the subscriptions matching the filter. The filter may be nil, in which case
+// ListFiltered behaves like List. Starting at the page selected by the filter all following pages are
+// requested from Nakadi.
+func (s *SubscriptionAPI) ListFiltered(filter *SubscriptionFilter) ([]*Subscription, error) {
+	page, err := s.ListPage(filter)
+	if err != nil {
+		return nil, err
+	}
+	subscriptions := page.Items
+
+	visited := map[string]bool{}
+	for len(page.Items) > 0 {
+		nextURL, ok := s.linkURL(page.Links.Next)
+		if !ok || visited[nextURL] {
+			break
+		}
+		visited[nextURL] = true
+
+		page, err = s.requestPage(nextURL)
+		if err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, page.Items...)
+	}
+
+	if filter != nil && filter.SortByCreatedAt {
+		sort.SliceStable(subscriptions, func(i, j int) bool {
+			return subscriptions[i].CreatedAt.Before(subscriptions[j].CreatedAt)
+		})
+	}
+
+	return subscriptions, nil
+}
+
+// ListPage returns a single page of the subscriptions matching the filter along with the links
+// to the previous and next page. The filter may be nil.
+func (s *SubscriptionAPI) ListPage(filter *SubscriptionFilter) (*SubscriptionPage, error) {
+	listURL := s.subBaseURL()
+	if query := filter.query(); len(query) > 0 {
+		listURL += "?" + query.Encode()
+	}
+	return s.requestPage(listURL)
+}
+
+// NextPage returns the page following the given page. If there is no next page NextPage returns nil.
+func (s *SubscriptionAPI) NextPage(page *SubscriptionPage) (*SubscriptionPage, error) {
+	nextURL, ok := s.linkURL(page.Links.Next)
+	if !ok {
+		return nil, nil
+	}
+	return s.requestPage(nextURL)
+}
+
+func (s *SubscriptionAPI) requestPage(pageURL string) (*SubscriptionPage, error) {
+	response := &struct {
+		Items []*subscriptionResponse `json:"items"`
+		Links Links                   `json:"_links"`
 	}{}
-	err := s.client.httpGET(s.backOffConf.create(), s.subBaseURL(), &subscriptions, "unable to request subscriptions")
+	err := s.client.httpGET(context.Background(), s.backOffConf.create(), pageURL, response, "unable to request subscriptions")
 	if err != nil {
 		return nil, err
 	}
-	return subscriptions.Items, nil
+
+	page := &SubscriptionPage{Items: make([]*Subscription, 0, len(response.Items)), Links: response.Links}
+	for _, item := range response.Items {
+		page.Items = append(page.Items, item.normalized())
+	}
+	return page, nil
+}
+
+// linkURL resolves a link, which may be relative to the Nakadi URL. It returns false if the link is absent
+// or malformed.
+func (s *SubscriptionAPI) linkURL(link *Link) (string, bool) {
+	if link == nil || link.Href == "" {
+		return "", false
+	}
+	ref, err := url.Parse(link.Href)
+	if err != nil {
+		return "", false
+	}
+	if ref.IsAbs() {
+		return ref.String(), true
+	}
+	return strings.TrimSuffix(s.client.nakadiURL, "/") + "/" + strings.TrimPrefix(ref.String(), "/"), true
 }
 
-// Get obtains a single subscription identified by its ID.
+// Get obtains a single subscription identified by its ID. If the subscription does not exist the cause of the
+// returned error is ErrSubscriptionNotFound.
 func (s *SubscriptionAPI) Get(id string) (*Subscription, error) {
-	subscription := &Subscription{}
-	err := s.client.httpGET(s.backOffConf.create(), s.subURL(id), subscription, "unable to request subscription")
+	return s.GetContext(context.Background(), id)
+}
+
+// GetContext is like Get but uses the given context.
+func (s *SubscriptionAPI) GetContext(ctx context.Context, id string) (*Subscription, error) {
+	response := &subscriptionResponse{}
+	err := s.client.httpGET(ctx, s.backOffConf.create(), s.subURL(id), response, "unable to request subscription")
 	if err != nil {
-		return nil, err
+		return nil, subscriptionNotFound(err)
 	}
-	return subscription, err
+	return response.normalized(), err
 }
 
+// subscriptionNotFound replaces the cause of errors of requests for missing subscriptions with
+// ErrSubscriptionNotFound. Other errors are returned unchanged.
+func subscriptionNotFound(err error) error {
+	if !isNotFound(err) {
+		return err
+	}
+	return notFoundError{withSentinel(ErrSubscriptionNotFound, err)}
+}
+
+// ErrSubscriptionConflict is the cause of errors returned when Nakadi refuses to create a subscription because
+// it conflicts with the definition of an existing subscription.
+var ErrSubscriptionConflict = errors.New("subscription conflicts with an existing subscription")
+
+// ErrFilterRejected is the cause of errors returned when Nakadi refuses to create a subscription with a Filter
+// with status 422, because the cluster does not support filtering or the expression is invalid.
+var ErrFilterRejected = errors.New("subscription filter was rejected")
+
 // Create initializes a new subscription. If the subscription already exists the pre existing subscription
-// is returned.
+// is returned. Use CreateWithStatus in order to find out which of both happened. If the subscription conflicts
+// with an existing subscription the cause of the returned error is ErrSubscriptionConflict, if its Filter is
+// rejected the cause is ErrFilterRejected.
 func (s *SubscriptionAPI) Create(subscription *Subscription) (*Subscription, error) {
+	return s.CreateContext(context.Background(), subscription)
+}
+
+// CreateContext is like Create but uses the given context.
+func (s *SubscriptionAPI) CreateContext(ctx context.Context, subscription *Subscription) (*Subscription, error) {
+	subscription, _, err := s.CreateWithStatusContext(ctx, subscription)
+	return subscription, err
+}
+
+// CreateWithStatus initializes a new subscription like Create and additionally reports whether the subscription
+// was created (true) or an existing subscription was returned (false).
+func (s *SubscriptionAPI) CreateWithStatus(subscription *Subscription) (*Subscription, bool, error) {
+	return s.CreateWithStatusContext(context.Background(), subscription)
+}
+
+// CreateWithStatusContext is like CreateWithStatus but uses the given context.
+func (s *SubscriptionAPI) CreateWithStatusContext(ctx context.Context, subscription *Subscription) (*Subscription, bool, error) {
 	const errMsg = "unable to create subscription"
 
-	response, err := s.client.httpPOST(s.backOffConf.create(), s.subBaseURL(), subscription, errMsg)
+	if subscription.ReadFrom == ReadFromCursors {
+		if err := s.validateInitialCursors(subscription); err != nil {
+			return nil, false, errors.Wrap(err, errMsg)
+		}
+	}
+
+	response, err := s.client.httpPOST(ctx, s.backOffConf.create(), s.subBaseURL(), s.createBody(subscription), errMsg)
+	s.client.inspectResponse(OperationCreateSubscription, response)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 	defer response.Body.Close()
 
 	if response.StatusCode != http.StatusOK && response.StatusCode != http.StatusCreated {
 		buffer, err := ioutil.ReadAll(response.Body)
 		if err != nil {
-			return nil, errors.Wrapf(err, "%s: unable to read response body", errMsg)
+			return nil, false, errors.Wrapf(err, "%s: unable to read response body", errMsg)
 		}
-		return nil, decodeResponseToError(buffer, errMsg)
+		err = decodeResponseToError(response.StatusCode, buffer, errMsg)
+		if response.StatusCode == http.StatusConflict {
+			return nil, false, withSentinel(ErrSubscriptionConflict, err)
+		}
+		if response.StatusCode == http.StatusUnprocessableEntity && subscription.Filter != "" {
+			return nil, false, withSentinel(ErrFilterRejected, err)
+		}
+		return nil, false, err
 	}
 
-	subscription = &Subscription{}
-	err = json.NewDecoder(response.Body).Decode(subscription)
+	decoded := &subscriptionResponse{}
+	err = s.client.decodeJSON(response.Body, decoded)
 	if err != nil {
-		return nil, errors.Wrapf(err, "%s: unable to decode response body", errMsg)
+		return nil, false, errors.Wrapf(err, "%s: unable to decode response body", errMsg)
+	}
+
+	return decoded.normalized(), response.StatusCode == http.StatusCreated, nil
+}
+
+// createBody returns the body of a request creating the subscription. An empty consumer group is omitted
+// unless the SubscriptionAPI sends empty consumer groups.
+func (s *SubscriptionAPI) createBody(subscription *Subscription) interface{} {
+	if !s.sendEmptyGroup || subscription.ConsumerGroup != "" {
+		return subscription
+	}
+	return struct {
+		*Subscription
+		ConsumerGroup string `json:"consumer_group"`
+	}{Subscription: subscription}
+}
+
+// SubscribeOrGet returns the subscription which is identified by the owning application, the event types and
+// the consumer group of the given subscription and creates the subscription if it does not exist. The event
+// types are sorted and deduplicated and an empty consumer group is replaced with Nakadi's default consumer
+// group, so that all consumers which pass equivalent subscriptions share the same subscription. Concurrent
+// calls are safe: only one of them creates the subscription, Nakadi returns the existing subscription to all
+// others. Properties which don't identify a subscription, like ReadFrom, only take effect on creation.
+func (s *SubscriptionAPI) SubscribeOrGet(subscription *Subscription) (*Subscription, error) {
+	return s.SubscribeOrGetContext(context.Background(), subscription)
+}
+
+// SubscribeOrGetContext is like SubscribeOrGet but uses the given context.
+func (s *SubscriptionAPI) SubscribeOrGetContext(ctx context.Context, subscription *Subscription) (*Subscription, error) {
+	subscription, _, err := s.SubscribeOrGetWithStatusContext(ctx, subscription)
+	return subscription, err
+}
+
+// SubscribeOrGetWithStatus works like SubscribeOrGet and additionally reports whether the subscription was
+// created (true) or an existing subscription was returned (false).
+func (s *SubscriptionAPI) SubscribeOrGetWithStatus(subscription *Subscription) (*Subscription, bool, error) {
+	return s.SubscribeOrGetWithStatusContext(context.Background(), subscription)
+}
+
+// SubscribeOrGetWithStatusContext is like SubscribeOrGetWithStatus but uses the given context.
+func (s *SubscriptionAPI) SubscribeOrGetWithStatusContext(ctx context.Context, subscription *Subscription) (*Subscription, bool, error) {
+	normalized := *subscription
+	normalized.EventTypes = uniqueSorted(subscription.EventTypes)
+	if normalized.ConsumerGroup == "" {
+		normalized.ConsumerGroup = defaultConsumerGroup
+	}
+	return s.CreateWithStatusContext(ctx, &normalized)
+}
+
+// uniqueSorted returns a sorted copy of values without duplicates.
+func uniqueSorted(values []string) []string {
+	sorted := append([]string(nil), values...)
+	sort.Strings(sorted)
+
+	unique := sorted[:0]
+	for _, value := range sorted {
+		if len(unique) == 0 || value != unique[len(unique)-1] {
+			unique = append(unique, value)
+		}
+	}
+	return unique
+}
+
+// validateInitialCursors checks that the subscription has exactly one initial cursor for each partition of
+// its event types.
+func (s *SubscriptionAPI) validateInitialCursors(subscription *Subscription) error {
+	given := make(map[string]bool, len(subscription.InitialCursors))
+	for _, c := range subscription.InitialCursors {
+		key := c.EventType + "/" + c.Partition
+		if given[key] {
+			return errors.Errorf("duplicate initial cursor for partition %s", key)
+		}
+		given[key] = true
+	}
+
+	eventAPI := &EventAPI{client: s.client, backOffConf: s.backOffConf}
+	var missing []string
+	for _, eventType := range subscription.EventTypes {
+		partitions, err := eventAPI.Partitions(eventType)
+		if err != nil {
+			return errors.Wrap(err, "unable to validate initial cursors")
+		}
+		for _, p := range partitions {
+			key := eventType + "/" + p.Partition
+			if !given[key] {
+				missing = append(missing, key)
+			}
+			delete(given, key)
+		}
 	}
 
-	return subscription, nil
+	if len(missing) > 0 {
+		return errors.Errorf("missing initial cursors for partitions %s", strings.Join(missing, ", "))
+	}
+	if len(given) > 0 {
+		unknown := make([]string, 0, len(given))
+		for key := range given {
+			unknown = append(unknown, key)
+		}
+		sort.Strings(unknown)
+		return errors.Errorf("initial cursors for unknown partitions %s", strings.Join(unknown, ", "))
+	}
+	return nil
 }
 
-// Delete removes an existing subscription.
+// Delete removes an existing subscription. If the subscription does not exist the cause of the returned error
+// is ErrSubscriptionNotFound.
 func (s *SubscriptionAPI) Delete(id string) error {
-	return s.client.httpDELETE(s.backOffConf.create(), s.subURL(id), "unable to delete subscription")
+	return s.DeleteContext(context.Background(), id)
+}
+
+// DeleteContext is like Delete but uses the given context.
+func (s *SubscriptionAPI) DeleteContext(ctx context.Context, id string) error {
+	err := s.client.httpDELETE(ctx, s.backOffConf.create(), s.subURL(id), "unable to delete subscription")
+	return subscriptionNotFound(err)
+}
+
+// ListSubscriptions returns all subscriptions owned by the given application which read from the given event
+// type, e.g. in order to find and delete subscriptions which are not used anymore. Either of both may be empty
+// to not filter by it. All pages are requested from Nakadi. If no subscription matches an empty slice is
+// returned.
+func (c *Client) ListSubscriptions(owningApp, eventType string) ([]*Subscription, error) {
+	filter := &SubscriptionFilter{OwningApplication: owningApp}
+	if eventType != "" {
+		filter.EventTypes = []string{eventType}
+	}
+
+	subscriptions, err := NewSubscriptionAPI(c, nil).ListFiltered(filter)
+	if err != nil {
+		return nil, err
+	}
+	if subscriptions == nil {
+		subscriptions = []*Subscription{}
+	}
+	return subscriptions, nil
+}
+
+// SubscriptionsForEventType returns all subscriptions which read from the given event type, which allows
+// to find the consumers of an event type before it is changed or deleted. All pages are requested from
+// Nakadi. If no subscription reads from the event type an empty slice is returned.
+func (c *Client) SubscriptionsForEventType(name string) ([]*Subscription, error) {
+	if name == "" {
+		return nil, errors.New("unable to list subscriptions: event type required")
+	}
+
+	subscriptions, err := NewSubscriptionAPI(c, nil).ListFiltered(&SubscriptionFilter{EventTypes: []string{name}})
+	if err != nil {
+		return nil, err
+	}
+	if subscriptions == nil {
+		subscriptions = []*Subscription{}
+	}
+	return subscriptions, nil
+}
+
+// ConsumerGroupsForEventType returns the sorted and distinct consumer groups of all subscriptions which read
+// from the given event type, e.g. for a report of the consumers depending on the event type. Subscriptions
+// without consumer group belong to the group "default". If no subscription reads from the event type an
+// empty slice is returned.
+func (c *Client) ConsumerGroupsForEventType(name string) ([]string, error) {
+	subscriptions, err := c.SubscriptionsForEventType(name)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(subscriptions) == 0 {
+		return []string{}, nil
+	}
+	groups := make([]string, 0, len(subscriptions))
+	for _, subscription := range subscriptions {
+		group := subscription.ConsumerGroup
+		if group == "" {
+			group = defaultConsumerGroup
+		}
+		groups = append(groups, group)
+	}
+	return uniqueSorted(groups), nil
+}
+
+// AuditSubscribe returns a subscription which reads all events of the given event types from the beginning and
+// creates it if it does not exist. The subscription uses the consumer group "audit-<owningApp>", so that its
+// cursors are independent of the subscriptions of production consumers, which usually use the default
+// consumer group. Repeated calls with the same owning application and event types return the same subscription.
+func (c *Client) AuditSubscribe(owningApp string, eventTypes []string) (*Subscription, error) {
+	const errMsg = "unable to create audit subscription"
+
+	if owningApp == "" {
+		return nil, errors.Errorf("%s: owning application required", errMsg)
+	}
+	if len(eventTypes) == 0 {
+		return nil, errors.Errorf("%s: event types required", errMsg)
+	}
+
+	return NewSubscriptionAPI(c, nil).SubscribeOrGet(&Subscription{
+		OwningApplication: owningApp,
+		EventTypes:        eventTypes,
+		ConsumerGroup:     auditConsumerGroupPrefix + owningApp,
+		ReadFrom:          ReadFromBegin})
+}
+
+// DeleteSubscriptionsByFilter deletes all subscriptions which are owned by owningApp and read from eventType
+// and returns the number of deleted subscriptions. One of both parameters may be empty in order to match
+// subscriptions by the other parameter only. Subscriptions which were already deleted are skipped. If the
+// deletion of individual subscriptions fails, the remaining subscriptions are deleted anyway and an error
+// listing all failures is returned.
+func (c *Client) DeleteSubscriptionsByFilter(owningApp, eventType string) (int, error) {
+	const errMsg = "unable to delete subscriptions"
+
+	if owningApp == "" && eventType == "" {
+		return 0, errors.Errorf("%s: owning application or event type required", errMsg)
+	}
+
+	filter := &SubscriptionFilter{OwningApplication: owningApp}
+	if eventType != "" {
+		filter.EventTypes = []string{eventType}
+	}
+
+	subAPI := NewSubscriptionAPI(c, nil)
+	subscriptions, err := subAPI.ListFiltered(filter)
+	if err != nil {
+		return 0, errors.Wrap(err, errMsg)
+	}
+
+	deleted := 0
+	var failures []string
+	for _, sub := range subscriptions {
+		found, err := subAPI.deleteIfExists(sub.ID)
+		if err != nil {
+			failures = append(failures, err.Error())
+			continue
+		}
+		if found {
+			deleted++
+		}
+	}
+
+	if len(failures) > 0 {
+		return deleted, errors.Errorf("%s: %d of %d failed: %s", errMsg, len(failures), len(subscriptions), strings.Join(failures, "; "))
+	}
+	return deleted, nil
+}
+
+// deleteIfExists deletes a subscription and reports whether the subscription existed.
+func (s *SubscriptionAPI) deleteIfExists(id string) (bool, error) {
+	const errMsg = "unable to delete subscription"
+
+	response, err := s.client.httpDELETEResponse(context.Background(), s.backOffConf.create(), s.subURL(id), errMsg)
+	if err != nil {
+		return false, err
+	}
+	defer response.Body.Close()
+
+	switch response.StatusCode {
+	case http.StatusOK, http.StatusNoContent:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	}
+
+	buffer, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return false, errors.Wrapf(err, "%s: unable to read response body", errMsg)
+	}
+	return false, decodeResponseToError(response.StatusCode, buffer, errMsg)
+}
+
+// ErrActiveStreams is returned if an operation requires that no stream is consuming from a subscription,
+// but the subscription is currently consumed by one or many streams.
+var ErrActiveStreams = errors.New("subscription has active streams")
+
+type resetRequest struct {
+	Items []SubscriptionCursor `json:"items"`
+}
+
+// ErrOffsetUnavailable is the cause of errors returned when cursors are reset to an offset which is older than
+// the oldest offset still available in the partition, e.g. because the events expired.
+var ErrOffsetUnavailable = errors.New("offset is no longer available")
+
+// ResetCursors moves the read position of a subscription to the provided cursors. Nakadi closes all
+// streams which are currently consuming from the subscription as a result of this operation. If Nakadi
+// rejects the reset due to a conflict with active streams the returned error is caused by ErrActiveStreams.
+// Before the reset the oldest available offsets of the partitions are requested: cursors pointing before
+// them are rejected with an error caused by ErrOffsetUnavailable or, if the option ClampToAvailable is set,
+// moved to the beginning of the partition.
+func (s *SubscriptionAPI) ResetCursors(id string, cursors []Cursor) error {
+	return s.ResetCursorsContext(context.Background(), id, cursors)
+}
+
+// ResetCursorsContext is like ResetCursors but uses the given context.
+func (s *SubscriptionAPI) ResetCursorsContext(ctx context.Context, id string, cursors []Cursor) error {
+	const errMsg = "unable to reset subscription cursors"
+
+	cursors, err := s.checkAvailableOffsets(ctx, cursors)
+	if err != nil {
+		return errors.Wrap(err, errMsg)
+	}
+	return s.resetCursors(ctx, id, cursors)
+}
+
+// checkAvailableOffsets compares the cursors with the oldest available offsets of their partitions and
+// returns the cursors which can be used for a reset. Partitions are only requested for event types with
+// cursors which don't point to the beginning.
+func (s *SubscriptionAPI) checkAvailableOffsets(ctx context.Context, cursors []Cursor) ([]Cursor, error) {
+	eventAPI := &EventAPI{client: s.client, backOffConf: s.backOffConf}
+	oldest := make(map[string]map[string]string)
+
+	checked := append([]Cursor(nil), cursors...)
+	for i, c := range checked {
+		if c.Offset == "BEGIN" {
+			continue
+		}
+
+		if _, ok := oldest[c.EventType]; !ok {
+			partitions, err := eventAPI.partitions(ctx, c.EventType)
+			if err != nil {
+				return nil, errors.Wrap(err, "unable to check available offsets")
+			}
+			oldest[c.EventType] = make(map[string]string, len(partitions))
+			for _, p := range partitions {
+				oldest[c.EventType][p.Partition] = p.OldestAvailableOffset
+			}
+		}
+
+		// unknown partitions and offsets which can't be compared are left to Nakadi to reject
+		available, ok := oldest[c.EventType][c.Partition]
+		if !ok {
+			continue
+		}
+		if cmp, ok := compareOffsets(c.Offset, available); !ok || cmp >= 0 {
+			continue
+		}
+		if s.clampToAvailable {
+			checked[i].Offset = "BEGIN"
+			continue
+		}
+		return nil, errors.Wrapf(ErrOffsetUnavailable, "offset %s of partition %s/%s is older than the oldest available offset %s",
+			c.Offset, c.EventType, c.Partition, available)
+	}
+	return checked, nil
+}
+
+// resetCursors moves the read position of a subscription to the provided cursors without checking the
+// available offsets.
+func (s *SubscriptionAPI) resetCursors(ctx context.Context, id string, cursors []Cursor) error {
+	const errMsg = "unable to reset subscription cursors"
+
+	request := resetRequest{Items: make([]SubscriptionCursor, 0, len(cursors))}
+	for _, c := range cursors {
+		request.Items = append(request.Items, SubscriptionCursor{Partition: c.Partition, Offset: c.Offset, EventType: c.EventType})
+	}
+
+	response, err := s.client.httpPATCH(ctx, s.backOffConf.create(), s.subURL(id)+"/cursors", &request, errMsg)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode == http.StatusConflict {
+		return errors.Wrap(ErrActiveStreams, errMsg)
+	}
+
+	if response.StatusCode != http.StatusNoContent && response.StatusCode != http.StatusOK {
+		buffer, err := ioutil.ReadAll(response.Body)
+		if err != nil {
+			return errors.Wrapf(err, "%s: unable to read response body", errMsg)
+		}
+		return decodeResponseToError(response.StatusCode, buffer, errMsg)
+	}
+
+	return nil
+}
+
+// GetCursors returns the committed cursors of the subscription identified by id including their cursor tokens.
+// Nakadi only accepts commits of cursors on behalf of a stream, so cursors returned by GetCursors can't be
+// committed without a stream: tools which need to edit the read position of a subscription use ResetCursors
+// or EditCursors instead.
+func (s *SubscriptionAPI) GetCursors(id string) ([]Cursor, error) {
+	response := &committedCursorsResponse{}
+	if err := s.client.httpGET(context.Background(), s.backOffConf.create(), s.subURL(id)+"/cursors", response, "unable to request committed cursors"); err != nil {
+		return nil, err
+	}
+
+	cursors := make([]Cursor, 0, len(response.Items))
+	for _, item := range response.Items {
+		cursors = append(cursors, Cursor{Partition: item.Partition, Offset: item.Offset, EventType: item.EventType, CursorToken: item.CursorToken})
+	}
+	return cursors, nil
+}
+
+// EditCursors reads the committed cursors of the subscription identified by id, passes them to transform and
+// resets the subscription to the cursors returned by transform like ResetCursors, e.g. in order to skip a
+// number of events in some partitions. EditCursors refuses to run and returns an error caused by
+// ErrActiveStreams if any stream is consuming from the subscription. A stream which connects between the check
+// and the reset is closed by Nakadi. Errors returned by transform abort the edit without resetting the cursors,
+// as does an empty result of transform.
+func (s *SubscriptionAPI) EditCursors(id string, transform func([]Cursor) ([]Cursor, error)) error {
+	const errMsg = "unable to edit subscription cursors"
+
+	stats, err := s.GetStats(id)
+	if err != nil {
+		return errors.Wrap(err, errMsg)
+	}
+	if hasActiveStreams(stats) {
+		return errors.Wrap(ErrActiveStreams, errMsg)
+	}
+
+	cursors, err := s.GetCursors(id)
+	if err != nil {
+		return errors.Wrap(err, errMsg)
+	}
+	cursors, err = transform(cursors)
+	if err != nil {
+		return errors.Wrap(err, errMsg)
+	}
+	if len(cursors) == 0 {
+		return nil
+	}
+
+	if err := s.ResetCursors(id, cursors); err != nil {
+		return errors.Wrap(err, errMsg)
+	}
+	return nil
 }
 
 // SubscriptionStats represents detailed statistics for the subscription
@@ -150,12 +838,23 @@ type SubscriptionStats struct {
 	Partitions []*PartitionStats `json:"partitions"`
 }
 
-// PartitionStats represents statistic information for the particular partition
+// States of a partition in the statistics of a subscription.
+const (
+	PartitionStateUnassigned  = "unassigned"
+	PartitionStateAssigned    = "assigned"
+	PartitionStateReassigning = "reassigning"
+)
+
+// PartitionStats represents statistic information for the particular partition. StreamID is the ID of the
+// stream the partition is assigned to and empty if the partition is unassigned. ConsumerLagSeconds is only
+// reported by Nakadi if the time lag was requested with SubscriptionOptions.ShowTimeLag.
 type PartitionStats struct {
-	Partition        string `json:"partition"`
-	State            string `json:"state"`
-	UnconsumedEvents int    `json:"unconsumed_events"`
-	StreamID         string `json:"stream_id"`
+	Partition          string `json:"partition"`
+	State              string `json:"state"`
+	UnconsumedEvents   int    `json:"unconsumed_events"`
+	ConsumerLagSeconds int    `json:"consumer_lag_seconds,omitempty"`
+	StreamID           string `json:"stream_id"`
+	AssignmentType     string `json:"assignment_type,omitempty"`
 }
 
 type statsResponse struct {
@@ -164,13 +863,96 @@ type statsResponse struct {
 
 // GetStats returns statistic information for subscription
 func (s *SubscriptionAPI) GetStats(id string) ([]*SubscriptionStats, error) {
+	return s.GetStatsContext(context.Background(), id)
+}
+
+// GetStatsContext is like GetStats but uses the given context.
+func (s *SubscriptionAPI) GetStatsContext(ctx context.Context, id string) ([]*SubscriptionStats, error) {
+	statsURL := s.subURL(id) + "/stats"
+	if s.showTimeLag {
+		statsURL += "?show_time_lag=true"
+	}
+
 	stats := &statsResponse{}
-	if err := s.client.httpGET(s.backOffConf.create(), s.subURL(id)+"/stats", stats, "unable to get stats for subscription"); err != nil {
+	if err := s.client.httpGET(ctx, s.backOffConf.create(), statsURL, stats, "unable to get stats for subscription"); err != nil {
 		return nil, err
 	}
 	return stats.Items, nil
 }
 
+// BulkSubscriptionStats requests the statistics of many subscriptions concurrently, e.g. in order to monitor the
+// lag of all subscriptions of an application. At most concurrency requests are made at the same time. The
+// statistics and the errors are returned per subscription id: each id is contained in exactly one of both
+// maps. Once the context is done no further requests are made and the error of the remaining ids is the
+// error of the context.
+func (c *Client) BulkSubscriptionStats(ctx context.Context, ids []string, concurrency int) (map[string][]*SubscriptionStats, map[string]error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	subAPI := NewSubscriptionAPI(c, nil)
+
+	results := make(map[string][]*SubscriptionStats, len(ids))
+	failures := make(map[string]error)
+	var mutex sync.Mutex
+
+	idCh := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for id := range idCh {
+				stats, err := subAPI.GetStatsContext(ctx, id)
+				mutex.Lock()
+				if err != nil {
+					failures[id] = err
+				} else {
+					results[id] = stats
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	seen := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		select {
+		case idCh <- id:
+		case <-ctx.Done():
+			mutex.Lock()
+			failures[id] = errors.Wrap(ctx.Err(), "unable to get stats for subscription")
+			mutex.Unlock()
+		}
+	}
+	close(idCh)
+	wg.Wait()
+
+	return results, failures
+}
+
+// TotalLag returns the number of unconsumed events of the subscription identified by id, summed up over all
+// partitions of all its event types. TotalLag returns zero if the subscription caught up and an error if its
+// statistics can't be requested.
+func (c *Client) TotalLag(id string) (int64, error) {
+	stats, err := NewSubscriptionAPI(c, nil).GetStats(id)
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to compute lag of subscription")
+	}
+
+	var lag int64
+	for _, s := range stats {
+		for _, p := range s.Partitions {
+			lag += int64(p.UnconsumedEvents)
+		}
+	}
+	return lag, nil
+}
+
 func (s *SubscriptionAPI) subURL(id string) string {
 	return fmt.Sprintf("%s/subscriptions/%s", s.client.nakadiURL, id)
 }
@@ -1,15 +1,20 @@
 package nakadi
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 
 	"time"
 
 	"github.com/jarcoal/httpmock"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -58,6 +63,7 @@ func TestSubscriptionAPI_Get(t *testing.T) {
 		_, err := api.Get(expected.ID)
 		require.Error(t, err)
 		assert.Regexp(t, "some problem detail", err)
+		assert.Equal(t, ErrSubscriptionNotFound, errors.Cause(err))
 	})
 
 	t.Run("fail decode response", func(t *testing.T) {
@@ -75,6 +81,83 @@ func TestSubscriptionAPI_Get(t *testing.T) {
 		require.NoError(t, err)
 		assert.Equal(t, expected, requested)
 	})
+
+	t.Run("success single event type", func(t *testing.T) {
+		httpmock.RegisterResponder("GET", url, httpmock.NewStringResponder(http.StatusOK,
+			`{"id":"`+expected.ID+`","owning_application":"test-app","event_type":"test-event"}`))
+
+		requested, err := api.Get(expected.ID)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"test-event"}, requested.EventTypes)
+
+		httpmock.RegisterResponder("GET", url, httpmock.NewStringResponder(http.StatusOK,
+			`{"id":"`+expected.ID+`","owning_application":"test-app","event_types":["test-event","other-event"]}`))
+
+		requested, err = api.Get(expected.ID)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"test-event", "other-event"}, requested.EventTypes)
+	})
+
+	t.Run("success initial cursors", func(t *testing.T) {
+		httpmock.RegisterResponder("GET", url, httpmock.NewStringResponder(http.StatusOK,
+			`{"id":"`+expected.ID+`","owning_application":"test-app","event_types":["test-event"],"read_from":"cursors",
+			"initial_cursors":[{"event_type":"test-event","partition":"0","offset":"001-0001-000000000000000042"}]}`))
+
+		requested, err := api.Get(expected.ID)
+		require.NoError(t, err)
+		assert.Equal(t, ReadFromCursors, requested.ReadFrom)
+		assert.Equal(t, []SubscriptionCursor{{EventType: "test-event", Partition: "0", Offset: "001-0001-000000000000000042"}}, requested.InitialCursors)
+	})
+}
+
+func TestSubscriptionAPI_StrictDecode(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	expected := &Subscription{}
+	serialized := helperLoadTestData(t, "subscription.json", expected)
+	withUnknownField := strings.Replace(string(serialized), "{", `{"unknown_field":"value",`, 1)
+
+	getURL := fmt.Sprintf("%s/subscriptions/%s", defaultNakadiURL, expected.ID)
+	createURL := fmt.Sprintf("%s/subscriptions", defaultNakadiURL)
+
+	setup := func(strict bool) *SubscriptionAPI {
+		client := New(defaultNakadiURL, &ClientOptions{StrictDecode: strict})
+		client.httpClient = http.DefaultClient
+		return NewSubscriptionAPI(client, nil)
+	}
+
+	t.Run("success strict without unknown fields", func(t *testing.T) {
+		httpmock.RegisterResponder("GET", getURL, httpmock.NewBytesResponder(http.StatusOK, serialized))
+
+		subscription, err := setup(true).Get(expected.ID)
+		require.NoError(t, err)
+		assert.Equal(t, expected, subscription)
+	})
+
+	t.Run("success lenient with unknown fields", func(t *testing.T) {
+		httpmock.RegisterResponder("GET", getURL, httpmock.NewStringResponder(http.StatusOK, withUnknownField))
+
+		subscription, err := setup(false).Get(expected.ID)
+		require.NoError(t, err)
+		assert.Equal(t, expected, subscription)
+	})
+
+	t.Run("fail get strict with unknown fields", func(t *testing.T) {
+		httpmock.RegisterResponder("GET", getURL, httpmock.NewStringResponder(http.StatusOK, withUnknownField))
+
+		_, err := setup(true).Get(expected.ID)
+		require.Error(t, err)
+		assert.Regexp(t, `unable to decode response body: json: unknown field "unknown_field"`, err)
+	})
+
+	t.Run("fail create strict with unknown fields", func(t *testing.T) {
+		httpmock.RegisterResponder("POST", createURL, httpmock.NewStringResponder(http.StatusCreated, withUnknownField))
+
+		_, err := setup(true).Create(&Subscription{OwningApplication: "test-app", EventTypes: []string{"test-event"}})
+		require.Error(t, err)
+		assert.Regexp(t, `unable to decode response body: json: unknown field "unknown_field"`, err)
+	})
 }
 
 func TestSubscriptionAPI_List(t *testing.T) {
@@ -131,6 +214,196 @@ func TestSubscriptionAPI_List(t *testing.T) {
 		require.NoError(t, err)
 		assert.Equal(t, expected.Items, requested)
 	})
+
+	t.Run("success single event type", func(t *testing.T) {
+		httpmock.RegisterResponder("GET", url, httpmock.NewStringResponder(http.StatusOK,
+			`{"items":[{"id":"a","event_type":"test-event"},{"id":"b","event_types":["test-event","other-event"]}]}`))
+
+		requested, err := api.List()
+		require.NoError(t, err)
+		require.Len(t, requested, 2)
+		assert.Equal(t, []string{"test-event"}, requested[0].EventTypes)
+		assert.Equal(t, []string{"test-event", "other-event"}, requested[1].EventTypes)
+	})
+}
+
+func TestSubscriptionAPI_ListFiltered(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	expected := struct {
+		Items []*Subscription `json:"items"`
+	}{}
+	helperLoadTestData(t, "subscriptions.json", &expected.Items)
+
+	client := &Client{nakadiURL: defaultNakadiURL, httpClient: http.DefaultClient}
+	api := NewSubscriptionAPI(client, nil)
+	url := fmt.Sprintf("%s/subscriptions", defaultNakadiURL)
+
+	t.Run("fail connection error", func(t *testing.T) {
+		httpmock.RegisterResponder("GET", url, httpmock.NewErrorResponder(assert.AnError))
+
+		_, err := api.ListFiltered(&SubscriptionFilter{OwningApplication: "test-application"})
+		require.Error(t, err)
+		assert.Regexp(t, assert.AnError, err)
+	})
+
+	t.Run("fail with problem", func(t *testing.T) {
+		httpmock.RegisterResponder("GET", url, httpmock.NewStringResponder(http.StatusBadRequest, testProblemJSON))
+
+		_, err := api.ListFiltered(&SubscriptionFilter{OwningApplication: "test-application"})
+		require.Error(t, err)
+		assert.Regexp(t, "some problem detail", err)
+	})
+
+	t.Run("success without filter", func(t *testing.T) {
+		httpmock.RegisterResponder("GET", url, func(r *http.Request) (*http.Response, error) {
+			assert.Empty(t, r.URL.RawQuery)
+			return httpmock.NewJsonResponse(http.StatusOK, expected)
+		})
+
+		requested, err := api.ListFiltered(nil)
+		require.NoError(t, err)
+		assert.Equal(t, expected.Items, requested)
+	})
+
+	t.Run("success with filter", func(t *testing.T) {
+		filter := &SubscriptionFilter{
+			OwningApplication: "test-application",
+			EventTypes:        []string{"test-event.data", "test-event.business"},
+			Reader:            "service:test-service",
+			Limit:             20,
+			Offset:            40}
+
+		httpmock.RegisterResponder("GET", url, func(r *http.Request) (*http.Response, error) {
+			query := r.URL.Query()
+			assert.Equal(t, "test-application", query.Get("owning_application"))
+			assert.Equal(t, []string{"test-event.data", "test-event.business"}, query["event_type"])
+			assert.Equal(t, "service:test-service", query.Get("reader"))
+			assert.Equal(t, "20", query.Get("limit"))
+			assert.Equal(t, "40", query.Get("offset"))
+			return httpmock.NewJsonResponse(http.StatusOK, expected)
+		})
+
+		requested, err := api.ListFiltered(filter)
+		require.NoError(t, err)
+		assert.Equal(t, expected.Items, requested)
+	})
+
+	t.Run("success sorted by creation", func(t *testing.T) {
+		unsorted := struct {
+			Items []*Subscription `json:"items"`
+		}{Items: []*Subscription{expected.Items[2], expected.Items[0], expected.Items[1]}}
+		responder, err := httpmock.NewJsonResponder(http.StatusOK, unsorted)
+		require.NoError(t, err)
+		httpmock.RegisterResponder("GET", url, responder)
+
+		requested, err := api.ListFiltered(&SubscriptionFilter{SortByCreatedAt: true})
+		require.NoError(t, err)
+		require.Len(t, requested, 3)
+		assert.Equal(t, expected.Items[0].ID, requested[0].ID)
+		assert.Equal(t, expected.Items[1].ID, requested[1].ID)
+		assert.Equal(t, expected.Items[2].ID, requested[2].ID)
+	})
+}
+
+func TestSubscriptionAPI_ListPagination(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	client := &Client{nakadiURL: defaultNakadiURL, httpClient: http.DefaultClient}
+	api := NewSubscriptionAPI(client, nil)
+	url := fmt.Sprintf("%s/subscriptions", defaultNakadiURL)
+
+	first := &Subscription{ID: "first"}
+	second := &Subscription{ID: "second"}
+
+	t.Run("success follow next links", func(t *testing.T) {
+		httpmock.Reset()
+		httpmock.RegisterResponder("GET", url, func(r *http.Request) (*http.Response, error) {
+			if r.URL.Query().Get("offset") == "1" {
+				return httpmock.NewJsonResponse(http.StatusOK, &SubscriptionPage{
+					Items: []*Subscription{second},
+					Links: Links{Prev: &Link{Href: "/subscriptions?offset=0&limit=1"}}})
+			}
+			return httpmock.NewJsonResponse(http.StatusOK, &SubscriptionPage{
+				Items: []*Subscription{first},
+				Links: Links{Next: &Link{Href: "/subscriptions?offset=1&limit=1"}}})
+		})
+
+		subscriptions, err := api.List()
+		require.NoError(t, err)
+		assert.Equal(t, []*Subscription{first, second}, subscriptions)
+	})
+
+	t.Run("success stop on repeated link", func(t *testing.T) {
+		httpmock.Reset()
+		responder, err := httpmock.NewJsonResponder(http.StatusOK, &SubscriptionPage{
+			Items: []*Subscription{first},
+			Links: Links{Next: &Link{Href: "/subscriptions?offset=1"}}})
+		require.NoError(t, err)
+		httpmock.RegisterResponder("GET", url, responder)
+
+		subscriptions, err := api.List()
+		require.NoError(t, err)
+		assert.Equal(t, []*Subscription{first, first}, subscriptions)
+		assert.Equal(t, 2, httpmock.GetTotalCallCount())
+	})
+
+	t.Run("success stop on malformed link", func(t *testing.T) {
+		httpmock.Reset()
+		responder, err := httpmock.NewJsonResponder(http.StatusOK, &SubscriptionPage{
+			Items: []*Subscription{first},
+			Links: Links{Next: &Link{Href: "%zz"}}})
+		require.NoError(t, err)
+		httpmock.RegisterResponder("GET", url, responder)
+
+		subscriptions, err := api.List()
+		require.NoError(t, err)
+		assert.Equal(t, []*Subscription{first}, subscriptions)
+		assert.Equal(t, 1, httpmock.GetTotalCallCount())
+	})
+
+	t.Run("fail on next page", func(t *testing.T) {
+		httpmock.Reset()
+		httpmock.RegisterResponder("GET", url, func(r *http.Request) (*http.Response, error) {
+			if r.URL.Query().Get("offset") == "1" {
+				return httpmock.NewStringResponse(http.StatusInternalServerError, testProblemJSON), nil
+			}
+			return httpmock.NewJsonResponse(http.StatusOK, &SubscriptionPage{
+				Items: []*Subscription{first},
+				Links: Links{Next: &Link{Href: "/subscriptions?offset=1"}}})
+		})
+
+		_, err := api.List()
+		require.Error(t, err)
+		assert.Regexp(t, "some problem detail", err)
+	})
+
+	t.Run("success list page and next page", func(t *testing.T) {
+		httpmock.Reset()
+		httpmock.RegisterResponder("GET", url, func(r *http.Request) (*http.Response, error) {
+			if r.URL.Query().Get("offset") == "1" {
+				return httpmock.NewJsonResponse(http.StatusOK, &SubscriptionPage{Items: []*Subscription{second}})
+			}
+			return httpmock.NewJsonResponse(http.StatusOK, &SubscriptionPage{
+				Items: []*Subscription{first},
+				Links: Links{Next: &Link{Href: defaultNakadiURL + "/subscriptions?offset=1"}}})
+		})
+
+		page, err := api.ListPage(&SubscriptionFilter{Limit: 1})
+		require.NoError(t, err)
+		assert.Equal(t, []*Subscription{first}, page.Items)
+		require.NotNil(t, page.Links.Next)
+
+		page, err = api.NextPage(page)
+		require.NoError(t, err)
+		assert.Equal(t, []*Subscription{second}, page.Items)
+
+		page, err = api.NextPage(page)
+		require.NoError(t, err)
+		assert.Nil(t, page)
+	})
 }
 
 func TestSubscriptionAPI_Create(t *testing.T) {
@@ -210,6 +483,307 @@ func TestSubscriptionAPI_Create(t *testing.T) {
 	})
 }
 
+func TestSubscriptionAPI_CreateWithStatus(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	client := &Client{nakadiURL: defaultNakadiURL, httpClient: http.DefaultClient}
+	api := NewSubscriptionAPI(client, nil)
+	url := fmt.Sprintf("%s/subscriptions", defaultNakadiURL)
+	subscription := &Subscription{OwningApplication: "test-app", EventTypes: []string{"test-event.data"}}
+	serialized := `{"id":"sub-1","owning_application":"test-app","event_types":["test-event.data"]}`
+
+	t.Run("fail conflict", func(t *testing.T) {
+		httpmock.RegisterResponder("POST", url, httpmock.NewStringResponder(http.StatusConflict, testProblemJSON))
+
+		_, created, err := api.CreateWithStatus(subscription)
+		require.Error(t, err)
+		assert.False(t, created)
+		assert.Equal(t, ErrSubscriptionConflict, errors.Cause(err))
+		assert.Regexp(t, "unable to create subscription: some problem detail", err)
+		status, ok := ResponseStatus(err)
+		assert.True(t, ok)
+		assert.Equal(t, http.StatusConflict, status)
+
+		_, err = api.SubscribeOrGet(subscription)
+		assert.Equal(t, ErrSubscriptionConflict, errors.Cause(err))
+	})
+
+	t.Run("fail filter rejected", func(t *testing.T) {
+		httpmock.RegisterResponder("POST", url, httpmock.NewStringResponder(http.StatusUnprocessableEntity, testProblemJSON))
+
+		filtered := *subscription
+		filtered.Filter = "data.type == 'order'"
+		_, _, err := api.CreateWithStatus(&filtered)
+		require.Error(t, err)
+		assert.Equal(t, ErrFilterRejected, errors.Cause(err))
+		assert.Regexp(t, "unable to create subscription: some problem detail", err)
+
+		_, _, err = api.CreateWithStatus(subscription)
+		require.Error(t, err)
+		assert.NotEqual(t, ErrFilterRejected, errors.Cause(err))
+	})
+
+	t.Run("success with filter", func(t *testing.T) {
+		httpmock.RegisterResponder("POST", url, func(r *http.Request) (*http.Response, error) {
+			uploaded := map[string]interface{}{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&uploaded))
+			assert.Equal(t, "data.type == 'order'", uploaded["filter"])
+			return httpmock.NewStringResponse(http.StatusCreated,
+				`{"id":"sub-1","owning_application":"test-app","event_types":["test-event.data"],"filter":"data.type == 'order'"}`), nil
+		})
+
+		filtered := *subscription
+		filtered.Filter = "data.type == 'order'"
+		requested, created, err := api.CreateWithStatus(&filtered)
+		require.NoError(t, err)
+		assert.True(t, created)
+		assert.Equal(t, "data.type == 'order'", requested.Filter)
+	})
+
+	t.Run("success consumer group", func(t *testing.T) {
+		cases := []struct {
+			name     string
+			group    string
+			options  *SubscriptionOptions
+			expected interface{}
+			sent     bool
+		}{
+			{name: "omitted", group: ""},
+			{name: "explicit default", group: "default", expected: "default", sent: true},
+			{name: "empty with option", group: "", options: &SubscriptionOptions{SendEmptyConsumerGroup: true}, expected: "", sent: true},
+			{name: "explicit with option", group: "group", options: &SubscriptionOptions{SendEmptyConsumerGroup: true}, expected: "group", sent: true}}
+
+		for _, c := range cases {
+			httpmock.RegisterResponder("POST", url, func(r *http.Request) (*http.Response, error) {
+				uploaded := map[string]interface{}{}
+				require.NoError(t, json.NewDecoder(r.Body).Decode(&uploaded))
+				group, sent := uploaded["consumer_group"]
+				assert.Equal(t, c.sent, sent, c.name)
+				assert.Equal(t, c.expected, group, c.name)
+				assert.Equal(t, "test-app", uploaded["owning_application"], c.name)
+				return httpmock.NewStringResponse(http.StatusCreated, serialized), nil
+			})
+
+			grouped := *subscription
+			grouped.ConsumerGroup = c.group
+			_, _, err := NewSubscriptionAPI(client, c.options).CreateWithStatus(&grouped)
+			require.NoError(t, err, c.name)
+		}
+	})
+
+	t.Run("success created", func(t *testing.T) {
+		httpmock.RegisterResponder("POST", url, httpmock.NewStringResponder(http.StatusCreated, serialized))
+
+		requested, created, err := api.CreateWithStatus(subscription)
+		require.NoError(t, err)
+		assert.True(t, created)
+		assert.Equal(t, "sub-1", requested.ID)
+	})
+
+	t.Run("success existing", func(t *testing.T) {
+		httpmock.RegisterResponder("POST", url, httpmock.NewStringResponder(http.StatusOK, serialized))
+
+		requested, created, err := api.SubscribeOrGetWithStatus(subscription)
+		require.NoError(t, err)
+		assert.False(t, created)
+		assert.Equal(t, "sub-1", requested.ID)
+	})
+}
+
+func TestSubscriptionAPI_CreateContext(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	client := &Client{nakadiURL: defaultNakadiURL, httpClient: http.DefaultClient}
+	api := NewSubscriptionAPI(client, &SubscriptionOptions{Retry: true})
+	url := fmt.Sprintf("%s/subscriptions", defaultNakadiURL)
+	subscription := &Subscription{OwningApplication: "test-app", EventTypes: []string{"test-event.data"}}
+
+	t.Run("fail canceled context", func(t *testing.T) {
+		httpmock.RegisterResponder("POST", url, helperCanceledResponder())
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := api.CreateContext(ctx, subscription)
+		require.Error(t, err)
+		assert.Regexp(t, context.Canceled, err)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		httpmock.RegisterResponder("POST", url, func(r *http.Request) (*http.Response, error) {
+			assert.Equal(t, "application/json;charset=UTF-8", r.Header.Get("Content-Type"))
+			assert.Equal(t, "application/json", r.Header.Get("Accept"))
+			return httpmock.NewJsonResponse(http.StatusCreated, subscription)
+		})
+
+		created, err := api.CreateContext(context.Background(), subscription)
+		require.NoError(t, err)
+		assert.Equal(t, subscription, created)
+	})
+}
+
+func TestSubscriptionAPI_SubscribeOrGet(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	client := &Client{nakadiURL: defaultNakadiURL, httpClient: http.DefaultClient}
+	api := NewSubscriptionAPI(client, nil)
+	url := fmt.Sprintf("%s/subscriptions", defaultNakadiURL)
+
+	// the responder behaves like Nakadi: the first request creates the subscription, all further requests
+	// with the same owning application, event types and consumer group receive the existing one
+	var mutex sync.Mutex
+	var created int32
+	existing := map[string]*Subscription{}
+	httpmock.RegisterResponder("POST", url, func(r *http.Request) (*http.Response, error) {
+		subscription := &Subscription{}
+		if err := json.NewDecoder(r.Body).Decode(subscription); err != nil {
+			return nil, err
+		}
+		key := fmt.Sprintf("%s|%s|%s", subscription.OwningApplication, strings.Join(subscription.EventTypes, ","), subscription.ConsumerGroup)
+
+		mutex.Lock()
+		defer mutex.Unlock()
+		if found, ok := existing[key]; ok {
+			return httpmock.NewJsonResponse(http.StatusOK, found)
+		}
+		subscription.ID = newUUID()
+		existing[key] = subscription
+		atomic.AddInt32(&created, 1)
+		return httpmock.NewJsonResponse(http.StatusCreated, subscription)
+	})
+
+	orders := [][]string{
+		{"test-event.b", "test-event.a"},
+		{"test-event.a", "test-event.b"},
+		{"test-event.b", "test-event.a", "test-event.b"}}
+
+	var wg sync.WaitGroup
+	ids := make([]string, 12)
+	for i := range ids {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			subscription, err := api.SubscribeOrGet(&Subscription{
+				OwningApplication: "test-app",
+				EventTypes:        orders[i%len(orders)]})
+			if assert.NoError(t, err) {
+				assert.Equal(t, []string{"test-event.a", "test-event.b"}, subscription.EventTypes)
+				assert.Equal(t, "default", subscription.ConsumerGroup)
+				ids[i] = subscription.ID
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), atomic.LoadInt32(&created))
+	for _, id := range ids {
+		assert.Equal(t, ids[0], id)
+	}
+}
+
+func TestSubscriptionAPI_SubscribeOrGetContext(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	client := &Client{nakadiURL: defaultNakadiURL, httpClient: http.DefaultClient}
+	api := NewSubscriptionAPI(client, &SubscriptionOptions{Retry: true})
+	url := fmt.Sprintf("%s/subscriptions", defaultNakadiURL)
+	subscription := &Subscription{OwningApplication: "test-app", EventTypes: []string{"test-event.data"}}
+
+	t.Run("fail canceled context", func(t *testing.T) {
+		httpmock.RegisterResponder("POST", url, helperCanceledResponder())
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		_, err := api.SubscribeOrGetContext(ctx, subscription)
+		require.Error(t, err)
+		assert.Regexp(t, context.Canceled, err)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		httpmock.RegisterResponder("POST", url, func(r *http.Request) (*http.Response, error) {
+			requested := &Subscription{}
+			if err := json.NewDecoder(r.Body).Decode(requested); err != nil {
+				return nil, err
+			}
+			return httpmock.NewJsonResponse(http.StatusOK, requested)
+		})
+
+		subscription, created, err := api.SubscribeOrGetWithStatusContext(context.Background(), subscription)
+		require.NoError(t, err)
+		assert.False(t, created)
+		assert.Equal(t, "default", subscription.ConsumerGroup)
+	})
+}
+
+func TestSubscriptionAPI_CreateInitialCursors(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	client := &Client{nakadiURL: defaultNakadiURL, httpClient: http.DefaultClient}
+	api := NewSubscriptionAPI(client, nil)
+	url := fmt.Sprintf("%s/subscriptions", defaultNakadiURL)
+	partitionsURL := fmt.Sprintf("%s/event-types/%s/partitions", defaultNakadiURL, "test-event.data")
+	httpmock.RegisterResponder("GET", partitionsURL, httpmock.NewBytesResponder(http.StatusOK, helperLoadTestData(t, "event-type-partitions.json", nil)))
+
+	newSubscription := func(cursors ...SubscriptionCursor) *Subscription {
+		return &Subscription{
+			OwningApplication: "test-app",
+			EventTypes:        []string{"test-event.data"},
+			ReadFrom:          ReadFromCursors,
+			InitialCursors:    cursors}
+	}
+	first := SubscriptionCursor{EventType: "test-event.data", Partition: "0", Offset: "001-0001-000000000000000020"}
+	second := SubscriptionCursor{EventType: "test-event.data", Partition: "1", Offset: "BEGIN"}
+
+	t.Run("fail missing partitions", func(t *testing.T) {
+		_, err := api.Create(newSubscription(first))
+		require.Error(t, err)
+		assert.Regexp(t, "missing initial cursors for partitions test-event.data/1", err)
+	})
+
+	t.Run("fail unknown partitions", func(t *testing.T) {
+		unknown := SubscriptionCursor{EventType: "other-event", Partition: "0", Offset: "BEGIN"}
+
+		_, err := api.Create(newSubscription(first, second, unknown))
+		require.Error(t, err)
+		assert.Regexp(t, "initial cursors for unknown partitions other-event/0", err)
+	})
+
+	t.Run("fail duplicate cursor", func(t *testing.T) {
+		_, err := api.Create(newSubscription(first, second, first))
+		require.Error(t, err)
+		assert.Regexp(t, "duplicate initial cursor for partition test-event.data/0", err)
+	})
+
+	t.Run("fail request partitions", func(t *testing.T) {
+		subscription := newSubscription(first, second)
+		subscription.EventTypes = []string{"missing-event"}
+		httpmock.RegisterResponder("GET", fmt.Sprintf("%s/event-types/missing-event/partitions", defaultNakadiURL),
+			httpmock.NewStringResponder(http.StatusNotFound, testProblemJSON))
+
+		_, err := api.Create(subscription)
+		require.Error(t, err)
+		assert.Regexp(t, "unable to validate initial cursors", err)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		subscription := newSubscription(first, second)
+		httpmock.RegisterResponder("POST", url, func(r *http.Request) (*http.Response, error) {
+			uploaded := &Subscription{}
+			require.NoError(t, json.NewDecoder(r.Body).Decode(uploaded))
+			assert.Equal(t, subscription.InitialCursors, uploaded.InitialCursors)
+			return httpmock.NewJsonResponse(http.StatusCreated, uploaded)
+		})
+
+		created, err := api.Create(subscription)
+		require.NoError(t, err)
+		assert.Equal(t, ReadFromCursors, created.ReadFrom)
+	})
+}
+
 func TestSubscriptionAPI_Delete(t *testing.T) {
 	httpmock.Activate()
 	defer httpmock.DeactivateAndReset()
@@ -243,6 +817,15 @@ func TestSubscriptionAPI_Delete(t *testing.T) {
 		err := api.Delete(id)
 		require.Error(t, err)
 		assert.Regexp(t, "not found", err)
+		assert.Equal(t, ErrSubscriptionNotFound, errors.Cause(err))
+	})
+
+	t.Run("fail server error", func(t *testing.T) {
+		httpmock.RegisterResponder("DELETE", url, httpmock.NewStringResponder(http.StatusInternalServerError, testProblemJSON))
+
+		err := api.Delete(id)
+		require.Error(t, err)
+		assert.NotEqual(t, ErrSubscriptionNotFound, errors.Cause(err))
 	})
 
 	t.Run("success", func(t *testing.T) {
@@ -253,6 +836,426 @@ func TestSubscriptionAPI_Delete(t *testing.T) {
 	})
 }
 
+func TestClient_ListSubscriptions(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	client := &Client{nakadiURL: defaultNakadiURL, httpClient: http.DefaultClient}
+	url := fmt.Sprintf("%s/subscriptions", defaultNakadiURL)
+
+	t.Run("fail list subscriptions", func(t *testing.T) {
+		httpmock.RegisterResponder("GET", url, httpmock.NewStringResponder(http.StatusBadRequest, testProblemJSON))
+
+		_, err := client.ListSubscriptions("test-app", "test-event")
+		require.Error(t, err)
+		assert.Regexp(t, "some problem detail", err)
+	})
+
+	t.Run("success empty", func(t *testing.T) {
+		httpmock.RegisterResponder("GET", url, func(r *http.Request) (*http.Response, error) {
+			assert.Equal(t, "test-app", r.URL.Query().Get("owning_application"))
+			assert.Empty(t, r.URL.Query()["event_type"])
+			return httpmock.NewStringResponse(http.StatusOK, `{"items":[],"_links":{}}`), nil
+		})
+
+		subscriptions, err := client.ListSubscriptions("test-app", "")
+		require.NoError(t, err)
+		assert.NotNil(t, subscriptions)
+		assert.Empty(t, subscriptions)
+	})
+
+	t.Run("success all pages", func(t *testing.T) {
+		httpmock.RegisterResponder("GET", url, func(r *http.Request) (*http.Response, error) {
+			assert.Equal(t, "test-app", r.URL.Query().Get("owning_application"))
+			assert.Equal(t, []string{"test-event"}, r.URL.Query()["event_type"])
+			if r.URL.Query().Get("offset") == "1" {
+				return httpmock.NewStringResponse(http.StatusOK, `{"items":[{"id":"sub-2"}],"_links":{}}`), nil
+			}
+			return httpmock.NewStringResponse(http.StatusOK,
+				`{"items":[{"id":"sub-1"}],"_links":{"next":{"href":"/subscriptions?owning_application=test-app&event_type=test-event&offset=1"}}}`), nil
+		})
+
+		subscriptions, err := client.ListSubscriptions("test-app", "test-event")
+		require.NoError(t, err)
+		require.Len(t, subscriptions, 2)
+		assert.Equal(t, "sub-1", subscriptions[0].ID)
+		assert.Equal(t, "sub-2", subscriptions[1].ID)
+	})
+}
+
+func TestClient_SubscriptionsForEventType(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	client := &Client{nakadiURL: defaultNakadiURL, httpClient: http.DefaultClient}
+	url := fmt.Sprintf("%s/subscriptions", defaultNakadiURL)
+
+	t.Run("fail without event type", func(t *testing.T) {
+		_, err := client.SubscriptionsForEventType("")
+		require.Error(t, err)
+		assert.Regexp(t, "event type required", err)
+	})
+
+	t.Run("fail list subscriptions", func(t *testing.T) {
+		httpmock.RegisterResponder("GET", url, httpmock.NewStringResponder(http.StatusBadRequest, testProblemJSON))
+
+		_, err := client.SubscriptionsForEventType("test-event")
+		require.Error(t, err)
+		assert.Regexp(t, "some problem detail", err)
+	})
+
+	t.Run("success empty", func(t *testing.T) {
+		httpmock.RegisterResponder("GET", url, httpmock.NewStringResponder(http.StatusOK, `{"items":[],"_links":{}}`))
+
+		subscriptions, err := client.SubscriptionsForEventType("test-event")
+		require.NoError(t, err)
+		assert.NotNil(t, subscriptions)
+		assert.Empty(t, subscriptions)
+	})
+
+	t.Run("success all pages", func(t *testing.T) {
+		httpmock.RegisterResponder("GET", url, func(r *http.Request) (*http.Response, error) {
+			assert.Equal(t, []string{"test-event"}, r.URL.Query()["event_type"])
+			if r.URL.Query().Get("offset") == "1" {
+				return httpmock.NewStringResponse(http.StatusOK, `{"items":[{"id":"sub-2"}],"_links":{}}`), nil
+			}
+			return httpmock.NewStringResponse(http.StatusOK,
+				`{"items":[{"id":"sub-1"}],"_links":{"next":{"href":"/subscriptions?event_type=test-event&offset=1"}}}`), nil
+		})
+
+		subscriptions, err := client.SubscriptionsForEventType("test-event")
+		require.NoError(t, err)
+		require.Len(t, subscriptions, 2)
+		assert.Equal(t, "sub-1", subscriptions[0].ID)
+		assert.Equal(t, "sub-2", subscriptions[1].ID)
+	})
+}
+
+func TestClient_ConsumerGroupsForEventType(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	client := &Client{nakadiURL: defaultNakadiURL, httpClient: http.DefaultClient}
+	url := fmt.Sprintf("%s/subscriptions", defaultNakadiURL)
+
+	t.Run("fail list subscriptions", func(t *testing.T) {
+		httpmock.RegisterResponder("GET", url, httpmock.NewStringResponder(http.StatusBadRequest, testProblemJSON))
+
+		_, err := client.ConsumerGroupsForEventType("test-event")
+		require.Error(t, err)
+		assert.Regexp(t, "some problem detail", err)
+	})
+
+	t.Run("success empty", func(t *testing.T) {
+		httpmock.RegisterResponder("GET", url, httpmock.NewStringResponder(http.StatusOK, `{"items":[],"_links":{}}`))
+
+		groups, err := client.ConsumerGroupsForEventType("test-event")
+		require.NoError(t, err)
+		assert.NotNil(t, groups)
+		assert.Empty(t, groups)
+	})
+
+	t.Run("success distinct groups of all pages", func(t *testing.T) {
+		httpmock.RegisterResponder("GET", url, func(r *http.Request) (*http.Response, error) {
+			assert.Equal(t, []string{"test-event"}, r.URL.Query()["event_type"])
+			if r.URL.Query().Get("offset") == "2" {
+				return httpmock.NewStringResponse(http.StatusOK,
+					`{"items":[{"id":"sub-3","consumer_group":"reporting"},{"id":"sub-4"}],"_links":{}}`), nil
+			}
+			return httpmock.NewStringResponse(http.StatusOK, `{"items":[
+				{"id":"sub-1","consumer_group":"reporting"},
+				{"id":"sub-2","consumer_group":"audit"}],
+				"_links":{"next":{"href":"/subscriptions?event_type=test-event&offset=2"}}}`), nil
+		})
+
+		groups, err := client.ConsumerGroupsForEventType("test-event")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"audit", "default", "reporting"}, groups)
+	})
+}
+
+func TestClient_AuditSubscribe(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	client := &Client{nakadiURL: defaultNakadiURL, httpClient: http.DefaultClient}
+	url := fmt.Sprintf("%s/subscriptions", defaultNakadiURL)
+
+	t.Run("fail without owning application", func(t *testing.T) {
+		_, err := client.AuditSubscribe("", []string{"test-event"})
+		require.Error(t, err)
+		assert.Regexp(t, "owning application required", err)
+	})
+
+	t.Run("fail without event types", func(t *testing.T) {
+		_, err := client.AuditSubscribe("test-app", nil)
+		require.Error(t, err)
+		assert.Regexp(t, "event types required", err)
+	})
+
+	t.Run("fail create subscription", func(t *testing.T) {
+		httpmock.RegisterResponder("POST", url, httpmock.NewStringResponder(http.StatusBadRequest, testProblemJSON))
+
+		_, err := client.AuditSubscribe("test-app", []string{"test-event"})
+		require.Error(t, err)
+		assert.Regexp(t, "some problem detail", err)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		httpmock.RegisterResponder("POST", url, func(r *http.Request) (*http.Response, error) {
+			subscription := &Subscription{}
+			if err := json.NewDecoder(r.Body).Decode(subscription); err != nil {
+				return nil, err
+			}
+			assert.Equal(t, "test-app", subscription.OwningApplication)
+			assert.Equal(t, []string{"test-event.a", "test-event.b"}, subscription.EventTypes)
+			assert.Equal(t, "audit-test-app", subscription.ConsumerGroup)
+			assert.Equal(t, ReadFromBegin, subscription.ReadFrom)
+			subscription.ID = "sub-1"
+			return httpmock.NewJsonResponse(http.StatusCreated, subscription)
+		})
+
+		subscription, err := client.AuditSubscribe("test-app", []string{"test-event.b", "test-event.a"})
+		require.NoError(t, err)
+		assert.Equal(t, "sub-1", subscription.ID)
+		assert.Equal(t, "audit-test-app", subscription.ConsumerGroup)
+	})
+}
+
+func TestClient_DeleteSubscriptionsByFilter(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	client := &Client{nakadiURL: defaultNakadiURL, httpClient: http.DefaultClient}
+	url := fmt.Sprintf("%s/subscriptions", defaultNakadiURL)
+	subscriptions := struct {
+		Items []*Subscription `json:"items"`
+	}{Items: []*Subscription{{ID: "sub-1"}, {ID: "sub-2"}, {ID: "sub-3"}}}
+
+	t.Run("fail without filter", func(t *testing.T) {
+		_, err := client.DeleteSubscriptionsByFilter("", "")
+		require.Error(t, err)
+		assert.Regexp(t, "owning application or event type required", err)
+	})
+
+	t.Run("fail list subscriptions", func(t *testing.T) {
+		httpmock.RegisterResponder("GET", url, httpmock.NewStringResponder(http.StatusBadRequest, testProblemJSON))
+
+		_, err := client.DeleteSubscriptionsByFilter("test-app", "")
+		require.Error(t, err)
+		assert.Regexp(t, "some problem detail", err)
+	})
+
+	t.Run("fail some deletions", func(t *testing.T) {
+		responder, _ := httpmock.NewJsonResponder(http.StatusOK, subscriptions)
+		httpmock.RegisterResponder("GET", url, responder)
+		httpmock.RegisterResponder("DELETE", url+"/sub-1", httpmock.NewStringResponder(http.StatusNoContent, ""))
+		httpmock.RegisterResponder("DELETE", url+"/sub-2", httpmock.NewStringResponder(http.StatusForbidden, testProblemJSON))
+		httpmock.RegisterResponder("DELETE", url+"/sub-3", httpmock.NewStringResponder(http.StatusNotFound, testProblemJSON))
+
+		deleted, err := client.DeleteSubscriptionsByFilter("test-app", "test-event")
+		require.Error(t, err)
+		assert.Regexp(t, "1 of 3 failed: unable to delete subscription: some problem detail", err)
+		assert.Equal(t, 1, deleted)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		httpmock.RegisterResponder("GET", url, func(r *http.Request) (*http.Response, error) {
+			assert.Equal(t, "test-app", r.URL.Query().Get("owning_application"))
+			assert.Equal(t, "test-event", r.URL.Query().Get("event_type"))
+			return httpmock.NewJsonResponse(http.StatusOK, subscriptions)
+		})
+		httpmock.RegisterResponder("DELETE", url+"/sub-1", httpmock.NewStringResponder(http.StatusNoContent, ""))
+		httpmock.RegisterResponder("DELETE", url+"/sub-2", httpmock.NewStringResponder(http.StatusNoContent, ""))
+		httpmock.RegisterResponder("DELETE", url+"/sub-3", httpmock.NewStringResponder(http.StatusNotFound, testProblemJSON))
+
+		deleted, err := client.DeleteSubscriptionsByFilter("test-app", "test-event")
+		require.NoError(t, err)
+		assert.Equal(t, 2, deleted)
+	})
+}
+
+func TestSubscriptionAPI_ResetCursors(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	id := "7dd69d58-7f20-11e7-9748-133d6a0dbfb3"
+	cursors := []Cursor{{Partition: "0", Offset: "BEGIN", EventType: "test-event", CursorToken: "token"}}
+
+	client := &Client{nakadiURL: defaultNakadiURL, httpClient: http.DefaultClient}
+	api := NewSubscriptionAPI(client, nil)
+	url := fmt.Sprintf("%s/subscriptions/%s/cursors", defaultNakadiURL, id)
+
+	t.Run("fail connection error", func(t *testing.T) {
+		httpmock.RegisterResponder("PATCH", url, httpmock.NewErrorResponder(assert.AnError))
+
+		err := api.ResetCursors(id, cursors)
+		require.Error(t, err)
+		assert.Regexp(t, assert.AnError, err)
+	})
+
+	t.Run("fail with problem", func(t *testing.T) {
+		httpmock.RegisterResponder("PATCH", url, httpmock.NewStringResponder(http.StatusUnprocessableEntity, testProblemJSON))
+
+		err := api.ResetCursors(id, cursors)
+		require.Error(t, err)
+		assert.Regexp(t, "unable to reset subscription cursors: some problem detail", err)
+	})
+
+	t.Run("fail with conflict", func(t *testing.T) {
+		httpmock.RegisterResponder("PATCH", url, httpmock.NewStringResponder(http.StatusConflict, testProblemJSON))
+
+		err := api.ResetCursors(id, cursors)
+		require.Error(t, err)
+		assert.Equal(t, ErrActiveStreams, errors.Cause(err))
+	})
+
+	t.Run("success", func(t *testing.T) {
+		httpmock.RegisterResponder("PATCH", url, func(r *http.Request) (*http.Response, error) {
+			body := map[string][]map[string]string{}
+			err := json.NewDecoder(r.Body).Decode(&body)
+			require.NoError(t, err)
+			expected := []map[string]string{{"partition": "0", "offset": "BEGIN", "event_type": "test-event"}}
+			assert.Equal(t, expected, body["items"])
+			return httpmock.NewStringResponse(http.StatusNoContent, ""), nil
+		})
+
+		err := api.ResetCursors(id, cursors)
+		assert.NoError(t, err)
+	})
+}
+
+func TestSubscriptionAPI_ResetCursorsAvailableOffsets(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	id := "7dd69d58-7f20-11e7-9748-133d6a0dbfb3"
+	client := &Client{nakadiURL: defaultNakadiURL, httpClient: http.DefaultClient}
+	url := fmt.Sprintf("%s/subscriptions/%s/cursors", defaultNakadiURL, id)
+	partitionsURL := fmt.Sprintf("%s/event-types/%s/partitions", defaultNakadiURL, "test-event")
+	partitions := []*EventTypePartition{
+		{Partition: "0", OldestAvailableOffset: "001-0001-000000000000000010", NewestAvailableOffset: "001-0001-000000000000000020"},
+		{Partition: "1", OldestAvailableOffset: "001-0001-000000000000000005", NewestAvailableOffset: "001-0001-000000000000000020"}}
+	cursors := []Cursor{
+		{Partition: "0", Offset: "001-0001-000000000000000009", EventType: "test-event"},
+		{Partition: "1", Offset: "001-0001-000000000000000005", EventType: "test-event"}}
+
+	responder, err := httpmock.NewJsonResponder(http.StatusOK, partitions)
+	require.NoError(t, err)
+
+	t.Run("fail request partitions", func(t *testing.T) {
+		httpmock.RegisterResponder("GET", partitionsURL, httpmock.NewStringResponder(http.StatusInternalServerError, testProblemJSON))
+
+		err := NewSubscriptionAPI(client, nil).ResetCursors(id, cursors)
+		require.Error(t, err)
+		assert.Regexp(t, "unable to reset subscription cursors: unable to check available offsets: unable to request partitions", err)
+	})
+
+	t.Run("fail offset unavailable", func(t *testing.T) {
+		httpmock.RegisterResponder("GET", partitionsURL, responder)
+		httpmock.RegisterResponder("PATCH", url, func(r *http.Request) (*http.Response, error) {
+			assert.Fail(t, "cursors must not be reset")
+			return httpmock.NewStringResponse(http.StatusNoContent, ""), nil
+		})
+
+		err := NewSubscriptionAPI(client, nil).ResetCursors(id, cursors)
+		require.Error(t, err)
+		assert.Equal(t, ErrOffsetUnavailable, errors.Cause(err))
+		assert.Regexp(t, "offset 001-0001-000000000000000009 of partition test-event/0 is older than the oldest available offset 001-0001-000000000000000010", err)
+	})
+
+	t.Run("success clamp to available", func(t *testing.T) {
+		httpmock.RegisterResponder("GET", partitionsURL, responder)
+		httpmock.RegisterResponder("PATCH", url, func(r *http.Request) (*http.Response, error) {
+			body := map[string][]map[string]string{}
+			err := json.NewDecoder(r.Body).Decode(&body)
+			require.NoError(t, err)
+			expected := []map[string]string{
+				{"partition": "0", "offset": "BEGIN", "event_type": "test-event"},
+				{"partition": "1", "offset": "001-0001-000000000000000005", "event_type": "test-event"}}
+			assert.Equal(t, expected, body["items"])
+			return httpmock.NewStringResponse(http.StatusNoContent, ""), nil
+		})
+
+		err := NewSubscriptionAPI(client, &SubscriptionOptions{ClampToAvailable: true}).ResetCursors(id, cursors)
+		require.NoError(t, err)
+		assert.Equal(t, "001-0001-000000000000000009", cursors[0].Offset)
+	})
+}
+
+func TestSubscriptionAPI_EditCursors(t *testing.T) {
+	id := "7dd69d58-7f20-11e7-9748-133d6a0dbfb3"
+	url := fmt.Sprintf("%s/subscriptions/%s/cursors", defaultNakadiURL, id)
+	statsURL := fmt.Sprintf("%s/subscriptions/%s/stats", defaultNakadiURL, id)
+	cursors := `{"items":[{"partition":"0","offset":"BEGIN","event_type":"test-event","cursor_token":"token"}]}`
+	idle := `{"items":[{"event_type":"test-event","partitions":[{"partition":"0","state":"unassigned"}]}]}`
+	active := `{"items":[{"event_type":"test-event","partitions":[{"partition":"0","state":"assigned","stream_id":"stream-id"}]}]}`
+
+	setup := func() (*httpmock.MockTransport, *SubscriptionAPI) {
+		transport := httpmock.NewMockTransport()
+		client := &Client{nakadiURL: defaultNakadiURL, httpClient: &http.Client{Transport: transport}}
+		return transport, NewSubscriptionAPI(client, nil)
+	}
+
+	t.Run("fail active streams", func(t *testing.T) {
+		transport, api := setup()
+		transport.RegisterResponder("GET", statsURL, httpmock.NewStringResponder(http.StatusOK, active))
+
+		err := api.EditCursors(id, func(c []Cursor) ([]Cursor, error) {
+			assert.Fail(t, "transform must not be called")
+			return c, nil
+		})
+		require.Error(t, err)
+		assert.Equal(t, ErrActiveStreams, errors.Cause(err))
+		assert.Equal(t, 1, transport.GetTotalCallCount())
+	})
+
+	t.Run("fail transform", func(t *testing.T) {
+		transport, api := setup()
+		transport.RegisterResponder("GET", statsURL, httpmock.NewStringResponder(http.StatusOK, idle))
+		transport.RegisterResponder("GET", url, httpmock.NewStringResponder(http.StatusOK, cursors))
+
+		err := api.EditCursors(id, func(c []Cursor) ([]Cursor, error) { return nil, assert.AnError })
+		require.Error(t, err)
+		assert.Equal(t, assert.AnError, errors.Cause(err))
+		assert.Regexp(t, "unable to edit subscription cursors", err)
+		assert.Equal(t, 2, transport.GetTotalCallCount())
+	})
+
+	t.Run("fail request cursors", func(t *testing.T) {
+		transport, api := setup()
+		transport.RegisterResponder("GET", statsURL, httpmock.NewStringResponder(http.StatusOK, idle))
+		transport.RegisterResponder("GET", url, httpmock.NewStringResponder(http.StatusNotFound, testProblemJSON))
+
+		err := api.EditCursors(id, func(c []Cursor) ([]Cursor, error) { return c, nil })
+		require.Error(t, err)
+		assert.Regexp(t, "unable to edit subscription cursors: unable to request committed cursors: some problem detail", err)
+	})
+
+	t.Run("success", func(t *testing.T) {
+		transport, api := setup()
+		transport.RegisterResponder("GET", statsURL, httpmock.NewStringResponder(http.StatusOK, idle))
+		transport.RegisterResponder("GET", url, httpmock.NewStringResponder(http.StatusOK, cursors))
+		transport.RegisterResponder("PATCH", url, func(r *http.Request) (*http.Response, error) {
+			body := map[string][]map[string]string{}
+			err := json.NewDecoder(r.Body).Decode(&body)
+			require.NoError(t, err)
+			expected := []map[string]string{{"partition": "0", "offset": "BEGIN", "event_type": "other-event"}}
+			assert.Equal(t, expected, body["items"])
+			return httpmock.NewStringResponse(http.StatusNoContent, ""), nil
+		})
+
+		var received []Cursor
+		err := api.EditCursors(id, func(c []Cursor) ([]Cursor, error) {
+			received = append(received, c...)
+			return []Cursor{{Partition: "0", Offset: "BEGIN", EventType: "other-event"}}, nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []Cursor{{Partition: "0", Offset: "BEGIN", EventType: "test-event", CursorToken: "token"}}, received)
+		assert.Equal(t, 3, transport.GetTotalCallCount())
+	})
+}
+
 func TestSubscriptionAPI_GetStats(t *testing.T) {
 	httpmock.Activate()
 	defer httpmock.DeactivateAndReset()
@@ -316,6 +1319,178 @@ func TestSubscriptionAPI_GetStats(t *testing.T) {
 		actual, err := json.Marshal(stats)
 		require.NoError(t, err)
 		assert.JSONEq(t, string(expected.Items), string(actual))
+
+		assigned := stats[0].Partitions[0]
+		assert.Equal(t, PartitionStateAssigned, assigned.State)
+		assert.Equal(t, "8f5e3b4a-2f2b-4d92-9faf-c8b4a1e16c3d", assigned.StreamID)
+		assert.Equal(t, 12, assigned.ConsumerLagSeconds)
+		assert.Equal(t, PartitionStateUnassigned, stats[0].Partitions[1].State)
+	})
+
+	t.Run("success show time lag", func(t *testing.T) {
+		for _, showTimeLag := range []bool{false, true} {
+			httpmock.RegisterResponder("GET", url, func(r *http.Request) (*http.Response, error) {
+				if showTimeLag {
+					assert.Equal(t, "true", r.URL.Query().Get("show_time_lag"))
+				} else {
+					assert.Empty(t, r.URL.RawQuery)
+				}
+				return httpmock.NewStringResponse(http.StatusOK, `{"items":[]}`), nil
+			})
+
+			_, err := NewSubscriptionAPI(client, &SubscriptionOptions{ShowTimeLag: showTimeLag}).GetStats(id)
+			require.NoError(t, err)
+		}
+	})
+}
+
+func TestClient_BulkSubscriptionStats(t *testing.T) {
+	transport := httpmock.NewMockTransport()
+	client := &Client{nakadiURL: defaultNakadiURL, httpClient: &http.Client{Transport: transport}}
+
+	var active, maxActive int32
+	transport.RegisterNoResponder(func(r *http.Request) (*http.Response, error) {
+		current := atomic.AddInt32(&active, 1)
+		defer atomic.AddInt32(&active, -1)
+		for {
+			max := atomic.LoadInt32(&maxActive)
+			if current <= max || atomic.CompareAndSwapInt32(&maxActive, max, current) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+
+		id := strings.Split(r.URL.Path, "/")[2]
+		if id == "sub-failing" {
+			return httpmock.NewStringResponse(http.StatusNotFound, testProblemJSON), nil
+		}
+		return httpmock.NewJsonResponse(http.StatusOK, &statsResponse{Items: []*SubscriptionStats{{EventType: id}}})
+	})
+
+	t.Run("success bounded concurrency", func(t *testing.T) {
+		ids := []string{"sub-failing"}
+		for i := 0; i < 10; i++ {
+			ids = append(ids, fmt.Sprintf("sub-%d", i))
+		}
+
+		results, failures := client.BulkSubscriptionStats(context.Background(), append(ids, "sub-0"), 3)
+		require.Len(t, results, 10)
+		for _, id := range ids[1:] {
+			require.Len(t, results[id], 1)
+			assert.Equal(t, id, results[id][0].EventType)
+		}
+		require.Len(t, failures, 1)
+		assert.Regexp(t, "some problem detail", failures["sub-failing"])
+		assert.True(t, atomic.LoadInt32(&maxActive) <= 3)
+	})
+
+	t.Run("fail canceled context", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		results, failures := client.BulkSubscriptionStats(ctx, []string{"sub-0", "sub-1"}, 0)
+		assert.Empty(t, results)
+		require.Len(t, failures, 2)
+		for _, err := range failures {
+			assert.Regexp(t, context.Canceled, err)
+		}
+	})
+}
+
+func TestClient_TotalLag(t *testing.T) {
+	transport := httpmock.NewMockTransport()
+	client := &Client{nakadiURL: defaultNakadiURL, httpClient: &http.Client{Transport: transport}}
+	statsURL := defaultNakadiURL + "/subscriptions/sub-id/stats"
+
+	t.Run("fail stats unavailable", func(t *testing.T) {
+		transport.RegisterResponder("GET", statsURL, httpmock.NewStringResponder(http.StatusNotFound, testProblemJSON))
+
+		_, err := client.TotalLag("sub-id")
+		require.Error(t, err)
+		assert.Regexp(t, "unable to compute lag of subscription: .*some problem detail", err)
+	})
+
+	t.Run("success sum of all partitions", func(t *testing.T) {
+		transport.RegisterResponder("GET", statsURL, httpmock.NewStringResponder(http.StatusOK, `{"items":[
+			{"event_type":"event-a","partitions":[{"partition":"0","unconsumed_events":3},{"partition":"1","unconsumed_events":4}]},
+			{"event_type":"event-b","partitions":[{"partition":"0","unconsumed_events":5}]}]}`))
+
+		lag, err := client.TotalLag("sub-id")
+		require.NoError(t, err)
+		assert.Equal(t, int64(12), lag)
+	})
+
+	t.Run("success caught up", func(t *testing.T) {
+		transport.RegisterResponder("GET", statsURL, httpmock.NewStringResponder(http.StatusOK, `{"items":[
+			{"event_type":"event-a","partitions":[{"partition":"0","unconsumed_events":0}]}]}`))
+
+		lag, err := client.TotalLag("sub-id")
+		require.NoError(t, err)
+		assert.Equal(t, int64(0), lag)
+	})
+}
+
+func TestSubscriptionAPI_Timeout(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	id := "7dd69d58-7f20-11e7-9748-133d6a0dbfb3"
+	url := fmt.Sprintf("%s/subscriptions/%s", defaultNakadiURL, id)
+	client := &Client{nakadiURL: defaultNakadiURL, httpClient: &http.Client{Timeout: 10 * time.Millisecond}}
+
+	httpmock.RegisterResponder("GET", url, func(r *http.Request) (*http.Response, error) {
+		select {
+		case <-time.After(50 * time.Millisecond):
+			return httpmock.NewStringResponse(http.StatusOK, `{"id":"`+id+`"}`), nil
+		case <-r.Context().Done():
+			return nil, r.Context().Err()
+		}
+	})
+
+	t.Run("fail connection timeout of client", func(t *testing.T) {
+		_, err := NewSubscriptionAPI(client, nil).Get(id)
+		require.Error(t, err)
+		assert.Regexp(t, "deadline exceeded|Timeout", err)
+	})
+
+	t.Run("success timeout of subscription API", func(t *testing.T) {
+		subAPI := NewSubscriptionAPI(client, &SubscriptionOptions{Timeout: time.Second})
+		assert.Equal(t, 10*time.Millisecond, client.httpClient.Timeout)
+
+		subscription, err := subAPI.Get(id)
+		require.NoError(t, err)
+		assert.Equal(t, id, subscription.ID)
+	})
+}
+
+func TestSubscriptionAPI_Context(t *testing.T) {
+	httpmock.Activate()
+	defer httpmock.DeactivateAndReset()
+
+	id := "7dd69d58-7f20-11e7-9748-133d6a0dbfb3"
+	url := fmt.Sprintf("%s/subscriptions/%s", defaultNakadiURL, id)
+	client := &Client{nakadiURL: defaultNakadiURL, httpClient: http.DefaultClient}
+	subAPI := NewSubscriptionAPI(client, &SubscriptionOptions{Retry: true})
+
+	httpmock.RegisterResponder("GET", url, helperCanceledResponder())
+	httpmock.RegisterResponder("DELETE", url, helperCanceledResponder())
+
+	t.Run("fail get canceled", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		_, err := subAPI.GetContext(ctx, id)
+		require.Error(t, err)
+		assert.Regexp(t, "unable to request subscription", err)
+	})
+
+	t.Run("fail delete canceled", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		err := subAPI.DeleteContext(ctx, id)
+		require.Error(t, err)
+		assert.Regexp(t, "unable to delete subscription", err)
 	})
 }
 
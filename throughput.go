@@ -0,0 +1,168 @@
+package nakadi
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Throughput describes the rate at which a StreamAPI receives batches, events and bytes, averaged over the
+// window configured by StreamOptions.ThroughputWindow.
+type Throughput struct {
+	SubscriptionID   string
+	BatchesPerSecond float64
+	EventsPerSecond  float64
+	BytesPerSecond   float64
+}
+
+// ThroughputCollector can be implemented by the MetricsCollector of a client in order to receive the
+// throughput of all streams opened with the client. ObserveThroughput is called at most once per second
+// for each stream while batches are received.
+type ThroughputCollector interface {
+	ObserveThroughput(throughput Throughput)
+}
+
+// throughputSample holds the totals of a stream at a specific time.
+type throughputSample struct {
+	at      time.Time
+	batches int64
+	events  int64
+	bytes   int64
+}
+
+// throughputMeter computes rates from samples of the totals of a stream, which are taken at most once per
+// second. Between samples recording a batch only requires an atomic load.
+type throughputMeter struct {
+	// lastSecond is accessed atomically and must be the first field for 64-bit alignment
+	lastSecond int64
+	window     time.Duration
+	mutex      sync.Mutex
+	samples    []throughputSample
+}
+
+func newThroughputMeter(window time.Duration, now time.Time) *throughputMeter {
+	return &throughputMeter{
+		lastSecond: now.Unix(),
+		window:     window,
+		samples:    []throughputSample{{at: now}}}
+}
+
+// sample stores the totals unless a sample was already taken within the same second. It returns true if
+// the sample was stored.
+func (m *throughputMeter) sample(totals throughputSample) bool {
+	second := totals.at.Unix()
+	if atomic.LoadInt64(&m.lastSecond) == second {
+		return false
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.lastSecond == second {
+		return false
+	}
+	atomic.StoreInt64(&m.lastSecond, second)
+	m.samples = append(m.samples, totals)
+
+	// keep one sample at or before the start of the window as baseline
+	start := totals.at.Add(-m.window)
+	for len(m.samples) > 1 && !m.samples[1].at.After(start) {
+		m.samples = m.samples[1:]
+	}
+	return true
+}
+
+// rate computes the throughput between the oldest sample within the window and the current totals.
+func (m *throughputMeter) rate(totals throughputSample) Throughput {
+	m.mutex.Lock()
+	start := totals.at.Add(-m.window)
+	baseline := m.samples[len(m.samples)-1]
+	for _, sample := range m.samples {
+		if !sample.at.Before(start) {
+			baseline = sample
+			break
+		}
+	}
+	m.mutex.Unlock()
+
+	elapsed := totals.at.Sub(baseline.at).Seconds()
+	if elapsed <= 0 {
+		return Throughput{}
+	}
+	return Throughput{
+		BatchesPerSecond: float64(totals.batches-baseline.batches) / elapsed,
+		EventsPerSecond:  float64(totals.events-baseline.events) / elapsed,
+		BytesPerSecond:   float64(totals.bytes-baseline.bytes) / elapsed}
+}
+
+// Throughput returns the number of batches with events, events and bytes the StreamAPI received per second
+// within the window configured by StreamOptions.ThroughputWindow. Bytes include the cursors of the batches
+// and keep alive batches. Events are only counted for streams using JSONCodec.
+func (s *StreamAPI) Throughput() Throughput {
+	if s.throughput == nil {
+		return Throughput{SubscriptionID: s.SubscriptionID()}
+	}
+	throughput := s.throughput.rate(s.throughputTotals())
+	throughput.SubscriptionID = s.SubscriptionID()
+	return throughput
+}
+
+// recordThroughput samples the totals of the stream after a batch was received and reports the throughput
+// to the collector if a new sample was taken.
+func (s *StreamAPI) recordThroughput() {
+	if s.throughput == nil {
+		return
+	}
+	if s.throughput.sample(s.throughputTotals()) && s.throughputSink != nil {
+		s.throughputSink.ObserveThroughput(s.Throughput())
+	}
+}
+
+func (s *StreamAPI) throughputTotals() throughputSample {
+	return throughputSample{
+		at:      time.Now(),
+		batches: atomic.LoadInt64(&s.batchesRead),
+		events:  atomic.LoadInt64(&s.eventsRead),
+		bytes:   atomic.LoadInt64(&s.bytesRead)}
+}
+
+// countEvents counts the elements of a json array without decoding them.
+func countEvents(events []byte) int64 {
+	var count int64
+	depth, inString, escaped, empty := 0, false, false, true
+	for _, b := range events {
+		if inString {
+			switch {
+			case escaped:
+				escaped = false
+			case b == '\\':
+				escaped = true
+			case b == '"':
+				inString = false
+			}
+			continue
+		}
+
+		switch b {
+		case '"':
+			inString = true
+		case '[', '{':
+			depth++
+		case ']', '}':
+			depth--
+		case ',':
+			if depth == 1 {
+				count++
+			}
+		case ' ', '\t', '\r', '\n':
+			continue
+		}
+		if depth > 1 || (depth == 1 && b != '[') {
+			empty = false
+		}
+	}
+	if empty {
+		return 0
+	}
+	return count + 1
+}
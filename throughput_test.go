@@ -0,0 +1,103 @@
+package nakadi
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCountEvents(t *testing.T) {
+	tests := []struct {
+		events   string
+		expected int64
+	}{
+		{``, 0},
+		{`[]`, 0},
+		{` [ ] `, 0},
+		{`[{}]`, 1},
+		{`[1, "a", null]`, 3},
+		{`[{"a":[1,2],"b":{"c":","}},{"d":"\"],"}]`, 2},
+		{`[[1,2],[3]]`, 2},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, countEvents([]byte(test.events)), "events %s", test.events)
+	}
+}
+
+func TestThroughputMeter(t *testing.T) {
+	start := time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)
+	meter := newThroughputMeter(10*time.Second, start)
+
+	assert.Equal(t, Throughput{}, meter.rate(throughputSample{at: start}))
+
+	// only one sample is taken per second
+	assert.True(t, meter.sample(throughputSample{at: start.Add(time.Second), batches: 2, events: 4, bytes: 100}))
+	assert.False(t, meter.sample(throughputSample{at: start.Add(1500 * time.Millisecond), batches: 3, events: 6, bytes: 150}))
+
+	throughput := meter.rate(throughputSample{at: start.Add(2 * time.Second), batches: 4, events: 8, bytes: 200})
+	assert.Equal(t, Throughput{BatchesPerSecond: 2, EventsPerSecond: 4, BytesPerSecond: 100}, throughput)
+
+	// samples before the window are dropped
+	assert.True(t, meter.sample(throughputSample{at: start.Add(20 * time.Second), batches: 40, events: 80, bytes: 2000}))
+	require.Len(t, meter.samples, 2)
+	throughput = meter.rate(throughputSample{at: start.Add(25 * time.Second), batches: 50, events: 100, bytes: 2500})
+	assert.Equal(t, Throughput{BatchesPerSecond: 2, EventsPerSecond: 4, BytesPerSecond: 100}, throughput)
+}
+
+type recordingThroughputCollector struct {
+	recordingCollector
+	mutex      sync.Mutex
+	throughput []Throughput
+}
+
+func (c *recordingThroughputCollector) ObserveThroughput(throughput Throughput) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.throughput = append(c.throughput, throughput)
+}
+
+func (c *recordingThroughputCollector) Throughput() []Throughput {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return append([]Throughput(nil), c.throughput...)
+}
+
+func TestStreamAPI_Throughput(t *testing.T) {
+	expectedCursor := Cursor{NakadiStreamID: "stream-id"}
+	expectedEvents := []byte(`[{"metadata":{"eid":"1"}},{"metadata":{"eid":"2"}}]`)
+
+	collector := &recordingThroughputCollector{}
+	stream := &mockStreamer{}
+	streamAPI, opener, _ := newMockStream(nil, nil)
+	streamAPI.subscriptionID = "sub-id"
+	streamAPI.jsonCodec = true
+	streamAPI.throughput = newThroughputMeter(time.Minute, time.Now().Add(-2*time.Second))
+	streamAPI.throughputSink = collector
+
+	opener.On("openStream").Return(stream, nil)
+	stream.On("nextEvents").Return(expectedCursor, expectedEvents, nil)
+	stream.On("closeStream").Return(nil)
+
+	go streamAPI.startStream()
+	defer streamAPI.Close()
+
+	_, _, err := streamAPI.NextEvents()
+	require.NoError(t, err)
+
+	throughput := streamAPI.Throughput()
+	assert.Equal(t, "sub-id", throughput.SubscriptionID)
+	assert.True(t, throughput.BatchesPerSecond > 0)
+	assert.True(t, throughput.EventsPerSecond >= throughput.BatchesPerSecond)
+
+	reported := collector.Throughput()
+	require.NotEmpty(t, reported)
+	assert.Equal(t, "sub-id", reported[0].SubscriptionID)
+	assert.True(t, reported[0].EventsPerSecond > 0)
+
+	mock.AssertExpectationsForObjects(t, opener)
+}
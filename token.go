@@ -0,0 +1,59 @@
+package nakadi
+
+import (
+	"sync"
+	"time"
+)
+
+// CachingTokenProvider wraps a TokenProvider, so that the token it returns is reused for the given ttl instead
+// of being obtained for every request. If obtaining a new token fails, the error is returned.
+func CachingTokenProvider(provider func() (string, error), ttl time.Duration) func() (string, error) {
+	cache := &tokenCache{provider: func() (string, time.Time, error) {
+		token, err := provider()
+		return token, time.Now().Add(ttl), err
+	}}
+	return cache.token
+}
+
+// CachingExpiringTokenProvider creates a TokenProvider from a provider which returns tokens along with their
+// expiry. A token is reused until 30s before it expires. If refreshing the token fails while the cached token
+// has not yet expired, the cached token is returned, otherwise the error. Tokens with a zero expiry are not
+// cached. The returned provider can be shared by clients used from several goroutines.
+func CachingExpiringTokenProvider(provider func() (string, time.Time, error)) func() (string, error) {
+	cache := &tokenCache{margin: defaultTokenRefreshMargin, provider: provider}
+	return cache.token
+}
+
+// tokenCache holds the last token of a provider. The provider is called by at most one goroutine at a time.
+type tokenCache struct {
+	mutex    sync.Mutex
+	provider func() (string, time.Time, error)
+	margin   time.Duration
+	cached   string
+	expiry   time.Time
+}
+
+func (c *tokenCache) token() (string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := time.Now()
+	if c.cached != "" && now.Before(c.expiry.Add(-c.margin)) {
+		return c.cached, nil
+	}
+
+	token, expiry, err := c.provider()
+	if err != nil {
+		if c.cached != "" && now.Before(c.expiry) {
+			return c.cached, nil
+		}
+		return "", err
+	}
+
+	if expiry.IsZero() {
+		c.cached, c.expiry = "", time.Time{}
+	} else {
+		c.cached, c.expiry = token, expiry
+	}
+	return token, nil
+}
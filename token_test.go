@@ -0,0 +1,128 @@
+package nakadi
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCachingTokenProvider(t *testing.T) {
+	var calls int32
+	provider := CachingTokenProvider(func() (string, error) {
+		if atomic.AddInt32(&calls, 1) == 2 {
+			return "", assert.AnError
+		}
+		return "token", nil
+	}, 50*time.Millisecond)
+
+	token, err := provider()
+	require.NoError(t, err)
+	assert.Equal(t, "token", token)
+	token, err = provider()
+	require.NoError(t, err)
+	assert.Equal(t, "token", token)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	time.Sleep(60 * time.Millisecond)
+	_, err = provider()
+	assert.Equal(t, assert.AnError, err)
+
+	token, err = provider()
+	require.NoError(t, err)
+	assert.Equal(t, "token", token)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+}
+
+func TestCachingExpiringTokenProvider(t *testing.T) {
+	t.Run("fail expired token", func(t *testing.T) {
+		first := true
+		provider := CachingExpiringTokenProvider(func() (string, time.Time, error) {
+			if first {
+				first = false
+				return "token", time.Now().Add(-time.Second), nil
+			}
+			return "", time.Time{}, assert.AnError
+		})
+
+		_, err := provider()
+		require.NoError(t, err)
+		_, err = provider()
+		assert.Equal(t, assert.AnError, err)
+	})
+
+	t.Run("success refresh ahead of expiry", func(t *testing.T) {
+		var calls int32
+		provider := CachingExpiringTokenProvider(func() (string, time.Time, error) {
+			atomic.AddInt32(&calls, 1)
+			return "token", time.Now().Add(defaultTokenRefreshMargin + 20*time.Millisecond), nil
+		})
+
+		for i := 0; i < 3; i++ {
+			_, err := provider()
+			require.NoError(t, err)
+		}
+		assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+		time.Sleep(30 * time.Millisecond)
+		_, err := provider()
+		require.NoError(t, err)
+		assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	})
+
+	t.Run("success cached token on refresh error", func(t *testing.T) {
+		var calls int32
+		provider := CachingExpiringTokenProvider(func() (string, time.Time, error) {
+			if atomic.AddInt32(&calls, 1) > 1 {
+				return "", time.Time{}, assert.AnError
+			}
+			return "token", time.Now().Add(defaultTokenRefreshMargin / 2), nil
+		})
+
+		for i := 0; i < 2; i++ {
+			token, err := provider()
+			require.NoError(t, err)
+			assert.Equal(t, "token", token)
+		}
+		assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	})
+
+	t.Run("success zero expiry not cached", func(t *testing.T) {
+		var calls int32
+		provider := CachingExpiringTokenProvider(func() (string, time.Time, error) {
+			atomic.AddInt32(&calls, 1)
+			return "token", time.Time{}, nil
+		})
+
+		for i := 0; i < 2; i++ {
+			_, err := provider()
+			require.NoError(t, err)
+		}
+		assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+	})
+
+	t.Run("success concurrent use", func(t *testing.T) {
+		var calls int32
+		provider := CachingExpiringTokenProvider(func() (string, time.Time, error) {
+			atomic.AddInt32(&calls, 1)
+			time.Sleep(10 * time.Millisecond)
+			return "token", time.Now().Add(time.Hour), nil
+		})
+
+		var wg sync.WaitGroup
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				token, err := provider()
+				assert.NoError(t, err)
+				assert.Equal(t, "token", token)
+			}()
+		}
+		wg.Wait()
+		assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+	})
+}
@@ -0,0 +1,86 @@
+package nakadi
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/pkg/errors"
+)
+
+// SpanContextTraceParent is the key of the W3C traceparent header in the span context of an event.
+const SpanContextTraceParent = "traceparent"
+
+type spanContextKey struct{}
+
+// WithSpanContext returns a copy of ctx which carries the given span context. When such a context is passed
+// to PublishContext, the span context is added to the metadata field span_ctx of all published events which
+// do not have a span context yet. This way consumers are able to continue the trace of the producer. Since
+// events of the category "undefined" own their metadata, the span context is only added to events of the
+// categories "business" and "data", the category is requested from Nakadi once per PublishAPI.
+func WithSpanContext(ctx context.Context, spanCtx map[string]string) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, spanCtx)
+}
+
+// SpanContextFromContext returns the span context carried by ctx or nil if ctx has no span context.
+func SpanContextFromContext(ctx context.Context) map[string]string {
+	spanCtx, _ := ctx.Value(spanContextKey{}).(map[string]string)
+	return spanCtx
+}
+
+// SpanContexts extracts the span contexts from the metadata of the json encoded events of a received batch.
+// The result contains one entry per event, for events without span context the entry is nil.
+func SpanContexts(events []byte) ([]map[string]string, error) {
+	var decoded []struct {
+		Metadata struct {
+			SpanCtx map[string]string `json:"span_ctx"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal(events, &decoded); err != nil {
+		return nil, errors.Wrap(err, "unable to extract span context")
+	}
+
+	spanContexts := make([]map[string]string, len(decoded))
+	for i, event := range decoded {
+		spanContexts[i] = event.Metadata.SpanCtx
+	}
+	return spanContexts, nil
+}
+
+// injectSpanContext adds the span context to the metadata of all json encoded events which do not contain
+// a span context already. Missing or null metadata is created, events whose metadata is no object are left
+// unchanged for Nakadi to reject them.
+func injectSpanContext(encoded []byte, spanCtx map[string]string) ([]byte, error) {
+	var events []map[string]json.RawMessage
+	if err := json.Unmarshal(encoded, &events); err != nil {
+		return nil, errors.Wrap(err, "unable to add span context")
+	}
+
+	spanCtxJSON, err := json.Marshal(spanCtx)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to add span context")
+	}
+
+	for _, event := range events {
+		var metadata map[string]json.RawMessage
+		if raw, ok := event["metadata"]; ok {
+			if err := json.Unmarshal(raw, &metadata); err != nil {
+				continue
+			}
+		}
+		if metadata == nil {
+			metadata = map[string]json.RawMessage{}
+		}
+		if _, ok := metadata["span_ctx"]; ok {
+			continue
+		}
+		metadata["span_ctx"] = spanCtxJSON
+
+		raw, err := json.Marshal(metadata)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to add span context")
+		}
+		event["metadata"] = raw
+	}
+
+	return json.Marshal(events)
+}
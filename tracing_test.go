@@ -0,0 +1,61 @@
+package nakadi
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpanContextFromContext(t *testing.T) {
+	assert.Nil(t, SpanContextFromContext(context.Background()))
+
+	spanCtx := map[string]string{SpanContextTraceParent: "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"}
+	ctx := WithSpanContext(context.Background(), spanCtx)
+	assert.Equal(t, spanCtx, SpanContextFromContext(ctx))
+}
+
+func TestSpanContexts(t *testing.T) {
+	t.Run("fail invalid events", func(t *testing.T) {
+		_, err := SpanContexts([]byte("not json"))
+		require.Error(t, err)
+		assert.Regexp(t, "unable to extract span context", err.Error())
+	})
+
+	t.Run("success", func(t *testing.T) {
+		events := []byte(`[
+			{"metadata":{"eid":"1","span_ctx":{"traceparent":"00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"}}},
+			{"metadata":{"eid":"2"}}]`)
+
+		spanContexts, err := SpanContexts(events)
+		require.NoError(t, err)
+		require.Len(t, spanContexts, 2)
+		assert.Equal(t, "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", spanContexts[0][SpanContextTraceParent])
+		assert.Nil(t, spanContexts[1])
+	})
+}
+
+func TestInjectSpanContext(t *testing.T) {
+	spanCtx := map[string]string{SpanContextTraceParent: "trace"}
+
+	t.Run("fail no event array", func(t *testing.T) {
+		_, err := injectSpanContext([]byte(`{"metadata":{}}`), spanCtx)
+		require.Error(t, err)
+		assert.Regexp(t, "unable to add span context", err.Error())
+	})
+
+	t.Run("success null metadata", func(t *testing.T) {
+		encoded, err := injectSpanContext([]byte(`[{"metadata":null},{"metadata":"not an object"}]`), spanCtx)
+		require.NoError(t, err)
+		assert.JSONEq(t, `[{"metadata":{"span_ctx":{"traceparent":"trace"}}},{"metadata":"not an object"}]`, string(encoded))
+	})
+
+	t.Run("success", func(t *testing.T) {
+		encoded, err := injectSpanContext([]byte(`[{"data":{"id":12345678901234567890}},{"metadata":{"span_ctx":{"traceparent":"other"}}}]`), spanCtx)
+		require.NoError(t, err)
+		assert.JSONEq(t, `[
+			{"metadata":{"span_ctx":{"traceparent":"trace"}},"data":{"id":12345678901234567890}},
+			{"metadata":{"span_ctx":{"traceparent":"other"}}}]`, string(encoded))
+	})
+}